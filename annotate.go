@@ -0,0 +1,185 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	annotateBegin              = "scorpion:begin"
+	annotateEnd                = "scorpion:end"
+	defaultAnnotateMinComments = 10
+)
+
+var (
+	// lineCommentPrefixes are used for the header block, files of
+	// other languages are not annotated
+	lineCommentPrefixes = map[string]string{
+		"C": "//", "C#": "//", "C++": "//", "Go": "//", "Groovy": "//", "Java": "//",
+		"JavaScript": "//", "Kotlin": "//", "Less": "//", "Objective-C": "//", "Rust": "//",
+		"SCSS": "//", "Scala": "//", "Swift": "//", "TypeScript": "//",
+		"CMake": "#", "Dockerfile": "#", "Elixir": "#", "Makefile": "#", "Perl": "#",
+		"PowerShell": "#", "Python": "#", "R": "#", "Ruby": "#", "Shell": "#", "TOML": "#",
+		"Terraform": "#", "YAML": "#",
+		"Clojure": ";;", "Lisp": ";;", "INI": ";",
+		"Erlang": "%", "TeX": "%",
+		"Haskell": "--", "Lua": "--", "SQL": "--",
+	}
+	encodingLinePattern = regexp.MustCompile(`^#.*coding[:=]`)
+)
+
+// annotationSummary describes debt of the file comments
+func annotationSummary(comments []*ToDoComment) string {
+	summary := fmt.Sprintf("This file carries %v TODOs", len(comments))
+	if hours := totalEstimate(comments); hours >= estimateEpsilon {
+		summary += fmt.Sprintf(" / %.1fh of debt", hours)
+	}
+	return summary
+}
+
+// headerStart skips lines that must stay at the top of the file
+func headerStart(lines []string) int {
+	i := 0
+	if i < len(lines) && strings.HasPrefix(lines[i], "#!") {
+		i++
+	}
+	// encoding declaration must be on the first or second line
+	if i < len(lines) && i < 2 && encodingLinePattern.MatchString(lines[i]) {
+		i++
+	}
+	return i
+}
+
+// annotateLines removes the existing block between markers and inserts
+// the new one with the summary unless it is empty
+func annotateLines(lines []string, prefix, summary string) []string {
+	begin, end := -1, -1
+	for i, l := range lines {
+		trimmed := strings.TrimSpace(l)
+		if begin < 0 && trimmed == prefix+" "+annotateBegin {
+			begin = i
+		} else if begin >= 0 && trimmed == prefix+" "+annotateEnd {
+			end = i
+			break
+		}
+	}
+	if begin >= 0 && end >= 0 {
+		next := end + 1
+		// blank line separating the block
+		if next < len(lines) && len(strings.TrimSpace(lines[next])) == 0 {
+			next++
+		}
+		lines = append(lines[:begin:begin], lines[next:]...)
+	}
+	if len(summary) == 0 {
+		return lines
+	}
+	at := headerStart(lines)
+	block := []string{
+		prefix + " " + annotateBegin,
+		prefix + " " + summary,
+		prefix + " " + annotateEnd,
+		"",
+	}
+	result := make([]string, 0, len(lines)+len(block))
+	result = append(result, lines[:at]...)
+	result = append(result, block...)
+	return append(result, lines[at:]...)
+}
+
+// annotatedFiles returns files that already have the block, found with
+// git grep since files without comments are not in the report
+func annotatedFiles(env *Environment) []string {
+	out := env.git("grep", "-l", "--fixed-strings", annotateBegin)
+	if len(out) == 0 {
+		return nil
+	}
+	return strings.Split(out, "\n")
+}
+
+// planAnnotations adds edits inserting, updating or removing
+// blocks of files depending on their debt
+func planAnnotations(config *AnnotateConfig, root string, comments []*ToDoComment, extra []string, plan *Plan) error {
+	minComments := config.MinComments
+	if minComments <= 0 {
+		minComments = defaultAnnotateMinComments
+	}
+	files := make(map[string][]*ToDoComment)
+	for _, c := range comments {
+		files[c.File] = append(files[c.File], c)
+	}
+	for _, name := range extra {
+		if _, ok := files[name]; !ok {
+			files[name] = nil
+		}
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		prefix, ok := lineCommentPrefixes[detectLanguage(name)]
+		if !ok {
+			continue
+		}
+		fileComments := files[name]
+		summary := ""
+		if len(fileComments) >= minComments ||
+			(config.MinHours > 0 && totalEstimate(fileComments) >= config.MinHours) {
+			summary = annotationSummary(fileComments)
+		}
+		path := filepath.Join(root, name)
+		data, err := ioutil.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		content := strings.Join(annotateLines(strings.Split(string(data), "\n"), prefix, summary), "\n")
+		if content == string(data) {
+			continue
+		}
+		action := "annotate"
+		if len(summary) == 0 {
+			action = "remove annotation"
+		}
+		plan.Add(&PlanAction{Kind: planEditFile, Target: path, Summary: action, Content: content})
+	}
+	return nil
+}
+
+// runAnnotate implements "scorpion annotate" that maintains a header
+// block with debt summary in files exceeding the threshold
+func runAnnotate(args []string) error {
+	if err := resolveScanPaths(args); err != nil {
+		return err
+	}
+	config, err := loadConfig(configPathFlag, srcRootFlag)
+	if err != nil {
+		return err
+	}
+	env := NewEnvironment(srcRootFlag)
+	if env.ScansTree() {
+		return errFixTree
+	}
+	report, err := generateReport(config, env)
+	if err != nil {
+		return err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	plan := NewPlan(wd)
+	if err := planAnnotations(&config.Annotate, report.Root, report.Comments, annotatedFiles(env), plan); err != nil {
+		return err
+	}
+	return executePlan(plan)
+}
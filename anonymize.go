@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	anonymizeTransformerName = "anonymize"
+	anonymizedHashLength     = 12
+)
+
+// anonymizer hashes identifying information of the report
+type anonymizer struct {
+	config *AnonymizeConfig
+}
+
+func (a *anonymizer) hash(value string) string {
+	if len(value) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(a.config.Salt + value))
+	return hex.EncodeToString(sum[:])[:anonymizedHashLength]
+}
+
+// path hashes file path keeping its top level directory
+// and extension if configured
+func (a *anonymizer) path(file string) string {
+	file = path.Clean(strings.Replace(file, "\\", "/", -1))
+	hashed := a.hash(file)
+	if a.config.KeepExtension {
+		hashed += path.Ext(file)
+	}
+	if parts := strings.SplitN(file, "/", 2); a.config.KeepTopLevelDir && len(parts) == 2 {
+		hashed = parts[0] + "/" + hashed
+	}
+	return hashed
+}
+
+func (a *anonymizer) blame(b *Blame) {
+	b.Author = a.hash(b.Author)
+	b.Email = a.hash(b.Email)
+	b.CanonicalAuthor = a.hash(b.CanonicalAuthor)
+	b.CanonicalEmail = a.hash(b.CanonicalEmail)
+	b.Permalink = ""
+}
+
+// anonymize hashes authors and file paths in the report and strips
+// code context so that debt metrics can be shared externally
+func anonymize(report *result, config *AnonymizeConfig) {
+	a := &anonymizer{config: config}
+	report.Root = ""
+	report.Branch = a.hash(report.Branch)
+	report.Author = a.hash(report.Author)
+	report.Project = a.hash(report.Project)
+	report.Remotes = nil
+	for _, c := range report.Comments {
+		c.ID = a.hash(c.ID)
+		c.File = a.path(c.File)
+		c.Body = ""
+		c.Assignee = a.hash(c.Assignee)
+		c.Project = a.hash(c.Project)
+		if config.StripTitles {
+			c.Title = ""
+			c.OriginalTitle = ""
+			c.RawTitle = ""
+		}
+		if c.Blame != nil {
+			a.blame(c.Blame)
+		}
+		// relations keep linking the hashed files and IDs
+		for _, r := range c.Relations {
+			switch r.Kind {
+			case relationComment:
+				r.Target = a.hash(r.Target)
+			case relationFile:
+				file, line := relationPath(r)
+				r.Target = a.path(file)
+				if line > 0 {
+					r.Target += ":" + strconv.Itoa(line)
+				}
+			}
+		}
+	}
+}
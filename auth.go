@@ -0,0 +1,362 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	roleRead         = "read"
+	roleAdmin        = "admin"
+	defaultOIDCClaim = "groups"
+	// oidcLeeway tolerates clock skew between the server and the provider
+	oidcLeeway = time.Minute
+	// jwksRefreshInterval limits refetching of keys for unknown key IDs
+	jwksRefreshInterval = 5 * time.Minute
+)
+
+var (
+	errUnauthenticated = errors.New("Missing or invalid token")
+	errForbidden       = errors.New("Token does not allow this request")
+	errInvalidToken    = errors.New("Invalid token")
+)
+
+// identity is an authenticated API client
+type identity struct {
+	name string
+	role string
+}
+
+// allows checks if the client has the role, admins can also read
+func (id *identity) allows(role string) bool {
+	return role == roleRead || id.role == roleAdmin
+}
+
+type staticToken struct {
+	hash     [sha256.Size]byte
+	identity *identity
+}
+
+// authenticator checks bearer tokens of API requests
+type authenticator struct {
+	tokens []*staticToken
+	oidc   *oidcVerifier
+}
+
+func parseRole(role string) (string, error) {
+	switch role {
+	case "", roleRead:
+		return roleRead, nil
+	case roleAdmin:
+		return roleAdmin, nil
+	}
+	return "", fmt.Errorf("Unknown role: %v", role)
+}
+
+// newAuthenticator returns nil if authentication is not configured,
+// tokens are read from the secrets
+func newAuthenticator(ac *AuthConfig, secrets *Secrets) (*authenticator, error) {
+	if len(ac.Tokens) == 0 && ac.OIDC == nil {
+		return nil, nil
+	}
+	a := &authenticator{}
+	for _, tc := range ac.Tokens {
+		role, err := parseRole(tc.Role)
+		if err != nil {
+			return nil, fmt.Errorf("Token %v: %v", tc.Name, err)
+		}
+		if len(tc.Secret) == 0 {
+			return nil, fmt.Errorf("Token %v: secret is not set", tc.Name)
+		}
+		token, err := secrets.Get(tc.Secret)
+		if err != nil {
+			return nil, fmt.Errorf("Token %v: %v", tc.Name, err)
+		}
+		a.tokens = append(a.tokens, &staticToken{hash: sha256.Sum256([]byte(token)), identity: &identity{name: tc.Name, role: role}})
+	}
+	if ac.OIDC != nil {
+		v, err := newOIDCVerifier(ac.OIDC)
+		if err != nil {
+			return nil, err
+		}
+		a.oidc = v
+	}
+	return a, nil
+}
+
+// authenticate returns the client of the "Bearer <token>" header value
+func (a *authenticator) authenticate(authorization string) (*identity, error) {
+	token := strings.TrimPrefix(authorization, "Bearer ")
+	if token == authorization || len(token) == 0 {
+		return nil, errUnauthenticated
+	}
+	hash := sha256.Sum256([]byte(token))
+	var found *identity
+	for _, t := range a.tokens {
+		// hashes have the same length, so the comparison
+		// does not leak how much of a token matched
+		if subtle.ConstantTimeCompare(hash[:], t.hash[:]) == 1 {
+			found = t.identity
+		}
+	}
+	if found != nil {
+		return found, nil
+	}
+	if a.oidc != nil && strings.Count(token, ".") == 2 {
+		return a.oidc.verify(token)
+	}
+	return nil, errUnauthenticated
+}
+
+// oidcVerifier verifies JWT signed by keys of the issuer, keys are
+// discovered on the first token so that startup does not need the
+// provider
+type oidcVerifier struct {
+	config  *OIDCConfig
+	mu      sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+}
+
+func newOIDCVerifier(oc *OIDCConfig) (*oidcVerifier, error) {
+	if len(oc.Issuer) == 0 {
+		return nil, errors.New("OIDC issuer is not set")
+	}
+	if len(oc.Audience) == 0 {
+		return nil, errors.New("OIDC audience is not set")
+	}
+	return &oidcVerifier{config: oc}, nil
+}
+
+// jsonWebKey is a public key of JWKS, RSA or EC
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k *jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		curve, ok := curves[k.Crv]
+		if !ok {
+			return nil, fmt.Errorf("Unsupported curve %v", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("Unsupported key type %v", k.Kty)
+}
+
+// fetchKeys reads keys of the issuer from its discovery document
+func (v *oidcVerifier) fetchKeys() error {
+	var discovery struct {
+		JWKSURI string `json:"jwks_uri"`
+	}
+	endpoint := strings.TrimSuffix(v.config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := doJSONRequest("GET", endpoint, nil, nil, &discovery); err != nil {
+		return err
+	}
+	var jwks struct {
+		Keys []*jsonWebKey `json:"keys"`
+	}
+	if err := doJSONRequest("GET", discovery.JWKSURI, nil, nil, &jwks); err != nil {
+		return err
+	}
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		// keys of other types are not used for tokens
+		if key, err := k.publicKey(); err == nil {
+			keys[k.Kid] = key
+		}
+	}
+	v.keys = keys
+	return nil
+}
+
+// key returns the key of the ID, keys are refetched if it is unknown
+// (e.g. after rotation) at most once per jwksRefreshInterval
+func (v *oidcVerifier) key(kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(v.fetched) < jwksRefreshInterval {
+		return nil, errInvalidToken
+	}
+	v.fetched = time.Now()
+	if err := v.fetchKeys(); err != nil {
+		return nil, fmt.Errorf("Cannot fetch keys of %v: %v", v.config.Issuer, err)
+	}
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, errInvalidToken
+}
+
+// verifySignature checks RS* (PKCS #1 v1.5) and ES* signatures
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) bool {
+	var hash crypto.Hash
+	var digest []byte
+	switch alg[2:] {
+	case "256":
+		sum := sha256.Sum256([]byte(signed))
+		hash, digest = crypto.SHA256, sum[:]
+	case "384":
+		sum := sha512.Sum384([]byte(signed))
+		hash, digest = crypto.SHA384, sum[:]
+	case "512":
+		sum := sha512.Sum512([]byte(signed))
+		hash, digest = crypto.SHA512, sum[:]
+	default:
+		return false
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") && rsa.VerifyPKCS1v15(k, hash, digest, signature) == nil
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || len(signature) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		return ecdsa.Verify(k, digest, r, s)
+	}
+	return false
+}
+
+// claimStrings returns a string or a list of strings of the claim
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// verify checks the signature, issuer, audience and validity of the
+// token and returns the user, admin if one of the groups is an admin one
+func (v *oidcVerifier) verify(token string) (*identity, error) {
+	parts := strings.Split(token, ".")
+	data, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil || len(header.Alg) != 5 {
+		return nil, errInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if !verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature) {
+		return nil, errInvalidToken
+	}
+	data, err = base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	claims := make(map[string]interface{})
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, errInvalidToken
+	}
+	now := time.Now()
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != strings.TrimSuffix(v.config.Issuer, "/") {
+		return nil, errInvalidToken
+	}
+	if !containsString(claimStrings(claims["aud"]), v.config.Audience) {
+		return nil, errInvalidToken
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.Add(-oidcLeeway).After(time.Unix(int64(exp), 0)) {
+		return nil, errInvalidToken
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(oidcLeeway).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errInvalidToken
+	}
+	name, _ := claims["email"].(string)
+	if len(name) == 0 {
+		name, _ = claims["sub"].(string)
+	}
+	claim := v.config.Claim
+	if len(claim) == 0 {
+		claim = defaultOIDCClaim
+	}
+	id := &identity{name: name, role: roleRead}
+	for _, group := range claimStrings(claims[claim]) {
+		if containsString(v.config.AdminGroups, group) {
+			id.role = roleAdmin
+		}
+	}
+	return id, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/whilp/git-urls"
+)
+
+const (
+	blameWorkers    = 8
+	uncommittedHash = "0000000000000000000000000000000000000000"
+)
+
+// Blame describes the commit that introduced a comment
+type Blame struct {
+	Commit    string    `json:"commit"`
+	Author    string    `json:"author,omitempty"`
+	Email     string    `json:"email,omitempty"`
+	Date      time.Time `json:"date"`
+	Permalink string    `json:"permalink,omitempty"`
+	// CanonicalAuthor and CanonicalEmail are mapped with .mailmap
+	CanonicalAuthor string `json:"canonicalAuthor,omitempty"`
+	CanonicalEmail  string `json:"canonicalEmail,omitempty"`
+}
+
+// mailmap resolves canonical authors of commits using .mailmap
+type mailmap struct {
+	env     *Environment
+	mux     sync.Mutex
+	commits map[string][]string
+}
+
+func newMailmap(env *Environment) *mailmap {
+	return &mailmap{env: env, commits: make(map[string][]string)}
+}
+
+// apply sets raw author of the commit (git blame already maps it)
+// and the canonical one
+func (m *mailmap) apply(b *Blame) {
+	m.mux.Lock()
+	authors, ok := m.commits[b.Commit]
+	m.mux.Unlock()
+	if !ok {
+		out := m.env.git("log", "-1", "--format=%an%n%ae%n%aN%n%aE", b.Commit)
+		authors = strings.Split(out, "\n")
+		m.mux.Lock()
+		m.commits[b.Commit] = authors
+		m.mux.Unlock()
+	}
+	if len(authors) != 4 {
+		b.CanonicalAuthor, b.CanonicalEmail = b.Author, b.Email
+		return
+	}
+	b.Author, b.Email = authors[0], authors[1]
+	b.CanonicalAuthor, b.CanonicalEmail = authors[2], authors[3]
+}
+
+// parseBlamePorcelain parses output of "git blame --porcelain" for one line
+func parseBlamePorcelain(out string) *Blame {
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	if !scanner.Scan() {
+		return nil
+	}
+	header := strings.Fields(scanner.Text())
+	if len(header) == 0 || header[0] == uncommittedHash {
+		return nil
+	}
+	b := &Blame{Commit: header[0]}
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "\t") {
+			break
+		}
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "author":
+			b.Author = parts[1]
+		case "author-mail":
+			b.Email = strings.Trim(parts[1], "<>")
+		case "author-time":
+			if t, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+				b.Date = time.Unix(t, 0).UTC()
+			}
+		}
+	}
+	return b
+}
+
+// WebURL returns http url of the repository derived from the selected
+// remote (origin by default)
+func (env *Environment) WebURL() string {
+	env.initWebURL.Do(func() {
+		remote := env.git("config", "--get", "remote."+remoteFlag+".url")
+		if len(remote) == 0 {
+			return
+		}
+		u, err := giturls.Parse(remote)
+		if err != nil || len(u.Host) == 0 {
+			log.Printf("Cannot parse remote url %v: %v", remote, err)
+			return
+		}
+		p := strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git")
+		env.webURL = "https://" + u.Hostname() + "/" + p
+	})
+	return env.webURL
+}
+
+// Permalink returns link to the line of file (relative to repository
+// top level) at the commit in the web interface of the git host
+func (env *Environment) Permalink(commit, file string, line int) string {
+	base := env.WebURL()
+	if len(base) == 0 {
+		return ""
+	}
+	switch {
+	case strings.Contains(base, "gitlab"):
+		return fmt.Sprintf("%v/-/blob/%v/%v#L%v", base, commit, file, line)
+	case strings.Contains(base, "bitbucket"):
+		return fmt.Sprintf("%v/src/%v/%v#lines-%v", base, commit, file, line)
+	}
+	return fmt.Sprintf("%v/blob/%v/%v#L%v", base, commit, file, line)
+}
+
+// Blame returns commit that introduced the line (1-based)
+// of the file relative to environment's root
+func (env *Environment) Blame(file string, line int) *Blame {
+	lines := fmt.Sprintf("%v,%v", line, line)
+	args := []string{"blame", "--porcelain", "-L", lines}
+	if env.ScansTree() {
+		args = append(args, env.head())
+	}
+	out := env.git(append(args, "--", file)...)
+	if len(out) == 0 {
+		return nil
+	}
+	b := parseBlamePorcelain(out)
+	if b != nil {
+		b.Permalink = env.Permalink(b.Commit, path.Join(env.Prefix(), file), line)
+	}
+	return b
+}
+
+// enrichBlame sets blame information for every comment
+func enrichBlame(env *Environment, comments []*ToDoComment) {
+	var wg sync.WaitGroup
+	queue := make(chan *ToDoComment)
+	names := newMailmap(env)
+	for i := 0; i < concurrency(blameWorkers); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range queue {
+				if stopRequested() {
+					continue
+				}
+				// comment lines are 0-based
+				c.Blame = env.Blame(c.File, c.Line+1)
+				if c.Blame != nil {
+					names.apply(c.Blame)
+				}
+			}
+		}()
+	}
+	for _, c := range comments {
+		queue <- c
+	}
+	close(queue)
+	wg.Wait()
+}
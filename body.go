@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	truncatedLinesMarker = "[... %v more lines]"
+	truncatedCharsMarker = "[... truncated]"
+)
+
+// cutAtBlankLine drops body lines starting with the first
+// blank comment line after the title
+func cutAtBlankLine(body []string) []string {
+	for i := 1; i < len(body); i++ {
+		if len(strings.TrimSpace(body[i])) == 0 {
+			return body[:i]
+		}
+	}
+	return body
+}
+
+// truncateBody limits body of the comment to maxLines lines and
+// maxLength characters (no limit if zero) adding truncation marker
+func truncateBody(body string, maxLines, maxLength int) string {
+	if maxLines > 0 {
+		lines := strings.Split(body, "\n")
+		if len(lines) > maxLines {
+			rest := len(lines) - maxLines
+			body = strings.Join(lines[:maxLines], "\n") + "\n" + fmt.Sprintf(truncatedLinesMarker, rest)
+		}
+	}
+	if maxLength > 0 {
+		runes := []rune(body)
+		if len(runes) > maxLength {
+			body = strings.TrimSpace(string(runes[:maxLength])) + " " + truncatedCharsMarker
+		}
+	}
+	return body
+}
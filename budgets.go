@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	budgetsCheckName  = "budget"
+	defaultBudgetFile = "debt-budget.yml"
+)
+
+// BudgetFile is a file of budgets committed in the repository, so that
+// changes of budgets are reviewed like the code
+type BudgetFile struct {
+	Budgets []*BudgetConfig `yaml:"budgets"`
+}
+
+// budgetUsage is debt counted by a budget
+type budgetUsage struct {
+	count int
+	hours float64
+}
+
+// matchBudget checks if the comment counts against the budget
+func matchBudget(bc *BudgetConfig, c *ToDoComment) bool {
+	if len(bc.Types) > 0 && !containsFold(bc.Types, c.Type) {
+		return false
+	}
+	if len(bc.Categories) > 0 && !containsFold(bc.Categories, c.Category) {
+		return false
+	}
+	if len(bc.Path) > 0 && !strings.HasPrefix(c.File, bc.Path) {
+		return false
+	}
+	if len(bc.Owner) > 0 {
+		owners := []string{c.Assignee}
+		if c.Blame != nil {
+			owners = append(owners, c.Blame.Author, c.Blame.CanonicalAuthor, c.Blame.Email, c.Blame.CanonicalEmail)
+		}
+		if !containsFold(owners, bc.Owner) {
+			return false
+		}
+	}
+	return true
+}
+
+func measureBudget(bc *BudgetConfig, comments []*ToDoComment) budgetUsage {
+	usage := budgetUsage{}
+	for _, c := range comments {
+		if matchBudget(bc, c) {
+			usage.count++
+			usage.hours += c.Estimate
+		}
+	}
+	return usage
+}
+
+func budgetName(bc *BudgetConfig, i int) string {
+	if len(bc.Name) > 0 {
+		return bc.Name
+	}
+	return fmt.Sprintf("#%v", i+1)
+}
+
+// gateBudgets returns budgets of the config and of the budget file,
+// the default file is optional
+func gateBudgets(config *Config, root string) ([]*BudgetConfig, error) {
+	path := config.Gate.BudgetFile
+	if len(path) == 0 {
+		path = defaultBudgetFile
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && len(config.Gate.BudgetFile) == 0 {
+		return config.Gate.Budgets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	file := &BudgetFile{}
+	if err := yaml.UnmarshalStrict(data, file); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	budgets := make([]*BudgetConfig, 0, len(config.Gate.Budgets)+len(file.Budgets))
+	return append(append(budgets, config.Gate.Budgets...), file.Budgets...), nil
+}
+
+// loadBaseline returns the scan budgets are compared with,
+// nil if nothing is stored yet and --baseline is not set
+func loadBaseline(config *Config, env *Environment) (*result, error) {
+	store := NewScanStore(env.root, config.Gate.Store)
+	baseline, err := resolveScan(store, baselineFlag)
+	if err != nil && len(baselineFlag) == 0 {
+		log.Printf("Budget trends are not checked: %v", err)
+		return nil, nil
+	}
+	return baseline, err
+}
+
+// budgetsCheck fails budgets that are exceeded or grew since
+// the baseline more than their tolerance, the checked budgets are kept
+// in the report for the table of the gate
+func budgetsCheck(report *result, config *Config, env *Environment) ([]*Violation, error) {
+	budgets, err := gateBudgets(config, env.root)
+	if err != nil || len(budgets) == 0 {
+		return nil, err
+	}
+	report.budgets = budgets
+	var baseline *result
+	for _, bc := range budgets {
+		if bc.Tolerance != nil || bc.ToleranceHours != nil {
+			var err error
+			if baseline, err = loadBaseline(config, env); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	violations := make([]*Violation, 0)
+	fail := func(name, format string, a ...interface{}) {
+		violations = append(violations, &Violation{
+			Check:   budgetsCheckName + "/" + name,
+			Message: fmt.Sprintf(format, a...),
+		})
+	}
+	for i, bc := range budgets {
+		name := budgetName(bc, i)
+		usage := measureBudget(bc, report.Comments)
+		if bc.MaxCount > 0 && usage.count > bc.MaxCount {
+			fail(name, "%v comments exceed budget of %v", usage.count, bc.MaxCount)
+		}
+		if bc.MaxHours > 0 && usage.hours > bc.MaxHours+estimateEpsilon {
+			fail(name, "%.1fh estimated exceed budget of %.1fh", usage.hours, bc.MaxHours)
+		}
+		if baseline == nil {
+			continue
+		}
+		previous := measureBudget(bc, baseline.Comments)
+		if bc.Tolerance != nil && usage.count-previous.count > *bc.Tolerance {
+			fail(name, "%v comments added since baseline (%v to %v), tolerance is %v",
+				usage.count-previous.count, previous.count, usage.count, *bc.Tolerance)
+		}
+		if bc.ToleranceHours != nil && usage.hours-previous.hours > *bc.ToleranceHours+estimateEpsilon {
+			fail(name, "%.1fh added since baseline (%.1fh to %.1fh), tolerance is %.1fh",
+				usage.hours-previous.hours, previous.hours, usage.hours, *bc.ToleranceHours)
+		}
+	}
+	return violations, nil
+}
+
+// printBudgets writes a table of usage of the budgets, budgets with
+// violations are exceeded
+func printBudgets(w io.Writer, budgets []*BudgetConfig, report *result, violations []*Violation) error {
+	exceeded := make(map[string]bool)
+	for _, v := range violations {
+		exceeded[v.Check] = true
+	}
+	limit := func(v float64, format string) string {
+		if v == 0 {
+			return "-"
+		}
+		return fmt.Sprintf(format, v)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "BUDGET\tCOMMENTS\tMAX\tESTIMATE\tMAX\tSTATUS")
+	for i, bc := range budgets {
+		name := budgetName(bc, i)
+		usage := measureBudget(bc, report.Comments)
+		status := "ok"
+		if exceeded[budgetsCheckName+"/"+name] {
+			status = "exceeded"
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%.1fh\t%v\t%v\n", name, usage.count, limit(float64(bc.MaxCount), "%.0f"),
+			usage.hours, limit(bc.MaxHours, "%.1fh"), status)
+	}
+	return tw.Flush()
+}
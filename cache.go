@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	cacheDirName = appName
+)
+
+// ReportCache stores reports of clean worktrees keyed by commit
+type ReportCache struct {
+	dir string
+}
+
+// NewReportCache creates cache in the user cache directory
+func NewReportCache() (*ReportCache, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return &ReportCache{dir: filepath.Join(dir, cacheDirName)}, nil
+}
+
+// cacheKey returns key of the report for the commit, it includes
+// everything else that affects the scan: version, root, config and flags
+func cacheKey(commit, root string, config *Config) (string, error) {
+	configData, err := yaml.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	io.WriteString(h, version)
+	io.WriteString(h, commit)
+	io.WriteString(h, root)
+	h.Write(configData)
+	fmt.Fprintf(h, "%v|%v|%v|%v|%v|%v|%v|%v|%v|%v|%v|%v", includePatternsFlag, minWordCountFlag, minCharsFlag,
+		blameFlag, scanPaths, submodulesFlag, includeGenFlag, includeVendorFlag, lenientFlag, remoteFlag, maxDepthFlag, hiddenFlag)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func (rc *ReportCache) path(key string) string {
+	return filepath.Join(rc.dir, key+".json")
+}
+
+// Get returns cached report or nil if there is none
+func (rc *ReportCache) Get(key string) *result {
+	data, err := ioutil.ReadFile(rc.path(key))
+	if err != nil {
+		return nil
+	}
+	report := &result{}
+	if err := json.Unmarshal(data, report); err != nil {
+		log.Printf("Ignoring broken cache entry %v: %v", key, err)
+		return nil
+	}
+	return report
+}
+
+// Put saves the report in the cache
+func (rc *ReportCache) Put(key string, report *result) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(rc.dir, 0755); err != nil {
+		return err
+	}
+	// write to temporary file first so that concurrent jobs
+	// never read partially written report
+	tmp, err := ioutil.TempFile(rc.dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), rc.path(key))
+}
+
+// reportCacheKey returns cache key for the environment or empty
+// string if the report cannot be cached (not a git repo or dirty)
+func reportCacheKey(env *Environment, config *Config) string {
+	commit := env.Commit()
+	if len(commit) == 0 || !env.IsClean() {
+		return ""
+	}
+	key, err := cacheKey(commit, env.root, config)
+	if err != nil {
+		log.Printf("Cannot compute cache key: %v", err)
+		return ""
+	}
+	return key
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+)
+
+const (
+	canonicalTransformerName = "canonical"
+	commentIDLength          = 12
+)
+
+// assignIDs sets IDs that do not change when comments move to other
+// lines or their body is edited, identical comments of the same file
+// get suffixes in order of lines
+func assignIDs(comments []*ToDoComment) {
+	// files are parsed concurrently so the order has to be fixed
+	ordered := append([]*ToDoComment{}, comments...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].File != ordered[j].File {
+			return ordered[i].File < ordered[j].File
+		}
+		return ordered[i].Line < ordered[j].Line
+	})
+	seen := make(map[string]int)
+	for _, c := range ordered {
+		if len(c.ID) > 0 {
+			continue
+		}
+		h := md5.New()
+		io.WriteString(h, commentIdentity(c))
+		id := hex.EncodeToString(h.Sum(nil))[:commentIDLength]
+		seen[id]++
+		if n := seen[id]; n > 1 {
+			id = fmt.Sprintf("%v-%v", id, n)
+		}
+		c.ID = id
+	}
+}
+
+// canonicalize makes the report stable for committing to the repository:
+// comments are sorted and everything depending on the machine, the time
+// or the current commit is removed
+func canonicalize(report *result) error {
+	report.Root = ""
+	report.Branch = ""
+	report.Revision = ""
+	report.Author = ""
+	for _, c := range report.Comments {
+		c.Blame = nil
+	}
+	return sortComments(report.Comments, "file")
+}
+
+func canonicalTransformer(tc *TransformerConfig, config *Config, env *Environment, report *result) error {
+	return canonicalize(report)
+}
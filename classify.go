@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var (
+	errClassifyNothing = errors.New("sets neither type nor category")
+)
+
+// classifyRule is a compiled ClassifyConfig
+type classifyRule struct {
+	match    *regexp.Regexp
+	types    map[string]bool
+	ctype    string
+	category string
+}
+
+// compileClassifyRules compiles rules of the classify section in order
+func compileClassifyRules(rules []*ClassifyConfig) ([]*classifyRule, error) {
+	compiled := make([]*classifyRule, 0, len(rules))
+	for i, rc := range rules {
+		if len(rc.Type) == 0 && len(rc.Category) == 0 {
+			return nil, fmt.Errorf("Classify rule %v: %v", i+1, errClassifyNothing)
+		}
+		match, err := regexp.Compile(rc.Match)
+		if err != nil {
+			return nil, fmt.Errorf("Classify rule %v: %v", i+1, err)
+		}
+		r := &classifyRule{match: match, ctype: strings.ToUpper(rc.Type), category: rc.Category}
+		if len(rc.Types) > 0 {
+			r.types = make(map[string]bool, len(rc.Types))
+			for _, t := range rc.Types {
+				r.types[strings.ToUpper(t)] = true
+			}
+		}
+		compiled = append(compiled, r)
+	}
+	return compiled, nil
+}
+
+// classify applies the first rule matching the title of the comment,
+// categories from comment properties are kept
+func classify(rules []*classifyRule, c *ToDoComment) {
+	for _, r := range rules {
+		if r.types != nil && !r.types[strings.ToUpper(c.Type)] {
+			continue
+		}
+		if !r.match.MatchString(c.Title) {
+			continue
+		}
+		if len(r.ctype) > 0 {
+			c.Type = r.ctype
+		}
+		if len(r.category) > 0 && len(c.Category) == 0 {
+			c.Category = r.category
+		}
+		return
+	}
+}
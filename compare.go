@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+var (
+	errTooManyScans = errors.New("Compare expects at most two scans")
+)
+
+// CommentChange is a comment present in both scans with different fields
+type CommentChange struct {
+	Before *ToDoComment `json:"before"`
+	After  *ToDoComment `json:"after"`
+	Fields []string     `json:"fields"`
+}
+
+// Delta is the difference between two scans
+type Delta struct {
+	Added   []*ToDoComment   `json:"added"`
+	Removed []*ToDoComment   `json:"removed"`
+	Changed []*CommentChange `json:"changed"`
+	// Comments and Estimate are totals of both scans
+	CommentsBefore int     `json:"commentsBefore"`
+	CommentsAfter  int     `json:"commentsAfter"`
+	EstimateBefore float64 `json:"estimateBefore"`
+	EstimateAfter  float64 `json:"estimateAfter"`
+	EstimateDelta  float64 `json:"estimateDelta"`
+}
+
+// commentIdentity matches comments across scans even if
+// their body or tags were edited
+func commentIdentity(c *ToDoComment) string {
+	return c.Type + "\x00" + c.File + "\x00" + c.Title
+}
+
+// changedFields lists tracked fields that differ between comments,
+// moving the comment to another line is not a change
+func changedFields(a, b *ToDoComment) []string {
+	fields := make([]string, 0)
+	if a.Body != b.Body {
+		fields = append(fields, "body")
+	}
+	if a.Category != b.Category {
+		fields = append(fields, "category")
+	}
+	if a.Assignee != b.Assignee {
+		fields = append(fields, "assignee")
+	}
+	if a.Issue != b.Issue {
+		fields = append(fields, "issue")
+	}
+	if a.Estimate-b.Estimate > estimateEpsilon || b.Estimate-a.Estimate > estimateEpsilon {
+		fields = append(fields, "estimate")
+	}
+	return fields
+}
+
+func totalEstimate(comments []*ToDoComment) float64 {
+	total := 0.0
+	for _, c := range comments {
+		total += c.Estimate
+	}
+	return total
+}
+
+// compareScans returns comments added, removed and changed in the after scan,
+// comments with equal identity are paired in order of appearance
+func compareScans(before, after *result) *Delta {
+	delta := &Delta{
+		Added:          make([]*ToDoComment, 0),
+		Removed:        make([]*ToDoComment, 0),
+		Changed:        make([]*CommentChange, 0),
+		CommentsBefore: len(before.Comments),
+		CommentsAfter:  len(after.Comments),
+		EstimateBefore: totalEstimate(before.Comments),
+		EstimateAfter:  totalEstimate(after.Comments),
+	}
+	delta.EstimateDelta = delta.EstimateAfter - delta.EstimateBefore
+	unmatched := make(map[string][]*ToDoComment)
+	for _, c := range before.Comments {
+		id := commentIdentity(c)
+		unmatched[id] = append(unmatched[id], c)
+	}
+	for _, c := range after.Comments {
+		id := commentIdentity(c)
+		candidates := unmatched[id]
+		if len(candidates) == 0 {
+			delta.Added = append(delta.Added, c)
+			continue
+		}
+		previous := candidates[0]
+		unmatched[id] = candidates[1:]
+		if fields := changedFields(previous, c); len(fields) > 0 {
+			delta.Changed = append(delta.Changed, &CommentChange{Before: previous, After: c, Fields: fields})
+		}
+	}
+	for _, c := range before.Comments {
+		id := commentIdentity(c)
+		for _, u := range unmatched[id] {
+			if u == c {
+				delta.Removed = append(delta.Removed, c)
+				break
+			}
+		}
+	}
+	return delta
+}
+
+// printDelta writes delta as json with verbose flag
+// or as human-readable summary otherwise
+func printDelta(w io.Writer, delta *Delta, hf *humanFormat) error {
+	if verboseFlag {
+		js, err := json.MarshalIndent(delta, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(js))
+		return err
+	}
+	for _, c := range delta.Added {
+		fmt.Fprintf(w, "+ %v: %v %v\n", commentLocation(c), c.Type, c.Title)
+	}
+	for _, c := range delta.Removed {
+		fmt.Fprintf(w, "- %v: %v %v\n", commentLocation(c), c.Type, c.Title)
+	}
+	for _, ch := range delta.Changed {
+		fmt.Fprintf(w, "~ %v: %v %v (%v)\n", commentLocation(ch.After), ch.After.Type, ch.After.Title,
+			strings.Join(ch.Fields, ", "))
+	}
+	_, err := fmt.Fprintf(w, "Comments: %v -> %v (%+d), estimate: %v -> %v (%v)\n",
+		delta.CommentsBefore, delta.CommentsAfter, delta.CommentsAfter-delta.CommentsBefore,
+		hf.Estimate(delta.EstimateBefore), hf.Estimate(delta.EstimateAfter), hf.SignedEstimate(delta.EstimateDelta))
+	return err
+}
+
+// runCompare implements "scorpion compare [scanA [scanB]]", scans are
+// report files or IDs in the store, the latest stored scan is compared
+// with the current one by default
+func runCompare(args []string) error {
+	if len(args) > 2 {
+		return errTooManyScans
+	}
+	config, err := loadConfig(configPathFlag, srcRootFlag)
+	if err != nil {
+		return err
+	}
+	hf, err := newHumanFormat(&config.Display)
+	if err != nil {
+		return err
+	}
+	env := NewEnvironment(srcRootFlag)
+	store := NewScanStore(env.root, config.Gate.Store)
+	refs := append([]string{}, args...)
+	if len(refs) == 0 {
+		refs = append(refs, "")
+	}
+	scans := make([]*result, 0, 2)
+	for _, ref := range refs {
+		report, err := resolveScan(store, ref)
+		if err != nil {
+			return err
+		}
+		scans = append(scans, report)
+	}
+	if len(scans) < 2 {
+		report, err := buildReport(&config.Pipeline, config, env)
+		if err != nil {
+			return err
+		}
+		scans = append(scans, report)
+	}
+	return printDelta(os.Stdout, compareScans(scans[0], scans[1]), hf)
+}
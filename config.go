@@ -0,0 +1,463 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultConfigName = ".scorpion.yml"
+)
+
+// Config is the content of the optional configuration file
+type Config struct {
+	// Secrets contains tokens for integrations, either plain
+	// or encrypted with "scorpion secret encrypt"
+	Secrets map[string]string `yaml:"secrets"`
+	// Keywords configures recognition of TODO-like comments
+	Keywords KeywordsConfig `yaml:"keywords"`
+	// Estimates configures parsing of time estimates
+	Estimates EstimatesConfig `yaml:"estimates"`
+	// Titles configures post-processing of comment titles
+	Titles TitlesConfig `yaml:"titles"`
+	// Classify reclassifies comments by their titles
+	Classify []*ClassifyConfig `yaml:"classify"`
+	// Bodies configures capturing of comment bodies
+	Bodies BodiesConfig `yaml:"bodies"`
+	// Limits safeguard reports against pathological files
+	Limits LimitsConfig `yaml:"limits"`
+	// Generated configures exclusion of generated files
+	Generated GeneratedConfig `yaml:"generated"`
+	// Templates select template engines of files, besides the
+	// ones of template languages (.php, .erb, .j2, .tmpl)
+	Templates []*TemplateConfig `yaml:"templates"`
+	// Vendor configures exclusion of third-party code
+	Vendor VendorConfig `yaml:"vendor"`
+	// Hidden configures scanning of hidden files and directories
+	Hidden HiddenConfig `yaml:"hidden"`
+	// Translate configures the translate transformer
+	Translate TranslateConfig `yaml:"translate"`
+	// Anonymize configures --anonymize
+	Anonymize AnonymizeConfig `yaml:"anonymize"`
+	// Gate configures checks of "scorpion gate"
+	Gate GateConfig `yaml:"gate"`
+	// Annotate configures "scorpion annotate"
+	Annotate AnnotateConfig `yaml:"annotate"`
+	// Pipeline describes processing of comments from the source to
+	// outputs, by default json goes to stdout and markdown to TODO.md
+	Pipeline PipelineConfig `yaml:"pipeline"`
+	// Daemon configures scheduled scans of "scorpion daemon"
+	Daemon DaemonConfig `yaml:"daemon"`
+	// Serve configures the API of "scorpion serve"
+	Serve ServeConfig `yaml:"serve"`
+	// Display configures ages and estimates in human-readable outputs
+	Display DisplayConfig `yaml:"display"`
+	// Cost converts estimates to money in stats and reports
+	Cost CostConfig `yaml:"cost"`
+	// Theme styles types in terminal, HTML and tracker outputs
+	Theme ThemeConfig `yaml:"theme"`
+	// Signing signs json reports written to files
+	Signing SigningConfig `yaml:"signing"`
+}
+
+// DisplayConfig sets language and units of human-readable outputs,
+// e.g. locale "de" and units "days" print "2,5 Tage"
+type DisplayConfig struct {
+	// Locale is en, de, es, fr, it or pt, the compact "2.5h" and
+	// "40d" are used if neither locale nor units are set
+	Locale string `yaml:"locale"`
+	// Units of estimates are hours (default), days or weeks
+	Units string `yaml:"units"`
+	// HoursPerDay converts estimates to days, 8 by default
+	HoursPerDay float64 `yaml:"hoursPerDay"`
+}
+
+// ThemeConfig styles types of comments in outputs, see defaultTypeThemes
+type ThemeConfig struct {
+	// Color of terminal outputs is auto (if stdout is a terminal and
+	// NO_COLOR is not set), always or never
+	Color string `yaml:"color"`
+	// Types override styles of the types, e.g. of custom keywords
+	Types map[string]*TypeTheme `yaml:"types"`
+}
+
+// TypeTheme is the style of a comment type
+type TypeTheme struct {
+	// Terminal is a color name like red or "bold bright yellow"
+	Terminal string `yaml:"terminal"`
+	// Badge is the CSS color of the type in HTML reports
+	Badge string `yaml:"badge"`
+	// Label is name of the tracker label, the lowercase type by default
+	Label string `yaml:"label"`
+	// LabelColor is the hex color ("d73a4a") labels are created with
+	LabelColor string `yaml:"labelColor"`
+}
+
+// SigningConfig signs json reports and adds provenance to them, so
+// that consumers can check they were produced by a trusted run
+type SigningConfig struct {
+	// Method is hmac (HMAC-SHA256 with secret signing) or cosign
+	Method string `yaml:"method"`
+	// Key is the cosign key (a file or KMS uri), keyless if empty
+	Key string `yaml:"key"`
+	// Identity and Issuer of the certificate verify keyless signatures
+	Identity string `yaml:"identity"`
+	Issuer   string `yaml:"issuer"`
+	// Binary is path to cosign, found in PATH by default
+	Binary string `yaml:"binary"`
+}
+
+// ServeConfig configures the API server
+type ServeConfig struct {
+	// Auth requires tokens for API requests, all requests
+	// are allowed if neither tokens nor OIDC are configured
+	Auth AuthConfig `yaml:"auth"`
+	// Webhooks receives pushes and pull requests of git providers
+	Webhooks WebhooksConfig `yaml:"webhooks"`
+}
+
+// WebhooksConfig enables receivers of the providers, deliveries are
+// verified with their secrets instead of the tokens of auth
+type WebhooksConfig struct {
+	GitHub *WebhookConfig `yaml:"github"`
+	GitLab *WebhookConfig `yaml:"gitlab"`
+	// Pull fast-forwards the checked out branch when it is pushed to
+	// and rescans changed files
+	Pull bool `yaml:"pull"`
+}
+
+// WebhookConfig names the secret deliveries of a provider are signed with
+type WebhookConfig struct {
+	Secret string `yaml:"secret"`
+}
+
+// AuthConfig lists accepted tokens, static ones are checked first
+type AuthConfig struct {
+	Tokens []*TokenConfig `yaml:"tokens"`
+	OIDC   *OIDCConfig    `yaml:"oidc"`
+}
+
+// TokenConfig is a static token of an API client
+type TokenConfig struct {
+	// Name of the client in logs
+	Name string `yaml:"name"`
+	// Secret is the name of the secret with the token
+	Secret string `yaml:"secret"`
+	// Role is read (default) or admin
+	Role string `yaml:"role"`
+}
+
+// OIDCConfig accepts ID tokens (JWT) of an OpenID Connect provider
+type OIDCConfig struct {
+	// Issuer url, keys are discovered from its configuration
+	Issuer string `yaml:"issuer"`
+	// Audience the tokens must be issued for (client ID)
+	Audience string `yaml:"audience"`
+	// Claim with groups of the user, "groups" by default
+	Claim string `yaml:"claim"`
+	// AdminGroups are groups of admins, other users can read
+	AdminGroups []string `yaml:"adminGroups"`
+}
+
+// DaemonConfig configures repositories scanned by the daemon
+type DaemonConfig struct {
+	// Database is the SQLite file all scans are stored to,
+	// .scorpion/scans.db in the working directory by default
+	Database     string            `yaml:"database"`
+	Repositories []*ScheduleConfig `yaml:"repositories"`
+}
+
+// ScheduleConfig is a repository scanned on a schedule
+type ScheduleConfig struct {
+	// Root of the repository
+	Root string `yaml:"root"`
+	// Schedule is a cron expression ("0 */6 * * *") in local time
+	Schedule string `yaml:"schedule"`
+	// Config is the configuration of the scan, the one
+	// in the root by default. Its sinks send notifications
+	Config string `yaml:"config"`
+	// Ref is scanned without checkout instead of the worktree
+	Ref string `yaml:"ref"`
+}
+
+// KeywordsConfig configures recognition of TODO-like comments
+type KeywordsConfig struct {
+	// Lenient accepts keywords followed by a space or annotation
+	// without the colon ("TODO fix this"), strict form is preferred
+	Lenient bool `yaml:"lenient"`
+	// Custom keywords in addition to the built-in ones
+	Custom []*KeywordConfig `yaml:"custom"`
+	// Trailing finds comments following code on the same line
+	// ("x := 1 // TODO: ...") in known languages, true by default
+	Trailing *bool `yaml:"trailing"`
+}
+
+// KeywordConfig describes a custom keyword, e.g. a localized one,
+// or options of a built-in one
+type KeywordConfig struct {
+	Keyword string `yaml:"keyword"`
+	// Type of comments with the keyword, keyword itself if empty
+	Type string `yaml:"type"`
+	// CaseSensitive matches the keyword exactly ("Todo" is not a TODO)
+	CaseSensitive bool `yaml:"caseSensitive"`
+	// RequireColon requires ":" after the keyword, true by default
+	// unless in lenient mode
+	RequireColon *bool `yaml:"requireColon"`
+	// WordBoundary requires a non-word character after the keyword
+	// ("TODOLIST" is not a TODO), true by default
+	WordBoundary *bool `yaml:"wordBoundary"`
+}
+
+// EstimatesConfig configures parsing of time estimates
+type EstimatesConfig struct {
+	// TitlePatterns are regular expressions matched against the title,
+	// first capturing group is parsed as estimate and the match is
+	// removed from the title
+	TitlePatterns []string `yaml:"titlePatterns"`
+	// Defaults are estimates of comments without one by type
+	// (e.g. BUG: 4h), so they still count in totals
+	Defaults map[string]string `yaml:"defaults"`
+}
+
+// TitlesConfig configures post-processing of comment titles
+type TitlesConfig struct {
+	// JoinWrapped appends continuation lines of a title wrapped
+	// across several comment lines to the title instead of the body
+	JoinWrapped bool `yaml:"joinWrapped"`
+	// CollapseWhitespace replaces runs of spaces and tabs with a space
+	CollapseWhitespace bool `yaml:"collapseWhitespace"`
+	// StripPunctuation removes trailing ".,;:!?" so that "fix this!!"
+	// and "fix this" are the same title
+	StripPunctuation bool `yaml:"stripPunctuation"`
+	// Capitalize upper-cases the first letter
+	Capitalize bool `yaml:"capitalize"`
+	// Emoji strips leading emojis and gitmoji codes, or maps them to
+	// types with "map"
+	Emoji string `yaml:"emoji"`
+	// EmojiTypes are types of emojis besides the default ones, like
+	// "🔥": URGENT or ":bug:": BUG
+	EmojiTypes map[string]string `yaml:"emojiTypes"`
+}
+
+// ClassifyConfig sets type and category of comments with titles
+// matching the regular expression, the first matching rule applies
+type ClassifyConfig struct {
+	// Match is the regular expression of titles, e.g. "(?i)security"
+	Match string `yaml:"match"`
+	// Types limit the rule to comments of these types, all if empty
+	Types []string `yaml:"types"`
+	// Type replaces the type and may be a new one like SECURITY
+	Type string `yaml:"type"`
+	// Category is set unless the comment has category= already
+	Category string `yaml:"category"`
+}
+
+// BodiesConfig configures capturing of comment bodies
+type BodiesConfig struct {
+	// MaxLines truncates bodies longer than this, no limit if zero
+	MaxLines int `yaml:"maxLines"`
+	// MaxLength truncates bodies with more characters, no limit if zero
+	MaxLength int `yaml:"maxLength"`
+	// StopAtBlank ends body at the first blank comment line
+	StopAtBlank bool `yaml:"stopAtBlank"`
+}
+
+// LimitsConfig caps what a single file contributes to the report
+type LimitsConfig struct {
+	// CommentsPerFile keeps the first comments of files with more,
+	// 1000 by default and no limit if zero
+	CommentsPerFile *int `yaml:"commentsPerFile"`
+}
+
+// TemplateConfig selects files with comments of the template engine
+type TemplateConfig struct {
+	// Engine is php, erb, jinja, go or handlebars, or a custom name
+	// if comments are configured
+	Engine string `yaml:"engine"`
+	// Patterns are regular expressions matched against the path
+	Patterns []string `yaml:"patterns"`
+	// Comments are extra comment markers of the engine
+	Comments []*CommentMarkerConfig `yaml:"comments"`
+}
+
+// CommentMarkerConfig describes comments between start and end
+type CommentMarkerConfig struct {
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+}
+
+// GeneratedConfig configures exclusion of generated files
+type GeneratedConfig struct {
+	// Include scans generated files too
+	Include bool `yaml:"include"`
+	// Patterns are extra regular expressions matched against the
+	// path, in addition to the default ones (.pb.go, lockfiles, etc.)
+	Patterns []string `yaml:"patterns"`
+	// Attributes uses linguist-generated of .gitattributes files
+	// over the patterns and headers, true by default
+	Attributes *bool `yaml:"attributes"`
+}
+
+// VendorConfig configures exclusion of third-party code
+type VendorConfig struct {
+	// Include reports comments in vendor directories
+	Include bool `yaml:"include"`
+	// Dirs are names of vendor directories at any depth,
+	// vendor, node_modules and third_party if empty
+	Dirs []string `yaml:"dirs"`
+	// Attributes uses linguist-vendored of .gitattributes files
+	// over the directories, true by default
+	Attributes *bool `yaml:"attributes"`
+	// Count reads files of vendor directories to count their comments,
+	// true by default. Otherwise the directories are not walked at all
+	Count *bool `yaml:"count"`
+}
+
+// HiddenConfig configures scanning of files and directories
+// which names start with a dot
+type HiddenConfig struct {
+	// Mode is default (all except Skip), all or none,
+	// --hidden takes precedence
+	Mode string `yaml:"mode"`
+	// Skip are names of hidden files and directories skipped by
+	// default mode, tool caches and editor settings if empty
+	Skip []string `yaml:"skip"`
+	// Include are names removed from Skip
+	Include []string `yaml:"include"`
+}
+
+// TranslateConfig configures translation of comment titles
+type TranslateConfig struct {
+	// Command reads title from stdin and prints its translation,
+	// languages are passed in SCORPION_FROM and SCORPION_TO
+	Command string `yaml:"command"`
+	// Target language, "en" if empty
+	Target string `yaml:"target"`
+}
+
+// AnonymizeConfig configures hashing of reports shared externally
+type AnonymizeConfig struct {
+	// Salt is prepended to hashed values so they cannot be guessed
+	Salt string `yaml:"salt"`
+	// KeepExtension keeps extensions of hashed file paths
+	KeepExtension bool `yaml:"keepExtension"`
+	// KeepTopLevelDir keeps first directory of hashed file paths
+	KeepTopLevelDir bool `yaml:"keepTopLevelDir"`
+	// StripTitles removes titles of the comments too
+	StripTitles bool `yaml:"stripTitles"`
+}
+
+// AnnotateConfig selects files that get a header with debt summary
+type AnnotateConfig struct {
+	// MinComments in the file to annotate it, 10 if zero
+	MinComments int `yaml:"minComments"`
+	// MinHours of estimated debt to annotate the file, not checked if zero
+	MinHours float64 `yaml:"minHours"`
+}
+
+// GateConfig configures checks of "scorpion gate"
+type GateConfig struct {
+	Lint LintConfig `yaml:"lint"`
+	// Rules report comments matching their expressions
+	Rules []*RuleConfig `yaml:"rules"`
+	OPA   OPAConfig     `yaml:"opa"`
+	// Security fails the gate on SECURITY comments, true by default
+	Security *bool `yaml:"security"`
+	// Budgets limit debt per category, type or owner
+	Budgets []*BudgetConfig `yaml:"budgets"`
+	// BudgetFile adds budgets committed in the repository, relative
+	// to the root, debt-budget.yml by default
+	BudgetFile string `yaml:"budgetFile"`
+	// Store is directory of scans saved by the store sink,
+	// the latest one is the baseline of budget trends
+	Store string `yaml:"store"`
+}
+
+// BudgetConfig limits debt of the comments it selects,
+// zero values of the selectors match everything
+type BudgetConfig struct {
+	Name       string   `yaml:"name"`
+	Types      []string `yaml:"types"`
+	Categories []string `yaml:"categories"`
+	Path       string   `yaml:"path"`
+	// Owner is the assignee or the blame author (requires --blame)
+	Owner string `yaml:"owner"`
+	// MaxCount and MaxHours are not checked if zero
+	MaxCount int     `yaml:"maxCount"`
+	MaxHours float64 `yaml:"maxHours"`
+	// Tolerance is allowed growth of count since the baseline,
+	// trend is not checked if not set
+	Tolerance *int `yaml:"tolerance"`
+	// ToleranceHours is allowed growth of estimates since the baseline
+	ToleranceHours *float64 `yaml:"toleranceHours"`
+}
+
+// OPAConfig configures evaluation of Rego policies with opa binary
+type OPAConfig struct {
+	// Policies are files or directories passed to "opa eval --data"
+	Policies []string `yaml:"policies"`
+	// Query returning the deny set, "data.scorpion.deny" by default
+	Query string `yaml:"query"`
+	// Binary is path to opa, found in PATH by default
+	Binary string `yaml:"binary"`
+}
+
+// RuleConfig is a user-defined gate rule
+type RuleConfig struct {
+	Name string `yaml:"name"`
+	// When is an expression like `type == "HACK" && ageDays > 90`
+	When string `yaml:"when"`
+	// Message of the violation, the expression itself if empty
+	Message string `yaml:"message"`
+}
+
+// LintConfig configures quality check of comment titles
+type LintConfig struct {
+	// Enabled runs the check, same as --lint
+	Enabled bool `yaml:"enabled"`
+	// MinScore of acceptable titles from 0 to 1, 0.5 if zero
+	MinScore float64 `yaml:"minScore"`
+	// Vague titles in addition to the built-in ones ("fix this", "temp")
+	Vague []string `yaml:"vague"`
+}
+
+// NewConfig creates default configuration
+func NewConfig() *Config {
+	return &Config{
+		Secrets: make(map[string]string),
+	}
+}
+
+// loadConfig reads configuration from path or, if path is empty,
+// from SCORPION_CONFIG_YAML or the default config file in the source
+// root when it exists
+func loadConfig(path, root string) (*Config, error) {
+	config := NewConfig()
+	if data := os.Getenv(configEnv); len(path) == 0 && len(data) > 0 {
+		if err := yaml.UnmarshalStrict([]byte(data), config); err != nil {
+			return nil, fmt.Errorf("%v: %v", configEnv, err)
+		}
+		log.Printf("Loaded config from %v", configEnv)
+		return config, nil
+	}
+	if len(path) == 0 {
+		path = filepath.Join(root, defaultConfigName)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return config, nil
+		}
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, err
+	}
+	log.Printf("Loaded config from %v", path)
+	return config, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// teamMetadataKey is the property selecting the rate of the team
+const teamMetadataKey = "team"
+
+// CostConfig converts estimates to money, e.g. for leadership reports
+type CostConfig struct {
+	// Currency is printed after amounts ("EUR")
+	Currency string `yaml:"currency"`
+	// Rate is the cost of an hour
+	Rate float64 `yaml:"rate"`
+	// Categories are rates of comment categories
+	Categories map[string]float64 `yaml:"categories"`
+	// Teams are rates of teams set with team= in comment properties,
+	// they take precedence over categories
+	Teams map[string]float64 `yaml:"teams"`
+}
+
+// costModel computes costs of comments, nil if no rate is configured
+type costModel struct {
+	currency   string
+	rate       float64
+	categories map[string]float64
+	teams      map[string]float64
+}
+
+func newCostModel(cc *CostConfig) (*costModel, error) {
+	if cc.Rate == 0 && len(cc.Categories) == 0 && len(cc.Teams) == 0 {
+		return nil, nil
+	}
+	m := &costModel{
+		currency:   cc.Currency,
+		rate:       cc.Rate,
+		categories: make(map[string]float64),
+		teams:      make(map[string]float64),
+	}
+	if cc.Rate < 0 {
+		return nil, fmt.Errorf("Negative cost rate: %v", cc.Rate)
+	}
+	for category, rate := range cc.Categories {
+		if rate < 0 {
+			return nil, fmt.Errorf("Negative cost rate of category %v: %v", category, rate)
+		}
+		m.categories[strings.ToLower(category)] = rate
+	}
+	for team, rate := range cc.Teams {
+		if rate < 0 {
+			return nil, fmt.Errorf("Negative cost rate of team %v: %v", team, rate)
+		}
+		m.teams[strings.ToLower(team)] = rate
+	}
+	return m, nil
+}
+
+// Cost returns cost of the estimate of the comment
+func (m *costModel) Cost(c *ToDoComment) float64 {
+	if m == nil {
+		return 0
+	}
+	if rate, ok := m.teams[strings.ToLower(c.Metadata[teamMetadataKey])]; ok {
+		return rate * c.Estimate
+	}
+	if rate, ok := m.categories[strings.ToLower(c.Category)]; ok {
+		return rate * c.Estimate
+	}
+	return m.rate * c.Estimate
+}
+
+// total returns cost of the comments
+func (m *costModel) total(comments []*ToDoComment) float64 {
+	total := 0.0
+	for _, c := range comments {
+		total += m.Cost(c)
+	}
+	return total
+}
+
+// Format returns the amount in the currency of the model
+func (m *costModel) Format(amount float64) string {
+	if m == nil {
+		return formatCost(amount, "")
+	}
+	return formatCost(amount, m.currency)
+}
+
+// apply sets costs of the stats of the comments
+func (m *costModel) apply(stats *Stats, comments []*ToDoComment) {
+	if m == nil || stats == nil {
+		return
+	}
+	stats.Currency = m.currency
+	stats.Cost = 0
+	for _, ls := range stats.Languages {
+		ls.Cost = 0
+	}
+	for _, c := range comments {
+		cost := m.Cost(c)
+		stats.Cost += cost
+		if ls, ok := stats.Languages[c.Language]; ok {
+			ls.Cost += cost
+		}
+	}
+}
+
+// formatCost returns the amount rounded to whole units of the currency
+func formatCost(amount float64, currency string) string {
+	return strings.TrimSpace(fmt.Sprintf("%.0f %v", amount, currency))
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronMaxYears limits the search of the next run of schedules
+// matching only rare dates ("0 0 29 2 *")
+const cronMaxYears = 5
+
+var (
+	// cronMacros are shortcuts of common schedules
+	cronMacros = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// cronSchedule is a parsed cron expression, fields are bit sets
+// of minutes, hours, days of month, months and days of week
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// days match both fields if either is "*" and any of them
+	// otherwise, as in cron
+	anyDom, anyDow bool
+}
+
+// parseCron parses the standard five fields "minute hour day month
+// weekday" with lists, ranges and steps, or a macro ("@daily")
+func parseCron(expr string) (*cronSchedule, error) {
+	spec := strings.TrimSpace(expr)
+	if macro, ok := cronMacros[spec]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Invalid schedule %q: expected 5 fields", expr)
+	}
+	s := &cronSchedule{anyDom: fields[2] == "*", anyDow: fields[4] == "*"}
+	bounds := [...][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	sets := [...]*uint64{&s.minute, &s.hour, &s.dom, &s.month, &s.dow}
+	for i, field := range fields {
+		set, err := parseCronField(field, bounds[i][0], bounds[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid schedule %q: %v", expr, err)
+		}
+		*sets[i] = set
+	}
+	// Sunday is both 0 and 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	if s.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("Invalid schedule %q: it never runs", expr)
+	}
+	return s, nil
+}
+
+// parseCronField returns bit set of values of the comma-separated
+// list of "*", "n", "a-b" followed by an optional "/step"
+func parseCronField(field string, min, max int) (uint64, error) {
+	var set uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			n, err := strconv.Atoi(part[i+1:])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid step in %v", part)
+			}
+			part, step = part[:i], n
+		}
+		from, to := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			a, errA := strconv.Atoi(bounds[0])
+			b, errB := strconv.Atoi(bounds[1])
+			if errA != nil || errB != nil || a > b {
+				return 0, fmt.Errorf("invalid range %v", part)
+			}
+			from, to = a, b
+		default:
+			n, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, fmt.Errorf("invalid value %v", part)
+			}
+			from = n
+			if step == 1 {
+				to = n
+			}
+		}
+		if from < min || to > max {
+			return 0, fmt.Errorf("%v is out of range %v-%v", part, min, max)
+		}
+		for v := from; v <= to; v += step {
+			set |= 1 << uint(v)
+		}
+	}
+	return set, nil
+}
+
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.anyDom || s.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first time after t the schedule runs at in the
+// location of t, zero if it does not run in the next years
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(cronMaxYears, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchesDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+)
+
+var (
+	errNoSchedules = errors.New("No repositories to scan, configure daemon.repositories")
+)
+
+// daemonJob is a repository with the time of its next scan
+type daemonJob struct {
+	repo     *ScheduleConfig
+	schedule *cronSchedule
+	next     time.Time
+}
+
+// daemonSchedule is the database and jobs of the daemon config
+type daemonSchedule struct {
+	database string
+	jobs     []*daemonJob
+}
+
+func newDaemonSchedule(config *Config) (*daemonSchedule, error) {
+	dc := &config.Daemon
+	if len(dc.Repositories) == 0 {
+		return nil, errNoSchedules
+	}
+	database := dc.Database
+	if len(database) == 0 {
+		database = defaultSQLiteDatabase
+	}
+	database, err := filepath.Abs(database)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	jobs := make([]*daemonJob, 0, len(dc.Repositories))
+	for _, repo := range dc.Repositories {
+		schedule, err := parseCron(repo.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("Repository %v: %v", repo.Root, err)
+		}
+		jobs = append(jobs, &daemonJob{repo: repo, schedule: schedule, next: schedule.Next(now)})
+	}
+	return &daemonSchedule{database: database, jobs: jobs}, nil
+}
+
+// runDaemon scans the configured repositories on their schedules
+// until it is stopped, scans run one by one. Changes of the config
+// file replace the schedules, configs of repositories are loaded
+// for every scan anyway
+func runDaemon(args []string) error {
+	config, err := loadConfig(configPathFlag, srcRootFlag)
+	if err != nil {
+		return err
+	}
+	ds, err := newDaemonSchedule(config)
+	if err != nil {
+		return err
+	}
+	reloads := make(chan *daemonSchedule)
+	go newConfigWatcher(configPathFlag, srcRootFlag).Run(stopping, watchIntervalFlag, func(config *Config) error {
+		ds, err := newDaemonSchedule(config)
+		if err != nil {
+			return err
+		}
+		reloads <- ds
+		return nil
+	})
+	log.Printf("Scheduled scans of %v repositories to %v", len(ds.jobs), ds.database)
+	for {
+		database, jobs := ds.database, ds.jobs
+		next := jobs[0].next
+		for _, job := range jobs[1:] {
+			if job.next.Before(next) {
+				next = job.next
+			}
+		}
+		select {
+		case <-time.After(time.Until(next)):
+		case ds = <-reloads:
+			log.Printf("Scheduled scans of %v repositories to %v", len(ds.jobs), ds.database)
+			continue
+		case <-stopping:
+			log.Printf("Daemon stopped")
+			return nil
+		}
+		for _, job := range jobs {
+			if job.next.After(next) {
+				continue
+			}
+			if stopRequested() {
+				log.Printf("Daemon stopped")
+				return nil
+			}
+			start := time.Now()
+			trace := startTrace(appName + " scheduled scan")
+			trace.set("scorpion.root", job.repo.Root)
+			err := runScheduledScan(job.repo, database)
+			trace.finish(err)
+			flushTelemetry()
+			if err != nil {
+				log.Printf("Scan of %v failed: %v", job.repo.Root, err)
+			} else {
+				log.Printf("Scanned %v in %s", job.repo.Root, time.Since(start))
+			}
+			job.next = job.schedule.Next(time.Now())
+		}
+	}
+}
+
+// runScheduledScan scans the repository with its configuration,
+// stores the scan to the database and plans its sinks
+func runScheduledScan(repo *ScheduleConfig, database string) error {
+	root, err := filepath.Abs(repo.Root)
+	if err != nil {
+		return err
+	}
+	// the scan is configured with flags of a single repository
+	srcRootFlag, refFlag, scanPaths = root, repo.Ref, nil
+	config, err := loadConfig(repo.Config, root)
+	if err != nil {
+		return err
+	}
+	env := NewEnvironment(root)
+	report, err := buildReport(&config.Pipeline, config, env)
+	if err != nil {
+		return err
+	}
+	plan := NewPlan(root)
+	plan.Secrets = NewSecrets(config)
+	// default sinks would write TODO.md to the repository
+	sinks := append([]*SinkConfig{{Sink: sqliteSinkName, Path: database}}, config.Pipeline.Sinks...)
+	if err := planSinks(sinks, report, env, plan); err != nil {
+		return err
+	}
+	return executePlan(plan)
+}
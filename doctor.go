@@ -0,0 +1,294 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+
+	"github.com/karrick/godirwalk"
+)
+
+const (
+	// doctorMaxWalkErrors limits number of reported unreadable paths
+	doctorMaxWalkErrors = 10
+)
+
+var (
+	errDoctorFailed = errors.New("Some checks failed")
+)
+
+// doctor runs setup checks and prints their results
+type doctor struct {
+	out      io.Writer
+	failures int
+}
+
+func (d *doctor) ok(check, format string, args ...interface{}) {
+	fmt.Fprintf(d.out, "ok    %-10v %v\n", check, fmt.Sprintf(format, args...))
+}
+
+func (d *doctor) warn(check, format string, args ...interface{}) {
+	fmt.Fprintf(d.out, "warn  %-10v %v\n", check, fmt.Sprintf(format, args...))
+}
+
+func (d *doctor) fail(check string, err error) {
+	d.failures++
+	fmt.Fprintf(d.out, "FAIL  %-10v %v\n", check, err)
+}
+
+// validateConfig returns problems of everything in the config that
+// is otherwise only checked when used
+func validateConfig(config *Config) []error {
+	var errs []error
+	patterns := append([]string{}, includePatternsFlag...)
+	patterns = append(patterns, config.Estimates.TitlePatterns...)
+	patterns = append(patterns, config.Generated.Patterns...)
+	for _, tc := range config.Templates {
+		patterns = append(patterns, tc.Patterns...)
+		if _, err := newTemplateEngine(tc); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if _, err := parseDefaultEstimates(config.Estimates.Defaults); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newHiddenPolicy(&config.Hidden, hiddenFlag); err != nil {
+		errs = append(errs, err)
+	}
+	for _, p := range patterns {
+		if _, err := regexp.Compile(p); err != nil {
+			errs = append(errs, fmt.Errorf("Invalid pattern %v: %v", p, err))
+		}
+	}
+	pc := &config.Pipeline
+	if kind := pc.Source.Kind; len(kind) > 0 {
+		if _, ok := sources[kind]; !ok {
+			errs = append(errs, fmt.Errorf("Unknown source: %v", kind))
+		}
+	}
+	for _, fc := range pc.Filters {
+		if _, err := fc.Filter(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, tc := range pc.Transformers {
+		if _, ok := transformers[tc.Transformer]; !ok {
+			errs = append(errs, fmt.Errorf("Unknown transformer: %v", tc.Transformer))
+		} else if err := sortComments(nil, tc.Sort); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(pc.Sinks) == 0 {
+		if _, err := defaultSinks(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	usesSQLite := len(config.Daemon.Repositories) > 0
+	for _, sc := range pc.Sinks {
+		if _, ok := sinkFactories[sc.Sink]; !ok {
+			errs = append(errs, fmt.Errorf("Unknown sink: %v", sc.Sink))
+		}
+		if _, err := compileFieldMapping(sc.Sink, sc.Fields); err != nil {
+			errs = append(errs, err)
+		}
+		usesSQLite = usesSQLite || sc.Sink == sqliteSinkName
+	}
+	for _, repo := range config.Daemon.Repositories {
+		if _, err := parseCron(repo.Schedule); err != nil {
+			errs = append(errs, fmt.Errorf("Repository %v: %v", repo.Root, err))
+		}
+	}
+	if usesSQLite {
+		bin := os.Getenv(sqliteBinEnv)
+		if len(bin) == 0 {
+			bin = defaultSQLite
+		}
+		if _, err := exec.LookPath(bin); err != nil {
+			errs = append(errs, fmt.Errorf("Cannot store scans to SQLite: %v", err))
+		}
+	}
+	if _, err := newAuthenticator(&config.Serve.Auth, NewSecrets(config)); err != nil {
+		errs = append(errs, err)
+	}
+	errs = append(errs, validateWebhooks(&config.Serve.Webhooks, NewSecrets(config))...)
+	if _, err := newHumanFormat(&config.Display); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newCostModel(&config.Cost); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := compileClassifyRules(config.Classify); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newEmojiMapper(&config.Titles); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newTheme(&config.Theme); err != nil {
+		errs = append(errs, err)
+	}
+	if err := checkSigning(&config.Signing); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := gateBudgets(config, srcRootFlag); err != nil {
+		errs = append(errs, err)
+	}
+	for _, rc := range config.Gate.Rules {
+		if _, err := compileRule(rc); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if oc := &config.Gate.OPA; len(oc.Policies) > 0 {
+		bin := oc.Binary
+		if len(bin) == 0 {
+			bin = defaultOPABin
+		}
+		if _, err := exec.LookPath(bin); err != nil {
+			errs = append(errs, fmt.Errorf("Cannot evaluate gate policies: %v", err))
+		}
+	}
+	return errs
+}
+
+// checkConfig loads the config and validates it
+func (d *doctor) checkConfig() *Config {
+	config, err := loadConfig(configPathFlag, srcRootFlag)
+	if err != nil {
+		d.fail("config", err)
+		return nil
+	}
+	for _, err := range validateConfig(config) {
+		d.fail("config", err)
+	}
+	if d.failures == 0 {
+		d.ok("config", "configuration is valid")
+	}
+	return config
+}
+
+// checkGit verifies git is available and the remote can be parsed
+func (d *doctor) checkGit(env *Environment) {
+	path, err := exec.LookPath("git")
+	if err != nil {
+		d.fail("git", err)
+		return
+	}
+	d.ok("git", "found %v", path)
+	top := gitTopLevel(env.root)
+	if len(top) == 0 {
+		d.warn("git", "%v is not inside a git repository, git metadata will be empty", env.root)
+		return
+	}
+	d.ok("git", "repository %v on branch %v", top, env.Branch())
+	if url := env.WebURL(); len(url) > 0 {
+		d.ok("remote", "%v is %v", remoteFlag, url)
+	} else {
+		d.warn("remote", "cannot parse %v remote, permalinks and trackers need repo in the config", remoteFlag)
+	}
+}
+
+// checkTrackers verifies credentials of configured sinks, for GitHub
+// with a read-only request of the repository
+func (d *doctor) checkTrackers(config *Config, env *Environment) {
+	secrets := NewSecrets(config)
+	for _, sc := range config.Pipeline.Sinks {
+		switch sc.Sink {
+		case githubSinkName:
+			repo := sc.Repo
+			if len(repo) == 0 {
+				repo = githubRepo(env)
+			}
+			if len(repo) == 0 {
+				d.fail("github", errNoGitHubRepo)
+				continue
+			}
+			token, err := secrets.Get(githubSecret)
+			if err != nil {
+				d.fail("github", err)
+				continue
+			}
+			endpoint := fmt.Sprintf("%v/repos/%v", githubAPI, repo)
+			if err := doJSONRequest("GET", endpoint, githubHeaders(token), nil, nil); err != nil {
+				d.fail("github", fmt.Errorf("Cannot access %v: %v", repo, err))
+				continue
+			}
+			d.ok("github", "token can access %v", repo)
+		case slackSinkName:
+			if _, err := secrets.Get(slackSecret); err != nil {
+				d.fail("slack", err)
+				continue
+			}
+			// webhooks have no read-only call to verify them
+			d.ok("slack", "webhook is configured")
+		}
+	}
+}
+
+// checkWalker reports files and directories that cannot be read
+func (d *doctor) checkWalker(root string) {
+	failed := 0
+	files := 0
+	report := func(err error) {
+		failed++
+		if failed <= doctorMaxWalkErrors {
+			d.fail("walker", err)
+		}
+	}
+	err := godirwalk.Walk(root, &godirwalk.Options{
+		Callback: func(osPathname string, de *godirwalk.Dirent) error {
+			if de.IsDir() {
+				if filepath.Base(osPathname) == ".git" {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !de.IsRegular() {
+				return nil
+			}
+			files++
+			f, err := os.Open(osPathname)
+			if err != nil {
+				report(err)
+				return nil
+			}
+			return f.Close()
+		},
+		ErrorCallback: func(osPathname string, err error) godirwalk.ErrorAction {
+			report(err)
+			return godirwalk.SkipNode
+		},
+		Unsorted: true,
+	})
+	if err != nil {
+		d.fail("walker", err)
+		return
+	}
+	if failed > doctorMaxWalkErrors {
+		d.warn("walker", "%v more unreadable paths", failed-doctorMaxWalkErrors)
+	}
+	if failed == 0 {
+		d.ok("walker", "%v files are readable", files)
+	}
+}
+
+// runDoctor implements "scorpion doctor"
+func runDoctor(args []string) error {
+	if err := resolveScanPaths(args); err != nil {
+		return err
+	}
+	d := &doctor{out: os.Stdout}
+	env := NewEnvironment(srcRootFlag)
+	config := d.checkConfig()
+	d.checkGit(env)
+	if config != nil {
+		d.checkTrackers(config, env)
+	}
+	d.checkWalker(srcRootFlag)
+	if d.failures > 0 {
+		return fmt.Errorf("%v: %v", errDoctorFailed, d.failures)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	duplicatesTransformerName = "duplicates"
+	// defaultSimilarity is the minimal estimated Jaccard similarity
+	// of shingles of near-duplicate comments
+	defaultSimilarity = 0.8
+	// minHashes is length of MinHash signatures, split in bands of
+	// rows for locality-sensitive hashing of candidate pairs
+	minHashes     = 64
+	minHashBands  = 16
+	shingleLength = 3
+	// minDuplicateWords skips short comments like "fix this", which
+	// are similar without being copies
+	minDuplicateWords = 4
+)
+
+// duplicateWords returns normalized words of the title and the body
+func duplicateWords(c *ToDoComment) []string {
+	return textTerms(c.Title + " " + c.Body)
+}
+
+// splitmix64 derives hash functions of the signature from the index
+func splitmix64(x uint64) uint64 {
+	x += 0x9e3779b97f4a7c15
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// minHash returns the MinHash signature of shingles of the words
+func minHash(words []string) []uint64 {
+	signature := make([]uint64, minHashes)
+	for i := range signature {
+		signature[i] = ^uint64(0)
+	}
+	for i := 0; i+shingleLength <= len(words); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:i+shingleLength], " ")))
+		shingle := h.Sum64()
+		for j := range signature {
+			if v := splitmix64(shingle ^ uint64(j)*0x2545f4914f6cdd1d); v < signature[j] {
+				signature[j] = v
+			}
+		}
+	}
+	return signature
+}
+
+// similarity estimates Jaccard similarity of the signatures
+func similarity(a, b []uint64) float64 {
+	same := 0
+	for i := range a {
+		if a[i] == b[i] {
+			same++
+		}
+	}
+	return float64(same) / float64(len(a))
+}
+
+// findDuplicates returns clusters of near-duplicate comments, the
+// comments of every cluster are ordered by file and line
+func findDuplicates(comments []*ToDoComment, threshold float64) [][]*ToDoComment {
+	candidates := make([]*ToDoComment, 0, len(comments))
+	signatures := make([][]uint64, 0, len(comments))
+	for _, c := range comments {
+		if words := duplicateWords(c); len(words) >= minDuplicateWords {
+			candidates = append(candidates, c)
+			signatures = append(signatures, minHash(words))
+		}
+	}
+	parents := make([]int, len(candidates))
+	for i := range parents {
+		parents[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+		return parents[i]
+	}
+	rows := minHashes / minHashBands
+	for band := 0; band < minHashBands; band++ {
+		buckets := make(map[string][]int)
+		for i, s := range signatures {
+			key := fmt.Sprint(s[band*rows : (band+1)*rows])
+			buckets[key] = append(buckets[key], i)
+		}
+		for _, bucket := range buckets {
+			for _, j := range bucket[1:] {
+				if a, b := find(bucket[0]), find(j); a != b && similarity(signatures[bucket[0]], signatures[j]) >= threshold {
+					parents[b] = a
+				}
+			}
+		}
+	}
+	groups := make(map[int][]*ToDoComment)
+	for i, c := range candidates {
+		groups[find(i)] = append(groups[find(i)], c)
+	}
+	clusters := make([][]*ToDoComment, 0)
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		sort.Slice(group, func(i, j int) bool {
+			if group[i].File != group[j].File {
+				return group[i].File < group[j].File
+			}
+			return group[i].Line < group[j].Line
+		})
+		clusters = append(clusters, group)
+	}
+	sort.Slice(clusters, func(i, j int) bool {
+		if len(clusters[i]) != len(clusters[j]) {
+			return len(clusters[i]) > len(clusters[j])
+		}
+		return clusters[i][0].File < clusters[j][0].File
+	})
+	return clusters
+}
+
+// markDuplicates links copies of every cluster to its first comment
+// with DuplicateOf, the first one lists IDs of the copies
+func markDuplicates(clusters [][]*ToDoComment) {
+	for _, cluster := range clusters {
+		primary := cluster[0]
+		primary.Duplicates = make([]string, 0, len(cluster)-1)
+		for _, c := range cluster[1:] {
+			c.DuplicateOf = primary.ID
+			primary.Duplicates = append(primary.Duplicates, c.ID)
+		}
+	}
+}
+
+// duplicateLocations lists other copies of the comment for issue
+// descriptions, so that one issue covers all of them
+func duplicateLocations(c *ToDoComment, byID map[string]*ToDoComment) string {
+	if len(c.Duplicates) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("\n\nAlso in:")
+	for _, id := range c.Duplicates {
+		if d, ok := byID[id]; ok {
+			fmt.Fprintf(&sb, "\n- %v", commentLocation(d))
+		}
+	}
+	return sb.String()
+}
+
+func duplicatesTransformer(tc *TransformerConfig, config *Config, env *Environment, report *result) error {
+	threshold := tc.Threshold
+	if threshold == 0 {
+		threshold = defaultSimilarity
+	}
+	if threshold < 0 || threshold > 1 {
+		return fmt.Errorf("Threshold must be between 0 and 1: %v", threshold)
+	}
+	markDuplicates(findDuplicates(report.Comments, threshold))
+	return nil
+}
+
+func printDuplicates(w io.Writer, clusters [][]*ToDoComment, t *theme) error {
+	if verboseFlag {
+		js, err := json.MarshalIndent(clusters, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(js))
+		return err
+	}
+	for i, cluster := range clusters {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%v copies: %v: %v\n", len(cluster), t.Paint(cluster[0].Type, cluster[0].Type), cluster[0].Title)
+		for _, c := range cluster {
+			fmt.Fprintf(w, "  %v\n", commentLocation(c))
+		}
+	}
+	return nil
+}
+
+// runDuplicates implements "scorpion duplicates [paths]", it prints
+// clusters of copy-pasted comments
+func runDuplicates(args []string) error {
+	if similarityFlag <= 0 || similarityFlag > 1 {
+		return fmt.Errorf("Similarity must be between 0 and 1: %v", similarityFlag)
+	}
+	comments, report, err := commandComments(args)
+	if err != nil {
+		return err
+	}
+	return printDuplicates(os.Stdout, findDuplicates(comments, similarityFlag), report.theme)
+}
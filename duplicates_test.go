@@ -0,0 +1,30 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+// TestExactCopiesAreDuplicates checks that a comment copied to another
+// file is kept by the scan and clustered with the original
+func TestExactCopiesAreDuplicates(t *testing.T) {
+	td, err := NewToDoGenerator(os.TempDir(), nil, 0, 0, NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []string{"retry the request when the upstream service returns a timeout error"}
+	for _, file := range []string{"b.go", "a.go"} {
+		td.addComment(NewComment(file, 3, "TODO", body))
+	}
+	comments := td.collect()
+	if len(comments) != 2 {
+		t.Fatalf("Collected %v comments, expected both copies", len(comments))
+	}
+	clusters := findDuplicates(comments, defaultSimilarity)
+	if len(clusters) != 1 || len(clusters[0]) != 2 {
+		t.Fatalf("Found clusters %v, expected one of both copies", clusters)
+	}
+	if clusters[0][0].File != "a.go" {
+		t.Errorf("First comment of the cluster is in %v, expected a.go", clusters[0][0].File)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// isoDurationRegexp matches ISO 8601 durations of weeks, days, hours,
+// minutes and seconds ("PT4H30M", "P1DT2H"). Years and months have no
+// fixed length in working hours and are not supported
+var isoDurationRegexp = regexp.MustCompile(`^P(?:([0-9.,]+)W)?(?:([0-9.,]+)D)?(?:T(?:([0-9.,]+)H)?(?:([0-9.,]+)M)?(?:([0-9.,]+)S)?)?$`)
+
+// parseISODuration converts the duration to hours, a day is 8 hours
+// and a week is 5 days like in other estimates
+func parseISODuration(duration string) (float64, error) {
+	upper := strings.ToUpper(duration)
+	m := isoDurationRegexp.FindStringSubmatch(upper)
+	// "P", "PT" and "P1DT" miss components after the designators
+	if m == nil || strings.HasSuffix(upper, "T") || len(strings.Join(m[1:], "")) == 0 {
+		return 0, errCannotParseEstimate
+	}
+	units := []float64{hoursPerDay * daysPerWeek, hoursPerDay, 1, 1 / 60.0, 1 / 3600.0}
+	hours := 0.0
+	for i, part := range m[1:] {
+		if len(part) == 0 {
+			continue
+		}
+		// ISO 8601 allows both separators
+		f, err := strconv.ParseFloat(strings.Replace(part, ",", ".", 1), 64)
+		if err != nil {
+			return 0, errCannotParseEstimate
+		}
+		hours += f * units[i]
+	}
+	return hours, nil
+}
+
+// formatISODuration returns the estimate as ISO 8601 duration of hours,
+// minutes and seconds, which needs no conversion of working days
+func formatISODuration(hours float64) string {
+	seconds := int64(math.Round(hours * 3600))
+	if seconds <= 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("PT")
+	if h := seconds / 3600; h > 0 {
+		fmt.Fprintf(&sb, "%vH", h)
+	}
+	if m := seconds % 3600 / 60; m > 0 {
+		fmt.Fprintf(&sb, "%vM", m)
+	}
+	if s := seconds % 60; s > 0 {
+		fmt.Fprintf(&sb, "%vS", s)
+	}
+	return sb.String()
+}
+
+// MarshalJSON writes the estimate also as ISO 8601 duration for tools
+// exchanging them, e.g. Jira and Tempo
+func (t ToDoComment) MarshalJSON() ([]byte, error) {
+	type plain ToDoComment
+	t.EstimateISO = formatISODuration(t.Estimate)
+	return json.Marshal(plain(t))
+}
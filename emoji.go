@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	emojiStrip = "strip"
+	emojiMap   = "map"
+	// emojiVariation asks for the emoji presentation of the rune before it
+	emojiVariation = '\ufe0f'
+	emojiJoiner    = '\u200d'
+)
+
+var (
+	// defaultEmojiTypes are the emojis and gitmoji codes teams mark
+	// priorities with
+	defaultEmojiTypes = map[string]string{
+		"🔥": "URGENT", ":fire:": "URGENT",
+		"🚨": "URGENT", ":rotating_light:": "URGENT",
+		"🚑": "URGENT", ":ambulance:": "URGENT",
+		"🐛": "BUG", ":bug:": "BUG",
+		"🩹": "FIXME", ":adhesive_bandage:": "FIXME",
+		"💩": "HACK", ":poop:": "HACK",
+		"🚧": "TODO", ":construction:": "TODO",
+	}
+	gitmojiRegexp = regexp.MustCompile(`^:[a-z0-9_+-]+:`)
+)
+
+// emojiMapper strips leading emojis and gitmoji codes of titles and
+// maps them to types, a nil mapper keeps titles as they are
+type emojiMapper struct {
+	mapTypes bool
+	types    map[string]string
+}
+
+func newEmojiMapper(tc *TitlesConfig) (*emojiMapper, error) {
+	m := &emojiMapper{types: make(map[string]string)}
+	switch strings.ToLower(tc.Emoji) {
+	case "":
+		return nil, nil
+	case emojiStrip:
+	case emojiMap:
+		m.mapTypes = true
+	default:
+		return nil, fmt.Errorf("Unknown title emoji: %v, use strip or map", tc.Emoji)
+	}
+	for emoji, ctype := range defaultEmojiTypes {
+		m.types[emoji] = ctype
+	}
+	for emoji, ctype := range tc.EmojiTypes {
+		if len(leadingEmojis(emoji)) != 1 {
+			return nil, fmt.Errorf("Invalid emoji of %v: %v", ctype, emoji)
+		}
+		m.types[strings.Replace(emoji, string(emojiVariation), "", -1)] = strings.ToUpper(ctype)
+	}
+	return m, nil
+}
+
+// isEmoji checks if the rune is a pictograph, ASCII symbols like ^ are
+// not
+func isEmoji(r rune) bool {
+	return r >= utf8.RuneSelf && unicode.In(r, unicode.So, unicode.Sk)
+}
+
+// emojiLength returns the bytes of the emoji at the start of the text,
+// together with variation selectors, skin tones and joined emojis
+func emojiLength(text string) int {
+	r, size := utf8.DecodeRuneInString(text)
+	if !isEmoji(r) {
+		return 0
+	}
+	for size < len(text) {
+		next, n := utf8.DecodeRuneInString(text[size:])
+		switch {
+		case next == emojiVariation || (next >= 0x1f3fb && next <= 0x1f3ff):
+			size += n
+		case next == emojiJoiner:
+			size += n
+			if joined := emojiLength(text[size:]); joined > 0 {
+				size += joined
+				return size
+			}
+		default:
+			return size
+		}
+	}
+	return size
+}
+
+// leadingEmojis returns the emojis and gitmoji codes the text starts
+// with
+func leadingEmojis(text string) []string {
+	emojis := make([]string, 0)
+	rest := strings.TrimLeft(text, " \t")
+	for len(rest) > 0 {
+		n := len(gitmojiRegexp.FindString(rest))
+		if n == 0 {
+			n = emojiLength(rest)
+		}
+		if n == 0 {
+			break
+		}
+		emojis = append(emojis, rest[:n])
+		rest = strings.TrimLeft(rest[n:], " \t")
+	}
+	return emojis
+}
+
+// Apply strips leading emojis of the title, the first one with a type
+// sets the type of the comment when mapping, titles of nothing but
+// emojis are kept
+func (m *emojiMapper) Apply(c *ToDoComment) {
+	if m == nil {
+		return
+	}
+	emojis := leadingEmojis(c.Title)
+	if len(emojis) == 0 {
+		return
+	}
+	rest := strings.TrimLeft(c.Title, " \t")
+	for _, emoji := range emojis {
+		rest = strings.TrimLeft(strings.TrimPrefix(rest, emoji), " \t")
+	}
+	if len(rest) == 0 {
+		return
+	}
+	c.Title = rest
+	if !m.mapTypes {
+		return
+	}
+	for _, emoji := range emojis {
+		if ctype, ok := m.types[strings.Replace(emoji, string(emojiVariation), "", -1)]; ok {
+			c.Type = ctype
+			return
+		}
+	}
+}
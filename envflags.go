@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+const (
+	// flagEnvPrefix prefixes environment variables of flags,
+	// e.g. SCORPION_MIN_CHARS sets --min-chars
+	flagEnvPrefix = "SCORPION_"
+	// configEnv contains yaml of the configuration used
+	// instead of the file when --config is not given
+	configEnv = "SCORPION_CONFIG_YAML"
+)
+
+// flagEnvName returns environment variable of the flag
+func flagEnvName(name string) string {
+	return flagEnvPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+// flagsFromEnv sets flags not given on the command line from their
+// environment variables, so that containers can be configured
+// without arguments. Lists are comma-separated
+func flagsFromEnv() error {
+	var err error
+	pflag.VisitAll(func(f *pflag.Flag) {
+		if err != nil || f.Changed {
+			return
+		}
+		value, ok := os.LookupEnv(flagEnvName(f.Name))
+		if !ok {
+			return
+		}
+		if setErr := pflag.CommandLine.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("Invalid %v: %v", flagEnvName(f.Name), setErr)
+		}
+	})
+	return err
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"log"
 	"os"
 	"os/exec"
@@ -10,19 +11,34 @@ import (
 	"sync"
 
 	"github.com/whilp/git-urls"
-	"gopkg.in/src-d/go-git.v4"
 	"gopkg.in/src-d/go-git.v4/plumbing"
 )
 
+var (
+	errNoRemote = errors.New("Repository has no such remote")
+)
+
 // Environment contains information about git repository
 type Environment struct {
-	root        string
-	branch      string
-	author      string
-	project     string
-	initBranch  sync.Once
-	initAuthor  sync.Once
-	initProject sync.Once
+	root     string
+	branch   string
+	revision string
+	author   string
+	project  string
+	prefix   string
+	webURL   string
+	// ref is scanned instead of the work tree if not empty
+	ref          string
+	hasGit       bool
+	nativeGit    *nativeGit
+	initGit      sync.Once
+	initNative   sync.Once
+	initBranch   sync.Once
+	initRevision sync.Once
+	initAuthor   sync.Once
+	initProject  sync.Once
+	initPrefix   sync.Once
+	initWebURL   sync.Once
 }
 
 // NewEnvironment creates new instance of Environment struct
@@ -34,6 +50,7 @@ func NewEnvironment(root string) *Environment {
 	}
 	env := &Environment{
 		root: absolutePath,
+		ref:  refFlag,
 	}
 	go func() {
 		log.Printf("Current root is %v", env.root)
@@ -55,8 +72,26 @@ func sliceWithoutGitDir(slice []string) []string {
 	return newEnv
 }
 
-// Run executes a command in the environment's root
-func (env *Environment) Run(cmd string, arg ...string) string {
+// gitTopLevel returns top level directory of the git repository
+// containing dir or empty string if there is none
+func gitTopLevel(dir string) string {
+	if noGitFlag {
+		return ""
+	}
+	env := &Environment{root: dir}
+	if !hasGitBinary() {
+		return env.git("rev-parse", "--show-toplevel")
+	}
+	out, _, err := env.output("git", "rev-parse", "--show-toplevel")
+	if err != nil {
+		return ""
+	}
+	return out
+}
+
+// output executes a command in the environment's root
+// and returns its trimmed stdout and stderr
+func (env *Environment) output(cmd string, arg ...string) (string, string, error) {
 	command := exec.Command(cmd, arg...)
 	// setting working directory here breaks GIT_DIR variable
 	command.Dir = env.root
@@ -68,28 +103,115 @@ func (env *Environment) Run(cmd string, arg ...string) string {
 	command.Stderr = &stderr
 
 	err := command.Run()
+	return strings.TrimSpace(string(stdout.Bytes())), string(stderr.Bytes()), err
+}
+
+// Run executes a command in the environment's root
+func (env *Environment) Run(cmd string, arg ...string) string {
+	out, stderr, err := env.output(cmd, arg...)
 	if err != nil {
 		log.Printf("Command run error: %s", err)
-		log.Printf("Command stderr: %s", string(stderr.Bytes()))
+		log.Printf("Command stderr: %s", stderr)
 		return ""
 	}
+	return out
+}
 
-	outStr := string(stdout.Bytes())
-	return strings.TrimSpace(outStr)
+// HasGit checks if the root is inside a git work tree,
+// git metadata is empty otherwise or with --no-git
+func (env *Environment) HasGit() bool {
+	env.initGit.Do(func() {
+		if noGitFlag {
+			return
+		}
+		if !hasGitBinary() {
+			env.hasGit = env.native() != nil
+			return
+		}
+		// works in worktrees and bare repositories alike
+		_, _, err := env.output("git", "rev-parse", "--git-dir")
+		env.hasGit = err == nil
+		if !env.hasGit {
+			log.Printf("%v is not in a git repository, git metadata is disabled", env.root)
+		}
+	})
+	return env.hasGit
+}
+
+// git runs git command if git metadata is available
+func (env *Environment) git(arg ...string) string {
+	if !env.HasGit() {
+		return ""
+	}
+	if !hasGitBinary() {
+		out, err := env.native().run(arg...)
+		if err != nil {
+			log.Printf("Command run error: %s", err)
+		}
+		return out
+	}
+	return env.Run("git", arg...)
 }
 
 // Branch returns current git branch
 func (env *Environment) Branch() string {
 	env.initBranch.Do(func() {
-		env.branch = env.Run("git", "rev-parse", "--abbrev-ref", "HEAD")
+		env.branch = env.git("rev-parse", "--abbrev-ref", env.head())
 	})
 	return env.branch
 }
 
+// Revision returns abbreviated hash of the current git commit
+func (env *Environment) Revision() string {
+	env.initRevision.Do(func() {
+		env.revision = env.git("rev-parse", "--short", env.head())
+	})
+	return env.revision
+}
+
+// Commit returns full hash of the current git commit
+func (env *Environment) Commit() string {
+	return env.git("rev-parse", env.head())
+}
+
+// head returns the scanned ref, HEAD by default
+func (env *Environment) head() string {
+	if len(env.ref) > 0 {
+		return env.ref
+	}
+	return "HEAD"
+}
+
+// ScansTree checks if files are read from the git tree of the ref
+// instead of the work tree, always the case for bare repositories
+func (env *Environment) ScansTree() bool {
+	return len(env.ref) > 0 || env.IsBare()
+}
+
+// IsClean checks that worktree has no modified or untracked files
+func (env *Environment) IsClean() bool {
+	if !env.HasGit() {
+		return false
+	}
+	if env.ScansTree() {
+		// committed tree cannot be modified
+		return true
+	}
+	if !hasGitBinary() {
+		out, err := env.native().status()
+		return err == nil && len(out) == 0
+	}
+	command := exec.Command("git", "status", "--porcelain")
+	command.Dir = env.root
+	command.Env = sliceWithoutGitDir(os.Environ())
+	out, err := command.Output()
+	return err == nil && len(bytes.TrimSpace(out)) == 0
+}
+
 // Author returns current git author
 func (env *Environment) Author() string {
 	env.initAuthor.Do(func() {
-		env.author = env.Run("git", "config", "user.name")
+		env.author = env.git("config", "user.name")
 	})
 	return env.author
 }
@@ -97,12 +219,23 @@ func (env *Environment) Author() string {
 // Project returns current git project name
 func (env *Environment) Project() string {
 	env.initProject.Do(func() {
-		project := env.Run("git", "rev-parse", "--show-toplevel")
+		project := env.git("rev-parse", "--show-toplevel")
+		if len(project) == 0 {
+			project = env.root
+		}
 		env.project = filepath.Base(project)
 	})
 	return env.project
 }
 
+// Prefix returns path of the root relative to the repository top level
+func (env *Environment) Prefix() string {
+	env.initPrefix.Do(func() {
+		env.prefix = env.git("rev-parse", "--show-prefix")
+	})
+	return env.prefix
+}
+
 // RefBranchName returns the branch name of a reference.
 // It assumes that the ref has a branch type.
 func refBranchName(ref *plumbing.Reference) string {
@@ -121,7 +254,7 @@ func getRemoteURLPath(path string) (string, error) {
 		path = "."
 	}
 	// We instantiate a new repository targeting the given path (the .git folder)
-	r, err := git.PlainOpen(path)
+	r, err := openRepository(path)
 	if err != nil {
 		return "", err
 	}
@@ -129,7 +262,11 @@ func getRemoteURLPath(path string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	g, err := giturls.Parse(cfg.Remotes["origin"].URLs[0])
+	remote, ok := cfg.Remotes[remoteFlag]
+	if !ok || len(remote.URLs) == 0 {
+		return "", errNoRemote
+	}
+	g, err := giturls.Parse(remote.URLs[0])
 	if err != nil {
 		return "", err
 	}
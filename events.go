@@ -0,0 +1,141 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+)
+
+const (
+	eventAdded   = "added"
+	eventRemoved = "removed"
+	eventScan    = "scan"
+
+	subscriberBuffer = 64
+)
+
+// ScanEvent is pushed to subscribers when comments change
+type ScanEvent struct {
+	Kind    string       `json:"kind"`
+	Comment *ToDoComment `json:"comment,omitempty"`
+	Total   int          `json:"total,omitempty"`
+}
+
+// commentKey identifies a comment across scans regardless of its line
+func commentKey(c *ToDoComment) string {
+	h := md5.New()
+	io.WriteString(h, c.Type)
+	io.WriteString(h, c.File)
+	io.WriteString(h, c.Title)
+	io.WriteString(h, c.Body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// diffComments returns events for comments added and removed between scans
+func diffComments(before, after []*ToDoComment) []*ScanEvent {
+	events := make([]*ScanEvent, 0)
+	beforeKeys := make(map[string]bool, len(before))
+	for _, c := range before {
+		beforeKeys[commentKey(c)] = true
+	}
+	afterKeys := make(map[string]bool, len(after))
+	for _, c := range after {
+		key := commentKey(c)
+		afterKeys[key] = true
+		if !beforeKeys[key] {
+			events = append(events, &ScanEvent{Kind: eventAdded, Comment: c})
+		}
+	}
+	for _, c := range before {
+		if !afterKeys[commentKey(c)] {
+			events = append(events, &ScanEvent{Kind: eventRemoved, Comment: c})
+		}
+	}
+	return events
+}
+
+// Broker fans out scan events to subscribed clients
+type Broker struct {
+	mux         sync.Mutex
+	subscribers map[chan *ScanEvent]bool
+}
+
+// NewBroker creates broker without subscribers
+func NewBroker() *Broker {
+	return &Broker{
+		subscribers: make(map[chan *ScanEvent]bool),
+	}
+}
+
+// Subscribe returns channel receiving all future events
+func (b *Broker) Subscribe() chan *ScanEvent {
+	ch := make(chan *ScanEvent, subscriberBuffer)
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.subscribers[ch] = true
+	return ch
+}
+
+// Unsubscribe stops delivery of events to the channel
+func (b *Broker) Unsubscribe(ch chan *ScanEvent) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.subscribers[ch] {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Publish sends events to all subscribers, slow subscribers
+// that have their buffer full miss the events
+func (b *Broker) Publish(events []*ScanEvent) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	for ch := range b.subscribers {
+		for _, e := range events {
+			select {
+			case ch <- e:
+			default:
+				log.Printf("Dropping %v event for slow subscriber", e.Kind)
+			}
+		}
+	}
+}
+
+// ServeHTTP streams events to the client using server-sent events
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+
+	ch := b.Subscribe()
+	defer b.Unsubscribe(ch)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(e)
+			if err != nil {
+				log.Printf("Error encoding event: %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %v\ndata: %s\n\n", e.Kind, data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// trackerFields are fields of issues each tracker sink can map
+var trackerFields = map[string][]string{
+	githubSinkName: {"labels", "assignees"},
+}
+
+// fieldMapping sets tracker fields from comment fields. Values are
+// templates with placeholders of rule fields, e.g. "area/{metadata.component}"
+// or "{type|lower}". Values with an empty placeholder are dropped, so
+// comments without the field get no label
+type fieldMapping map[string][]string
+
+// compileFieldMapping checks the mapping of the sink, nil if there is none
+func compileFieldMapping(sink string, fields map[string][]string) (fieldMapping, error) {
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	supported, ok := trackerFields[sink]
+	if !ok {
+		return nil, fmt.Errorf("Sink %v has no tracker fields to map", sink)
+	}
+	for field, templates := range fields {
+		if !containsString(supported, field) {
+			return nil, fmt.Errorf("Unknown field %v of %v, use one of %v", field, sink, strings.Join(supported, ", "))
+		}
+		for _, t := range templates {
+			if _, _, err := expandFieldTemplate(t, nil, time.Time{}); err != nil {
+				return nil, fmt.Errorf("Field %v: %v", field, err)
+			}
+		}
+	}
+	return fieldMapping(fields), nil
+}
+
+// has checks if the tracker field is mapped instead of the defaults
+func (m fieldMapping) has(field string) bool {
+	_, ok := m[field]
+	return ok
+}
+
+// values returns non-empty values of the tracker field for the comment
+func (m fieldMapping) values(field string, c *ToDoComment) []string {
+	values := make([]string, 0, len(m[field]))
+	now := time.Now()
+	for _, t := range m[field] {
+		if v, ok, err := expandFieldTemplate(t, c, now); err == nil && ok {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// fieldString formats a rule field value, zero numbers are empty
+func fieldString(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case float64:
+		if value == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	}
+	return fmt.Sprint(v)
+}
+
+// expandFieldTemplate replaces placeholders with fields of the comment,
+// ok is false if one of them is empty. Without a comment it only
+// validates the template
+func expandFieldTemplate(template string, c *ToDoComment, now time.Time) (string, bool, error) {
+	var sb strings.Builder
+	ok := true
+	rest := template
+	for {
+		start := strings.Index(rest, "{")
+		if start < 0 {
+			sb.WriteString(rest)
+			break
+		}
+		end := strings.Index(rest[start:], "}")
+		if end < 0 {
+			return "", false, fmt.Errorf("Unterminated placeholder in %q", template)
+		}
+		sb.WriteString(rest[:start])
+		name := strings.TrimSpace(rest[start+1 : start+end])
+		rest = rest[start+end+1:]
+		modifier := ""
+		if i := strings.Index(name, "|"); i >= 0 {
+			name, modifier = strings.TrimSpace(name[:i]), strings.TrimSpace(name[i+1:])
+		}
+		if modifier != "" && modifier != "lower" && modifier != "upper" {
+			return "", false, fmt.Errorf("Unknown modifier %v in %q", modifier, template)
+		}
+		field, known := ruleFields[name]
+		if !known && !strings.HasPrefix(name, metadataFieldPrefix) {
+			return "", false, fmt.Errorf("%v: %v", errUnknownField, name)
+		}
+		if c == nil {
+			continue
+		}
+		var value string
+		if known {
+			value = fieldString(field(c, now))
+		} else {
+			value = c.Metadata[strings.TrimPrefix(name, metadataFieldPrefix)]
+		}
+		switch modifier {
+		case "lower":
+			value = strings.ToLower(value)
+		case "upper":
+			value = strings.ToUpper(value)
+		}
+		ok = ok && len(value) > 0
+		sb.WriteString(value)
+	}
+	return sb.String(), ok, nil
+}
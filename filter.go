@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errUnknownSortField = errors.New("Unknown sort field")
+)
+
+// CommentFilter selects comments by their properties,
+// zero values of the fields match everything
+type CommentFilter struct {
+	Types       []string
+	Categories  []string
+	PathPrefix  string
+	Assignee    string
+	MinAge      time.Duration
+	MinEstimate float64
+	// MinConfidence skips lenient matches with lower confidence
+	MinConfidence float64
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Match checks if the comment satisfies all conditions of the filter
+func (f *CommentFilter) Match(c *ToDoComment, now time.Time) bool {
+	if len(f.Types) > 0 && !containsFold(f.Types, c.Type) {
+		return false
+	}
+	if len(f.Categories) > 0 && !containsFold(f.Categories, c.Category) {
+		return false
+	}
+	if len(f.PathPrefix) > 0 && !strings.HasPrefix(c.File, f.PathPrefix) {
+		return false
+	}
+	if len(f.Assignee) > 0 && !strings.EqualFold(f.Assignee, c.Assignee) {
+		return false
+	}
+	if f.MinAge > 0 {
+		if c.Blame == nil || now.Sub(c.Blame.Date) < f.MinAge {
+			return false
+		}
+	}
+	if f.MinEstimate > 0 && c.Estimate < f.MinEstimate {
+		return false
+	}
+	if f.MinConfidence > 0 && c.confidence() < f.MinConfidence {
+		return false
+	}
+	return true
+}
+
+// Apply returns comments matching the filter
+func (f *CommentFilter) Apply(comments []*ToDoComment, now time.Time) []*ToDoComment {
+	filtered := make([]*ToDoComment, 0, len(comments))
+	for _, c := range comments {
+		if f.Match(c, now) {
+			filtered = append(filtered, c)
+		}
+	}
+	return filtered
+}
+
+func splitValues(values []string) []string {
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); len(s) > 0 {
+				result = append(result, s)
+			}
+		}
+	}
+	return result
+}
+
+// parseFilterQuery creates filter from url query parameters
+// (type, category, path, assignee, minAge, minEstimate, minConfidence)
+func parseFilterQuery(q url.Values) (*CommentFilter, error) {
+	f := &CommentFilter{
+		Types:      splitValues(q["type"]),
+		Categories: splitValues(q["category"]),
+		PathPrefix: q.Get("path"),
+		Assignee:   q.Get("assignee"),
+	}
+	if v := q.Get("minAge"); len(v) > 0 {
+		days, err := strconv.Atoi(strings.TrimSuffix(v, "d"))
+		if err != nil {
+			return nil, fmt.Errorf("Cannot parse minAge: %v", v)
+		}
+		f.MinAge = time.Duration(days) * 24 * time.Hour
+	}
+	if v := q.Get("minEstimate"); len(v) > 0 {
+		estimate, err := parseEstimate(v)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot parse minEstimate: %v", v)
+		}
+		f.MinEstimate = estimate
+	}
+	if v := q.Get("minConfidence"); len(v) > 0 {
+		confidence, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot parse minConfidence: %v", v)
+		}
+		f.MinConfidence = confidence
+	}
+	return f, nil
+}
+
+type commentLess func(a, b *ToDoComment) bool
+
+// typeSeverities ranks comment types for sorting by severity, other
+// types rank below TODO
+var typeSeverities = map[string]int{
+	"SECURITY":   7,
+	"URGENT":     6,
+	"BUG":        5,
+	"FIXME":      4,
+	"DEPRECATED": 3,
+	"HACK":       2,
+	"TODO":       1,
+}
+
+var commentSortFields = map[string]commentLess{
+	"file": func(a, b *ToDoComment) bool {
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	},
+	"type":     func(a, b *ToDoComment) bool { return a.Type < b.Type },
+	"category": func(a, b *ToDoComment) bool { return a.Category < b.Category },
+	"assignee": func(a, b *ToDoComment) bool { return a.Assignee < b.Assignee },
+	"estimate": func(a, b *ToDoComment) bool { return a.Estimate < b.Estimate },
+	// types of the same severity, e.g. custom ones, come by name with
+	// -severity
+	"severity": func(a, b *ToDoComment) bool {
+		sa, sb := typeSeverities[strings.ToUpper(a.Type)], typeSeverities[strings.ToUpper(b.Type)]
+		if sa != sb {
+			return sa < sb
+		}
+		return a.Type > b.Type
+	},
+	"age": func(a, b *ToDoComment) bool {
+		// younger comments have later commit date
+		return blameDate(a).After(blameDate(b))
+	},
+}
+
+func blameDate(c *ToDoComment) time.Time {
+	if c.Blame == nil {
+		return time.Time{}
+	}
+	return c.Blame.Date
+}
+
+// sortComments sorts comments by comma-separated fields, each one can
+// be prefixed with "-" for descending order; file and line are always
+// used as the last keys to keep the order stable
+func sortComments(comments []*ToDoComment, fields string) error {
+	keys := make([]commentLess, 0)
+	for _, field := range splitValues([]string{fields}) {
+		desc := strings.HasPrefix(field, "-")
+		less, ok := commentSortFields[strings.TrimPrefix(field, "-")]
+		if !ok {
+			return fmt.Errorf("%v: %v", errUnknownSortField, field)
+		}
+		if desc {
+			asc := less
+			less = func(a, b *ToDoComment) bool { return asc(b, a) }
+		}
+		keys = append(keys, less)
+	}
+	keys = append(keys, commentSortFields["file"])
+	sort.SliceStable(comments, func(i, j int) bool {
+		a, b := comments[i], comments[j]
+		for _, less := range keys {
+			if less(a, b) {
+				return true
+			}
+			if less(b, a) {
+				return false
+			}
+		}
+		return false
+	})
+	return nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSortBySeverity(t *testing.T) {
+	types := []string{"PERF", "TODO", "hack", "DEPRECATED", "XXX", "URGENT", "FIXME", "SECURITY", "BUG", "REFS"}
+	comments := make([]*ToDoComment, 0, len(types))
+	for i, ctype := range types {
+		comments = append(comments, &ToDoComment{Type: ctype, File: "a.go", Line: i})
+	}
+	if err := sortComments(comments, "-severity"); err != nil {
+		t.Fatal(err)
+	}
+	sorted := make([]string, 0, len(comments))
+	for _, c := range comments {
+		sorted = append(sorted, c.Type)
+	}
+	expected := "SECURITY URGENT BUG FIXME DEPRECATED hack TODO PERF REFS XXX"
+	if got := strings.Join(sorted, " "); got != expected {
+		t.Errorf("Sorted by severity: %v, expected %v", got, expected)
+	}
+}
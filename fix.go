@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+var (
+	errFixTree = errors.New("Files of a git ref cannot be edited, check it out first")
+)
+
+// normalizeToDoLine writes the keyword of TODO-like comment in its
+// configured case and adds the colon missing in lenient form,
+// other lines are returned unchanged
+func normalizeToDoLine(keywords *keywordSet, line string) string {
+	runes := []rune(line)
+	start := commentStart(runes)
+	if start < 0 {
+		return line
+	}
+	text := runes[start:]
+	m := matchToDo(keywords, text)
+	if m == nil {
+		return line
+	}
+	fixed := make([]rune, 0, len(runes)+1)
+	fixed = append(fixed, runes[:start]...)
+	fixed = append(fixed, m.kw.runes...)
+	fixed = append(fixed, text[len(m.kw.runes):m.end]...)
+	if !m.strict {
+		fixed = append(fixed, ':')
+	}
+	fixed = append(fixed, text[m.end:]...)
+	return string(fixed)
+}
+
+// planFixes adds edits of files normalizing lines of the comments
+func planFixes(keywords *keywordSet, root string, comments []*ToDoComment, plan *Plan) error {
+	files := make(map[string][]*ToDoComment)
+	for _, c := range comments {
+		files[c.File] = append(files[c.File], c)
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := filepath.Join(root, name)
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		lines := strings.Split(string(data), "\n")
+		fixed := 0
+		for _, c := range files[name] {
+			if c.Line >= len(lines) {
+				continue
+			}
+			if line := normalizeToDoLine(keywords, lines[c.Line]); line != lines[c.Line] {
+				lines[c.Line] = line
+				fixed++
+			}
+		}
+		if fixed == 0 {
+			continue
+		}
+		plan.Add(&PlanAction{
+			Kind:    planEditFile,
+			Target:  path,
+			Summary: fmt.Sprintf("normalize %v comments", fixed),
+			Content: strings.Join(lines, "\n"),
+		})
+	}
+	return nil
+}
+
+// runFix implements "scorpion fix" that normalizes keywords of comments
+// in place, as a plan with --dry-run or as a patch with --patch
+func runFix(args []string) error {
+	if err := resolveScanPaths(args); err != nil {
+		return err
+	}
+	config, err := loadConfig(configPathFlag, srcRootFlag)
+	if err != nil {
+		return err
+	}
+	env := NewEnvironment(srcRootFlag)
+	if env.ScansTree() {
+		return errFixTree
+	}
+	// lines must match the files, so the cached report is not used
+	report, err := generateReport(config, env)
+	if err != nil {
+		return err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	plan := NewPlan(wd)
+	keywords := newKeywords(&config.Keywords, lenientFlag)
+	if err := planFixes(keywords, report.Root, report.Comments, plan); err != nil {
+		return err
+	}
+	return executePlan(plan)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+var (
+	errGateFailed = errors.New("Gate failed")
+)
+
+// Violation is a problem found by a gate check
+type Violation struct {
+	Check   string       `json:"check"`
+	Message string       `json:"message"`
+	Comment *ToDoComment `json:"comment,omitempty"`
+}
+
+// gateCheck finds violations in the report
+type gateCheck func(report *result, config *Config, env *Environment) ([]*Violation, error)
+
+// gateChecks are run by "scorpion gate" in order
+var gateChecks = []gateCheck{
+	lintCheck,
+	rulesCheck,
+	opaCheck,
+	budgetsCheck,
+}
+
+// runGateChecks returns violations of all checks sorted by location
+func runGateChecks(report *result, config *Config, env *Environment) ([]*Violation, error) {
+	violations := make([]*Violation, 0)
+	for _, check := range gateChecks {
+		found, err := check(report, config, env)
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, found...)
+	}
+	sort.SliceStable(violations, func(i, j int) bool {
+		a, b := violations[i].Comment, violations[j].Comment
+		if a == nil || b == nil {
+			return a == nil && b != nil
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return violations, nil
+}
+
+// printViolations writes violations as json with verbose flag
+// or as "file:line: check: message" lines otherwise
+func printViolations(w io.Writer, violations []*Violation) error {
+	if verboseFlag {
+		js, err := json.MarshalIndent(violations, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(js))
+		return err
+	}
+	for _, v := range violations {
+		if v.Comment != nil {
+			fmt.Fprintf(w, "%v: ", commentLocation(v.Comment))
+		}
+		fmt.Fprintf(w, "%v: %v\n", v.Check, v.Message)
+	}
+	return nil
+}
+
+func hasBudgetViolations(violations []*Violation) bool {
+	for _, v := range violations {
+		if strings.HasPrefix(v.Check, budgetsCheckName+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// runGate implements "scorpion gate" that fails with non-zero
+// exit code if any of the checks found violations, with the table of
+// budgets if any of them is exceeded
+func runGate(args []string) error {
+	if err := resolveScanPaths(args); err != nil {
+		return err
+	}
+	config, err := loadConfig(configPathFlag, srcRootFlag)
+	if err != nil {
+		return err
+	}
+	env := NewEnvironment(srcRootFlag)
+	report, err := buildReport(&config.Pipeline, config, env)
+	if err != nil {
+		return err
+	}
+	violations, err := runGateChecks(report, config, env)
+	if err != nil {
+		return err
+	}
+	if err := printViolations(os.Stdout, violations); err != nil {
+		return err
+	}
+	if hasBudgetViolations(violations) {
+		fmt.Println()
+		if err := printBudgets(os.Stdout, report.budgets, report, violations); err != nil {
+			return err
+		}
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("%v: %v violations", errGateFailed, len(violations))
+	}
+	fmt.Fprintln(os.Stderr, "Gate passed")
+	return nil
+}
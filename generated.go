@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+const (
+	// generatedHeaderLines is how many first lines are checked for the marker
+	generatedHeaderLines = 10
+)
+
+var (
+	// defaultGeneratedPatterns match paths of generated files and lockfiles
+	defaultGeneratedPatterns = []string{
+		`\.pb(\.gw)?\.go$`,
+		`_pb2(_grpc)?\.py$`,
+		`\.min\.(js|css)$`,
+		`(^|/)(package-lock\.json|yarn\.lock|pnpm-lock\.yaml|go\.sum|Cargo\.lock|Gemfile\.lock|composer\.lock|poetry\.lock|Pipfile\.lock)$`,
+	}
+	// generatedMarker is the header convention of the generated code,
+	// e.g. "Code generated by protoc-gen-go. DO NOT EDIT."
+	generatedMarker = regexp.MustCompile(`(?i)(code generated\b.*\bdo not edit|@generated\b|autogenerated file)`)
+)
+
+// compileGeneratedPatterns returns default patterns with extra ones
+func compileGeneratedPatterns(extra []string) ([]*regexp.Regexp, error) {
+	patterns := make([]*regexp.Regexp, 0, len(defaultGeneratedPatterns)+len(extra))
+	for _, p := range defaultGeneratedPatterns {
+		patterns = append(patterns, regexp.MustCompile(p))
+	}
+	compiled, err := compilePatterns(extra)
+	if err != nil {
+		return nil, fmt.Errorf("Generated files: %v", err)
+	}
+	return append(patterns, compiled...), nil
+}
+
+// hasGeneratedHeader checks first lines of the file for the generated marker
+func hasGeneratedHeader(r io.Reader) bool {
+	scanner := bufio.NewScanner(r)
+	for i := 0; i < generatedHeaderLines && scanner.Scan(); i++ {
+		if generatedMarker.MatchString(scanner.Text()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-git.v4/plumbing/format/gitattributes"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+const (
+	gitattributesName = ".gitattributes"
+	linguistGenerated = "linguist-generated"
+	linguistVendored  = "linguist-vendored"
+)
+
+// linguistAttributes contains patterns of .gitattributes files, which
+// mark paths as generated or vendored the same way as for GitHub
+type linguistAttributes struct {
+	mux     sync.RWMutex
+	stack   []gitattributes.MatchAttribute
+	matcher gitattributes.Matcher
+}
+
+// add reads attributes of the file in the directory, domain is the
+// path of the directory relative to the root. Files deeper in the
+// tree must be added later as their patterns take precedence
+func (la *linguistAttributes) add(r io.Reader, domain []string) error {
+	attributes, err := gitattributes.ReadAttributes(r, domain, len(domain) == 0)
+	if err != nil || len(attributes) == 0 {
+		return err
+	}
+	la.mux.Lock()
+	defer la.mux.Unlock()
+	la.stack = append(la.stack, attributes...)
+	la.matcher = gitattributes.NewMatcher(la.stack)
+	return nil
+}
+
+// value returns if the attribute is set for the path relative to the
+// root and if it is specified at all ("linguist-generated=false")
+func (la *linguistAttributes) value(relativePath, name string) (bool, bool) {
+	la.mux.RLock()
+	defer la.mux.RUnlock()
+	if la.matcher == nil {
+		return false, false
+	}
+	path := strings.Split(filepath.ToSlash(relativePath), "/")
+	// results of several attributes are not merged by priority
+	results, _ := la.matcher.Match(path, []string{name})
+	a, ok := results[name]
+	switch {
+	case !ok || a.IsUnspecified():
+		return false, false
+	case a.IsSet():
+		return true, true
+	case a.IsValueSet():
+		v := strings.ToLower(a.Value())
+		return v == "true" || v == "1", true
+	}
+	return false, true
+}
+
+func attributesDomain(relativeDir string) []string {
+	relativeDir = filepath.ToSlash(relativeDir)
+	if relativeDir == "." || len(relativeDir) == 0 {
+		return nil
+	}
+	return strings.Split(relativeDir, "/")
+}
+
+// loadAttributes reads .gitattributes of the directory if there is one
+func (td *ToDoGenerator) loadAttributes(dir string) {
+	if td.attributes == nil {
+		return
+	}
+	f, err := os.Open(filepath.Join(dir, gitattributesName))
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	relativeDir, err := filepath.Rel(td.root, dir)
+	if err != nil || strings.HasPrefix(relativeDir, "..") {
+		return
+	}
+	if err := td.attributes.add(f, attributesDomain(relativeDir)); err != nil {
+		log.Printf("Cannot read %v: %v", f.Name(), err)
+	}
+}
+
+// loadParentAttributes reads .gitattributes of the root and the
+// directories down to the scanned path, which are not walked
+func (td *ToDoGenerator) loadParentAttributes(path string) {
+	relativePath, err := filepath.Rel(td.root, path)
+	if err != nil || relativePath == "." || strings.HasPrefix(relativePath, "..") {
+		return
+	}
+	dir := td.root
+	td.loadAttributes(dir)
+	parts := strings.Split(filepath.ToSlash(filepath.Dir(relativePath)), "/")
+	for _, part := range parts {
+		if part == "." {
+			continue
+		}
+		dir = filepath.Join(dir, part)
+		td.loadAttributes(dir)
+	}
+}
+
+// loadTreeAttributes reads .gitattributes files of the commit tree
+func (td *ToDoGenerator) loadTreeAttributes(tree *object.Tree) error {
+	if td.attributes == nil {
+		return nil
+	}
+	var files []*object.File
+	err := tree.Files().ForEach(func(f *object.File) error {
+		if filepath.Base(f.Name) == gitattributesName && f.Mode.IsFile() {
+			files = append(files, f)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return strings.Count(files[i].Name, "/") < strings.Count(files[j].Name, "/")
+	})
+	for _, f := range files {
+		reader, err := f.Reader()
+		if err != nil {
+			return err
+		}
+		err = td.attributes.add(reader, attributesDomain(filepath.Dir(f.Name)))
+		reader.Close()
+		if err != nil {
+			log.Printf("Cannot read %v: %v", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// linguistAttribute returns value of the attribute of the file
+// and if it is specified
+func (td *ToDoGenerator) linguistAttribute(path, name string) (bool, bool) {
+	if td.attributes == nil {
+		return false, false
+	}
+	relativePath, err := filepath.Rel(td.root, path)
+	if err != nil {
+		return false, false
+	}
+	return td.attributes.value(relativePath, name)
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	githubAPI          = "https://api.github.com"
+	githubSecret       = "github"
+	githubTargetPrefix = "github:"
+	planSetLabel       = "set-label"
+)
+
+var (
+	errNoGitHubRepo = errors.New("Cannot derive GitHub repository, set repo in the sink config")
+)
+
+type githubSink struct {
+	repo   string
+	labels []string
+	types  []string
+	fields fieldMapping
+	state  string
+	root   string
+}
+
+// githubRepo returns "owner/name" of the selected remote on github.com
+func githubRepo(env *Environment) string {
+	u, err := url.Parse(env.WebURL())
+	if err != nil || u.Host != "github.com" {
+		return ""
+	}
+	return strings.Trim(u.Path, "/")
+}
+
+func newGitHubSink(sc *SinkConfig, env *Environment) (Sink, error) {
+	repo := sc.Repo
+	if len(repo) == 0 {
+		repo = githubRepo(env)
+	}
+	if len(repo) == 0 {
+		return nil, errNoGitHubRepo
+	}
+	fields, err := compileFieldMapping(sc.Sink, sc.Fields)
+	if err != nil {
+		return nil, err
+	}
+	return &githubSink{repo: repo, labels: sc.Labels, types: sc.Types, fields: fields, state: sc.State, root: env.root}, nil
+}
+
+// issueLabels returns labels of the issue, the label of the type in
+// the theme and the category unless they are mapped
+func (s *githubSink) issueLabels(c *ToDoComment, t *theme) []string {
+	if s.fields.has("labels") {
+		return append(s.fields.values("labels", c), s.labels...)
+	}
+	labels := append([]string{t.Label(c.Type)}, s.labels...)
+	if len(c.Category) > 0 {
+		labels = append(labels, c.Category)
+	}
+	return labels
+}
+
+// Emit plans creation of issues for comments not linked to an issue
+// yet, updates of issues whose comments changed and closing of issues
+// whose comments were resolved, issues are tracked in the state file
+func (s *githubSink) Emit(report *result, plan *Plan) error {
+	target := githubTargetPrefix + s.repo
+	reconciler, err := newIssueReconciler(target, s.state, s.root)
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]*ToDoComment, len(report.Comments))
+	for _, c := range report.Comments {
+		byID[c.ID] = c
+	}
+	content := func(c *ToDoComment) string { return issueBody(c) + duplicateLocations(c, byID) }
+	changes, resolved := reconciler.reconcile(report, s.types, content)
+	s.planLabels(report.theme, changes, target, plan)
+	for _, ch := range changes {
+		c := ch.comment
+		action := &PlanAction{
+			Kind:    planCreateIssue,
+			Target:  target,
+			Summary: c.Title,
+			Content: content(c),
+			Labels:  s.issueLabels(c, report.theme),
+			Sync:    ch.sync,
+		}
+		if ch.number != 0 {
+			action.Kind = planUpdateIssue
+			action.Target = fmt.Sprintf("%v#%v", target, ch.number)
+		}
+		if s.fields.has("assignees") {
+			action.Fields = map[string][]string{"assignees": s.fields.values("assignees", c)}
+		}
+		plan.Add(action)
+	}
+	ids := make([]string, 0, len(resolved))
+	for id := range resolved {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		plan.Add(&PlanAction{
+			Kind:    planCloseIssue,
+			Target:  fmt.Sprintf("%v#%v", target, resolved[id].Number),
+			Summary: resolved[id].Title,
+			Sync:    reconciler.closeSync(id),
+		})
+	}
+	return nil
+}
+
+// planLabels plans setting colors of type labels of the theme used by
+// the changed issues, before the issues are created with them
+func (s *githubSink) planLabels(t *theme, changes []*issueChange, target string, plan *Plan) {
+	if s.fields.has("labels") {
+		return
+	}
+	planned := make(map[string]bool)
+	for _, ch := range changes {
+		label := t.Label(ch.comment.Type)
+		color := t.LabelColor(ch.comment.Type)
+		if len(color) == 0 || planned[label] {
+			continue
+		}
+		planned[label] = true
+		plan.Add(&PlanAction{Kind: planSetLabel, Target: target, Summary: label, Content: color})
+	}
+}
+
+type githubIssue struct {
+	Title     string   `json:"title"`
+	Body      string   `json:"body"`
+	Labels    []string `json:"labels,omitempty"`
+	Assignees []string `json:"assignees,omitempty"`
+}
+
+type githubIssueResponse struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+}
+
+func githubHeaders(token string) map[string]string {
+	return map[string]string{
+		"Authorization": "token " + token,
+		"Accept":        "application/vnd.github.v3+json",
+	}
+}
+
+// createGitHubIssue creates issue planned by the github sink and
+// returns its number
+func createGitHubIssue(p *Plan, a *PlanAction) (int, error) {
+	token, err := p.Secrets.Get(githubSecret)
+	if err != nil {
+		return 0, err
+	}
+	repo := strings.TrimPrefix(a.Target, githubTargetPrefix)
+	issue := &githubIssue{Title: a.Summary, Body: a.Content, Labels: a.Labels, Assignees: a.Fields["assignees"]}
+	created := &githubIssueResponse{}
+	endpoint := fmt.Sprintf("%v/repos/%v/issues", githubAPI, repo)
+	if err := doJSONRequest("POST", endpoint, githubHeaders(token), issue, created); err != nil {
+		return 0, err
+	}
+	log.Printf("Created issue %v", created.HTMLURL)
+	return created.Number, nil
+}
+
+// updateGitHubIssue replaces title, description and labels of the
+// issue of a changed comment
+func updateGitHubIssue(p *Plan, a *PlanAction) error {
+	token, err := p.Secrets.Get(githubSecret)
+	if err != nil {
+		return err
+	}
+	repo, number := splitTarget(a.Target, githubTargetPrefix, "#")
+	issue := &githubIssue{Title: a.Summary, Body: a.Content, Labels: a.Labels, Assignees: a.Fields["assignees"]}
+	endpoint := fmt.Sprintf("%v/repos/%v/issues/%v", githubAPI, repo, number)
+	return doJSONRequest("PATCH", endpoint, githubHeaders(token), issue, nil)
+}
+
+// closeGitHubIssue closes the issue of a resolved comment as completed
+func closeGitHubIssue(p *Plan, a *PlanAction) error {
+	token, err := p.Secrets.Get(githubSecret)
+	if err != nil {
+		return err
+	}
+	repo, number := splitTarget(a.Target, githubTargetPrefix, "#")
+	state := map[string]string{"state": "closed", "state_reason": "completed"}
+	endpoint := fmt.Sprintf("%v/repos/%v/issues/%v", githubAPI, repo, number)
+	return doJSONRequest("PATCH", endpoint, githubHeaders(token), state, nil)
+}
+
+type githubLabel struct {
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+// applySetLabel creates the label with the color of the theme or
+// updates the color of an existing one
+func applySetLabel(p *Plan, a *PlanAction) error {
+	if !strings.HasPrefix(a.Target, githubTargetPrefix) {
+		return fmt.Errorf("%v: %v %v", errUnknownPlanAction, a.Kind, a.Target)
+	}
+	token, err := p.Secrets.Get(githubSecret)
+	if err != nil {
+		return err
+	}
+	repo := strings.TrimPrefix(a.Target, githubTargetPrefix)
+	label := &githubLabel{Name: a.Summary, Color: a.Content}
+	endpoint := fmt.Sprintf("%v/repos/%v/labels", githubAPI, repo)
+	err = doJSONRequest("POST", endpoint, githubHeaders(token), label, nil)
+	var statusErr *httpStatusError
+	// 422 is returned for labels that already exist
+	if errors.As(err, &statusErr) && statusErr.Status == http.StatusUnprocessableEntity {
+		endpoint = fmt.Sprintf("%v/%v", endpoint, url.PathEscape(a.Summary))
+		return doJSONRequest("PATCH", endpoint, githubHeaders(token), label, nil)
+	}
+	return err
+}
+
+type githubComment struct {
+	ID   int64  `json:"id,omitempty"`
+	Body string `json:"body"`
+}
+
+// applyGitHubComment comments the pull request, the comment of
+// previous deliveries is updated if there is one
+func applyGitHubComment(p *Plan, a *PlanAction) error {
+	token, err := p.Secrets.Get(githubSecret)
+	if err != nil {
+		return err
+	}
+	repo, number := splitTarget(a.Target, githubTargetPrefix, "#")
+	endpoint := fmt.Sprintf("%v/repos/%v/issues/%v/comments", githubAPI, repo, number)
+	var comments []*githubComment
+	if err := doJSONRequest("GET", endpoint+"?per_page=100", githubHeaders(token), nil, &comments); err != nil {
+		return err
+	}
+	comment := &githubComment{Body: a.Content}
+	for _, c := range comments {
+		if strings.HasPrefix(c.Body, webhookMarker) {
+			endpoint := fmt.Sprintf("%v/repos/%v/issues/comments/%v", githubAPI, repo, c.ID)
+			return doJSONRequest("PATCH", endpoint, githubHeaders(token), comment, nil)
+		}
+	}
+	return doJSONRequest("POST", endpoint, githubHeaders(token), comment, nil)
+}
+
+type githubStatus struct {
+	State       string `json:"state"`
+	Context     string `json:"context"`
+	Description string `json:"description"`
+}
+
+// applyGitHubStatus sets commit status, statuses need no GitHub App
+// unlike check runs
+func applyGitHubStatus(p *Plan, a *PlanAction) error {
+	token, err := p.Secrets.Get(githubSecret)
+	if err != nil {
+		return err
+	}
+	repo, sha := splitTarget(a.Target, githubTargetPrefix, "@")
+	status := &githubStatus{State: a.Summary, Context: appName, Description: a.Content}
+	endpoint := fmt.Sprintf("%v/repos/%v/statuses/%v", githubAPI, repo, sha)
+	return doJSONRequest("POST", endpoint, githubHeaders(token), status, nil)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	gitlabSecret       = "gitlab"
+	gitlabTargetPrefix = "gitlab:"
+)
+
+// gitlabProjectAPI returns API endpoint of the project with the web URL,
+// e.g. https://gitlab.com/group/project
+func gitlabProjectAPI(webURL string) (string, error) {
+	u, err := url.Parse(webURL)
+	if err != nil || len(u.Host) == 0 {
+		return "", fmt.Errorf("Invalid GitLab project %v", webURL)
+	}
+	project := url.PathEscape(strings.Trim(u.Path, "/"))
+	return fmt.Sprintf("%v://%v/api/v4/projects/%v", u.Scheme, u.Host, project), nil
+}
+
+func gitlabHeaders(token string) map[string]string {
+	return map[string]string{"PRIVATE-TOKEN": token}
+}
+
+type gitlabNote struct {
+	ID   int64  `json:"id,omitempty"`
+	Body string `json:"body"`
+}
+
+// applyGitLabNote comments the merge request, the note of previous
+// deliveries is updated if there is one
+func applyGitLabNote(p *Plan, a *PlanAction) error {
+	token, err := p.Secrets.Get(gitlabSecret)
+	if err != nil {
+		return err
+	}
+	project, iid := splitTarget(a.Target, gitlabTargetPrefix, "#")
+	api, err := gitlabProjectAPI(project)
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%v/merge_requests/%v/notes", api, iid)
+	var notes []*gitlabNote
+	if err := doJSONRequest("GET", endpoint+"?per_page=100", gitlabHeaders(token), nil, &notes); err != nil {
+		return err
+	}
+	note := &gitlabNote{Body: a.Content}
+	for _, n := range notes {
+		if strings.HasPrefix(n.Body, webhookMarker) {
+			return doJSONRequest("PUT", fmt.Sprintf("%v/%v", endpoint, n.ID), gitlabHeaders(token), note, nil)
+		}
+	}
+	return doJSONRequest("POST", endpoint, gitlabHeaders(token), note, nil)
+}
+
+type gitlabStatus struct {
+	State       string `json:"state"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// applyGitLabStatus sets commit status, GitLab calls failures "failed"
+func applyGitLabStatus(p *Plan, a *PlanAction) error {
+	token, err := p.Secrets.Get(gitlabSecret)
+	if err != nil {
+		return err
+	}
+	project, sha := splitTarget(a.Target, gitlabTargetPrefix, "@")
+	api, err := gitlabProjectAPI(project)
+	if err != nil {
+		return err
+	}
+	state := a.Summary
+	if state == statusFailure {
+		state = "failed"
+	}
+	status := &gitlabStatus{State: state, Name: appName, Description: a.Content}
+	return doJSONRequest("POST", fmt.Sprintf("%v/statuses/%v", api, sha), gitlabHeaders(token), status, nil)
+}
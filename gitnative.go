@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+)
+
+var (
+	gitBinaryOnce  sync.Once
+	gitBinaryFound bool
+)
+
+// hasGitBinary checks if git is installed, otherwise metadata
+// is read with go-git, e.g. in minimal container images
+func hasGitBinary() bool {
+	gitBinaryOnce.Do(func() {
+		_, err := exec.LookPath("git")
+		gitBinaryFound = err == nil
+		if !gitBinaryFound {
+			log.Printf("git is not installed, reading git metadata without it")
+		}
+	})
+	return gitBinaryFound
+}
+
+// nativeGit answers the read-only git commands used for metadata
+// from the repository opened with go-git. Other commands (blame,
+// grep) are not supported and return errors
+type nativeGit struct {
+	root string
+	repo *git.Repository
+}
+
+func openNativeGit(root string) (*nativeGit, error) {
+	repo, err := git.PlainOpenWithOptions(root, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return nil, err
+	}
+	return &nativeGit{root: root, repo: repo}, nil
+}
+
+// native returns go-git repository of the root, nil if there is none
+func (env *Environment) native() *nativeGit {
+	env.initNative.Do(func() {
+		ng, err := openNativeGit(env.root)
+		if err != nil {
+			log.Printf("%v is not in a git repository: %v", env.root, err)
+			return
+		}
+		env.nativeGit = ng
+	})
+	return env.nativeGit
+}
+
+// run returns output of the git command like the binary does
+func (ng *nativeGit) run(arg ...string) (string, error) {
+	if len(arg) > 0 {
+		switch arg[0] {
+		case "rev-parse":
+			return ng.revParse(arg[1:])
+		case "config":
+			return ng.config(arg[1:])
+		case "status":
+			return ng.status()
+		}
+	}
+	return "", fmt.Errorf("git %v is not supported without git binary", strings.Join(arg, " "))
+}
+
+func (ng *nativeGit) revParse(args []string) (string, error) {
+	mode := ""
+	rev := "HEAD"
+	for _, a := range args {
+		switch a {
+		case "--verify":
+		case "--abbrev-ref", "--short", "--show-toplevel", "--show-prefix", "--is-bare-repository", "--git-dir":
+			mode = a
+		default:
+			rev = strings.TrimSuffix(a, "^{commit}")
+		}
+	}
+	wt, wtErr := ng.repo.Worktree()
+	switch mode {
+	case "--is-bare-repository":
+		return fmt.Sprint(wtErr == git.ErrIsBareRepository), nil
+	case "--git-dir":
+		return ".git", nil
+	case "--show-toplevel":
+		if wtErr != nil {
+			return "", wtErr
+		}
+		return wt.Filesystem.Root(), nil
+	case "--show-prefix":
+		if wtErr != nil {
+			return "", wtErr
+		}
+		prefix, err := filepath.Rel(wt.Filesystem.Root(), ng.root)
+		if err != nil || prefix == "." {
+			return "", err
+		}
+		return filepath.ToSlash(prefix) + "/", nil
+	}
+	hash, err := ng.repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return "", err
+	}
+	switch mode {
+	case "--short":
+		return hash.String()[:7], nil
+	case "--abbrev-ref":
+		if rev != "HEAD" {
+			return rev, nil
+		}
+		head, err := ng.repo.Head()
+		if err != nil || !head.Name().IsBranch() {
+			return "HEAD", err
+		}
+		return head.Name().Short(), nil
+	}
+	return hash.String(), nil
+}
+
+// configEntries returns all entries of the repository config as
+// "section.subsection.key" names, global config is not read
+func (ng *nativeGit) configEntries() ([][2]string, error) {
+	cfg, err := ng.repo.Config()
+	if err != nil {
+		return nil, err
+	}
+	var entries [][2]string
+	for _, s := range cfg.Raw.Sections {
+		section := strings.ToLower(s.Name)
+		for _, o := range s.Options {
+			entries = append(entries, [2]string{section + "." + strings.ToLower(o.Key), o.Value})
+		}
+		for _, ss := range s.Subsections {
+			for _, o := range ss.Options {
+				entries = append(entries, [2]string{section + "." + ss.Name + "." + strings.ToLower(o.Key), o.Value})
+			}
+		}
+	}
+	return entries, nil
+}
+
+func (ng *nativeGit) config(args []string) (string, error) {
+	entries, err := ng.configEntries()
+	if err != nil {
+		return "", err
+	}
+	if len(args) == 2 && args[0] == "--get-regexp" {
+		r, err := regexp.Compile(args[1])
+		if err != nil {
+			return "", err
+		}
+		lines := make([]string, 0)
+		for _, e := range entries {
+			if r.MatchString(e[0]) {
+				lines = append(lines, e[0]+" "+e[1])
+			}
+		}
+		return strings.Join(lines, "\n"), nil
+	}
+	if len(args) == 0 {
+		return "", fmt.Errorf("git config requires a key")
+	}
+	key := args[len(args)-1]
+	value := ""
+	for _, e := range entries {
+		// the last value wins as in git
+		if strings.EqualFold(e[0], key) {
+			value = e[1]
+		}
+	}
+	if len(args) > 1 && args[0] == "--bool" {
+		switch strings.ToLower(value) {
+		case "", "false", "no", "off", "0":
+			return "false", nil
+		}
+		return "true", nil
+	}
+	return value, nil
+}
+
+// status returns modified and untracked files like "status --porcelain"
+func (ng *nativeGit) status() (string, error) {
+	wt, err := ng.repo.Worktree()
+	if err != nil {
+		return "", err
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return "", err
+	}
+	lines := make([]string, 0, len(status))
+	for path, s := range status {
+		lines = append(lines, fmt.Sprintf("%c%c %v", s.Staging, s.Worktree, path))
+	}
+	return strings.Join(lines, "\n"), nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/object"
+)
+
+var (
+	errCannotResolveRef = errors.New("Cannot resolve git ref")
+)
+
+// openRepository opens repository containing path, including linked
+// worktrees (where .git is a file) and bare repositories
+func openRepository(path string) (*git.Repository, error) {
+	env := &Environment{root: path}
+	// objects and config of worktrees are in the common git dir
+	commonDir, _, err := env.output("git", "rev-parse", "--git-common-dir")
+	if err != nil {
+		return git.PlainOpenWithOptions(path, &git.PlainOpenOptions{DetectDotGit: true})
+	}
+	if !filepath.IsAbs(commonDir) {
+		commonDir = filepath.Join(path, commonDir)
+	}
+	return git.PlainOpen(commonDir)
+}
+
+// IsBare checks if the root is a bare repository
+func (env *Environment) IsBare() bool {
+	return env.git("rev-parse", "--is-bare-repository") == "true"
+}
+
+// ResolveRef returns full hash of the commit the ref points to
+func (env *Environment) ResolveRef(ref string) (string, error) {
+	hash := env.git("rev-parse", "--verify", ref+"^{commit}")
+	if len(hash) == 0 {
+		return "", errCannotResolveRef
+	}
+	return hash, nil
+}
+
+// skippedInTree checks path and its parent directories against
+// paths skipped by submodule and sparse checkout settings and
+// returns the reason, empty if the path is not skipped
+func (td *ToDoGenerator) skippedInTree(path string) string {
+	for p := path; p != td.root && p != filepath.Dir(p); p = filepath.Dir(p) {
+		if reason, ok := td.skipped[p]; ok {
+			return reason
+		}
+	}
+	return ""
+}
+
+// scanPath returns the scanned path containing the path
+func (td *ToDoGenerator) scanPath(path string) string {
+	for _, p := range td.paths {
+		if path == p || strings.HasPrefix(path, p+string(os.PathSeparator)) {
+			return p
+		}
+	}
+	return td.root
+}
+
+// inPaths checks if path is inside one of the paths given to the scan
+func (td *ToDoGenerator) inPaths(path string) bool {
+	if len(td.paths) == 0 {
+		return true
+	}
+	for _, p := range td.paths {
+		if path == p || strings.HasPrefix(path, p+string(os.PathSeparator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// GenerateTree parses files of the commit tree without checkout,
+// file paths are relative to the repository top level
+func (td *ToDoGenerator) GenerateTree(repo *git.Repository, hash string) ([]*ToDoComment, error) {
+	commit, err := repo.CommitObject(plumbing.NewHash(hash))
+	if err != nil {
+		return nil, err
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, err
+	}
+	if err := td.loadTreeAttributes(tree); err != nil {
+		return nil, err
+	}
+	stages := td.startStages()
+	walk := startSpan("walk")
+	walk.set("scorpion.revision", hash)
+	err = tree.Files().ForEach(func(f *object.File) error {
+		if stopRequested() {
+			return errInterrupted
+		}
+		if !f.Mode.IsFile() {
+			return nil
+		}
+		path := filepath.Join(td.root, filepath.FromSlash(f.Name))
+		if !td.inPaths(path) {
+			return nil
+		}
+		if reason := td.skippedInTree(path); len(reason) > 0 {
+			td.skip(path, reason, "")
+			return nil
+		}
+		if td.prunedInTree(path) {
+			return nil
+		}
+		if td.hiddenInTree(path) {
+			td.skip(path, skipHidden, "")
+			return nil
+		}
+		anyMatch := false
+		for _, r := range td.filters {
+			if r.MatchString(path) {
+				anyMatch = true
+				break
+			}
+		}
+		if !anyMatch && len(td.filters) > 0 {
+			td.skip(path, skipFilter, "")
+			return nil
+		}
+		if td.skipGenerated {
+			if reason := td.generatedPathReason(path); len(reason) > 0 {
+				td.countGenerated(path, reason)
+				return nil
+			}
+		}
+		if isBinary, err := f.IsBinary(); err != nil || isBinary {
+			td.skip(path, skipBinary, "")
+			return nil
+		}
+		reader, err := f.Reader()
+		if err != nil {
+			td.fileError(path, err)
+			return nil
+		}
+		content, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			td.fileError(path, err)
+			return nil
+		}
+		stages.Parse(path, content)
+		return nil
+	})
+	if err == errInterrupted {
+		log.Printf("Scan of tree %v was interrupted", hash)
+		err = nil
+	}
+	walk.set("scorpion.files", stages.Queued())
+	walk.finish(err)
+	stages.Wait()
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Matched files in tree %v: %v", hash, stages.Queued())
+	return td.collect(), nil
+}
+
+// generateTree scans files of the environment's ref
+func generateTree(td *ToDoGenerator, env *Environment) ([]*ToDoComment, error) {
+	hash, err := env.ResolveRef(env.head())
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", err, env.head())
+	}
+	repo, err := openRepository(env.root)
+	if err != nil {
+		return nil, err
+	}
+	return td.GenerateTree(repo, hash)
+}
@@ -4,8 +4,11 @@ go 1.14
 
 require (
 	github.com/karrick/godirwalk v1.15.5
-	github.com/spf13/pflag v1.0.5 // indirect
+	github.com/spf13/pflag v1.0.5
 	github.com/whilp/git-urls v0.0.0-20191001220047-6db9661140c0
-	github.com/zieckey/goini v0.0.0-20180118150432-0da17d361d26
+	golang.org/x/crypto v0.0.0-20190701094942-4def268fd1a4
+	google.golang.org/grpc v1.36.0
+	google.golang.org/protobuf v1.26.0
 	gopkg.in/src-d/go-git.v4 v4.13.1
+	gopkg.in/yaml.v2 v2.4.0
 )
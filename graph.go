@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+const (
+	graphMermaid = "mermaid"
+	graphDOT     = "dot"
+	linkRefs     = "refs"
+	linkIssue    = "issue"
+	linkCategory = "category"
+)
+
+// graphNode is a comment or an issue of the graph
+type graphNode struct {
+	id      string
+	label   string
+	url     string
+	cluster string
+}
+
+type graphEdge struct {
+	from, to *graphNode
+	label    string
+}
+
+// debtGraph links related comments, comments of a category are
+// clustered
+type debtGraph struct {
+	nodes []*graphNode
+	edges []*graphEdge
+}
+
+// graphLabel returns the node label of the comment
+func graphLabel(c *ToDoComment) string {
+	return fmt.Sprintf("%v: %v\n%v:%v", c.Type, c.Title, c.File, c.Line+1)
+}
+
+// buildGraph returns the graph of the comments with links of the
+// kinds (refs, issue, category), comments without links are left out
+func buildGraph(comments []*ToDoComment, links []string) *debtGraph {
+	g := &debtGraph{}
+	nodes := make(map[*ToDoComment]*graphNode)
+	issues := make(map[int]*graphNode)
+	linked := make(map[[2]*graphNode]bool)
+	link := func(from, to *graphNode, label string) {
+		if !linked[[2]*graphNode{from, to}] {
+			linked[[2]*graphNode{from, to}] = true
+			g.edges = append(g.edges, &graphEdge{from: from, to: to, label: label})
+		}
+	}
+	byID := make(map[string]*ToDoComment)
+	for _, c := range comments {
+		byID[c.ID] = c
+	}
+	node := func(c *ToDoComment) *graphNode {
+		if n, ok := nodes[c]; ok {
+			return n
+		}
+		n := &graphNode{id: fmt.Sprintf("c%v", len(nodes)+1), label: graphLabel(c)}
+		if c.Blame != nil {
+			n.url = c.Blame.Permalink
+		}
+		if containsString(links, linkCategory) {
+			n.cluster = c.Category
+		}
+		nodes[c] = n
+		g.nodes = append(g.nodes, n)
+		return n
+	}
+	issue := func(number int) *graphNode {
+		if n, ok := issues[number]; ok {
+			return n
+		}
+		n := &graphNode{id: fmt.Sprintf("i%v", number), label: fmt.Sprintf("#%v", number)}
+		issues[number] = n
+		g.nodes = append(g.nodes, n)
+		return n
+	}
+	for _, c := range comments {
+		if containsString(links, linkIssue) && c.Issue != 0 {
+			link(node(c), issue(c.Issue), linkIssue)
+		}
+		if !containsString(links, linkRefs) {
+			continue
+		}
+		for _, r := range c.Relations {
+			switch r.Kind {
+			case relationIssue:
+				if number, err := strconv.Atoi(r.Target); err == nil {
+					link(node(c), issue(number), linkRefs)
+				}
+				continue
+			case relationComment:
+				if target, ok := byID[r.Target]; ok {
+					link(node(c), node(target), linkRefs)
+					continue
+				}
+			case relationFile:
+				found := false
+				file, line := relationPath(r)
+				for _, target := range comments {
+					inFile := target.File == file && (line == 0 || target.Line+1 == line)
+					if target != c && (inFile || line == 0 && strings.HasPrefix(target.File, file+"/")) {
+						link(node(c), node(target), linkRefs)
+						found = true
+					}
+				}
+				if found {
+					continue
+				}
+			}
+			log.Printf("Unresolved %v relation %v of %v:%v", r.Kind, r.Target, c.File, c.Line+1)
+		}
+	}
+	if containsString(links, linkCategory) {
+		counts := make(map[string]int)
+		for _, c := range comments {
+			counts[c.Category]++
+		}
+		for _, c := range comments {
+			if len(c.Category) > 0 && counts[c.Category] > 1 {
+				node(c)
+			}
+		}
+	}
+	return g
+}
+
+// clusters returns names of the clusters of the nodes and nodes
+// without a cluster
+func (g *debtGraph) clusters() ([]string, map[string][]*graphNode) {
+	members := make(map[string][]*graphNode)
+	for _, n := range g.nodes {
+		members[n.cluster] = append(members[n.cluster], n)
+	}
+	names := make([]string, 0, len(members))
+	for name := range members {
+		if len(name) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, members
+}
+
+// mermaidText escapes the label for quoted mermaid strings
+func mermaidText(s string) string {
+	return strings.NewReplacer(`"`, "#quot;", "\n", "<br>").Replace(s)
+}
+
+func writeMermaid(w io.Writer, g *debtGraph) error {
+	fmt.Fprintln(w, "graph LR")
+	names, members := g.clusters()
+	for i, name := range names {
+		fmt.Fprintf(w, "  subgraph g%v[\"%v\"]\n", i+1, mermaidText(name))
+		for _, n := range members[name] {
+			fmt.Fprintf(w, "    %v[\"%v\"]\n", n.id, mermaidText(n.label))
+		}
+		fmt.Fprintln(w, "  end")
+	}
+	for _, n := range members[""] {
+		fmt.Fprintf(w, "  %v[\"%v\"]\n", n.id, mermaidText(n.label))
+	}
+	for _, e := range g.edges {
+		fmt.Fprintf(w, "  %v -->|%v| %v\n", e.from.id, e.label, e.to.id)
+	}
+	for _, n := range g.nodes {
+		if len(n.url) > 0 {
+			fmt.Fprintf(w, "  click %v \"%v\"\n", n.id, mermaidText(n.url))
+		}
+	}
+	return nil
+}
+
+func writeDOTNode(w io.Writer, indent string, n *graphNode) {
+	fmt.Fprintf(w, "%v%v [label=%v", indent, n.id, strconv.Quote(n.label))
+	if len(n.url) > 0 {
+		fmt.Fprintf(w, ", URL=%v", strconv.Quote(n.url))
+	}
+	if strings.HasPrefix(n.id, "i") {
+		fmt.Fprint(w, ", shape=ellipse")
+	}
+	fmt.Fprintln(w, "];")
+}
+
+func writeDOT(w io.Writer, g *debtGraph) error {
+	fmt.Fprintln(w, "digraph debt {")
+	fmt.Fprintln(w, "  rankdir=LR;")
+	fmt.Fprintln(w, "  node [shape=box];")
+	names, members := g.clusters()
+	for i, name := range names {
+		fmt.Fprintf(w, "  subgraph cluster_%v {\n    label=%v;\n", i+1, strconv.Quote(name))
+		for _, n := range members[name] {
+			writeDOTNode(w, "    ", n)
+		}
+		fmt.Fprintln(w, "  }")
+	}
+	for _, n := range members[""] {
+		writeDOTNode(w, "  ", n)
+	}
+	for _, e := range g.edges {
+		fmt.Fprintf(w, "  %v -> %v [label=%v];\n", e.from.id, e.to.id, strconv.Quote(e.label))
+	}
+	fmt.Fprintln(w, "}")
+	return nil
+}
+
+// runGraph implements "scorpion graph [paths]", it prints comments
+// linked by relations and issue numbers as a mermaid or dot graph
+func runGraph(args []string) error {
+	links := splitValues(graphLinksFlag)
+	for _, l := range links {
+		if l != linkRefs && l != linkIssue && l != linkCategory {
+			return fmt.Errorf("Unknown graph link: %v, use refs, issue or category", l)
+		}
+	}
+	format := graphMermaid
+	if pflag.CommandLine.Changed("format") {
+		format = strings.Join(formatFlag, ",")
+	}
+	if format != graphMermaid && format != graphDOT {
+		return fmt.Errorf("Unknown graph format: %v, use mermaid or dot", format)
+	}
+	comments, _, err := commandComments(args)
+	if err != nil {
+		return err
+	}
+	g := buildGraph(comments, links)
+	if format == graphDOT {
+		return writeDOT(os.Stdout, g)
+	}
+	return writeMermaid(os.Stdout, g)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	errNoQueryArg = errors.New("Usage: scorpion grep <query> [database]")
+	errNoDatabase = errors.New("No SQLite database with stored scans, add sink sqlite")
+)
+
+// grepMatch is a comment of the full-text index matching the query
+type grepMatch struct {
+	Project string `json:"project"`
+	Type    string `json:"type"`
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Title   string `json:"title"`
+	// Snippet is the matching part of the title or the body
+	Snippet string `json:"snippet,omitempty"`
+}
+
+// grepSQL returns the query of comments of the latest scan of every
+// project, ranked by FTS5
+func grepSQL(query string) string {
+	return fmt.Sprintf(`SELECT s.project, f.type, f.file, f.line, replace(f.title, char(9), ' '),
+  replace(replace(snippet(comments_fts, -1, '', '', '...', 12), char(9), ' '), char(10), ' ')
+FROM comments_fts f JOIN scans s ON s.id = f.scan_id
+WHERE comments_fts MATCH %v AND f.scan_id IN (SELECT max(id) FROM scans GROUP BY project)
+ORDER BY rank`, sqlQuote(query))
+}
+
+// grepDatabase returns matches of the query in the database
+func grepDatabase(path, query string) ([]*grepMatch, error) {
+	cmd := exec.Command(sqliteBinary(), "-batch", "-separator", "\t", path, grepSQL(query))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("sqlite3 %v: %v: %v", path, err, strings.TrimSpace(stderr.String()))
+	}
+	matches := make([]*grepMatch, 0)
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 6 {
+			continue
+		}
+		number, _ := strconv.Atoi(fields[3])
+		matches = append(matches, &grepMatch{
+			Project: fields[0],
+			Type:    fields[1],
+			File:    fields[2],
+			Line:    number,
+			Title:   fields[4],
+			Snippet: fields[5],
+		})
+	}
+	return matches, nil
+}
+
+func printGrep(w io.Writer, matches []*grepMatch, t *theme) error {
+	projects := make(map[string]bool)
+	for _, m := range matches {
+		projects[m.Project] = true
+	}
+	for _, m := range matches {
+		// databases of the daemon keep scans of several repositories
+		if len(projects) > 1 {
+			fmt.Fprintf(w, "%v: ", m.Project)
+		}
+		fmt.Fprintf(w, "%v:%v: %v: %v\n", m.File, m.Line, t.Paint(m.Type, m.Type), m.Title)
+		if len(m.Snippet) > 0 && m.Snippet != m.Title {
+			fmt.Fprintf(w, "    %v\n", m.Snippet)
+		}
+	}
+	return nil
+}
+
+// runGrep implements "scorpion grep <query> [database]", it searches
+// comments of the latest stored scans with the full-text index of the
+// sqlite sink, the query has the FTS5 syntax ("pool*", "a OR b")
+func runGrep(args []string) error {
+	if len(args) == 0 || len(args) > 2 || len(strings.TrimSpace(args[0])) == 0 {
+		return errNoQueryArg
+	}
+	config, err := loadConfig(configPathFlag, srcRootFlag)
+	if err != nil {
+		return err
+	}
+	databases := args[1:]
+	if len(databases) == 0 {
+		databases = sqliteDatabases(config, srcRootFlag)
+	}
+	if len(databases) == 0 {
+		path := filepath.Join(srcRootFlag, defaultSQLiteDatabase)
+		if _, err := os.Stat(path); err != nil {
+			return errNoDatabase
+		}
+		databases = []string{path}
+	}
+	matches := make([]*grepMatch, 0)
+	for _, path := range databases {
+		found, err := grepDatabase(path, args[0])
+		if err != nil {
+			return err
+		}
+		matches = append(matches, found...)
+	}
+	if verboseFlag {
+		js, err := json.MarshalIndent(matches, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Println(string(js))
+		return err
+	}
+	t, err := newTheme(&config.Theme)
+	if err != nil {
+		return err
+	}
+	return printGrep(os.Stdout, matches, t)
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	groupByAuthor   = "author"
+	unknownGroupKey = "(none)"
+)
+
+// GroupStats contains totals of comments of one group, e.g. author
+type GroupStats struct {
+	Key      string         `json:"key"`
+	Email    string         `json:"email,omitempty"`
+	Comments int            `json:"comments"`
+	Estimate float64        `json:"estimate,omitempty"`
+	Types    map[string]int `json:"types"`
+	// Oldest is the date of the oldest comment (requires blame)
+	Oldest time.Time `json:"oldest,omitempty"`
+	// Cost is set if the cost config has rates
+	Cost float64 `json:"cost,omitempty"`
+}
+
+// commentGrouper returns group key and optional email of the comment
+type commentGrouper func(c *ToDoComment) (string, string)
+
+var commentGroupers = map[string]commentGrouper{
+	// authors come from blame normalized with .mailmap
+	groupByAuthor: func(c *ToDoComment) (string, string) {
+		if c.Blame == nil {
+			return "", ""
+		}
+		if len(c.Blame.CanonicalAuthor) > 0 {
+			return c.Blame.CanonicalAuthor, c.Blame.CanonicalEmail
+		}
+		return c.Blame.Author, c.Blame.Email
+	},
+	"assignee": func(c *ToDoComment) (string, string) { return c.Assignee, "" },
+	"category": func(c *ToDoComment) (string, string) { return c.Category, "" },
+	"language": func(c *ToDoComment) (string, string) { return c.Language, "" },
+	"team":     func(c *ToDoComment) (string, string) { return c.Metadata[teamMetadataKey], "" },
+	"type":     func(c *ToDoComment) (string, string) { return c.Type, "" },
+}
+
+// groupComments aggregates comments by the field, groups are
+// sorted by number of comments. Costs are optional
+func groupComments(comments []*ToDoComment, by string, costs *costModel) ([]*GroupStats, error) {
+	grouper, ok := commentGroupers[by]
+	if !ok {
+		return nil, fmt.Errorf("Unknown group field: %v", by)
+	}
+	groups := make(map[string]*GroupStats)
+	for _, c := range comments {
+		key, email := grouper(c)
+		if len(key) == 0 {
+			key = unknownGroupKey
+		}
+		g, ok := groups[key]
+		if !ok {
+			g = &GroupStats{Key: key, Email: email, Types: make(map[string]int)}
+			groups[key] = g
+		}
+		g.Comments++
+		g.Estimate += c.Estimate
+		g.Cost += costs.Cost(c)
+		g.Types[c.Type]++
+		if c.Blame != nil && (g.Oldest.IsZero() || c.Blame.Date.Before(g.Oldest)) {
+			g.Oldest = c.Blame.Date
+		}
+	}
+	result := make([]*GroupStats, 0, len(groups))
+	for _, g := range groups {
+		result = append(result, g)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Comments != result[j].Comments {
+			return result[i].Comments > result[j].Comments
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result, nil
+}
+
+func groupTypes(groups []*GroupStats) []string {
+	seen := make(map[string]bool)
+	types := make([]string, 0)
+	for _, g := range groups {
+		for t := range g.Types {
+			if !seen[t] {
+				seen[t] = true
+				types = append(types, t)
+			}
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format("2006-01-02")
+}
+
+// printGroups writes human-readable table of the groups
+func printGroups(w io.Writer, by string, groups []*GroupStats, hf *humanFormat, costs *costModel) error {
+	types := groupTypes(groups)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "%v\tCOMMENTS\tESTIMATE\tOLDEST", strings.ToUpper(by))
+	if costs != nil {
+		fmt.Fprint(tw, "\tCOST")
+	}
+	for _, t := range types {
+		fmt.Fprintf(tw, "\t%v", t)
+	}
+	fmt.Fprintln(tw)
+	for _, g := range groups {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v", g.Key, g.Comments, hf.Estimate(g.Estimate), formatDate(g.Oldest))
+		if costs != nil {
+			fmt.Fprintf(tw, "\t%v", costs.Format(g.Cost))
+		}
+		for _, t := range types {
+			fmt.Fprintf(tw, "\t%v", g.Types[t])
+		}
+		fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+// writeGroupsCSV exports groups as csv with a header row, the cost
+// column is added if costs are set
+func writeGroupsCSV(w io.Writer, by string, groups []*GroupStats, costs *costModel) error {
+	types := groupTypes(groups)
+	cw := csv.NewWriter(w)
+	header := []string{by, "email", "comments", "estimate", "oldest"}
+	if costs != nil {
+		header = append(header, "cost")
+	}
+	header = append(header, types...)
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	for _, g := range groups {
+		row := []string{
+			g.Key,
+			g.Email,
+			strconv.Itoa(g.Comments),
+			strconv.FormatFloat(g.Estimate, 'f', 1, 64),
+			formatDate(g.Oldest),
+		}
+		if costs != nil {
+			row = append(row, strconv.FormatFloat(g.Cost, 'f', 2, 64))
+		}
+		for _, t := range types {
+			row = append(row, strconv.Itoa(g.Types[t]))
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
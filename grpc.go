@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/qorpress/scorpion/pkg/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// grpcServer implements api.ScorpionServer on top of the REST server
+type grpcServer struct {
+	api.UnimplementedScorpionServer
+	server *Server
+}
+
+func toProtoComment(c *ToDoComment) *api.ToDoComment {
+	if c == nil {
+		return nil
+	}
+	pc := &api.ToDoComment{
+		Type:            c.Type,
+		Title:           c.Title,
+		Body:            c.Body,
+		File:            c.File,
+		Line:            int32(c.Line),
+		Issue:           int32(c.Issue),
+		Category:        c.Category,
+		Assignee:        c.Assignee,
+		Estimate:        c.Estimate,
+		Language:        c.Language,
+		Project:         c.Project,
+		Confidence:      c.Confidence,
+		TitleLanguage:   c.TitleLanguage,
+		OriginalTitle:   c.OriginalTitle,
+		Id:              c.ID,
+		Metadata:        c.Metadata,
+		EstimateIso:     formatISODuration(c.Estimate),
+		EstimateDefault: c.EstimateDefault,
+		DuplicateOf:     c.DuplicateOf,
+		Duplicates:      c.Duplicates,
+		RawTitle:        c.RawTitle,
+	}
+	for _, r := range c.Relations {
+		pc.Relations = append(pc.Relations, &api.Relation{Kind: r.Kind, Target: r.Target})
+	}
+	if c.Tracker != nil {
+		pc.Tracker = &api.TrackerIssue{
+			Number:    int32(c.Tracker.Number),
+			Url:       c.Tracker.URL,
+			State:     c.Tracker.State,
+			Assignees: c.Tracker.Assignees,
+			Labels:    c.Tracker.Labels,
+		}
+	}
+	if c.Blame != nil {
+		pc.Blame = &api.Blame{
+			Commit:          c.Blame.Commit,
+			Author:          c.Blame.Author,
+			Email:           c.Blame.Email,
+			Date:            timestamppb.New(c.Blame.Date),
+			Permalink:       c.Blame.Permalink,
+			CanonicalAuthor: c.Blame.CanonicalAuthor,
+			CanonicalEmail:  c.Blame.CanonicalEmail,
+		}
+	}
+	return pc
+}
+
+func toProtoComments(comments []*ToDoComment) []*api.ToDoComment {
+	result := make([]*api.ToDoComment, 0, len(comments))
+	for _, c := range comments {
+		result = append(result, toProtoComment(c))
+	}
+	return result
+}
+
+func toProtoReport(r *result) *api.Report {
+	report := &api.Report{
+		Root:          r.Root,
+		Branch:        r.Branch,
+		Revision:      r.Revision,
+		Author:        r.Author,
+		Project:       r.Project,
+		Comments:      toProtoComments(r.Comments),
+		Remotes:       toProtoRemotes(r.Remotes),
+		SchemaVersion: int32(r.SchemaVersion),
+		Partial:       r.Partial,
+	}
+	for _, fe := range r.FileErrors {
+		report.FileErrors = append(report.FileErrors, &api.FileError{Path: fe.Path, Error: fe.Error})
+	}
+	for _, cf := range r.CappedFiles {
+		report.CappedFiles = append(report.CappedFiles, &api.CappedFile{Path: cf.Path, Comments: int32(cf.Comments), Kept: int32(cf.Kept)})
+	}
+	if r.Stats != nil {
+		report.Stats = toProtoStats(r.Stats)
+	}
+	if p := r.Provenance; p != nil {
+		report.Provenance = &api.Provenance{
+			Tool:         p.Tool,
+			Version:      p.Version,
+			GeneratedAt:  timestamppb.New(p.GeneratedAt),
+			Builder:      p.Builder,
+			Invocation:   p.Invocation,
+			ConfigDigest: p.ConfigDigest,
+		}
+	}
+	return report
+}
+
+func toProtoTypes(types map[string]int) map[string]int32 {
+	result := make(map[string]int32, len(types))
+	for t, n := range types {
+		result[t] = int32(n)
+	}
+	return result
+}
+
+func toProtoStats(s *Stats) *api.Stats {
+	stats := &api.Stats{
+		Files:     int32(s.Files),
+		Comments:  int32(s.Comments),
+		Estimate:  s.Estimate,
+		Types:     toProtoTypes(s.Types),
+		Languages: make(map[string]*api.LanguageStats, len(s.Languages)),
+		Vendored:  int32(s.Vendored),
+		Cost:      s.Cost,
+		Currency:  s.Currency,
+	}
+	for language, ls := range s.Languages {
+		stats.Languages[language] = &api.LanguageStats{
+			Files:    int32(ls.Files),
+			Comments: int32(ls.Comments),
+			Estimate: ls.Estimate,
+			Types:    toProtoTypes(ls.Types),
+			Cost:     ls.Cost,
+		}
+	}
+	return stats
+}
+
+func toProtoRemotes(remotes []*Remote) []*api.Remote {
+	result := make([]*api.Remote, 0, len(remotes))
+	for _, r := range remotes {
+		result = append(result, &api.Remote{Name: r.Name, Url: r.URL})
+	}
+	return result
+}
+
+func grpcCode(httpStatus int) codes.Code {
+	switch httpStatus {
+	case 400:
+		return codes.InvalidArgument
+	case 410:
+		return codes.FailedPrecondition
+	}
+	return codes.Internal
+}
+
+func (g *grpcServer) Scan(ctx context.Context, req *api.ScanRequest) (*api.Report, error) {
+	if err := g.server.Rescan(); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	report, _ := g.server.snapshot()
+	return toProtoReport(report), nil
+}
+
+func (g *grpcServer) ListComments(ctx context.Context, req *api.ListCommentsRequest) (*api.ListCommentsResponse, error) {
+	filter := &CommentFilter{
+		Types:         req.Types,
+		Categories:    req.Categories,
+		PathPrefix:    req.PathPrefix,
+		Assignee:      req.Assignee,
+		MinAge:        time.Duration(req.MinAgeDays) * 24 * time.Hour,
+		MinEstimate:   req.MinEstimate,
+		MinConfidence: req.MinConfidence,
+	}
+	page, httpStatus, err := g.server.listComments(filter, req.Sort, int(req.Limit), req.Cursor)
+	if err != nil {
+		return nil, status.Error(grpcCode(httpStatus), err.Error())
+	}
+	return &api.ListCommentsResponse{
+		Total:      int32(page.Total),
+		Comments:   toProtoComments(page.Comments),
+		NextCursor: page.NextCursor,
+	}, nil
+}
+
+func (g *grpcServer) StreamChanges(req *api.StreamChangesRequest, stream api.Scorpion_StreamChangesServer) error {
+	ch := g.server.broker.Subscribe()
+	defer g.server.broker.Unsubscribe(ch)
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case e, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			err := stream.Send(&api.ChangeEvent{
+				Kind:    e.Kind,
+				Comment: toProtoComment(e.Comment),
+				Total:   int32(e.Total),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// grpcRole returns the role required for the method, scans are
+// admin requests
+func grpcRole(method string) string {
+	if strings.HasSuffix(method, "/Scan") {
+		return roleAdmin
+	}
+	return roleRead
+}
+
+// grpcIdentify checks the token of "authorization" metadata like
+// the header of REST requests
+func (s *Server) grpcIdentify(ctx context.Context, method string) error {
+	authorization := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+	}
+	role := grpcRole(method)
+	id, err := s.identify(authorization, role)
+	if err == errForbidden {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	if role == roleAdmin {
+		log.Printf("%v by %v", method, id.name)
+	}
+	return nil
+}
+
+func (s *Server) authorizeUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if err := s.grpcIdentify(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) authorizeStream(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.grpcIdentify(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+	return handler(srv, ss)
+}
+
+// serveGRPC serves gRPC API of the server on addr
+func serveGRPC(server *Server, addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	s := grpc.NewServer(grpc.UnaryInterceptor(server.authorizeUnary), grpc.StreamInterceptor(server.authorizeStream))
+	api.RegisterScorpionServer(s, &grpcServer{server: server})
+	go func() {
+		<-stopping
+		s.GracefulStop()
+	}()
+	log.Printf("Serving gRPC API on %v", addr)
+	return s.Serve(listener)
+}
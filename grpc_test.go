@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestToProtoReport checks fields of json reports that are easy to
+// miss in the API
+func TestToProtoReport(t *testing.T) {
+	c := &ToDoComment{
+		ID: "a", Type: "TODO", Title: "handle errors", Estimate: 1.5, EstimateDefault: true,
+		Metadata: map[string]string{"team": "core"}, Relations: []*Relation{{Kind: relationIssue, Target: "12"}},
+		DuplicateOf: "b", RawTitle: "Handle errors.", Tracker: &TrackerIssue{Number: 12, State: "open"},
+	}
+	r := &result{
+		SchemaVersion: 1,
+		Comments:      []*ToDoComment{c},
+		Stats:         &Stats{Comments: 1, Types: map[string]int{"TODO": 1}, Languages: map[string]*LanguageStats{"Go": {Comments: 1}}},
+		Partial:       true,
+		FileErrors:    []*FileError{{Path: "b.go", Error: "denied"}},
+		CappedFiles:   []*CappedFile{{Path: "c.go", Comments: 300, Kept: 200}},
+		Provenance:    &Provenance{Tool: appName, GeneratedAt: time.Now()},
+	}
+	report := toProtoReport(r)
+	pc := report.Comments[0]
+	if pc.EstimateIso != "PT1H30M" || !pc.EstimateDefault || pc.Metadata["team"] != "core" || len(pc.Relations) != 1 ||
+		pc.DuplicateOf != "b" || pc.RawTitle != c.RawTitle || pc.Tracker.GetNumber() != 12 {
+		t.Errorf("Comment fields are missing: %v", pc)
+	}
+	if report.SchemaVersion != 1 || !report.Partial || len(report.FileErrors) != 1 || len(report.CappedFiles) != 1 ||
+		report.Stats.GetTypes()["TODO"] != 1 || report.Stats.GetLanguages()["Go"].GetComments() != 1 ||
+		report.Provenance.GetTool() != appName {
+		t.Errorf("Report fields are missing: %v", report)
+	}
+}
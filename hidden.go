@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// hiddenDefault scans hidden files and directories except the
+	// ones of tools, hiddenAll scans all and hiddenNone none of them
+	hiddenDefault = "default"
+	hiddenAll     = "all"
+	hiddenNone    = "none"
+	skipHidden    = "hidden"
+)
+
+var (
+	// defaultHiddenSkip contain state of version control systems,
+	// editors, caches and environments rather than own code
+	defaultHiddenSkip = []string{
+		".hg", ".svn", ".bzr", ".idea", ".vscode", ".cache", ".venv", ".tox",
+		".mypy_cache", ".pytest_cache", ".gradle", ".terraform", ".next",
+		".nuxt", ".yarn", ".pnpm-store", ".history", ".DS_Store",
+	}
+)
+
+// hiddenPolicy decides which hidden files and directories are scanned
+type hiddenPolicy struct {
+	mode string
+	skip map[string]bool
+}
+
+// newHiddenPolicy returns policy of the mode, of the config if mode
+// is empty, error if the mode is unknown
+func newHiddenPolicy(hc *HiddenConfig, mode string) (*hiddenPolicy, error) {
+	if len(mode) == 0 {
+		mode = hc.Mode
+	}
+	if len(mode) == 0 {
+		mode = hiddenDefault
+	}
+	switch mode {
+	case hiddenDefault, hiddenAll, hiddenNone:
+	default:
+		return nil, fmt.Errorf("Unknown hidden mode: %v", mode)
+	}
+	names := hc.Skip
+	if names == nil {
+		names = defaultHiddenSkip
+	}
+	policy := &hiddenPolicy{mode: mode, skip: make(map[string]bool)}
+	for _, name := range names {
+		policy.skip[name] = true
+	}
+	for _, name := range hc.Include {
+		delete(policy.skip, name)
+	}
+	return policy, nil
+}
+
+// compileHiddenPolicy returns policy of the config and --hidden,
+// the mode is validated by doctor
+func compileHiddenPolicy(hc *HiddenConfig) *hiddenPolicy {
+	policy, err := newHiddenPolicy(hc, hiddenFlag)
+	if err != nil {
+		log.Printf("Using default hidden mode: %v", err)
+		policy, _ = newHiddenPolicy(hc, hiddenDefault)
+	}
+	return policy
+}
+
+// skips checks the file or directory name against the policy
+func (hp *hiddenPolicy) skips(name string) bool {
+	if !strings.HasPrefix(name, ".") || name == "." || name == ".." {
+		return false
+	}
+	switch hp.mode {
+	case hiddenAll:
+		return false
+	case hiddenNone:
+		return true
+	}
+	return hp.skip[name]
+}
+
+// hiddenInTree checks names of the path below the scanned path
+// against the policy, as commit trees have no directories to prune
+func (td *ToDoGenerator) hiddenInTree(path string) bool {
+	relativePath, err := filepath.Rel(td.scanPath(path), path)
+	if err != nil || relativePath == "." {
+		return false
+	}
+	for _, name := range strings.Split(filepath.ToSlash(relativePath), "/") {
+		if td.hidden.skips(name) {
+			return true
+		}
+	}
+	return false
+}
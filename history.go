@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	historyAdded   = "added"
+	historyRemoved = "removed"
+)
+
+var (
+	errHistoryNeedsGit = errors.New("History needs a git repository and the git binary")
+	errTooManyRevs     = errors.New("History expects at most one revision")
+)
+
+// HistoryEvent is a comment added or removed by a commit, the commit
+// has raw and canonical (.mailmap) authors like blame
+type HistoryEvent struct {
+	Kind    string       `json:"kind"`
+	Commit  *Blame       `json:"commit"`
+	Comment *ToDoComment `json:"comment"`
+}
+
+// historyCommits lists first-parent commits of the revision from the
+// oldest, limited by --since and --max-commits
+func historyCommits(env *Environment, rev string) ([]*Blame, error) {
+	args := []string{"log", "--first-parent", "--reverse", "--format=%H%x09%an%x09%ae%x09%aN%x09%aE%x09%aI"}
+	if len(historySinceFlag) > 0 {
+		args = append(args, "--since="+historySinceFlag)
+	}
+	if maxCommitsFlag > 0 {
+		args = append(args, "--max-count="+strconv.Itoa(maxCommitsFlag))
+	}
+	out, stderr, err := env.output("git", append(args, rev, "--")...)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot list commits of %v: %v", rev, strings.TrimSpace(stderr))
+	}
+	commits := make([]*Blame, 0)
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 6 {
+			continue
+		}
+		date, err := time.Parse(time.RFC3339, fields[5])
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, &Blame{
+			Commit:          fields[0],
+			Author:          fields[1],
+			Email:           fields[2],
+			CanonicalAuthor: fields[3],
+			CanonicalEmail:  fields[4],
+			Date:            date,
+		})
+	}
+	return commits, nil
+}
+
+// scanHistory scans every commit of the revision and returns comments
+// each of them added and removed. The parent of the oldest commit is
+// the starting point, so its comments are not reported as added
+func scanHistory(config *Config, rev string) ([]*HistoryEvent, error) {
+	env := NewEnvironment(srcRootFlag)
+	if !env.HasGit() || !hasGitBinary() {
+		return nil, errHistoryNeedsGit
+	}
+	commits, err := historyCommits(env, rev)
+	if err != nil || len(commits) == 0 {
+		return nil, err
+	}
+	previous := &result{}
+	if parent, _, err := env.output("git", "rev-parse", "--verify", "--quiet", commits[0].Commit+"^"); err == nil {
+		if previous, _, err = scanCommit(config, parent); err != nil {
+			return nil, err
+		}
+	}
+	events := make([]*HistoryEvent, 0)
+	for i, commit := range commits {
+		if stopRequested() {
+			log.Printf("History stopped after %v of %v commits", i, len(commits))
+			break
+		}
+		report, _, err := scanCommit(config, commit.Commit)
+		if err != nil {
+			return nil, err
+		}
+		delta := compareScans(previous, report)
+		for _, c := range delta.Added {
+			events = append(events, &HistoryEvent{Kind: historyAdded, Commit: commit, Comment: c})
+		}
+		for _, c := range delta.Removed {
+			events = append(events, &HistoryEvent{Kind: historyRemoved, Commit: commit, Comment: c})
+		}
+		previous = report
+	}
+	return events, nil
+}
+
+// quarter returns the quarter of the time, e.g. "2021-Q3"
+func quarter(t time.Time) string {
+	return fmt.Sprintf("%v-Q%v", t.Year(), (int(t.Month())-1)/3+1)
+}
+
+// PaydownStats are comments removed by a group in a quarter
+type PaydownStats struct {
+	Key      string  `json:"key"`
+	Email    string  `json:"email,omitempty"`
+	Quarter  string  `json:"quarter"`
+	Resolved int     `json:"resolved"`
+	Estimate float64 `json:"estimate,omitempty"`
+}
+
+// groupPaydown aggregates removed comments by quarter and the field,
+// the author is the one of the commit removing the comment
+func groupPaydown(events []*HistoryEvent, by string) ([]*PaydownStats, error) {
+	grouper, ok := commentGroupers[by]
+	if !ok {
+		return nil, fmt.Errorf("Unknown group field: %v", by)
+	}
+	groups := make(map[string]*PaydownStats)
+	for _, ev := range events {
+		if ev.Kind != historyRemoved {
+			continue
+		}
+		var key, email string
+		if by == groupByAuthor {
+			key, email = ev.Commit.CanonicalAuthor, ev.Commit.CanonicalEmail
+		} else {
+			key, email = grouper(ev.Comment)
+		}
+		if len(key) == 0 {
+			key = unknownGroupKey
+		}
+		q := quarter(ev.Commit.Date)
+		g, ok := groups[q+"\x00"+key]
+		if !ok {
+			g = &PaydownStats{Key: key, Email: email, Quarter: q}
+			groups[q+"\x00"+key] = g
+		}
+		g.Resolved++
+		g.Estimate += ev.Comment.Estimate
+	}
+	result := make([]*PaydownStats, 0, len(groups))
+	for _, g := range groups {
+		result = append(result, g)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Quarter != result[j].Quarter {
+			return result[i].Quarter < result[j].Quarter
+		}
+		if result[i].Resolved != result[j].Resolved {
+			return result[i].Resolved > result[j].Resolved
+		}
+		return result[i].Key < result[j].Key
+	})
+	return result, nil
+}
+
+func printPaydown(w io.Writer, by string, groups []*PaydownStats, hf *humanFormat) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "QUARTER\t%v\tRESOLVED\tESTIMATE\n", strings.ToUpper(by))
+	for _, g := range groups {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\n", g.Quarter, g.Key, g.Resolved, hf.Estimate(g.Estimate))
+	}
+	return tw.Flush()
+}
+
+func writePaydownCSV(w io.Writer, by string, groups []*PaydownStats) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"quarter", by, "email", "resolved", "estimate"})
+	for _, g := range groups {
+		cw.Write([]string{g.Quarter, g.Key, g.Email, strconv.Itoa(g.Resolved), strconv.FormatFloat(g.Estimate, 'f', 1, 64)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// printHistory writes events as json with verbose flag or one line
+// per event otherwise
+func printHistory(w io.Writer, events []*HistoryEvent) error {
+	if verboseFlag {
+		js, err := json.MarshalIndent(events, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(js))
+		return err
+	}
+	for _, ev := range events {
+		sign := "+"
+		if ev.Kind == historyRemoved {
+			sign = "-"
+		}
+		c := ev.Comment
+		if _, err := fmt.Fprintf(w, "%v %v %v %v %v: %v %v\n", sign, formatDate(ev.Commit.Date), ev.Commit.Commit[:8],
+			ev.Commit.CanonicalAuthor, commentLocation(c), c.Type, c.Title); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runHistory implements "scorpion history [revision]", it reports the
+// commits adding and removing comments (HEAD by default), with
+// --group-by the comments removed per quarter
+func runHistory(args []string) error {
+	if len(args) > 1 {
+		return errTooManyRevs
+	}
+	rev := "HEAD"
+	if len(args) == 1 {
+		rev = args[0]
+	}
+	config, err := loadConfig(configPathFlag, srcRootFlag)
+	if err != nil {
+		return err
+	}
+	hf, err := newHumanFormat(&config.Display)
+	if err != nil {
+		return err
+	}
+	events, err := scanHistory(config, rev)
+	if err != nil {
+		return err
+	}
+	if len(groupByFlag) == 0 {
+		return printHistory(os.Stdout, events)
+	}
+	groups, err := groupPaydown(events, groupByFlag)
+	if err != nil {
+		return err
+	}
+	if csvFlag {
+		return writePaydownCSV(os.Stdout, groupByFlag, groups)
+	}
+	return printPaydown(os.Stdout, groupByFlag, groups, hf)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	httpTimeout = 30 * time.Second
+	userAgent   = appName
+)
+
+var (
+	httpClient = &http.Client{Timeout: httpTimeout}
+)
+
+// httpStatusError is returned for non-2xx responses
+type httpStatusError struct {
+	Status int
+	Body   string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("HTTP %v: %v", e.Status, e.Body)
+}
+
+// doJSONRequest sends in as json body (if not nil) and decodes
+// response into out (if not nil), headers are added as is
+func doJSONRequest(method, url string, headers map[string]string, in, out interface{}) error {
+	return doJSONRequestWith(httpClient, method, url, headers, in, out)
+}
+
+// doJSONRequestWith sends the request with the client, e.g. one
+// trusting a private certificate authority
+func doJSONRequestWith(client *http.Client, method, url string, headers map[string]string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &httpStatusError{Status: resp.StatusCode, Body: string(data)}
+	}
+	if out != nil && len(data) > 0 {
+		return json.Unmarshal(data, out)
+	}
+	return nil
+}
+
+// doRawRequest sends the body as is, e.g. a file to cloud storage
+func doRawRequest(method, url string, headers map[string]string, body []byte) error {
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &httpStatusError{Status: resp.StatusCode, Body: string(data)}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	unitHour  = "hour"
+	unitDay   = "day"
+	unitWeek  = "week"
+	unitMonth = "month"
+	unitYear  = "year"
+)
+
+// localeUnits are the decimal separator and singular and plural names
+// of units of a language
+type localeUnits struct {
+	decimal string
+	units   map[string][2]string
+}
+
+var locales = map[string]*localeUnits{
+	"en": {".", map[string][2]string{
+		unitHour: {"hour", "hours"}, unitDay: {"day", "days"}, unitWeek: {"week", "weeks"},
+		unitMonth: {"month", "months"}, unitYear: {"year", "years"},
+	}},
+	"de": {",", map[string][2]string{
+		unitHour: {"Stunde", "Stunden"}, unitDay: {"Tag", "Tage"}, unitWeek: {"Woche", "Wochen"},
+		unitMonth: {"Monat", "Monate"}, unitYear: {"Jahr", "Jahre"},
+	}},
+	"es": {",", map[string][2]string{
+		unitHour: {"hora", "horas"}, unitDay: {"día", "días"}, unitWeek: {"semana", "semanas"},
+		unitMonth: {"mes", "meses"}, unitYear: {"año", "años"},
+	}},
+	"fr": {",", map[string][2]string{
+		unitHour: {"heure", "heures"}, unitDay: {"jour", "jours"}, unitWeek: {"semaine", "semaines"},
+		unitMonth: {"mois", "mois"}, unitYear: {"an", "ans"},
+	}},
+	"it": {",", map[string][2]string{
+		unitHour: {"ora", "ore"}, unitDay: {"giorno", "giorni"}, unitWeek: {"settimana", "settimane"},
+		unitMonth: {"mese", "mesi"}, unitYear: {"anno", "anni"},
+	}},
+	"pt": {",", map[string][2]string{
+		unitHour: {"hora", "horas"}, unitDay: {"dia", "dias"}, unitWeek: {"semana", "semanas"},
+		unitMonth: {"mês", "meses"}, unitYear: {"ano", "anos"},
+	}},
+}
+
+// humanFormat formats ages and estimates in human-readable outputs,
+// machine outputs keep hours and RFC 3339 dates. The zero value and
+// nil use the compact "2.5h" and "40d"
+type humanFormat struct {
+	locale      *localeUnits
+	units       string
+	hoursPerDay float64
+}
+
+// newHumanFormat checks the display config, it is compact if empty
+func newHumanFormat(dc *DisplayConfig) (*humanFormat, error) {
+	f := &humanFormat{units: unitHour, hoursPerDay: dc.HoursPerDay}
+	if f.hoursPerDay <= 0 {
+		f.hoursPerDay = hoursPerDay
+	}
+	if len(dc.Locale) == 0 && len(dc.Units) == 0 {
+		return f, nil
+	}
+	locale := strings.ToLower(dc.Locale)
+	if len(locale) == 0 {
+		locale = "en"
+	}
+	// "de_DE" and "pt-BR" use the language
+	if i := strings.IndexAny(locale, "_-"); i > 0 {
+		locale = locale[:i]
+	}
+	f.locale = locales[locale]
+	if f.locale == nil {
+		return nil, fmt.Errorf("Unknown display locale: %v", dc.Locale)
+	}
+	switch units := strings.TrimSuffix(strings.ToLower(dc.Units), "s"); units {
+	case "", unitHour:
+	case unitDay, unitWeek:
+		f.units = units
+	default:
+		return nil, fmt.Errorf("Unknown estimate units: %v, use hours, days or weeks", dc.Units)
+	}
+	return f, nil
+}
+
+// quantity returns the amount with its unit name
+func (f *humanFormat) quantity(value float64, unit string, sign bool) string {
+	value = float64(int64(value*10+0.5*sign1(value))) / 10
+	number := strconv.FormatFloat(value, 'f', -1, 64)
+	if sign && value >= 0 {
+		number = "+" + number
+	}
+	names := f.locale.units[unit]
+	name := names[1]
+	if value == 1 || value == -1 {
+		name = names[0]
+	}
+	return strings.Replace(number, ".", f.locale.decimal, 1) + " " + name
+}
+
+func sign1(v float64) float64 {
+	if v < 0 {
+		return -1
+	}
+	return 1
+}
+
+func (f *humanFormat) estimate(hours float64, sign bool) string {
+	if f == nil || f.locale == nil {
+		if sign {
+			return fmt.Sprintf("%+.1fh", hours)
+		}
+		return fmt.Sprintf("%.1fh", hours)
+	}
+	switch f.units {
+	case unitDay:
+		return f.quantity(hours/f.hoursPerDay, unitDay, sign)
+	case unitWeek:
+		return f.quantity(hours/f.hoursPerDay/daysPerWeek, unitWeek, sign)
+	}
+	return f.quantity(hours, unitHour, sign)
+}
+
+// Estimate formats hours in the configured units
+func (f *humanFormat) Estimate(hours float64) string {
+	return f.estimate(hours, false)
+}
+
+// SignedEstimate formats a change of estimates, e.g. "+2.5h"
+func (f *humanFormat) SignedEstimate(hours float64) string {
+	return f.estimate(hours, true)
+}
+
+// Age formats duration in the largest fitting calendar unit
+func (f *humanFormat) Age(d time.Duration) string {
+	days := int(d.Hours() / 24)
+	if f == nil || f.locale == nil {
+		return fmt.Sprintf("%vd", days)
+	}
+	switch {
+	case days < 14:
+		return f.quantity(float64(days), unitDay, false)
+	case days < 63:
+		return f.quantity(float64(days/7), unitWeek, false)
+	case days < 730:
+		return f.quantity(float64(days/30), unitMonth, false)
+	}
+	return f.quantity(float64(days/365), unitYear, false)
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// defaultIssueStatePath is relative to the root, it has to be kept
+	// between runs (e.g. committed) for reruns to find created issues
+	defaultIssueStatePath = ".scorpion/issues.json"
+)
+
+// issueRecord is a tracker issue created for a comment, with fields
+// of the comment it was last synced with
+type issueRecord struct {
+	Number   int     `json:"number"`
+	Type     string  `json:"type"`
+	File     string  `json:"file"`
+	Line     int     `json:"line"`
+	Title    string  `json:"title"`
+	Estimate float64 `json:"estimate,omitempty"`
+	// Digest is the hash of the issue description
+	Digest string `json:"digest"`
+}
+
+// issueState maps IDs of comments to their issues by tracker target
+// ("github:owner/name")
+type issueState struct {
+	Targets map[string]map[string]*issueRecord `json:"targets"`
+}
+
+// issueSync is the part of issue actions that updates the state
+// once the action is applied
+type issueSync struct {
+	State   string `json:"state"`
+	Comment string `json:"comment"`
+	// Previous is the ID of the comment before its title changed
+	Previous string       `json:"previous,omitempty"`
+	Record   *issueRecord `json:"record,omitempty"`
+}
+
+func loadIssueState(path string) (*issueState, error) {
+	state := &issueState{Targets: make(map[string]map[string]*issueRecord)}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("Invalid issue state %v: %v", path, err)
+	}
+	if state.Targets == nil {
+		state.Targets = make(map[string]map[string]*issueRecord)
+	}
+	return state, nil
+}
+
+func issueDigest(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:8])
+}
+
+func newIssueRecord(c *ToDoComment, content string) *issueRecord {
+	return &issueRecord{
+		Type:     c.Type,
+		File:     c.File,
+		Line:     c.Line,
+		Title:    c.Title,
+		Estimate: c.Estimate,
+		Digest:   issueDigest(content),
+	}
+}
+
+// issueReconciler plans creation, update and closing of issues of a
+// tracker target so that reruns never create duplicates
+type issueReconciler struct {
+	target  string
+	path    string
+	records map[string]*issueRecord
+}
+
+// newIssueReconciler reads records of the target from the state file,
+// path is relative to the root. Syncs record the absolute path, so that
+// the plan writes the state where it was read from regardless of the
+// plan root (the working or output directory)
+func newIssueReconciler(target, path, root string) (*issueReconciler, error) {
+	if len(path) == 0 {
+		path = defaultIssueStatePath
+	}
+	if !filepath.IsAbs(path) {
+		abs, err := filepath.Abs(filepath.Join(root, path))
+		if err != nil {
+			return nil, err
+		}
+		path = abs
+	}
+	state, err := loadIssueState(path)
+	if err != nil {
+		return nil, err
+	}
+	records := state.Targets[target]
+	if records == nil {
+		records = make(map[string]*issueRecord)
+	}
+	return &issueReconciler{target: target, path: path, records: records}, nil
+}
+
+// issueChange is a comment that needs an issue created or updated,
+// number is 0 for new issues
+type issueChange struct {
+	comment *ToDoComment
+	number  int
+	sync    *issueSync
+}
+
+// reconcile returns comments whose issues have to be created or
+// updated (content renders their description) and issues of resolved
+// comments to close. Comments of the previous scan with the same type,
+// file and line but another title are updated instead of replaced.
+// Only issues of the types of the sink (all if empty) whose comments
+// are missing from the whole scan are closed, nothing is closed if
+// the comments do not come from a complete scan
+func (r *issueReconciler) reconcile(report *result, types []string, content func(c *ToDoComment) string) ([]*issueChange, map[string]*issueRecord) {
+	changes := make([]*issueChange, 0)
+	seen := make(map[string]bool)
+	unmatched := make([]*ToDoComment, 0)
+	for _, c := range report.Comments {
+		seen[c.ID] = true
+		// comments linked by issue= are synced by hand
+		if c.Issue != 0 {
+			continue
+		}
+		// copies are covered by the issue of the first comment
+		if len(c.DuplicateOf) > 0 {
+			continue
+		}
+		record, ok := r.records[c.ID]
+		if !ok {
+			unmatched = append(unmatched, c)
+			continue
+		}
+		body := content(c)
+		if record.Title != c.Title || record.Digest != issueDigest(body) {
+			changes = append(changes, r.change(c, body, record.Number, ""))
+		}
+	}
+	// comments filtered out of the report are not missing from the scan
+	missing := func(id string) bool {
+		if report.scanned != nil {
+			return !report.scanned[id]
+		}
+		return !seen[id]
+	}
+	resolved := make(map[string]*issueRecord)
+	for id, record := range r.records {
+		if missing(id) {
+			resolved[id] = record
+		}
+	}
+	for _, c := range unmatched {
+		previous := ""
+		for id, record := range resolved {
+			if record.Type == c.Type && record.File == c.File && record.Line == c.Line {
+				previous = id
+				break
+			}
+		}
+		if len(previous) == 0 {
+			changes = append(changes, r.change(c, content(c), 0, ""))
+			continue
+		}
+		changes = append(changes, r.change(c, content(c), resolved[previous].Number, previous))
+		delete(resolved, previous)
+	}
+	// comments of unreadable and capped files are missing, not resolved
+	if report.scanned == nil || report.Partial || len(report.FileErrors) > 0 || len(report.CappedFiles) > 0 || len(scanPaths) > 0 {
+		return changes, nil
+	}
+	// issues of other types belong to other sinks
+	for id, record := range resolved {
+		if len(types) > 0 && !containsFold(types, record.Type) {
+			delete(resolved, id)
+		}
+	}
+	return changes, resolved
+}
+
+func (r *issueReconciler) change(c *ToDoComment, content string, number int, previous string) *issueChange {
+	record := newIssueRecord(c, content)
+	record.Number = number
+	return &issueChange{
+		comment: c,
+		number:  number,
+		sync:    &issueSync{State: r.path, Comment: c.ID, Previous: previous, Record: record},
+	}
+}
+
+// closeSync returns sync of closing the issue of the comment
+func (r *issueReconciler) closeSync(id string) *issueSync {
+	return &issueSync{State: r.path, Comment: id}
+}
+
+// record updates the state of the applied action, the issue is
+// removed if the action has no record
+func (s *issueSync) record(p *Plan, target string, number int) error {
+	if s == nil {
+		return nil
+	}
+	// state is shared by issues of the target
+	if i := strings.LastIndex(target, "#"); i >= 0 {
+		target = target[:i]
+	}
+	path := p.resolve(s.State)
+	state, err := loadIssueState(path)
+	if err != nil {
+		return err
+	}
+	records := state.Targets[target]
+	if records == nil {
+		records = make(map[string]*issueRecord)
+		state.Targets[target] = records
+	}
+	delete(records, s.Previous)
+	if s.Record == nil {
+		delete(records, s.Comment)
+	} else {
+		record := *s.Record
+		if number != 0 {
+			record.Number = number
+		}
+		records[s.Comment] = &record
+	}
+	js, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return applyWriteFile(p, &PlanAction{Kind: planWriteFile, Target: path, Content: string(js) + "\n"})
+}
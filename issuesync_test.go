@@ -0,0 +1,105 @@
+package main
+
+import (
+	"sort"
+	"testing"
+)
+
+func syncReport(comments ...*ToDoComment) *result {
+	report := &result{Comments: comments, scanned: make(map[string]bool)}
+	for _, c := range comments {
+		report.scanned[c.ID] = true
+	}
+	return report
+}
+
+func syncComment(id, ctype, file string, line int) *ToDoComment {
+	return &ToDoComment{ID: id, Type: ctype, Title: "title of " + id, File: file, Line: line}
+}
+
+func syncReconciler(comments ...*ToDoComment) *issueReconciler {
+	r := &issueReconciler{target: "github:o/r", records: make(map[string]*issueRecord)}
+	for i, c := range comments {
+		record := newIssueRecord(c, issueBody(c))
+		record.Number = i + 1
+		r.records[c.ID] = record
+	}
+	return r
+}
+
+func resolvedIDs(resolved map[string]*issueRecord) []string {
+	ids := make([]string, 0, len(resolved))
+	for id := range resolved {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// TestReconcileTypeFilteredSink checks that a sink of some types does
+// not close issues of comments routed away from it
+func TestReconcileTypeFilteredSink(t *testing.T) {
+	todo := syncComment("todo", "TODO", "a.go", 1)
+	bug := syncComment("bug", "BUG", "a.go", 5)
+	gone := syncComment("gone", "BUG", "b.go", 3)
+	goneTodo := syncComment("gone-todo", "TODO", "b.go", 7)
+	r := syncReconciler(todo, bug, gone, goneTodo)
+	types := []string{"BUG"}
+	report := routeReport(syncReport(todo, bug), types)
+
+	changes, resolved := r.reconcile(report, types, issueBody)
+	if len(changes) != 0 {
+		t.Fatalf("Planned %v changes of unchanged comments", len(changes))
+	}
+	if ids := resolvedIDs(resolved); len(ids) != 1 || ids[0] != "gone" {
+		t.Fatalf("Resolved %v, expected only the missing BUG", ids)
+	}
+}
+
+func TestReconcileFilteredReport(t *testing.T) {
+	todo := syncComment("todo", "TODO", "a.go", 1)
+	bug := syncComment("bug", "BUG", "a.go", 5)
+	r := syncReconciler(todo, bug)
+	report := syncReport(todo, bug)
+	// e.g. a pipeline filter of the path
+	report.Comments = []*ToDoComment{bug}
+
+	if _, resolved := r.reconcile(report, nil, issueBody); len(resolved) != 0 {
+		t.Fatalf("Resolved %v of a filtered report", resolvedIDs(resolved))
+	}
+}
+
+func TestReconcileNotScanned(t *testing.T) {
+	todo := syncComment("todo", "TODO", "a.go", 1)
+	r := syncReconciler(todo, syncComment("bug", "BUG", "a.go", 5))
+	report := &result{Comments: []*ToDoComment{todo}}
+
+	if _, resolved := r.reconcile(report, nil, issueBody); len(resolved) != 0 {
+		t.Fatalf("Resolved %v of a report not coming from a scan", resolvedIDs(resolved))
+	}
+}
+
+func TestReconcileRenamedComment(t *testing.T) {
+	todo := syncComment("todo", "TODO", "a.go", 1)
+	r := syncReconciler(todo)
+	renamed := syncComment("renamed", "TODO", "a.go", 1)
+
+	changes, resolved := r.reconcile(syncReport(renamed), nil, issueBody)
+	if len(resolved) != 0 {
+		t.Fatalf("Resolved %v of a renamed comment", resolvedIDs(resolved))
+	}
+	if len(changes) != 1 || changes[0].number != 1 || changes[0].sync.Previous != "todo" {
+		t.Fatalf("Renamed comment does not update its issue: %+v", changes)
+	}
+}
+
+func TestReconcileCappedFiles(t *testing.T) {
+	todo := syncComment("todo", "TODO", "a.go", 1)
+	r := syncReconciler(todo, syncComment("capped", "TODO", "a.go", 300))
+	report := syncReport(todo)
+	report.CappedFiles = []*CappedFile{{Path: "a.go", Comments: 300, Kept: 1}}
+
+	if _, resolved := r.reconcile(report, nil, issueBody); len(resolved) != 0 {
+		t.Fatalf("Resolved %v of a capped file", resolvedIDs(resolved))
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	strictConfidence    = 1.0
+	annotatedConfidence = 0.8
+	lenientConfidence   = 0.6
+	caseMismatchPenalty = 0.2
+)
+
+// keyword is a prefix of TODO-like comments
+type keyword struct {
+	runes []rune
+	// ctype is the type of comments with this keyword
+	ctype         []rune
+	caseSensitive bool
+	requireColon  bool
+	wordBoundary  bool
+}
+
+func newKeyword(kw, ctype string, lenient bool) *keyword {
+	return &keyword{
+		runes:        []rune(kw),
+		ctype:        []rune(ctype),
+		requireColon: !lenient,
+		wordBoundary: true,
+	}
+}
+
+func boolValue(b *bool, def bool) bool {
+	if b == nil {
+		return def
+	}
+	return *b
+}
+
+func intValue(i *int, def int) int {
+	if i == nil {
+		return def
+	}
+	return *i
+}
+
+// caseSensitiveKeywords are common words of doc comments, like
+// "Deprecated:" of Go, so only the upper-case form is a keyword
+var caseSensitiveKeywords = map[string]bool{securityKeyword: true, deprecatedKeyword: true}
+
+// newKeywords returns built-in keywords followed by configured ones,
+// configured built-in keyword only changes its options. In lenient
+// mode the colon after keywords is not required by default
+func newKeywords(config *KeywordsConfig, lenient bool) *keywordSet {
+	lenient = lenient || config.Lenient
+	keywords := make([]*keyword, 0, len(commentKeywords)+len(config.Custom))
+	for _, kw := range commentKeywords {
+		k := newKeyword(kw, kw, lenient)
+		k.caseSensitive = caseSensitiveKeywords[kw]
+		keywords = append(keywords, k)
+	}
+	for _, kc := range config.Custom {
+		name := strings.TrimRightFunc(kc.Keyword, isKeywordSeparator)
+		if len(name) == 0 {
+			continue
+		}
+		var kw *keyword
+		for _, k := range keywords {
+			if strings.EqualFold(string(k.runes), name) {
+				kw = k
+				break
+			}
+		}
+		if kw == nil {
+			kw = newKeyword(name, strings.ToUpper(name), lenient)
+			keywords = append(keywords, kw)
+		}
+		if len(kc.Type) > 0 {
+			kw.ctype = []rune(kc.Type)
+		}
+		kw.caseSensitive = kc.CaseSensitive
+		kw.requireColon = boolValue(kc.RequireColon, !lenient)
+		kw.wordBoundary = boolValue(kc.WordBoundary, true)
+	}
+	return newKeywordSet(keywords)
+}
+
+// match checks if the line starts with the keyword
+func (kw *keyword) match(line []rune) bool {
+	if len(line) <= len(kw.runes) {
+		return false
+	}
+	if kw.caseSensitive {
+		for i, r := range kw.runes {
+			if line[i] != r {
+				return false
+			}
+		}
+	} else if !startsWith(line, kw.runes) {
+		return false
+	}
+	if kw.wordBoundary && isWordRune(line[len(kw.runes)]) {
+		return false
+	}
+	return true
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+// trimTitle returns title following the keyword and annotation,
+// with the separator or, unless strict, just a space if colon
+// is not required
+func (kw *keyword) trimTitle(rest []rune, strict bool) []rune {
+	if t := trimSeparator(rest); t != nil {
+		return t
+	}
+	if strict || kw.requireColon || len(rest) == 0 || !unicode.IsSpace(rest[0]) {
+		return nil
+	}
+	for len(rest) > 0 && unicode.IsSpace(rest[0]) {
+		rest = rest[1:]
+	}
+	if len(rest) == 0 {
+		return nil
+	}
+	return rest
+}
+
+func isKeywordSeparator(r rune) bool {
+	return r == ':' || r == '：' || unicode.IsSpace(r)
+}
+
+// equalFoldRune compares runes using simple Unicode case folding
+func equalFoldRune(a, b rune) bool {
+	if a == b {
+		return true
+	}
+	for f := unicode.SimpleFold(a); f != a; f = unicode.SimpleFold(f) {
+		if f == b {
+			return true
+		}
+	}
+	return false
+}
+
+// foldRune returns the smallest rune of the simple folding orbit,
+// so that all cases of a rune have the same folded form
+func foldRune(r rune) rune {
+	if r < utf8.RuneSelf {
+		if 'a' <= r && r <= 'z' {
+			r -= 'a' - 'A'
+		}
+		return r
+	}
+	min := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < min {
+			min = f
+		}
+	}
+	return min
+}
+
+// trimSeparator returns title after ": " or full-width colon
+// following the keyword, nil if there is no separator or title
+func trimSeparator(rest []rune) []rune {
+	if len(rest) == 0 {
+		return nil
+	}
+	switch {
+	case rest[0] == '：':
+		rest = rest[1:]
+	case len(rest) > len(keywordSeparator) && startsWith(rest, keywordSeparator):
+		rest = rest[len(keywordSeparator):]
+	default:
+		return nil
+	}
+	for len(rest) > 0 && unicode.IsSpace(rest[0]) {
+		rest = rest[1:]
+	}
+	if len(rest) == 0 {
+		return nil
+	}
+	return rest
+}
+
+// confidence estimates how likely the matched line is a real TODO:
+// strict form is certain, lenient forms are less so and more so
+// when the keyword is not written in the configured case
+func (kw *keyword) confidence(line []rune, strict, annotated bool) float64 {
+	if strict {
+		return strictConfidence
+	}
+	confidence := lenientConfidence
+	if annotated {
+		confidence = annotatedConfidence
+	}
+	if string(line[:len(kw.runes)]) != string(kw.runes) {
+		confidence -= caseMismatchPenalty
+	}
+	return math.Round(confidence*100) / 100
+}
+
+// keywordPrefilter finds files that may contain keywords by scanning
+// bytes, so that files without them skip parsing line by line
+type keywordPrefilter struct {
+	// first marks bytes keywords may start with in any case
+	first    [256]bool
+	keywords [][]byte
+}
+
+func newKeywordPrefilter(keywords []*keyword) *keywordPrefilter {
+	p := &keywordPrefilter{}
+	for _, kw := range keywords {
+		if len(kw.runes) == 0 {
+			continue
+		}
+		b := []byte(string(kw.runes))
+		p.keywords = append(p.keywords, b)
+		r := kw.runes[0]
+		if r >= utf8.RuneSelf {
+			// folded forms of non-ASCII runes may start with any
+			// multibyte sequence (and "ſ" folds to "s")
+			for c := utf8.RuneSelf; c < len(p.first); c++ {
+				p.first[c] = true
+			}
+			continue
+		}
+		p.first[unicode.ToLower(r)] = true
+		p.first[unicode.ToUpper(r)] = true
+	}
+	for c := range p.first {
+		if p.first[c] && c < utf8.RuneSelf && unicode.IsLetter(rune(c)) {
+			// non-ASCII runes folding to ASCII letters ("K" is Kelvin sign)
+			for f := unicode.SimpleFold(rune(c)); f != rune(c); f = unicode.SimpleFold(f) {
+				if f >= utf8.RuneSelf {
+					b := make([]byte, utf8.UTFMax)
+					p.first[b[:utf8.EncodeRune(b, f)][0]] = true
+				}
+			}
+		}
+	}
+	return p
+}
+
+// Match checks if content contains any keyword ignoring case,
+// it does not allocate
+func (p *keywordPrefilter) Match(content []byte) bool {
+	for i, c := range content {
+		if !p.first[c] {
+			continue
+		}
+		rest := content[i:]
+		for _, kw := range p.keywords {
+			if hasFoldPrefix(rest, kw) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasFoldPrefix compares runes of s and prefix by simple folding
+func hasFoldPrefix(s, prefix []byte) bool {
+	for len(prefix) > 0 {
+		if len(s) == 0 {
+			return false
+		}
+		a, n := utf8.DecodeRune(s)
+		b, m := utf8.DecodeRune(prefix)
+		if !equalFoldRune(a, b) {
+			return false
+		}
+		s, prefix = s[n:], prefix[m:]
+	}
+	return true
+}
+
+// keywordSet is the ordered list of keywords with Aho–Corasick
+// automaton of their folded runes, so that all keywords are matched
+// in one pass over the text instead of comparing them one by one
+type keywordSet struct {
+	list  []*keyword
+	nodes []keywordNode
+}
+
+// keywordNode is a state of the automaton
+type keywordNode struct {
+	next map[rune]int
+	fail int
+	// own are indexes of keywords ending in this state,
+	// out also includes the ones ending in its fail states
+	own []int
+	out []int
+}
+
+func newKeywordSet(keywords []*keyword) *keywordSet {
+	ks := &keywordSet{
+		list:  keywords,
+		nodes: []keywordNode{{next: make(map[rune]int)}},
+	}
+	for i, kw := range keywords {
+		if len(kw.runes) == 0 {
+			continue
+		}
+		s := 0
+		for _, r := range kw.runes {
+			r = foldRune(r)
+			n, ok := ks.nodes[s].next[r]
+			if !ok {
+				n = len(ks.nodes)
+				ks.nodes = append(ks.nodes, keywordNode{next: make(map[rune]int)})
+				ks.nodes[s].next[r] = n
+			}
+			s = n
+		}
+		ks.nodes[s].own = append(ks.nodes[s].own, i)
+	}
+	// breadth-first order sets fail states before they are followed
+	queue := []int{0}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		node := &ks.nodes[s]
+		node.out = append(node.out, node.own...)
+		if s != 0 {
+			node.out = append(node.out, ks.nodes[node.fail].out...)
+		}
+		for r, n := range node.next {
+			fail := 0
+			if s != 0 {
+				fail = ks.step(node.fail, r)
+			}
+			ks.nodes[n].fail = fail
+			queue = append(queue, n)
+		}
+	}
+	return ks
+}
+
+// step returns the state after reading rune r in state s
+func (ks *keywordSet) step(s int, r rune) int {
+	r = foldRune(r)
+	for {
+		if n, ok := ks.nodes[s].next[r]; ok {
+			return n
+		}
+		if s == 0 {
+			return 0
+		}
+		s = ks.nodes[s].fail
+	}
+}
+
+// prefixes appends to buf indexes of the keywords text starts with
+// ignoring case, in the order of the list
+func (ks *keywordSet) prefixes(text []rune, buf []int) []int {
+	s := 0
+	for _, r := range text {
+		n, ok := ks.nodes[s].next[foldRune(r)]
+		if !ok {
+			break
+		}
+		s = n
+		buf = append(buf, ks.nodes[s].own...)
+	}
+	// there are a few candidates at most
+	for i := 1; i < len(buf); i++ {
+		for j := i; j > 0 && buf[j] < buf[j-1]; j-- {
+			buf[j], buf[j-1] = buf[j-1], buf[j]
+		}
+	}
+	return buf
+}
+
+// find calls f with the start index of each keyword matching anywhere
+// in the text, at word boundary unless the keyword allows otherwise,
+// in order of their ends until f returns false
+func (ks *keywordSet) find(text []rune, f func(start int, kw *keyword) bool) {
+	s := 0
+	for i, r := range text {
+		s = ks.step(s, r)
+		for _, k := range ks.nodes[s].out {
+			kw := ks.list[k]
+			start := i + 1 - len(kw.runes)
+			if kw.wordBoundary && start > 0 && isWordRune(text[start-1]) {
+				continue
+			}
+			if kw.match(text[start:]) && !f(start, kw) {
+				return
+			}
+		}
+	}
+}
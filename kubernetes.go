@@ -0,0 +1,224 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	kubernetesSinkName     = "kubernetes"
+	kubernetesTargetPrefix = "kubernetes:"
+	planPatchObject        = "patch-object"
+	kubernetesSecret       = "kubernetes"
+	// kubernetesAnnotationPrefix prefixes annotations of deployments
+	kubernetesAnnotationPrefix = "scorpion.qorpress.io/"
+	// kubernetesTopItems is number of comments with the biggest
+	// estimates in the summary
+	kubernetesTopItems = 10
+	// kubernetesProxyURL is the API server outside of a cluster,
+	// started with "kubectl proxy"
+	kubernetesProxyURL         = "http://localhost:8001"
+	kubernetesServiceAccount   = "/var/run/secrets/kubernetes.io/serviceaccount"
+	kubernetesDefaultNamespace = "default"
+)
+
+var (
+	errInvalidKubernetesObject = errors.New(`Kubernetes object must be "configmap/<name>" or "deployment/<name>"`)
+)
+
+// kubernetesSummary is published for dashboards scraping objects
+type kubernetesSummary struct {
+	Project  string               `json:"project"`
+	Branch   string               `json:"branch,omitempty"`
+	Revision string               `json:"revision,omitempty"`
+	Comments int                  `json:"comments"`
+	Estimate float64              `json:"estimate"`
+	Types    map[string]int       `json:"types"`
+	Top      []*kubernetesTopItem `json:"top"`
+}
+
+type kubernetesTopItem struct {
+	Type     string  `json:"type"`
+	Title    string  `json:"title"`
+	Location string  `json:"location"`
+	Estimate float64 `json:"estimate,omitempty"`
+}
+
+// summarizeReport returns totals of the report and the comments with
+// the biggest estimates
+func summarizeReport(report *result) *kubernetesSummary {
+	summary := &kubernetesSummary{
+		Project:  report.Project,
+		Branch:   report.Branch,
+		Revision: report.Revision,
+		Comments: len(report.Comments),
+		Types:    make(map[string]int),
+		Top:      make([]*kubernetesTopItem, 0, kubernetesTopItems),
+	}
+	comments := append([]*ToDoComment{}, report.Comments...)
+	for _, c := range comments {
+		summary.Estimate += c.Estimate
+		summary.Types[c.Type]++
+	}
+	sort.SliceStable(comments, func(i, j int) bool {
+		return comments[i].Estimate > comments[j].Estimate
+	})
+	for i, c := range comments {
+		if i == kubernetesTopItems {
+			break
+		}
+		summary.Top = append(summary.Top, &kubernetesTopItem{
+			Type:     c.Type,
+			Title:    c.Title,
+			Location: commentLocation(c),
+			Estimate: c.Estimate,
+		})
+	}
+	return summary
+}
+
+type kubernetesSink struct {
+	kind      string
+	name      string
+	namespace string
+}
+
+func newKubernetesSink(sc *SinkConfig, env *Environment) (Sink, error) {
+	parts := strings.SplitN(sc.Object, "/", 2)
+	if len(parts) != 2 || len(parts[1]) == 0 {
+		return nil, errInvalidKubernetesObject
+	}
+	kind := strings.ToLower(parts[0])
+	if kind != "configmap" && kind != "deployment" {
+		return nil, errInvalidKubernetesObject
+	}
+	namespace := sc.Namespace
+	if len(namespace) == 0 {
+		namespace = kubernetesDefaultNamespace
+		// the namespace of the pod when running in a cluster
+		if data, err := ioutil.ReadFile(kubernetesServiceAccount + "/namespace"); err == nil {
+			namespace = strings.TrimSpace(string(data))
+		}
+	}
+	return &kubernetesSink{kind: kind, name: parts[1], namespace: namespace}, nil
+}
+
+// patch returns merge patch of the object with the summary, data of
+// config maps or annotations of deployments
+func (s *kubernetesSink) patch(summary *kubernetesSummary) (map[string]interface{}, error) {
+	js, err := json.Marshal(summary)
+	if err != nil {
+		return nil, err
+	}
+	types := make([]string, 0, len(summary.Types))
+	for t, count := range summary.Types {
+		types = append(types, fmt.Sprintf("%v=%v", t, count))
+	}
+	sort.Strings(types)
+	values := map[string]string{
+		"comments": strconv.Itoa(summary.Comments),
+		"estimate": strconv.FormatFloat(summary.Estimate, 'f', -1, 64),
+		"types":    strings.Join(types, ","),
+	}
+	if s.kind == "configmap" {
+		values["summary.json"] = string(js)
+		return map[string]interface{}{"data": values}, nil
+	}
+	annotations := make(map[string]string, len(values)+1)
+	for k, v := range values {
+		annotations[kubernetesAnnotationPrefix+k] = v
+	}
+	annotations[kubernetesAnnotationPrefix+"summary"] = string(js)
+	return map[string]interface{}{"metadata": map[string]interface{}{"annotations": annotations}}, nil
+}
+
+func (s *kubernetesSink) Emit(report *result, plan *Plan) error {
+	summary := summarizeReport(report)
+	patch, err := s.patch(summary)
+	if err != nil {
+		return err
+	}
+	js, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	plan.Add(&PlanAction{
+		Kind:    planPatchObject,
+		Target:  fmt.Sprintf("%v%v/%v/%v", kubernetesTargetPrefix, s.namespace, s.kind, s.name),
+		Summary: fmt.Sprintf("publish %v comments", summary.Comments),
+		Content: string(js),
+	})
+	return nil
+}
+
+// kubernetesClient returns url of the API server and the client,
+// the service account of the pod is used in a cluster
+func kubernetesClient(p *Plan) (string, map[string]string, *http.Client, error) {
+	headers := make(map[string]string)
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if len(host) == 0 {
+		// kubectl proxy authenticates requests itself
+		if token, err := p.Secrets.Get(kubernetesSecret); err == nil {
+			headers["Authorization"] = "Bearer " + token
+		}
+		return kubernetesProxyURL, headers, httpClient, nil
+	}
+	token, err := ioutil.ReadFile(kubernetesServiceAccount + "/token")
+	if err != nil {
+		return "", nil, nil, err
+	}
+	headers["Authorization"] = "Bearer " + strings.TrimSpace(string(token))
+	ca, err := ioutil.ReadFile(kubernetesServiceAccount + "/ca.crt")
+	if err != nil {
+		return "", nil, nil, err
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+	client := &http.Client{
+		Timeout:   httpTimeout,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}
+	return "https://" + net.JoinHostPort(host, port), headers, client, nil
+}
+
+// applyPatchObject merges the patch into the object, config maps
+// are created if they do not exist yet
+func applyPatchObject(p *Plan, a *PlanAction) error {
+	parts := strings.Split(strings.TrimPrefix(a.Target, kubernetesTargetPrefix), "/")
+	if len(parts) != 3 {
+		return fmt.Errorf("%v: %v %v", errUnknownPlanAction, a.Kind, a.Target)
+	}
+	namespace, kind, name := parts[0], parts[1], parts[2]
+	server, headers, client, err := kubernetesClient(p)
+	if err != nil {
+		return err
+	}
+	collection := fmt.Sprintf("%v/api/v1/namespaces/%v/configmaps", server, namespace)
+	if kind == "deployment" {
+		collection = fmt.Sprintf("%v/apis/apps/v1/namespaces/%v/deployments", server, namespace)
+	}
+	var patch map[string]interface{}
+	if err := json.Unmarshal([]byte(a.Content), &patch); err != nil {
+		return err
+	}
+	headers["Content-Type"] = "application/merge-patch+json"
+	err = doJSONRequestWith(client, "PATCH", collection+"/"+name, headers, patch, nil)
+	statusErr, ok := err.(*httpStatusError)
+	if !ok || statusErr.Status != http.StatusNotFound || kind != "configmap" {
+		return err
+	}
+	delete(headers, "Content-Type")
+	patch["apiVersion"], patch["kind"] = "v1", "ConfigMap"
+	patch["metadata"] = map[string]string{"name": name, "namespace": namespace}
+	return doJSONRequestWith(client, "POST", collection, headers, patch, nil)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+const (
+	unknownLanguage = "Other"
+)
+
+var (
+	languageByExtension = map[string]string{
+		".go":     "Go",
+		".c":      "C",
+		".h":      "C",
+		".cc":     "C++",
+		".cpp":    "C++",
+		".cxx":    "C++",
+		".hpp":    "C++",
+		".hh":     "C++",
+		".m":      "Objective-C",
+		".mm":     "Objective-C",
+		".cs":     "C#",
+		".java":   "Java",
+		".kt":     "Kotlin",
+		".kts":    "Kotlin",
+		".scala":  "Scala",
+		".swift":  "Swift",
+		".rs":     "Rust",
+		".js":     "JavaScript",
+		".mjs":    "JavaScript",
+		".cjs":    "JavaScript",
+		".jsx":    "JavaScript",
+		".ts":     "TypeScript",
+		".tsx":    "TypeScript",
+		".vue":    "Vue",
+		".svelte": "Svelte",
+		".py":     "Python",
+		".rb":     "Ruby",
+		".erb":    "ERB",
+		".php":    "PHP",
+		".pl":     "Perl",
+		".pm":     "Perl",
+		".lua":    "Lua",
+		".sh":     "Shell",
+		".bash":   "Shell",
+		".zsh":    "Shell",
+		".ps1":    "PowerShell",
+		".sql":    "SQL",
+		".r":      "R",
+		".tex":    "TeX",
+		".erl":    "Erlang",
+		".ex":     "Elixir",
+		".exs":    "Elixir",
+		".el":     "Emacs Lisp",
+		".clj":    "Clojure",
+		".lisp":   "Lisp",
+		".hs":     "Haskell",
+		".html":   "HTML",
+		".htm":    "HTML",
+		".css":    "CSS",
+		".scss":   "SCSS",
+		".less":   "Less",
+		".yml":    "YAML",
+		".yaml":   "YAML",
+		".toml":   "TOML",
+		".ini":    "INI",
+		".proto":  "Protocol Buffers",
+		".tf":     "Terraform",
+		".cmake":  "CMake",
+		".md":     "Markdown",
+		".j2":     "Jinja",
+		".jinja":  "Jinja",
+		".tmpl":   "Go Template",
+		".gohtml": "Go Template",
+	}
+	languageByFilename = map[string]string{
+		"makefile":       "Makefile",
+		"gnumakefile":    "Makefile",
+		"dockerfile":     "Dockerfile",
+		"containerfile":  "Dockerfile",
+		"cmakelists.txt": "CMake",
+		"gemfile":        "Ruby",
+		"rakefile":       "Ruby",
+		"jenkinsfile":    "Groovy",
+		"vagrantfile":    "Ruby",
+	}
+)
+
+// detectLanguage returns name of the language of the file
+// based on its name and extension
+func detectLanguage(path string) string {
+	name := strings.ToLower(filepath.Base(path))
+	if lang, ok := languageByFilename[name]; ok {
+		return lang
+	}
+	if strings.HasPrefix(name, "dockerfile.") || strings.HasSuffix(name, ".dockerfile") {
+		return "Dockerfile"
+	}
+	// Makefile.am and Makefile.in of autotools
+	if strings.HasPrefix(name, "makefile.") || strings.HasSuffix(name, ".mk") {
+		return "Makefile"
+	}
+	if lang, ok := languageByExtension[filepath.Ext(name)]; ok {
+		return lang
+	}
+	return unknownLanguage
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	lintCheckName       = "lint"
+	defaultLintMinScore = 0.5
+	lintMinWords        = 3
+)
+
+var (
+	// vagueTitles are not actionable on their own
+	vagueTitles = []string{
+		"fix", "fix this", "fix me", "fixme", "fix it", "fix later", "temp", "temporary",
+		"todo", "hack", "later", "cleanup", "clean up", "refactor", "refactor this",
+		"wip", "tbd", "xxx", "implement", "implement this", "remove", "remove this",
+		"check", "check this", "improve", "improve this", "change this", "revisit",
+	}
+	// lintVerbs are common verbs of actionable titles
+	lintVerbs = []string{
+		"add", "allow", "avoid", "build", "cache", "call", "change", "check", "clean",
+		"convert", "create", "delete", "deprecate", "detect", "document", "drop",
+		"enable", "disable", "ensure", "extract", "fix", "handle", "ignore", "implement",
+		"improve", "inline", "log", "make", "merge", "migrate", "move", "optimize",
+		"parse", "pass", "print", "read", "reduce", "refactor", "remove", "rename",
+		"replace", "report", "retry", "return", "reuse", "rewrite", "run", "save",
+		"send", "set", "simplify", "skip", "sort", "split", "store", "support",
+		"switch", "test", "update", "upgrade", "use", "validate", "verify", "write",
+	}
+)
+
+func titleWords(title string) []string {
+	return strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+}
+
+func isVagueTitle(title string, extra []string) bool {
+	normalized := strings.Join(titleWords(title), " ")
+	return containsFold(vagueTitles, normalized) || containsFold(extra, normalized)
+}
+
+func hasVerb(words []string) bool {
+	for _, w := range words {
+		for _, v := range lintVerbs {
+			// also matches forms like "adds", "added", "handling"
+			if w == v || strings.HasPrefix(w, v) && len(w)-len(v) <= 3 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func repeatedWord(words []string) string {
+	for i := 1; i < len(words); i++ {
+		if words[i] == words[i-1] {
+			return words[i]
+		}
+	}
+	return ""
+}
+
+// scoreTitle rates how actionable the title is from 0 to 1
+// and returns the reasons of lowering the score
+func scoreTitle(title string, config *LintConfig) (float64, []string) {
+	if isVagueTitle(title, config.Vague) {
+		return 0, []string{"title is too vague"}
+	}
+	words := titleWords(title)
+	score := 1.0
+	reasons := make([]string, 0)
+	if len(words) < lintMinWords {
+		score -= 0.4
+		reasons = append(reasons, fmt.Sprintf("title has less than %v words", lintMinWords))
+	}
+	// verbs are only known for english titles
+	if lang := detectNaturalLanguage(title); (lang == "" || lang == "en") && !hasVerb(words) {
+		score -= 0.3
+		reasons = append(reasons, "title lacks a verb")
+	}
+	if w := repeatedWord(words); len(w) > 0 {
+		score -= 0.2
+		reasons = append(reasons, fmt.Sprintf("word %q is repeated", w))
+	}
+	if score < 0 {
+		score = 0
+	}
+	return score, reasons
+}
+
+// lintCheck flags comments with titles below the quality score
+func lintCheck(report *result, config *Config, env *Environment) ([]*Violation, error) {
+	lc := &config.Gate.Lint
+	if !lc.Enabled && !lintFlag {
+		return nil, nil
+	}
+	minScore := lc.MinScore
+	if minScore <= 0 {
+		minScore = defaultLintMinScore
+	}
+	violations := make([]*Violation, 0)
+	for _, c := range report.Comments {
+		score, reasons := scoreTitle(c.Title, lc)
+		if score >= minScore {
+			continue
+		}
+		violations = append(violations, &Violation{
+			Check:   lintCheckName,
+			Message: fmt.Sprintf("score %.1f: %v", score, strings.Join(reasons, ", ")),
+			Comment: c,
+		})
+	}
+	return violations, nil
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf16"
+)
+
+const (
+	lspSource = appName
+
+	// diagnostic severities of LSP
+	lspError       = 1
+	lspWarning     = 2
+	lspInformation = 3
+	lspHint        = 4
+	// lspDeprecated tag renders the comment struck through
+	lspDeprecated = 2
+
+	// textDocumentSync kind of full content on every change
+	lspSyncFull = 1
+
+	lspMethodNotFound = -32601
+)
+
+var (
+	errLSPStdout   = errors.New("Logs cannot be duplicated to stdout in lsp mode")
+	errLSPShutdown = errors.New("Exit without shutdown request")
+	// lspSeverities maps comment types to diagnostic severities,
+	// other types are reported as information
+	lspSeverities = map[string]int{
+		"BUG":        lspWarning,
+		"FIXME":      lspWarning,
+		"URGENT":     lspError,
+		"REFS":       lspHint,
+		"SECURITY":   lspError,
+		"DEPRECATED": lspWarning,
+	}
+)
+
+type lspMessage struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      *json.RawMessage  `json:"id,omitempty"`
+	Method  string            `json:"method,omitempty"`
+	Params  json.RawMessage   `json:"params,omitempty"`
+	Result  interface{}       `json:"result,omitempty"`
+	Error   *lspResponseError `json:"error,omitempty"`
+}
+
+type lspResponseError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type lspPosition struct {
+	Line      int `json:"line"`
+	Character int `json:"character"`
+}
+
+type lspRange struct {
+	Start lspPosition `json:"start"`
+	End   lspPosition `json:"end"`
+}
+
+type lspDiagnostic struct {
+	Range    lspRange `json:"range"`
+	Severity int      `json:"severity"`
+	Code     string   `json:"code"`
+	Source   string   `json:"source"`
+	Message  string   `json:"message"`
+	Tags     []int    `json:"tags,omitempty"`
+}
+
+type lspDocumentParams struct {
+	TextDocument struct {
+		URI  string `json:"uri"`
+		Text string `json:"text"`
+	} `json:"textDocument"`
+	// ContentChanges of didChange, the last one has full text
+	ContentChanges []struct {
+		Text string `json:"text"`
+	} `json:"contentChanges"`
+	// Text of didSave if the client includes it
+	Text *string `json:"text"`
+}
+
+// lspServer publishes diagnostics for TODO-like comments
+// of open documents, messages are handled sequentially
+type lspServer struct {
+	td       *ToDoGenerator
+	in       *bufio.Reader
+	out      io.Writer
+	outMux   sync.Mutex
+	docs     map[string]string
+	shutdown bool
+}
+
+func newLSPServer(td *ToDoGenerator, in io.Reader, out io.Writer) *lspServer {
+	return &lspServer{
+		td:   td,
+		in:   bufio.NewReader(in),
+		out:  out,
+		docs: make(map[string]string),
+	}
+}
+
+// read returns the next message framed with Content-Length header
+func (s *lspServer) read() (*lspMessage, error) {
+	header, err := textproto.NewReader(s.in).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Content-Length: %v", header.Get("Content-Length"))
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(s.in, body); err != nil {
+		return nil, err
+	}
+	msg := &lspMessage{}
+	if err := json.Unmarshal(body, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (s *lspServer) write(msg *lspMessage) error {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	s.outMux.Lock()
+	defer s.outMux.Unlock()
+	if _, err := fmt.Fprintf(s.out, "Content-Length: %v\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = s.out.Write(body)
+	return err
+}
+
+func (s *lspServer) reply(id *json.RawMessage, result interface{}) error {
+	if result == nil {
+		// result must be present even if it is null
+		result = json.RawMessage("null")
+	}
+	return s.write(&lspMessage{ID: id, Result: result})
+}
+
+func (s *lspServer) replyError(id *json.RawMessage, code int, message string) error {
+	return s.write(&lspMessage{ID: id, Error: &lspResponseError{Code: code, Message: message}})
+}
+
+// Serve handles messages until the exit notification or end of input
+func (s *lspServer) Serve() error {
+	for {
+		msg, err := s.read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			// exit code is 1 unless the client shut the server down
+			if !s.shutdown {
+				return errLSPShutdown
+			}
+			return nil
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *lspServer) handle(msg *lspMessage) error {
+	switch msg.Method {
+	case "initialize":
+		return s.reply(msg.ID, map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync": map[string]interface{}{
+					"openClose": true,
+					"change":    lspSyncFull,
+					"save":      map[string]bool{"includeText": true},
+				},
+			},
+			"serverInfo": map[string]string{"name": appName, "version": version},
+		})
+	case "shutdown":
+		s.shutdown = true
+		return s.reply(msg.ID, nil)
+	case "textDocument/didOpen", "textDocument/didChange", "textDocument/didSave", "textDocument/didClose":
+		params := &lspDocumentParams{}
+		if err := json.Unmarshal(msg.Params, params); err != nil {
+			log.Printf("Invalid %v params: %v", msg.Method, err)
+			return nil
+		}
+		return s.sync(msg.Method, params)
+	}
+	if msg.ID != nil {
+		return s.replyError(msg.ID, lspMethodNotFound, "Method not found: "+msg.Method)
+	}
+	// other notifications are not needed for diagnostics
+	return nil
+}
+
+// sync updates the document and publishes its diagnostics
+func (s *lspServer) sync(method string, params *lspDocumentParams) error {
+	uri := params.TextDocument.URI
+	switch method {
+	case "textDocument/didOpen":
+		s.docs[uri] = params.TextDocument.Text
+	case "textDocument/didChange":
+		if n := len(params.ContentChanges); n > 0 {
+			s.docs[uri] = params.ContentChanges[n-1].Text
+		}
+	case "textDocument/didSave":
+		if params.Text != nil {
+			s.docs[uri] = *params.Text
+		}
+	case "textDocument/didClose":
+		delete(s.docs, uri)
+		return s.publish(uri, make([]*lspDiagnostic, 0))
+	}
+	return s.publish(uri, s.diagnose(uri, s.docs[uri]))
+}
+
+func (s *lspServer) publish(uri string, diagnostics []*lspDiagnostic) error {
+	params, err := json.Marshal(map[string]interface{}{
+		"uri":         uri,
+		"diagnostics": diagnostics,
+	})
+	if err != nil {
+		return err
+	}
+	return s.write(&lspMessage{Method: "textDocument/publishDiagnostics", Params: params})
+}
+
+// uriPath returns file path of the document uri
+func uriPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// utf16Length returns length of the line in LSP characters
+func utf16Length(line string) int {
+	return len(utf16.Encode([]rune(line)))
+}
+
+func (s *lspServer) diagnose(uri, text string) []*lspDiagnostic {
+	lines := strings.Split(text, "\n")
+	comments := s.td.ParseDocument(uriPath(uri), []byte(text))
+	diagnostics := make([]*lspDiagnostic, 0, len(comments))
+	for _, c := range comments {
+		severity, ok := lspSeverities[c.Type]
+		if !ok {
+			severity = lspInformation
+		}
+		end := 0
+		if c.Line < len(lines) {
+			end = utf16Length(strings.TrimRight(lines[c.Line], "\r"))
+		}
+		message := c.Title
+		if c.Estimate >= estimateEpsilon {
+			message = fmt.Sprintf("%v (%vh)", message, c.Estimate)
+		}
+		var tags []int
+		if c.Type == deprecatedKeyword {
+			tags = []int{lspDeprecated}
+		}
+		diagnostics = append(diagnostics, &lspDiagnostic{
+			Range: lspRange{
+				Start: lspPosition{Line: c.Line},
+				End:   lspPosition{Line: c.Line, Character: end},
+			},
+			Severity: severity,
+			Code:     c.Type,
+			Source:   lspSource,
+			Message:  message,
+			Tags:     tags,
+		})
+	}
+	return diagnostics
+}
+
+// runLSP implements "scorpion lsp" language server over stdio
+func runLSP(args []string) error {
+	if stdoutFlag {
+		return errLSPStdout
+	}
+	config, err := loadConfig(configPathFlag, srcRootFlag)
+	if err != nil {
+		return err
+	}
+	td, err := NewToDoGenerator(srcRootFlag, includePatternsFlag, minWordCountFlag, minCharsFlag, config)
+	if err != nil {
+		return err
+	}
+	log.Printf("Language server started")
+	go func() {
+		// reads of stdin cannot be cancelled
+		<-stopping
+		log.Printf("Language server stopped")
+		os.Exit(exitInterrupted)
+	}()
+	return newLSPServer(td, os.Stdin, os.Stdout).Serve()
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/spf13/pflag"
@@ -17,6 +19,11 @@ const (
 )
 
 var (
+	// version is set at build time with -ldflags "-X main.version=..."
+	version = "dev"
+
+	errNoFilename = errors.New("File name of the content is required with --stdin, use --filename")
+
 	srcRootFlag         string
 	helpFlag            bool
 	verboseFlag         bool
@@ -26,14 +33,120 @@ var (
 	logPathFlag         string
 	formatFlag          []string
 	includePatternsFlag []string
+	dryRunFlag          bool
+	applyPlanFlag       string
+	configPathFlag      string
+	blameFlag           bool
+	serveAddrFlag       string
+	grpcAddrFlag        string
+	watchFlag           bool
+	noCacheFlag         bool
+	submodulesFlag      bool
+	includeGenFlag      bool
+	includeVendorFlag   bool
+	lenientFlag         bool
+	noGitFlag           bool
+	remoteFlag          string
+	refFlag             string
+	groupByFlag         string
+	csvFlag             bool
+	anonymizeFlag       bool
+	lintFlag            bool
+	baselineFlag        string
+	canonicalFlag       bool
+	hideClosedFlag      bool
+	historySinceFlag    string
+	maxCommitsFlag      int
+	periodFlag          string
+	topByFlag           string
+	topCountFlag        int
+	filterFlag          []string
+	weightFlag          string
+	contextFlag         int
+	graphLinksFlag      []string
+	similarityFlag      float64
+	patchFlag           string
+	stdinFlag           bool
+	filenameFlag        string
+	ioWorkersFlag       int
+	cpuWorkersFlag      int
+	mmapFlag            bool
+	explainSkipsFlag    string
+	maxDepthFlag        int
+	hiddenFlag          string
+	niceFlag            bool
+	readLimitFlag       int
+	readRetriesFlag     int
+	readRetryDelayFlag  time.Duration
+	outputDirFlag       string
+	watchIntervalFlag   time.Duration
+
+	// scanPaths limits the scan to paths given as arguments
+	scanPaths []string
 )
 
 type result struct {
-	Root     string         `json:"root"`
-	Branch   string         `json:"branch"`
-	Author   string         `json:"author"`
-	Project  string         `json:"project"`
-	Comments []*ToDoComment `json:"comments"`
+	SchemaVersion int            `json:"schemaVersion"`
+	Root          string         `json:"root"`
+	Branch        string         `json:"branch"`
+	Revision      string         `json:"revision,omitempty"`
+	Author        string         `json:"author"`
+	Project       string         `json:"project"`
+	Remotes       []*Remote      `json:"remotes,omitempty"`
+	Comments      []*ToDoComment `json:"comments"`
+	Stats         *Stats         `json:"stats,omitempty"`
+	// Partial is set when the scan was interrupted and
+	// comments of files not scanned yet are missing
+	Partial bool `json:"partial,omitempty"`
+	// FileErrors are files that could not be read, their comments
+	// are missing
+	FileErrors []*FileError `json:"fileErrors,omitempty"`
+	// CappedFiles had more comments than limits.commentsPerFile
+	CappedFiles []*CappedFile `json:"cappedFiles,omitempty"`
+	// Provenance is set for signed reports
+	Provenance *Provenance `json:"provenance,omitempty"`
+	// skips are paths excluded from the scan with --explain-skips
+	skips []*SkippedPath
+	// display formats human-readable outputs of the report
+	display *humanFormat
+	// costs converts estimates of the report to money
+	costs *costModel
+	// theme styles types of the report
+	theme *theme
+	// signing signs the report written by json sinks
+	signing *SigningConfig
+	// budgets are the ones checked by the gate
+	budgets []*BudgetConfig
+	// scanned are IDs of all comments of the scan before filters, nil
+	// if the comments do not come from a scan
+	scanned map[string]bool
+}
+
+// command is a subcommand of the tool, scan is the default one
+type command func(args []string) error
+
+var commands = map[string]command{
+	"annotate":   runAnnotate,
+	"compare":    runCompare,
+	"daemon":     runDaemon,
+	"doctor":     runDoctor,
+	"duplicates": runDuplicates,
+	"fix":        runFix,
+	"gate":       runGate,
+	"graph":      runGraph,
+	"grep":       runGrep,
+	"history":    runHistory,
+	"lsp":        runLSP,
+	"migrate":    runMigrate,
+	"roulette":   runRoulette,
+	"scan":       runScan,
+	"schema":     runSchema,
+	"secret":     runSecretCommand,
+	"serve":      runServe,
+	"stats":      runStats,
+	"top":        runTop,
+	"velocity":   runVelocity,
+	"verify":     runVerify,
 }
 
 func main() {
@@ -47,44 +160,242 @@ func main() {
 	if err == nil {
 		defer logfile.Close()
 	}
+	applyNice()
+	setupTelemetry()
+	handleSignals()
+
+	name, args := "scan", pflag.Args()
+	if len(args) > 0 {
+		if _, ok := commands[args[0]]; ok {
+			name, args = args[0], args[1:]
+		}
+	}
+	var trace *span
+	if !longRunningCommands[name] {
+		trace = startTrace(appName + " " + name)
+	}
+	err = commands[name](args)
+	if err == nil && stopRequested() && !longRunningCommands[name] {
+		err = errInterrupted
+	}
+	trace.finish(err)
+	flushTelemetry()
+	if err == errInterrupted {
+		log.Printf("Interrupted, outputs have partial results")
+		os.Exit(exitInterrupted)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// generateStdin parses content read from stdin as the file with
+// --filename, so that editors can scan unsaved buffers
+func generateStdin(td *ToDoGenerator) ([]*ToDoComment, error) {
+	if len(filenameFlag) == 0 {
+		return nil, errNoFilename
+	}
+	content, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return nil, err
+	}
+	path := filenameFlag
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(td.root, path)
+	}
+	return td.ParseDocument(path, content), nil
+}
+
+// resolveScanPaths limits the scan to the paths and, unless root is set
+// explicitly, moves the root to the top level of the git repository
+// containing them, so that file names in the report do not depend
+// on the directory the scan was launched from
+func resolveScanPaths(args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	paths := make([]string, 0, len(args))
+	for _, arg := range args {
+		abs, err := filepath.Abs(arg)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(abs); err != nil {
+			return err
+		}
+		paths = append(paths, abs)
+	}
+	if !pflag.CommandLine.Changed("root") {
+		dir := paths[0]
+		if fi, err := os.Stat(dir); err == nil && !fi.IsDir() {
+			dir = filepath.Dir(dir)
+		}
+		if top := gitTopLevel(dir); len(top) > 0 {
+			srcRootFlag = top
+		} else {
+			srcRootFlag = "./"
+		}
+	}
+	root, err := filepath.Abs(srcRootFlag)
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		if rel, err := filepath.Rel(root, path); err != nil || strings.HasPrefix(rel, "..") {
+			return fmt.Errorf("Path %v is outside of the root %v", path, root)
+		}
+	}
+	scanPaths = paths
+	return nil
+}
+
+func runScan(args []string) error {
+	if err := resolveScanPaths(args); err != nil {
+		return err
+	}
+	config, err := loadConfig(configPathFlag, srcRootFlag)
+	if err != nil {
+		return err
+	}
+
+	if applyPlanFlag != "" {
+		plan, err := loadPlan(applyPlanFlag)
+		if err != nil {
+			return err
+		}
+		plan.Secrets = NewSecrets(config)
+		return executePlan(plan)
+	}
 
 	env := NewEnvironment(srcRootFlag)
-	td := NewToDoGenerator(srcRootFlag, includePatternsFlag, minWordCountFlag, minCharsFlag)
-	start := time.Now()
-	comments, err := td.Generate()
-	elapsed := time.Since(start)
-	log.Printf("Generation took %s", elapsed)
 
+	// outputs are written relative to the working directory
+	wd, err := outputDir()
 	if err != nil {
-		log.Fatal(err)
+		return err
+	}
+	plan := NewPlan(wd)
+	plan.Secrets = NewSecrets(config)
+	if err := runPipeline(&config.Pipeline, config, env, plan); err != nil {
+		return err
 	}
+	return executePlan(plan)
+}
 
-	// create a sheet
+// outputDir returns directory relative paths of outputs are
+// resolved against, the working directory unless --output-dir is set
+func outputDir() (string, error) {
+	if len(outputDirFlag) > 0 {
+		return filepath.Abs(outputDirFlag)
+	}
+	return os.Getwd()
+}
 
-	result := result{
-		Root:     td.root,
-		Branch:   env.Branch(),
-		Author:   env.Author(),
-		Project:  env.Project(),
-		Comments: comments,
+// scan returns report for the source root, reports of clean
+// worktrees are cached by commit unless --no-cache is used
+func scan(config *Config, env *Environment) (*result, error) {
+	// cached reports do not know skipped paths
+	if noCacheFlag || stdinFlag || len(explainSkipsFlag) > 0 {
+		return generateReport(config, env)
+	}
+	cache, err := NewReportCache()
+	if err != nil {
+		log.Printf("Report cache is disabled: %v", err)
+		return generateReport(config, env)
+	}
+	key := reportCacheKey(env, config)
+	if len(key) == 0 {
+		return generateReport(config, env)
+	}
+	if report := cache.Get(key); report != nil {
+		log.Printf("Using cached report %v", key)
+		return report, nil
+	}
+	report, err := generateReport(config, env)
+	if err != nil {
+		return nil, err
+	}
+	// unreadable files may be readable on the next run
+	if report.Partial || len(report.FileErrors) > 0 {
+		return report, nil
+	}
+	if err := cache.Put(key, report); err != nil {
+		log.Printf("Cannot cache report: %v", err)
 	}
+	return report, nil
+}
+
+// generateReport scans the source root
+func generateReport(config *Config, env *Environment) (*result, error) {
+	return generatePathsReport(config, env, scanPaths)
+}
 
-	var js []byte
-	// *formatFlag
-	if verboseFlag {
-		js, err = json.MarshalIndent(result, "", "  ")
+// generatePathsReport scans only the paths of the source root, all of
+// it if there are none
+func generatePathsReport(config *Config, env *Environment, paths []string) (*result, error) {
+	td, err := NewToDoGenerator(srcRootFlag, includePatternsFlag, minWordCountFlag, minCharsFlag, config)
+	if err != nil {
+		return nil, err
+	}
+	td.SetPaths(paths)
+	planCheckoutExclusions(env, td, submodulesFlag)
+	start := time.Now()
+	generation := startSpan("generate")
+	var comments []*ToDoComment
+	if stdinFlag {
+		generation.set("scorpion.source", "stdin")
+		comments, err = generateStdin(td)
+	} else if env.ScansTree() {
+		generation.set("scorpion.source", "tree")
+		comments, err = generateTree(td, env)
 	} else {
-		js, err = json.Marshal(result)
+		generation.set("scorpion.source", "walk")
+		comments, err = td.Generate()
 	}
+	elapsed := time.Since(start)
+	log.Printf("Generation took %s", elapsed)
+	generation.set("scorpion.comments", len(comments))
+	generation.finish(err)
+
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	fmt.Println(string(js))
 
-	if err := createTodoFile(result); err != nil {
-		log.Fatal(err)
+	// content of stdin has no history
+	if blameFlag && !stdinFlag {
+		start = time.Now()
+		enrichment := startSpan("enrich blame")
+		enrichBlame(env, comments)
+		enrichment.finish(nil)
+		log.Printf("Blame took %s", time.Since(start))
 	}
+	assignIDs(comments)
+	resolveRelations(comments)
+	types := make(map[string]int)
+	for _, c := range comments {
+		types[c.Type]++
+	}
+	for t, count := range types {
+		gaugeMetric("comments", "{comment}", float64(count), "type", t)
+	}
+
+	// create a sheet
 
+	return &result{
+		SchemaVersion: reportSchemaVersion,
+		Root:          td.root,
+		Branch:        env.Branch(),
+		Revision:      env.Revision(),
+		Author:        env.Author(),
+		Project:       env.Project(),
+		Remotes:       env.Remotes(),
+		Comments:      comments,
+		Stats:         computeStats(comments, td.FilesByLanguage(), td.Vendored()),
+		Partial:       stopRequested(),
+		FileErrors:    td.FileErrors(),
+		CappedFiles:   td.CappedFiles(),
+		skips:         td.Skips(),
+	}, nil
 }
 
 func parseFlags() error {
@@ -109,17 +420,91 @@ func parseFlags() error {
 	// logPathFlag         = flag.String("log", "tdg.log", "Path to the logfile")
 	pflag.StringVarP(&logPathFlag, "log", "l", "tdg.log", "Path to the logfile")
 
+	pflag.StringVarP(&outputDirFlag, "output-dir", "", "", "Directory relative paths of outputs are written to (default is the working directory)")
+
 	// formatFlag          = flag.String("format", "markdown", "format output")
-	pflag.StringSliceVarP(&formatFlag, "format", "f", []string{"markdown", "json"}, "format output")
+	pflag.StringSliceVarP(&formatFlag, "format", "f", []string{"json", "markdown"}, "Output formats if no sinks are configured: json (stdout), markdown (TODO.md), problems, treemap, sarif or csv (stdout), with a path as format:path")
 
 	// pflag.StringSliceVarP(&usePlugins, "plugins", "", defaultPlugins, "plugins to load.")
 
+	pflag.StringVarP(&configPathFlag, "config", "c", "", "Path to the config file (default is "+defaultConfigName+" in the root)")
+
+	pflag.BoolVarP(&blameFlag, "blame", "b", false, "Add commit that introduced each comment and its permalink")
+
+	pflag.StringVarP(&serveAddrFlag, "addr", "", "localhost:8080", "Address to listen on in serve mode")
+
+	pflag.StringVarP(&grpcAddrFlag, "grpc-addr", "", "", "Address to listen on for gRPC API in serve mode (disabled if empty)")
+	pflag.BoolVarP(&watchFlag, "watch", "w", false, "Rescan when files change (in serve mode)")
+	pflag.DurationVarP(&watchIntervalFlag, "watch-interval", "", 2*time.Second, "How often to check files for changes")
+
+	pflag.BoolVarP(&noCacheFlag, "no-cache", "", false, "Always rescan instead of using report cached for the commit")
+
+	pflag.BoolVarP(&submodulesFlag, "submodules", "", false, "Scan git submodules and attribute their comments to submodule project")
+
+	pflag.BoolVarP(&includeGenFlag, "include-generated", "", false, "Scan generated files, lockfiles and minified assets too")
+
+	pflag.BoolVarP(&includeVendorFlag, "include-vendor", "", false, "Report comments in vendor, node_modules and third_party directories")
+
+	pflag.BoolVarP(&lenientFlag, "lenient", "", false, "Accept keywords without the colon (\"TODO fix this\")")
+
+	pflag.BoolVarP(&noGitFlag, "no-git", "", false, "Scan plain directory without reading git metadata")
+
+	pflag.StringVarP(&remoteFlag, "remote", "", defaultRemote, "Git remote to derive links and tracker repository from")
+
+	pflag.StringVarP(&refFlag, "ref", "", "", "Scan files of the git ref without checkout (HEAD in bare repositories)")
+
+	pflag.StringVarP(&groupByFlag, "group-by", "", "", "Group stats by author (from blame), assignee, category, language, team or type")
+	pflag.BoolVarP(&csvFlag, "csv", "", false, "Print grouped stats as csv")
+
+	pflag.StringVarP(&historySinceFlag, "since", "", "", "Scan history of commits after the date, e.g. \"1 year ago\"")
+	pflag.IntVarP(&maxCommitsFlag, "max-commits", "", 100, "Scan history of at most this many latest commits (0 for all)")
+	pflag.StringVarP(&topByFlag, "by", "", topBySeverity, "Criteria of top comments: age, estimate or severity")
+	pflag.IntVarP(&topCountFlag, "top", "n", 20, "Number of top comments to print")
+	pflag.StringArrayVarP(&filterFlag, "filter", "", []string{}, "Only use comments matching the query like in the comments API of serve mode, e.g. type=BUG&minAge=30d")
+	pflag.StringVarP(&weightFlag, "weight", "", weightNone, "Pick comments in roulette more often by age or severity (none for equal chances)")
+	pflag.IntVarP(&contextFlag, "context", "", 3, "Lines of code around the comment picked in roulette")
+	pflag.StringVarP(&periodFlag, "period", "", periodWeek, "Period of velocity: week or month")
+	pflag.Float64VarP(&similarityFlag, "similarity", "", defaultSimilarity, "Minimal similarity (0-1) of titles and bodies of duplicate comments")
+	pflag.StringSliceVarP(&graphLinksFlag, "links", "", []string{linkRefs, linkIssue, linkCategory}, "Links of comments in graph: refs, issue and category")
+
+	pflag.BoolVarP(&canonicalFlag, "canonical", "", false, "Output stable sorted report without timestamps or absolute paths to commit it")
+	pflag.BoolVarP(&hideClosedFlag, "hide-closed", "", false, "Hide comments whose linked GitHub issue is already closed")
+	pflag.BoolVarP(&anonymizeFlag, "anonymize", "", false, "Hash authors and file paths and strip code context from the report")
+
+	pflag.IntVarP(&ioWorkersFlag, "io-workers", "", 0, "Number of files read concurrently (default is 4 per CPU)")
+	pflag.IntVarP(&cpuWorkersFlag, "cpu-workers", "", 0, "Number of files parsed concurrently (default is number of CPUs)")
+	pflag.BoolVarP(&niceFlag, "nice", "", false, "Scan with a single worker, the lowest priority and limited reads to run in the background")
+	pflag.IntVarP(&readLimitFlag, "read-limit", "", 0, "Limit reads of files to MiB per second (default is 16 with --nice, unlimited otherwise)")
+	pflag.BoolVarP(&mmapFlag, "mmap", "", false, "Memory-map big files instead of reading them")
+	pflag.IntVarP(&readRetriesFlag, "read-retries", "", 0, "Retry failed reads of a file this many times before recording a file error")
+	pflag.DurationVarP(&readRetryDelayFlag, "read-retry-delay", "", 100*time.Millisecond, "Delay before the first retry of a read, doubled for every next one with random jitter")
+	pflag.StringVarP(&explainSkipsFlag, "explain-skips", "", "", "Write skipped paths and reasons as json to the file (use - for stdout)")
+	pflag.StringVarP(&hiddenFlag, "hidden", "", "", "Scan hidden files and directories: default (except tool caches), all or none")
+	pflag.IntVarP(&maxDepthFlag, "max-depth", "", -1, "Scan at most this many directories deep below the root or scanned paths (0 for their files only)")
+	pflag.BoolVarP(&stdinFlag, "stdin", "", false, "Scan content of a single file read from stdin")
+	pflag.StringVarP(&filenameFlag, "filename", "", "", "Virtual file name of the content read with --stdin")
+	pflag.BoolVarP(&lintFlag, "lint", "", false, "Check quality of comment titles in gate")
+	pflag.StringVarP(&baselineFlag, "baseline", "", "", "Report file or stored scan ID to compare budgets with (default is the latest stored scan)")
+
+	pflag.BoolVarP(&dryRunFlag, "dry-run", "", false, "Print plan of all mutations instead of performing them")
+	pflag.StringVarP(&applyPlanFlag, "apply", "", "", "Apply plan saved with --dry-run (use - for stdin)")
+	pflag.StringVarP(&patchFlag, "patch", "", "", "Write edits of source files as unified diff to the file (use - for stdout) instead of changing them")
+
 	pflag.StringArrayVarP(&includePatternsFlag, "include", "i", []string{}, "Include pattern (can be specified multiple times)")
 	pflag.Parse()
+	if err := flagsFromEnv(); err != nil {
+		return err
+	}
 	if helpFlag {
 		pflag.PrintDefaults()
 		os.Exit(0)
 	}
+	if readRetriesFlag < 0 {
+		return fmt.Errorf("Read retries must not be negative: %v", readRetriesFlag)
+	}
+	if readRetryDelayFlag < 0 {
+		return fmt.Errorf("Read retry delay must not be negative: %v", readRetryDelayFlag)
+	}
 
 	/*
 		flag.Var(&includePatternsFlag, "include", "Include pattern (can be specified multiple times)")
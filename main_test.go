@@ -0,0 +1,14 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// scans log every skipped file and comment
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
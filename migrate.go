@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// reportMigration is a change of the report schema with the version
+// it was made in, apply upgrades a report written before it and
+// returns false if the report already has it
+type reportMigration struct {
+	Version int    `json:"version"`
+	Field   string `json:"field"`
+	Change  string `json:"change"`
+	apply   func(report *result) bool
+}
+
+// reportMigrations are all changes of the schema in order, they are
+// the machine-readable diff between versions
+var reportMigrations = []*reportMigration{
+	{
+		Version: 1,
+		Field:   "schemaVersion",
+		Change:  "added, reports without it are version 0",
+		apply: func(report *result) bool {
+			if report.SchemaVersion > 0 {
+				return false
+			}
+			report.SchemaVersion = 1
+			return true
+		},
+	},
+	{
+		Version: 1,
+		Field:   "comments[].id",
+		Change:  "added, stable across scans (md5 of type, file and title)",
+		apply: func(report *result) bool {
+			missing := false
+			for _, c := range report.Comments {
+				missing = missing || len(c.ID) == 0
+			}
+			assignIDs(report.Comments)
+			return missing
+		},
+	},
+}
+
+// migratedReport is a report upgraded by migrate
+type migratedReport struct {
+	Kind    string             `json:"kind"`
+	Path    string             `json:"path"`
+	Applied []*reportMigration `json:"applied"`
+	content string
+}
+
+// migrateReportData upgrades the json report, its migrations are
+// empty if it is up to date
+func migrateReportData(data []byte) (*result, []*reportMigration, error) {
+	report := &result{}
+	if err := json.Unmarshal(data, report); err != nil {
+		return nil, nil, err
+	}
+	if err := checkSchemaVersion(report); err != nil {
+		return nil, nil, err
+	}
+	applied := make([]*reportMigration, 0)
+	for _, m := range reportMigrations {
+		if m.apply(report) {
+			applied = append(applied, m)
+		}
+	}
+	return report, applied, nil
+}
+
+// migrateReportFile returns the upgraded report of the file, nil if it
+// is up to date. Indented reports stay indented
+func migrateReportFile(kind, path string) (*migratedReport, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	report, applied, err := migrateReportData(data)
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	if len(applied) == 0 {
+		return nil, nil
+	}
+	var js []byte
+	if bytes.HasPrefix(data, []byte("{\n")) {
+		js, err = json.MarshalIndent(report, "", "  ")
+		js = append(js, '\n')
+	} else {
+		js, err = json.Marshal(report)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &migratedReport{Kind: kind, Path: path, Applied: applied, content: string(js)}, nil
+}
+
+// reportFiles lists json files of the directory, none if it does not exist
+func reportFiles(dir string) ([]string, error) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		if !f.IsDir() && filepath.Ext(f.Name()) == ".json" {
+			paths = append(paths, filepath.Join(dir, f.Name()))
+		}
+	}
+	return paths, nil
+}
+
+func sqliteBinary() string {
+	if bin := os.Getenv(sqliteBinEnv); len(bin) > 0 {
+		return bin
+	}
+	return defaultSQLite
+}
+
+// migrateSQLite returns statements upgrading reports stored in the
+// database, empty if they are up to date
+func migrateSQLite(path string) (*migratedReport, error) {
+	cmd := exec.Command(sqliteBinary(), "-batch", "-separator", "\t", path, "SELECT id, report FROM scans")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("sqlite3 %v: %v: %v", path, err, strings.TrimSpace(stderr.String()))
+	}
+	migrated := &migratedReport{Kind: sqliteSinkName, Path: path, Applied: make([]*reportMigration, 0)}
+	seen := make(map[*reportMigration]bool)
+	var sb strings.Builder
+	for _, line := range strings.Split(strings.TrimSpace(stdout.String()), "\n") {
+		fields := strings.SplitN(line, "\t", 2)
+		if len(fields) != 2 {
+			continue
+		}
+		report, applied, err := migrateReportData([]byte(fields[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%v scan %v: %v", path, fields[0], err)
+		}
+		if len(applied) == 0 {
+			continue
+		}
+		for _, m := range applied {
+			if !seen[m] {
+				seen[m] = true
+				migrated.Applied = append(migrated.Applied, m)
+			}
+		}
+		js, err := json.Marshal(report)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&sb, "UPDATE scans SET report = %v WHERE id = %v;\n", sqlQuote(string(js)), fields[0])
+		// comments of the scan are matched by their position
+		for i, c := range report.Comments {
+			fmt.Fprintf(&sb, "UPDATE comments SET comment_id = %v WHERE rowid = (SELECT rowid FROM comments WHERE scan_id = %v ORDER BY rowid LIMIT 1 OFFSET %v) AND comment_id IS NOT %v;\n",
+				sqlQuote(c.ID), fields[0], i, sqlQuote(c.ID))
+		}
+	}
+	if sb.Len() == 0 {
+		return nil, nil
+	}
+	migrated.content = "BEGIN;\n" + sb.String() + "COMMIT;\n"
+	return migrated, nil
+}
+
+// migrationTargets returns reports and databases kept between runs:
+// stored scans, cached reports, databases of sqlite sinks and the
+// daemon, and the report files given as arguments or --baseline
+func migrationTargets(config *Config, env *Environment, files []string) (map[string][]string, []string, error) {
+	reports := make(map[string][]string)
+	store := NewScanStore(env.root, config.Gate.Store)
+	stored, err := store.List()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, id := range stored {
+		reports[storeSinkName] = append(reports[storeSinkName], store.path(id))
+	}
+	if cache, err := NewReportCache(); err == nil {
+		cached, err := reportFiles(cache.dir)
+		if err != nil {
+			return nil, nil, err
+		}
+		reports["cache"] = cached
+	}
+	if _, err := os.Stat(baselineFlag); err == nil && len(baselineFlag) > 0 {
+		files = append(files, baselineFlag)
+	}
+	reports["file"] = files
+	return reports, sqliteDatabases(config, env.root), nil
+}
+
+// runMigrate implements "scorpion migrate [reports]", it upgrades
+// state written by older versions to the current report schema. The
+// upgrades are planned, so --dry-run shows them. With --verbose the
+// migrated files and applied changes are printed as json
+func runMigrate(args []string) error {
+	config, err := loadConfig(configPathFlag, srcRootFlag)
+	if err != nil {
+		return err
+	}
+	env := NewEnvironment(srcRootFlag)
+	reports, databases, err := migrationTargets(config, env, args)
+	if err != nil {
+		return err
+	}
+	wd, err := outputDir()
+	if err != nil {
+		return err
+	}
+	plan := NewPlan(wd)
+	migrated := make([]*migratedReport, 0)
+	for _, kind := range []string{storeSinkName, "cache", "file"} {
+		for _, path := range reports[kind] {
+			m, err := migrateReportFile(kind, path)
+			if err != nil {
+				return err
+			}
+			if m == nil {
+				continue
+			}
+			migrated = append(migrated, m)
+			plan.Add(&PlanAction{Kind: planWriteFile, Target: path, Summary: "migrate " + kind + " report", Content: m.content})
+		}
+	}
+	for _, path := range databases {
+		m, err := migrateSQLite(path)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			continue
+		}
+		migrated = append(migrated, m)
+		plan.Add(&PlanAction{Kind: planExecSQL, Target: path, Summary: "migrate stored scans", Content: m.content})
+	}
+	if verboseFlag {
+		js, err := json.MarshalIndent(migrated, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(js))
+	} else {
+		for _, m := range migrated {
+			fields := make([]string, 0, len(m.Applied))
+			for _, a := range m.Applied {
+				fields = append(fields, a.Field)
+			}
+			fmt.Printf("%v %v: %v\n", m.Kind, m.Path, strings.Join(fields, ", "))
+		}
+		if len(migrated) == 0 {
+			fmt.Printf("Everything is up to date with schema version %v\n", reportSchemaVersion)
+		}
+	}
+	return executePlan(plan)
+}
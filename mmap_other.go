@@ -0,0 +1,9 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!solaris
+
+package main
+
+// mmapFile is not supported on this platform, files are read instead
+func mmapFile(path string) ([]byte, func(), error) {
+	return nil, nil, errMmapUnsupported
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"unicode"
+)
+
+const (
+	translateTransformerName = "translate"
+	defaultTargetLanguage    = "en"
+	// minStopwords is how many stopwords identify a latin-script language
+	minStopwords = 1
+)
+
+var (
+	errNoTranslateCommand = errors.New("Translate command is not configured")
+
+	// stopwords of latin-script languages, titles are usually short
+	// so even a single one is a good hint
+	stopwords = map[string][]string{
+		"en": {"the", "a", "an", "is", "to", "of", "and", "in", "for", "this", "it", "not", "with", "should", "add", "fix", "remove", "when"},
+		"de": {"der", "die", "das", "und", "ist", "nicht", "mit", "für", "ein", "eine", "zu", "auf", "noch", "wenn", "hier"},
+		"fr": {"le", "la", "les", "et", "est", "pas", "pour", "une", "un", "des", "du", "ce", "avec", "dans", "faire"},
+		"es": {"el", "los", "las", "y", "es", "no", "para", "una", "un", "del", "que", "con", "esto", "hacer", "por"},
+		"it": {"il", "lo", "gli", "e", "è", "non", "per", "una", "che", "con", "questo", "fare", "della", "del"},
+		"pt": {"o", "os", "as", "e", "é", "não", "para", "uma", "um", "que", "com", "isso", "fazer", "do", "da"},
+		"nl": {"de", "het", "een", "en", "is", "niet", "voor", "met", "dit", "van", "op", "nog", "moet"},
+	}
+)
+
+// detectNaturalLanguage returns ISO 639-1 code of the text language
+// guessed by its script and stopwords, empty string if unknown
+func detectNaturalLanguage(text string) string {
+	scripts := make(map[string]int)
+	letters := 0
+	ukrainian := false
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		switch {
+		case unicode.Is(unicode.Hiragana, r), unicode.Is(unicode.Katakana, r):
+			scripts["ja"]++
+		case unicode.Is(unicode.Hangul, r):
+			scripts["ko"]++
+		case unicode.Is(unicode.Han, r):
+			scripts["zh"]++
+		case unicode.Is(unicode.Cyrillic, r):
+			ukrainian = ukrainian || strings.ContainsRune("іїєґІЇЄҐ", r)
+			scripts["ru"]++
+		case unicode.Is(unicode.Greek, r):
+			scripts["el"]++
+		case unicode.Is(unicode.Arabic, r):
+			scripts["ar"]++
+		case unicode.Is(unicode.Hebrew, r):
+			scripts["he"]++
+		case unicode.Is(unicode.Latin, r):
+			scripts["latin"]++
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+	// japanese text contains kanji too
+	if scripts["ja"] > 0 {
+		return "ja"
+	}
+	best, bestCount := "", 0
+	for script, count := range scripts {
+		if count > bestCount || (count == bestCount && script < best) {
+			best, bestCount = script, count
+		}
+	}
+	if best == "ru" && ukrainian {
+		return "uk"
+	}
+	if best != "latin" {
+		return best
+	}
+	return detectLatinLanguage(text)
+}
+
+// detectLatinLanguage picks latin-script language with most stopwords
+func detectLatinLanguage(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	best, bestCount := "", 0
+	for lang, list := range stopwords {
+		count := 0
+		for _, w := range words {
+			for _, s := range list {
+				if w == s {
+					count++
+					break
+				}
+			}
+		}
+		if count > bestCount || (count == bestCount && count > 0 && lang < best) {
+			best, bestCount = lang, count
+		}
+	}
+	if bestCount < minStopwords {
+		return ""
+	}
+	return best
+}
+
+// Translator translates text between languages
+type Translator interface {
+	Translate(text, from, to string) (string, error)
+}
+
+// commandTranslator pipes text to the external command, source and
+// target languages are passed in SCORPION_FROM and SCORPION_TO
+type commandTranslator struct {
+	args []string
+}
+
+func (t *commandTranslator) Translate(text, from, to string) (string, error) {
+	cmd := exec.Command(t.args[0], t.args[1:]...)
+	cmd.Env = append(os.Environ(), "SCORPION_FROM="+from, "SCORPION_TO="+to)
+	cmd.Stdin = strings.NewReader(text)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%v: %v", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// newTranslator creates translator from the config
+func newTranslator(config *TranslateConfig) (Translator, error) {
+	args := strings.Fields(config.Command)
+	if len(args) == 0 {
+		return nil, errNoTranslateCommand
+	}
+	return &commandTranslator{args: args}, nil
+}
+
+func translateTransformer(tc *TransformerConfig, config *Config, env *Environment, report *result) error {
+	translator, err := newTranslator(&config.Translate)
+	if err != nil {
+		return err
+	}
+	to := config.Translate.Target
+	if len(to) == 0 {
+		to = defaultTargetLanguage
+	}
+	for _, c := range report.Comments {
+		if len(c.TitleLanguage) == 0 || c.TitleLanguage == to {
+			continue
+		}
+		translated, err := translator.Translate(c.Title, c.TitleLanguage, to)
+		if err != nil {
+			return err
+		}
+		if len(translated) > 0 {
+			c.OriginalTitle = c.Title
+			c.Title = translated
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+const (
+	// niceReadLimit is the default read bandwidth with --nice in MiB/s
+	niceReadLimit = 16
+	// niceWatchInterval is the default --watch-interval with --nice,
+	// as every check stats all files of the tree
+	niceWatchInterval = 10 * time.Second
+	// nicePriority is the scheduling priority, the lowest one on unix
+	nicePriority = 19
+)
+
+var (
+	errNiceUnsupported = errors.New("Process priority cannot be changed on this platform")
+)
+
+// applyNice limits the process to a single thread with the lowest
+// scheduling priority and defaults of other limits with --nice
+func applyNice() {
+	if !niceFlag {
+		return
+	}
+	runtime.GOMAXPROCS(1)
+	if err := lowerPriority(); err != nil {
+		log.Printf("Cannot lower priority: %v", err)
+	}
+	if readLimitFlag == 0 {
+		readLimitFlag = niceReadLimit
+	}
+	if !pflag.CommandLine.Changed("watch-interval") {
+		watchIntervalFlag = niceWatchInterval
+	}
+	log.Printf("Running nice, reads are limited to %v MiB/s", readLimitFlag)
+}
+
+// concurrency returns number of workers, a single one with --nice
+func concurrency(workers int) int {
+	if niceFlag {
+		return 1
+	}
+	return workers
+}
+
+// readLimiter delays reads to keep their average bandwidth
+type readLimiter struct {
+	mux sync.Mutex
+	// rate is in bytes per second
+	rate  float64
+	start time.Time
+	bytes int64
+}
+
+// newReadLimiter returns limiter of MiB/s, nil if it is not limited
+func newReadLimiter(limit int) *readLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return &readLimiter{rate: float64(limit) * (1 << 20)}
+}
+
+// wait blocks until n more bytes can be read, bandwidth not used
+// while idle for longer than a second is not saved up for bursts
+func (rl *readLimiter) wait(n int) {
+	if rl == nil {
+		return
+	}
+	rl.mux.Lock()
+	now := time.Now()
+	if rl.start.IsZero() || rl.due().Before(now.Add(-time.Second)) {
+		rl.start, rl.bytes = now, 0
+	}
+	rl.bytes += int64(n)
+	due := rl.due()
+	rl.mux.Unlock()
+	if d := due.Sub(now); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// due returns when the bytes read so far are within the rate
+func (rl *readLimiter) due() time.Time {
+	return rl.start.Add(time.Duration(float64(rl.bytes) / rl.rate * float64(time.Second)))
+}
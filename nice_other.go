@@ -0,0 +1,9 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+// lowerPriority is not supported on this platform
+func lowerPriority() error {
+	return errNiceUnsupported
+}
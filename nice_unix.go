@@ -0,0 +1,11 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package main
+
+import "syscall"
+
+// lowerPriority sets the lowest scheduling priority of the process
+func lowerPriority() error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, 0, nicePriority)
+}
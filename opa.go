@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const (
+	opaCheckName    = "opa"
+	defaultOPAQuery = "data.scorpion.deny"
+	defaultOPABin   = "opa"
+)
+
+// opaDeny is an element of the deny set, either a message
+// or an object pointing at the comment of the report
+type opaDeny struct {
+	Msg  string `json:"msg"`
+	File string `json:"file"`
+	Line *int   `json:"line"`
+}
+
+// opaOutput is the json output of "opa eval"
+type opaOutput struct {
+	Result []struct {
+		Expressions []struct {
+			Value json.RawMessage `json:"value"`
+		} `json:"expressions"`
+	} `json:"result"`
+}
+
+// evalOPA runs "opa eval" with the report as input
+// and returns values of the query
+func evalOPA(config *OPAConfig, input []byte) ([]json.RawMessage, error) {
+	bin := config.Binary
+	if len(bin) == 0 {
+		bin = defaultOPABin
+	}
+	query := config.Query
+	if len(query) == 0 {
+		query = defaultOPAQuery
+	}
+	args := []string{"eval", "--format", "json", "--stdin-input"}
+	for _, p := range config.Policies {
+		args = append(args, "--data", p)
+	}
+	args = append(args, query)
+
+	cmd := exec.Command(bin, args...)
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("opa eval: %v: %v", err, strings.TrimSpace(stderr.String()))
+	}
+	output := &opaOutput{}
+	if err := json.Unmarshal(out, output); err != nil {
+		return nil, fmt.Errorf("opa eval: %v", err)
+	}
+	values := make([]json.RawMessage, 0)
+	for _, r := range output.Result {
+		for _, e := range r.Expressions {
+			values = append(values, e.Value)
+		}
+	}
+	return values, nil
+}
+
+// parseOPADenies accepts a set of messages or objects
+func parseOPADenies(value json.RawMessage) ([]*opaDeny, error) {
+	var raw []json.RawMessage
+	if err := json.Unmarshal(value, &raw); err != nil {
+		return nil, fmt.Errorf("Query result is not a set: %v", string(value))
+	}
+	denies := make([]*opaDeny, 0, len(raw))
+	for _, r := range raw {
+		var msg string
+		if err := json.Unmarshal(r, &msg); err == nil {
+			denies = append(denies, &opaDeny{Msg: msg})
+			continue
+		}
+		d := &opaDeny{}
+		if err := json.Unmarshal(r, d); err != nil {
+			return nil, fmt.Errorf("Unexpected deny %v", string(r))
+		}
+		denies = append(denies, d)
+	}
+	return denies, nil
+}
+
+// opaCheck feeds the report into Rego policies, every element of
+// the deny set is a violation
+func opaCheck(report *result, config *Config, env *Environment) ([]*Violation, error) {
+	oc := &config.Gate.OPA
+	if len(oc.Policies) == 0 {
+		return nil, nil
+	}
+	input, err := json.Marshal(report)
+	if err != nil {
+		return nil, err
+	}
+	values, err := evalOPA(oc, input)
+	if err != nil {
+		return nil, err
+	}
+	violations := make([]*Violation, 0)
+	for _, value := range values {
+		denies, err := parseOPADenies(value)
+		if err != nil {
+			return nil, err
+		}
+		for _, d := range denies {
+			v := &Violation{Check: opaCheckName, Message: d.Msg}
+			if len(d.File) > 0 && d.Line != nil {
+				for _, c := range report.Comments {
+					if c.File == d.File && c.Line == *d.Line {
+						v.Comment = c
+						break
+					}
+				}
+			}
+			violations = append(violations, v)
+		}
+	}
+	return violations, nil
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	patchContext = 3
+	// maxDiffCells limits memory of the diff, bigger changes
+	// are shown as replacement of all changed lines
+	maxDiffCells = 4 << 20
+)
+
+type diffOp struct {
+	kind byte
+	line string
+}
+
+// splitLines splits content keeping line endings, only the last line
+// of a file without the trailing newline has none
+func splitLines(content string) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(content, "\n")
+	if len(lines[len(lines)-1]) == 0 {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns operations transforming a to b, common prefix
+// and suffix are trimmed before longest common subsequence
+func diffLines(a, b []string) []diffOp {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	ops := make([]diffOp, 0, len(a)+len(b))
+	for _, l := range a[:prefix] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if (len(ma)+1)*(len(mb)+1) > maxDiffCells {
+		for _, l := range ma {
+			ops = append(ops, diffOp{'-', l})
+		}
+		for _, l := range mb {
+			ops = append(ops, diffOp{'+', l})
+		}
+	} else {
+		// lcs[i][j] is length of common subsequence of ma[i:] and mb[j:]
+		lcs := make([][]int, len(ma)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(mb)+1)
+		}
+		for i := len(ma) - 1; i >= 0; i-- {
+			for j := len(mb) - 1; j >= 0; j-- {
+				if ma[i] == mb[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(ma) || j < len(mb) {
+			switch {
+			case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+				ops = append(ops, diffOp{' ', ma[i]})
+				i++
+				j++
+			case j >= len(mb) || (i < len(ma) && lcs[i+1][j] >= lcs[i][j+1]):
+				ops = append(ops, diffOp{'-', ma[i]})
+				i++
+			default:
+				ops = append(ops, diffOp{'+', mb[j]})
+				j++
+			}
+		}
+	}
+	for _, l := range a[len(a)-suffix:] {
+		ops = append(ops, diffOp{' ', l})
+	}
+	return ops
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%v,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%v", start+1)
+	}
+	return fmt.Sprintf("%v,%v", start+1, count)
+}
+
+// unifiedDiff returns changes between contents of the file in
+// unified format, empty string if they are equal
+func unifiedDiff(name, before, after string) string {
+	ops := diffLines(splitLines(before), splitLines(after))
+	var sb strings.Builder
+	aLine, bLine := 0, 0
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			aLine++
+			bLine++
+			i++
+			continue
+		}
+		// hunk starts with context before the change and
+		// continues while changes are close to each other
+		start := i - patchContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+			if next == len(ops) || next-end > 2*patchContext {
+				end += patchContext
+				if end > len(ops) {
+					end = len(ops)
+				}
+				break
+			}
+			end = next
+		}
+		aStart, bStart := aLine-(i-start), bLine-(i-start)
+		aCount, bCount := 0, 0
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		if sb.Len() == 0 {
+			fmt.Fprintf(&sb, "--- a/%v\n+++ b/%v\n", name, name)
+		}
+		fmt.Fprintf(&sb, "@@ -%v +%v @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+		for _, op := range ops[start:end] {
+			sb.WriteByte(op.kind)
+			sb.WriteString(op.line)
+			if !strings.HasSuffix(op.line, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		aLine, bLine = aStart+aCount, bStart+bCount
+		i = end
+	}
+	return sb.String()
+}
+
+// ToPatch replaces edits of files with a single patch written
+// to the path or to stdout if it is "-", so that write-backs
+// can be reviewed like any other change
+func (p *Plan) ToPatch(path string) error {
+	var sb strings.Builder
+	actions := make([]*PlanAction, 0, len(p.Actions))
+	for _, a := range p.Actions {
+		if a.Kind != planEditFile {
+			actions = append(actions, a)
+			continue
+		}
+		target := p.resolve(a.Target)
+		before, err := ioutil.ReadFile(target)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		name := a.Target
+		if rel, err := filepath.Rel(p.Root, target); err == nil {
+			name = rel
+		}
+		sb.WriteString(unifiedDiff(filepath.ToSlash(name), string(before), a.Content))
+	}
+	if sb.Len() > 0 {
+		patch := &PlanAction{Kind: planWriteFile, Target: path, Summary: "patch", Content: sb.String()}
+		if path == "-" {
+			patch.Kind, patch.Target = planStdout, "stdout"
+		}
+		actions = append(actions, patch)
+	}
+	p.Actions = actions
+	return nil
+}
+
+// executePlan prints the plan with --dry-run and performs it otherwise,
+// with --patch edits of files are converted to a patch first
+func executePlan(plan *Plan) error {
+	if len(patchFlag) > 0 {
+		if err := plan.ToPatch(patchFlag); err != nil {
+			return err
+		}
+	}
+	if dryRunFlag {
+		return printPlan(plan)
+	}
+	return plan.Apply()
+}
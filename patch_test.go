@@ -0,0 +1,63 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplitLinesKeepsTrailingNewline(t *testing.T) {
+	lines := splitLines("a\nb\n")
+	if len(lines) != 2 || lines[1] != "b\n" {
+		t.Fatalf("splitLines = %q", lines)
+	}
+	lines = splitLines("a\nb")
+	if len(lines) != 2 || lines[1] != "b" {
+		t.Fatalf("splitLines = %q", lines)
+	}
+}
+
+func TestUnifiedDiffLastLine(t *testing.T) {
+	tests := []struct {
+		name          string
+		before, after string
+		marker        bool
+	}{
+		{"trailing newline", "package a\n\n// TODO fix the loop\n", "package a\n\n// TODO: fix the loop\n", false},
+		{"no trailing newline", "package a\n\n// TODO fix the loop", "package a\n\n// TODO: fix the loop", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch := unifiedDiff("a.go", tt.before, tt.after)
+			if marker := strings.Contains(patch, "No newline at end of file"); marker != tt.marker {
+				t.Fatalf("no newline marker is %v in:\n%v", marker, patch)
+			}
+			checkPatchApplies(t, tt.before, patch)
+		})
+	}
+}
+
+// checkPatchApplies runs "git apply --check" of the patch on a.go with
+// the content
+func checkPatchApplies(t *testing.T, content, patch string) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "patch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "a.go"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("git", "apply", "--check", "-")
+	cmd.Dir = dir
+	cmd.Stdin = strings.NewReader(patch)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git apply --check: %v: %s\n%v", err, out, patch)
+	}
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+const (
+	scanSourceName    = "scan"
+	reportSourceName  = "report"
+	diffSourceName    = "diff"
+	historySourceName = "history"
+)
+
+// PipelineConfig describes processing of comments from the source
+// through filters and transformers to sinks
+type PipelineConfig struct {
+	Source       SourceConfig         `yaml:"source"`
+	Filters      []*FilterConfig      `yaml:"filters"`
+	Transformers []*TransformerConfig `yaml:"transformers"`
+	Sinks        []*SinkConfig        `yaml:"sinks"`
+}
+
+// SourceConfig selects where comments come from
+type SourceConfig struct {
+	// Kind is "scan" (default), "report" to read saved json report,
+	// "diff" for comments added since the base scan or "history" for
+	// comments added by commits of the revision
+	Kind string `yaml:"kind"`
+	Path string `yaml:"path"`
+	// Base of the diff source is a report file or stored scan ID, the
+	// latest stored scan if empty
+	Base string `yaml:"base"`
+	// Rev of the history source, HEAD by default
+	Rev string `yaml:"rev"`
+	// Verify fails unless the report has a valid signature
+	Verify bool `yaml:"verify"`
+}
+
+// FilterConfig is a declarative CommentFilter
+type FilterConfig struct {
+	Types       []string `yaml:"types"`
+	Categories  []string `yaml:"categories"`
+	Path        string   `yaml:"path"`
+	Assignee    string   `yaml:"assignee"`
+	MinAgeDays  int      `yaml:"minAgeDays"`
+	MinEstimate string   `yaml:"minEstimate"`
+	// MinConfidence skips lenient matches with lower confidence
+	MinConfidence float64 `yaml:"minConfidence"`
+}
+
+// TransformerConfig describes a step changing the comments
+type TransformerConfig struct {
+	// Transformer is "blame", "sort", "translate", "tracker", "duplicates", "anonymize" or "canonical"
+	Transformer string `yaml:"transformer"`
+	// Sort fields for the sort transformer
+	Sort string `yaml:"sort"`
+	// Repo and State of the tracker transformer, like in the github sink
+	Repo  string `yaml:"repo"`
+	State string `yaml:"state"`
+	// Threshold is the similarity of comments the duplicates
+	// transformer clusters, 0.8 by default
+	Threshold float64 `yaml:"threshold"`
+}
+
+// UnmarshalYAML also accepts a plain list of sinks
+func (pc *PipelineConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var sinks []*SinkConfig
+	if err := unmarshal(&sinks); err == nil {
+		pc.Sinks = sinks
+		return nil
+	}
+	type plain PipelineConfig
+	return unmarshal((*plain)(pc))
+}
+
+// Filter converts config to CommentFilter
+func (fc *FilterConfig) Filter() (*CommentFilter, error) {
+	f := &CommentFilter{
+		Types:      fc.Types,
+		Categories: fc.Categories,
+		PathPrefix: fc.Path,
+		Assignee:   fc.Assignee,
+		MinAge:     time.Duration(fc.MinAgeDays) * 24 * time.Hour,
+	}
+	if len(fc.MinEstimate) > 0 {
+		estimate, err := parseEstimate(fc.MinEstimate)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot parse minEstimate: %v", fc.MinEstimate)
+		}
+		f.MinEstimate = estimate
+	}
+	f.MinConfidence = fc.MinConfidence
+	return f, nil
+}
+
+type sourceFunc func(sc *SourceConfig, config *Config, env *Environment) (*result, error)
+
+type transformerFunc func(tc *TransformerConfig, config *Config, env *Environment, report *result) error
+
+var (
+	sources = map[string]sourceFunc{
+		scanSourceName:    scanSource,
+		reportSourceName:  reportSource,
+		diffSourceName:    diffSource,
+		historySourceName: historySource,
+	}
+	transformers = map[string]transformerFunc{
+		"blame":                   blameTransformer,
+		"sort":                    sortTransformer,
+		anonymizeTransformerName:  anonymizeTransformer,
+		translateTransformerName:  translateTransformer,
+		trackerTransformerName:    trackerTransformer,
+		duplicatesTransformerName: duplicatesTransformer,
+		canonicalTransformerName:  canonicalTransformer,
+	}
+)
+
+func scanSource(sc *SourceConfig, config *Config, env *Environment) (*result, error) {
+	return scan(config, env)
+}
+
+func reportSource(sc *SourceConfig, config *Config, env *Environment) (*result, error) {
+	if sc.Verify {
+		if err := verifyReport(sc.Path, "", &config.Signing, NewSecrets(config)); err != nil {
+			return nil, err
+		}
+	}
+	return loadReport(sc.Path)
+}
+
+// diffSource returns comments of the scan added or changed since the
+// base scan, e.g. to report only new debt of a pull request
+func diffSource(sc *SourceConfig, config *Config, env *Environment) (*result, error) {
+	base, err := resolveScan(NewScanStore(env.root, config.Gate.Store), sc.Base)
+	if err != nil {
+		return nil, err
+	}
+	report, err := scan(config, env)
+	if err != nil {
+		return nil, err
+	}
+	delta := compareScans(base, report)
+	changed := make(map[*ToDoComment]bool, len(delta.Added)+len(delta.Changed))
+	for _, c := range delta.Added {
+		changed[c] = true
+	}
+	for _, cc := range delta.Changed {
+		changed[cc.After] = true
+	}
+	comments := make([]*ToDoComment, 0, len(changed))
+	for _, c := range report.Comments {
+		if changed[c] {
+			comments = append(comments, c)
+		}
+	}
+	report.Comments = comments
+	report.Stats = refreshStats(report)
+	return report, nil
+}
+
+// historySource returns comments of the revision added by its commits
+// (limited by --since and --max-commits) with the adding commit as
+// blame, comments older than the commits are left out
+func historySource(sc *SourceConfig, config *Config, env *Environment) (*result, error) {
+	rev := sc.Rev
+	if len(rev) == 0 {
+		rev = "HEAD"
+	}
+	events, err := scanHistory(config, rev)
+	if err != nil {
+		return nil, err
+	}
+	report, _, err := scanCommit(config, rev)
+	if err != nil {
+		return nil, err
+	}
+	// commits adding comments that are still there
+	addedBy := make(map[string][]*Blame)
+	for _, e := range events {
+		id := commentIdentity(e.Comment)
+		if e.Kind == historyAdded {
+			addedBy[id] = append(addedBy[id], e.Commit)
+		} else if len(addedBy[id]) > 0 {
+			addedBy[id] = addedBy[id][1:]
+		}
+	}
+	comments := make([]*ToDoComment, 0)
+	for _, c := range report.Comments {
+		id := commentIdentity(c)
+		if commits := addedBy[id]; len(commits) > 0 {
+			blame := *commits[0]
+			c.Blame = &blame
+			addedBy[id] = commits[1:]
+			comments = append(comments, c)
+		}
+	}
+	report.Comments = comments
+	report.Stats = refreshStats(report)
+	return report, nil
+}
+
+func blameTransformer(tc *TransformerConfig, config *Config, env *Environment, report *result) error {
+	enrichBlame(env, report.Comments)
+	return nil
+}
+
+func sortTransformer(tc *TransformerConfig, config *Config, env *Environment, report *result) error {
+	return sortComments(report.Comments, tc.Sort)
+}
+
+func anonymizeTransformer(tc *TransformerConfig, config *Config, env *Environment, report *result) error {
+	anonymize(report, &config.Anonymize)
+	return nil
+}
+
+func hasTransformer(steps []*TransformerConfig, name string) bool {
+	for _, tc := range steps {
+		if tc.Transformer == name {
+			return true
+		}
+	}
+	return false
+}
+
+// buildReport gets comments from the source and passes them
+// through filters and transformers
+func buildReport(pc *PipelineConfig, config *Config, env *Environment) (*result, error) {
+	kind := pc.Source.Kind
+	if len(kind) == 0 {
+		kind = scanSourceName
+	}
+	source, ok := sources[kind]
+	if !ok {
+		return nil, fmt.Errorf("Unknown source: %v", kind)
+	}
+	sourceSpan := startSpan("source " + kind)
+	report, err := source(&pc.Source, config, env)
+	sourceSpan.finish(err)
+	if err != nil {
+		return nil, err
+	}
+	// trackers close issues of comments missing from the whole scan,
+	// not of the filtered ones
+	if kind == scanSourceName {
+		report.scanned = make(map[string]bool, len(report.Comments))
+		for _, c := range report.Comments {
+			report.scanned[c.ID] = true
+		}
+	}
+	if report.display, err = newHumanFormat(&config.Display); err != nil {
+		return nil, err
+	}
+	if report.costs, err = newCostModel(&config.Cost); err != nil {
+		return nil, err
+	}
+	report.costs.apply(report.Stats, report.Comments)
+	if report.theme, err = newTheme(&config.Theme); err != nil {
+		return nil, err
+	}
+	if len(config.Signing.Method) > 0 {
+		if err := checkSigning(&config.Signing); err != nil {
+			return nil, err
+		}
+		report.signing = &config.Signing
+		report.Provenance = newProvenance(config)
+	}
+
+	now := time.Now()
+	var filtering *span
+	if len(pc.Filters) > 0 {
+		filtering = startSpan("filter")
+	}
+	for _, fc := range pc.Filters {
+		filter, err := fc.Filter()
+		if err != nil {
+			filtering.finish(err)
+			return nil, err
+		}
+		report.Comments = filter.Apply(report.Comments, now)
+	}
+	filtering.set("scorpion.comments", len(report.Comments))
+	filtering.finish(nil)
+	if len(pc.Filters) > 0 {
+		report.Stats = refreshStats(report)
+	}
+
+	steps := append([]*TransformerConfig{}, pc.Transformers...)
+	if hideClosedFlag && !hasTransformer(steps, trackerTransformerName) {
+		steps = append(steps, &TransformerConfig{Transformer: trackerTransformerName})
+	}
+	if anonymizeFlag {
+		steps = append(steps, &TransformerConfig{Transformer: anonymizeTransformerName})
+	}
+	if canonicalFlag {
+		steps = append(steps, &TransformerConfig{Transformer: canonicalTransformerName})
+	}
+	for _, tc := range steps {
+		transformer, ok := transformers[tc.Transformer]
+		if !ok {
+			return nil, fmt.Errorf("Unknown transformer: %v", tc.Transformer)
+		}
+		start := time.Now()
+		transformation := startSpan("transform " + tc.Transformer)
+		err := transformer(tc, config, env, report)
+		transformation.finish(err)
+		if err != nil {
+			return nil, fmt.Errorf("Transformer %v: %v", tc.Transformer, err)
+		}
+		log.Printf("Transformer %v took %s", tc.Transformer, time.Since(start))
+	}
+	// e.g. blame of the remaining comments was skipped
+	report.Partial = report.Partial || stopRequested()
+	return report, nil
+}
+
+// runPipeline builds the report and plans outputs of all sinks
+func runPipeline(pc *PipelineConfig, config *Config, env *Environment, plan *Plan) error {
+	report, err := buildReport(pc, config, env)
+	if err != nil {
+		return err
+	}
+	if len(explainSkipsFlag) > 0 {
+		if err := planSkips(report.skips, explainSkipsFlag, plan); err != nil {
+			return err
+		}
+	}
+	return planSinks(pc.Sinks, report, env, plan)
+}
@@ -0,0 +1,207 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+
+package api
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// ScorpionClient is the client API for Scorpion service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ScorpionClient interface {
+	// Scan rescans the source root and returns the new report
+	Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (*Report, error)
+	// ListComments returns filtered and sorted page of comments
+	ListComments(ctx context.Context, in *ListCommentsRequest, opts ...grpc.CallOption) (*ListCommentsResponse, error)
+	// StreamChanges streams comment changes detected in watch mode
+	StreamChanges(ctx context.Context, in *StreamChangesRequest, opts ...grpc.CallOption) (Scorpion_StreamChangesClient, error)
+}
+
+type scorpionClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewScorpionClient(cc grpc.ClientConnInterface) ScorpionClient {
+	return &scorpionClient{cc}
+}
+
+func (c *scorpionClient) Scan(ctx context.Context, in *ScanRequest, opts ...grpc.CallOption) (*Report, error) {
+	out := new(Report)
+	err := c.cc.Invoke(ctx, "/scorpion.v1.Scorpion/Scan", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *scorpionClient) ListComments(ctx context.Context, in *ListCommentsRequest, opts ...grpc.CallOption) (*ListCommentsResponse, error) {
+	out := new(ListCommentsResponse)
+	err := c.cc.Invoke(ctx, "/scorpion.v1.Scorpion/ListComments", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *scorpionClient) StreamChanges(ctx context.Context, in *StreamChangesRequest, opts ...grpc.CallOption) (Scorpion_StreamChangesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Scorpion_ServiceDesc.Streams[0], "/scorpion.v1.Scorpion/StreamChanges", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &scorpionStreamChangesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Scorpion_StreamChangesClient interface {
+	Recv() (*ChangeEvent, error)
+	grpc.ClientStream
+}
+
+type scorpionStreamChangesClient struct {
+	grpc.ClientStream
+}
+
+func (x *scorpionStreamChangesClient) Recv() (*ChangeEvent, error) {
+	m := new(ChangeEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ScorpionServer is the server API for Scorpion service.
+// All implementations must embed UnimplementedScorpionServer
+// for forward compatibility
+type ScorpionServer interface {
+	// Scan rescans the source root and returns the new report
+	Scan(context.Context, *ScanRequest) (*Report, error)
+	// ListComments returns filtered and sorted page of comments
+	ListComments(context.Context, *ListCommentsRequest) (*ListCommentsResponse, error)
+	// StreamChanges streams comment changes detected in watch mode
+	StreamChanges(*StreamChangesRequest, Scorpion_StreamChangesServer) error
+	mustEmbedUnimplementedScorpionServer()
+}
+
+// UnimplementedScorpionServer must be embedded to have forward compatible implementations.
+type UnimplementedScorpionServer struct {
+}
+
+func (UnimplementedScorpionServer) Scan(context.Context, *ScanRequest) (*Report, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Scan not implemented")
+}
+func (UnimplementedScorpionServer) ListComments(context.Context, *ListCommentsRequest) (*ListCommentsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListComments not implemented")
+}
+func (UnimplementedScorpionServer) StreamChanges(*StreamChangesRequest, Scorpion_StreamChangesServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamChanges not implemented")
+}
+func (UnimplementedScorpionServer) mustEmbedUnimplementedScorpionServer() {}
+
+// UnsafeScorpionServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ScorpionServer will
+// result in compilation errors.
+type UnsafeScorpionServer interface {
+	mustEmbedUnimplementedScorpionServer()
+}
+
+func RegisterScorpionServer(s grpc.ServiceRegistrar, srv ScorpionServer) {
+	s.RegisterService(&Scorpion_ServiceDesc, srv)
+}
+
+func _Scorpion_Scan_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ScanRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ScorpionServer).Scan(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/scorpion.v1.Scorpion/Scan",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ScorpionServer).Scan(ctx, req.(*ScanRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Scorpion_ListComments_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListCommentsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ScorpionServer).ListComments(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/scorpion.v1.Scorpion/ListComments",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ScorpionServer).ListComments(ctx, req.(*ListCommentsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Scorpion_StreamChanges_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamChangesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ScorpionServer).StreamChanges(m, &scorpionStreamChangesServer{stream})
+}
+
+type Scorpion_StreamChangesServer interface {
+	Send(*ChangeEvent) error
+	grpc.ServerStream
+}
+
+type scorpionStreamChangesServer struct {
+	grpc.ServerStream
+}
+
+func (x *scorpionStreamChangesServer) Send(m *ChangeEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Scorpion_ServiceDesc is the grpc.ServiceDesc for Scorpion service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Scorpion_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "scorpion.v1.Scorpion",
+	HandlerType: (*ScorpionServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Scan",
+			Handler:    _Scorpion_Scan_Handler,
+		},
+		{
+			MethodName: "ListComments",
+			Handler:    _Scorpion_ListComments_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamChanges",
+			Handler:       _Scorpion_StreamChanges_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "pkg/api/scorpion.proto",
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	planWriteFile   = "write-file"
+	planEditFile    = "edit-file"
+	planCreateIssue = "create-issue"
+	planCloseIssue  = "close-issue"
+	planUpdateIssue = "update-issue"
+	planNotify      = "notify"
+	planStdout      = "stdout"
+)
+
+var (
+	errUnknownPlanAction = errors.New("Unknown plan action")
+	errEmptyPlanTarget   = errors.New("Plan action has no target")
+)
+
+// PlanAction is a single mutation that an integration wants to perform.
+// Everything needed to perform it later is stored in the action itself
+type PlanAction struct {
+	Kind    string   `json:"kind"`
+	Target  string   `json:"target"`
+	Summary string   `json:"summary,omitempty"`
+	Content string   `json:"content,omitempty"`
+	Labels  []string `json:"labels,omitempty"`
+	// Fields are other fields of tracker issues, e.g. assignees
+	Fields map[string][]string `json:"fields,omitempty"`
+	// Sync records issues of synced comments in the state file
+	Sync *issueSync `json:"sync,omitempty"`
+}
+
+// Plan is a reviewable list of mutations produced by a run with --dry-run
+// and executed either immediately or later with --apply
+type Plan struct {
+	Root    string        `json:"root"`
+	Actions []*PlanAction `json:"actions"`
+	// Secrets are used by actions of integrations when applied
+	Secrets *Secrets `json:"-"`
+}
+
+// planApplier performs a single plan action
+type planApplier func(p *Plan, a *PlanAction) error
+
+var planAppliers = map[string]planApplier{
+	planWriteFile:   applyWriteFile,
+	planEditFile:    applyWriteFile,
+	planStdout:      applyStdout,
+	planCreateIssue: applyCreateIssue,
+	planUpdateIssue: applyUpdateIssue,
+	planCloseIssue:  applyCloseIssue,
+	planNotify:      applyNotify,
+	planExecSQL:     applyExecSQL,
+	planPatchObject: applyPatchObject,
+	planUpload:      applyUpload,
+	planInsertRows:  applyInsertRows,
+	planPostComment: applyPostComment,
+	planSetStatus:   applySetStatus,
+	planSetLabel:    applySetLabel,
+	planSignFile:    applySignFile,
+}
+
+// NewPlan creates an empty plan for a source root
+func NewPlan(root string) *Plan {
+	return &Plan{
+		Root:    root,
+		Actions: make([]*PlanAction, 0),
+	}
+}
+
+// Add appends an action to the plan
+func (p *Plan) Add(a *PlanAction) {
+	p.Actions = append(p.Actions, a)
+}
+
+// Count returns number of actions of the kind
+func (p *Plan) Count(kind string) int {
+	count := 0
+	for _, a := range p.Actions {
+		if a.Kind == kind {
+			count++
+		}
+	}
+	return count
+}
+
+// Summary returns a human-readable description of the plan
+func (p *Plan) Summary() string {
+	return fmt.Sprintf("create %v issues, update %v, close %v, edit %v files, send %v notifications",
+		p.Count(planCreateIssue),
+		p.Count(planUpdateIssue),
+		p.Count(planCloseIssue),
+		p.Count(planWriteFile)+p.Count(planEditFile),
+		p.Count(planNotify))
+}
+
+// Apply performs all actions of the plan in order and stops on first error
+func (p *Plan) Apply() error {
+	for _, a := range p.Actions {
+		applier, ok := planAppliers[a.Kind]
+		if !ok {
+			return fmt.Errorf("%v: %v", errUnknownPlanAction, a.Kind)
+		}
+		if len(a.Target) == 0 {
+			return errEmptyPlanTarget
+		}
+		log.Printf("Applying %v %v", a.Kind, a.Target)
+		application := startSpan("apply " + a.Kind)
+		application.set("scorpion.target", a.Target)
+		err := applier(p, a)
+		application.finish(err)
+		countMetric("actions", "{action}", 1, "kind", a.Kind)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolve returns target path of the action relative to plan's root
+func (p *Plan) resolve(target string) string {
+	if filepath.IsAbs(target) || len(p.Root) == 0 {
+		return target
+	}
+	return filepath.Join(p.Root, target)
+}
+
+// applyWriteFile replaces the file with a renamed temporary one, so
+// that interrupted runs never leave truncated files
+func applyWriteFile(p *Plan, a *PlanAction) error {
+	path := p.resolve(a.Target)
+	// edits of symlinked files change their targets
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	mode := os.FileMode(0644)
+	if fi, err := os.Stat(path); err == nil {
+		if !fi.Mode().IsRegular() {
+			return ioutil.WriteFile(path, []byte(a.Content), mode)
+		}
+		mode = fi.Mode().Perm()
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write([]byte(a.Content)); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func applyStdout(p *Plan, a *PlanAction) error {
+	_, err := fmt.Print(a.Content)
+	return err
+}
+
+func applyCreateIssue(p *Plan, a *PlanAction) error {
+	if strings.HasPrefix(a.Target, githubTargetPrefix) {
+		number, err := createGitHubIssue(p, a)
+		if err != nil {
+			return err
+		}
+		return a.Sync.record(p, a.Target, number)
+	}
+	return fmt.Errorf("%v: %v %v", errUnknownPlanAction, a.Kind, a.Target)
+}
+
+func applyUpdateIssue(p *Plan, a *PlanAction) error {
+	if strings.HasPrefix(a.Target, githubTargetPrefix) {
+		if err := updateGitHubIssue(p, a); err != nil {
+			return err
+		}
+		return a.Sync.record(p, a.Target, 0)
+	}
+	return fmt.Errorf("%v: %v %v", errUnknownPlanAction, a.Kind, a.Target)
+}
+
+func applyCloseIssue(p *Plan, a *PlanAction) error {
+	if strings.HasPrefix(a.Target, githubTargetPrefix) {
+		if err := closeGitHubIssue(p, a); err != nil {
+			return err
+		}
+		return a.Sync.record(p, a.Target, 0)
+	}
+	return fmt.Errorf("%v: %v %v", errUnknownPlanAction, a.Kind, a.Target)
+}
+
+func applyNotify(p *Plan, a *PlanAction) error {
+	if a.Target == slackSinkName {
+		return applySlackMessage(p, a)
+	}
+	return fmt.Errorf("%v: %v %v", errUnknownPlanAction, a.Kind, a.Target)
+}
+
+// loadPlan reads plan previously saved with --dry-run
+func loadPlan(path string) (*Plan, error) {
+	var data []byte
+	var err error
+	if path == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{}
+	if err := json.Unmarshal(data, plan); err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
+// printPlan outputs the plan as json to stdout and its summary to stderr
+func printPlan(plan *Plan) error {
+	js, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(js))
+	fmt.Fprintf(os.Stderr, "Plan: %v\n", plan.Summary())
+	for _, a := range plan.Actions {
+		fmt.Fprintf(os.Stderr, "  %v %v %v\n", a.Kind, a.Target, strings.TrimSpace(a.Summary))
+	}
+	return nil
+}
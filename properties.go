@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+var (
+	errUnterminatedQuote = errors.New("Unterminated quote in properties")
+)
+
+// propertyToken is a word of a properties line, eq is the index of the
+// first "=" outside of quotes in the text, -1 if there is none
+type propertyToken struct {
+	text string
+	eq   int
+}
+
+// tokenizeProperties splits the line by whitespace, except for quoted
+// ("tech debt" or 'tech debt') and escaped (tech\ debt) spaces
+func tokenizeProperties(line string) ([]*propertyToken, error) {
+	tokens := make([]*propertyToken, 0)
+	var sb strings.Builder
+	eq := -1
+	started := false
+	escaped := false
+	var quote rune
+	flush := func() {
+		if started {
+			tokens = append(tokens, &propertyToken{text: sb.String(), eq: eq})
+		}
+		sb.Reset()
+		eq = -1
+		started = false
+	}
+	for _, r := range line {
+		switch {
+		case escaped:
+			sb.WriteRune(r)
+			escaped = false
+		case r == '\\':
+			escaped = true
+			started = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				sb.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			started = true
+		case unicode.IsSpace(r):
+			flush()
+		case r == '=' && eq < 0:
+			eq = sb.Len()
+			sb.WriteRune(r)
+			started = true
+		default:
+			sb.WriteRune(r)
+			started = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, errUnterminatedQuote
+	}
+	flush()
+	return tokens, nil
+}
+
+// isPropertyKey checks if the key is a word like "component" or
+// "sla-level", not a part of prose or a URL
+func isPropertyKey(key string) bool {
+	for i, r := range key {
+		if !unicode.IsLetter(r) && (i == 0 || !unicode.IsDigit(r) && r != '_' && r != '-' && r != '.') {
+			return false
+		}
+	}
+	return len(key) > 0
+}
+
+// parseProperties returns key=value pairs of the line. Words without
+// "=" continue the previous value, so "category=tech debt" works
+// without quotes, but the line has to start with a pair. continued
+// tells if there were such words
+func parseProperties(line string) (properties map[string]string, continued bool, err error) {
+	tokens, err := tokenizeProperties(line)
+	if err != nil {
+		return nil, false, err
+	}
+	properties = make(map[string]string)
+	last := ""
+	for _, t := range tokens {
+		if t.eq < 0 {
+			if len(last) == 0 {
+				return nil, false, errCannotParseIni
+			}
+			if len(properties[last]) > 0 {
+				properties[last] += " "
+			}
+			properties[last] += t.text
+			continued = true
+			continue
+		}
+		last = t.text[:t.eq]
+		if !isPropertyKey(last) {
+			return nil, false, errCannotParseIni
+		}
+		properties[last] = t.text[t.eq+1:]
+	}
+	if len(properties) == 0 {
+		return nil, false, errCannotParseIni
+	}
+	return properties, continued, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	refsKeyword     = "REFS"
+	relationIssue   = "issue"
+	relationFile    = "file"
+	relationComment = "comment"
+)
+
+var (
+	issueRefRegexp   = regexp.MustCompile(`^#([0-9]+)$`)
+	commentRefRegexp = regexp.MustCompile(`^[0-9a-f]{12}(-[0-9]+)?$`)
+)
+
+// Relation connects the comment to an issue, a file (relative to the
+// root) or another comment (by its ID)
+type Relation struct {
+	Kind   string `json:"kind"`
+	Target string `json:"target"`
+}
+
+func isRefSeparator(r rune) bool {
+	return r == ',' || r == ' ' || r == '\t'
+}
+
+// parseRelation returns relation of the reference, the kind is file
+// for anything but issue numbers, resolveRelations tells comments apart
+func parseRelation(ref string) *Relation {
+	if m := issueRefRegexp.FindStringSubmatch(ref); m != nil {
+		return &Relation{Kind: relationIssue, Target: m[1]}
+	}
+	if _, err := strconv.Atoi(ref); err == nil {
+		return &Relation{Kind: relationIssue, Target: ref}
+	}
+	return &Relation{Kind: relationFile, Target: strings.TrimPrefix(path.Clean(ref), "./")}
+}
+
+// parseTitleRelations returns relations of the title of a REFS
+// comment ("#123, pkg/parser.go"), words that look like neither
+// issues, comment IDs nor paths are prose and skipped
+func parseTitleRelations(title string) []*Relation {
+	relations := make([]*Relation, 0)
+	for _, word := range strings.FieldsFunc(title, isRefSeparator) {
+		word = strings.TrimRight(word, ".;:)")
+		word = strings.TrimLeft(word, "(")
+		switch {
+		case issueRefRegexp.MatchString(word):
+			relations = append(relations, parseRelation(word))
+		case commentRefRegexp.MatchString(word):
+			relations = append(relations, &Relation{Kind: relationComment, Target: word})
+		case strings.Contains(word, "/") || strings.Contains(strings.TrimPrefix(word, "."), "."):
+			relations = append(relations, parseRelation(word))
+		}
+	}
+	return relations
+}
+
+// relationPath returns path and line of the file relation
+// ("pkg/parser.go:40"), the line is 0 if it is not given
+func relationPath(r *Relation) (string, int) {
+	if i := strings.LastIndex(r.Target, ":"); i > 0 {
+		if line, err := strconv.Atoi(r.Target[i+1:]); err == nil {
+			return r.Target[:i], line
+		}
+	}
+	return r.Target, 0
+}
+
+// resolveRelations turns file relations to IDs of the comments into
+// comment relations, IDs have to be assigned
+func resolveRelations(comments []*ToDoComment) {
+	ids := make(map[string]bool, len(comments))
+	for _, c := range comments {
+		ids[c.ID] = true
+	}
+	for _, c := range comments {
+		for _, r := range c.Relations {
+			if r.Kind == relationFile && ids[r.Target] {
+				r.Kind = relationComment
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var (
+	errNoConfigFile = errors.New("Config is not read from a file")
+)
+
+// configWatcher reloads the config file of long running modes when it
+// changes. Invalid versions are rejected, so the previous config
+// stays in use until the file is fixed
+type configWatcher struct {
+	path        string
+	mu          sync.Mutex
+	fingerprint string
+}
+
+// newConfigWatcher watches the config of loadConfig, nil if it is
+// given with SCORPION_CONFIG_YAML and there is no file to watch
+func newConfigWatcher(path, root string) *configWatcher {
+	if len(path) == 0 && len(os.Getenv(configEnv)) > 0 {
+		return nil
+	}
+	if len(path) == 0 {
+		path = filepath.Join(root, defaultConfigName)
+	}
+	w := &configWatcher{path: path}
+	w.fingerprint = w.read()
+	return w
+}
+
+// read returns hash of the file, empty if it does not exist
+func (w *configWatcher) read() string {
+	data, err := ioutil.ReadFile(w.path)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// load returns the config if it is valid, like "scorpion doctor"
+// validates it
+func (w *configWatcher) load() (*Config, error) {
+	config, err := loadConfig(w.path, "")
+	if os.IsNotExist(err) {
+		// removed file means the defaults
+		return NewConfig(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	errs := validateConfig(config)
+	if len(errs) == 1 {
+		return nil, errs[0]
+	}
+	if len(errs) > 1 {
+		return nil, fmt.Errorf("%v (and %v more problems)", errs[0], len(errs)-1)
+	}
+	return config, nil
+}
+
+// Apply loads the file and calls onReload with it if it is valid,
+// onReload returns an error to reject the config, e.g. if a scan
+// with it failed
+func (w *configWatcher) Apply(onReload func(config *Config) error) error {
+	if w == nil {
+		return errNoConfigFile
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.fingerprint = w.read()
+	config, err := w.load()
+	if err == nil {
+		err = onReload(config)
+	}
+	if err != nil {
+		log.Printf("Keeping previous config, %v is invalid: %v", w.path, err)
+		return err
+	}
+	log.Printf("Reloaded config from %v", w.path)
+	return nil
+}
+
+// Run checks the file every interval until stop is closed and
+// applies it when it changes
+func (w *configWatcher) Run(stop <-chan struct{}, interval time.Duration, onReload func(config *Config) error) {
+	if w == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			changed := w.read() != w.fingerprint
+			w.mu.Unlock()
+			if changed {
+				w.Apply(onReload)
+			}
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	defaultRemote = "origin"
+)
+
+// Remote is a git remote of the repository
+type Remote struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// sanitizeRemoteURL removes credentials from http remote urls
+func sanitizeRemoteURL(remote string) string {
+	u, err := url.Parse(remote)
+	if err != nil || u.User == nil || len(u.Scheme) == 0 {
+		return remote
+	}
+	u.User = nil
+	return u.String()
+}
+
+// Remotes returns all remotes of the repository sorted by name
+func (env *Environment) Remotes() []*Remote {
+	out := env.git("config", "--get-regexp", `^remote\..*\.url$`)
+	remotes := make([]*Remote, 0)
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.Fields(line)
+		if len(parts) != 2 {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(parts[0], "remote."), ".url")
+		remotes = append(remotes, &Remote{Name: name, URL: sanitizeRemoteURL(parts[1])})
+	}
+	sort.Slice(remotes, func(i, j int) bool { return remotes[i].Name < remotes[j].Name })
+	return remotes
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	weightNone     = "none"
+	weightAge      = "age"
+	weightSeverity = "severity"
+)
+
+var (
+	errNoComments = errors.New("No comments match the filters")
+)
+
+// rouletteWeights return weight of the comment, comments with higher
+// weight are picked more often
+var rouletteWeights = map[string]func(c *ToDoComment, now time.Time) float64{
+	weightNone: func(c *ToDoComment, now time.Time) float64 { return 1 },
+	weightAge: func(c *ToDoComment, now time.Time) float64 {
+		if blameDate(c).IsZero() {
+			return 1
+		}
+		return 1 + now.Sub(blameDate(c)).Hours()/24
+	},
+	weightSeverity: func(c *ToDoComment, now time.Time) float64 {
+		return float64(1 + typeSeverities[strings.ToUpper(c.Type)])
+	},
+}
+
+// pickComment returns a random comment, the chance of every comment
+// is proportional to its weight
+func pickComment(comments []*ToDoComment, weight func(c *ToDoComment, now time.Time) float64, rnd *rand.Rand) *ToDoComment {
+	now := time.Now()
+	total := 0.0
+	weights := make([]float64, len(comments))
+	for i, c := range comments {
+		weights[i] = weight(c, now)
+		total += weights[i]
+	}
+	x := rnd.Float64() * total
+	for i, w := range weights {
+		if x < w {
+			return comments[i]
+		}
+		x -= w
+	}
+	return comments[len(comments)-1]
+}
+
+// writeCommentContext prints lines of the file around the comment,
+// the line of the comment is marked
+func writeCommentContext(w io.Writer, root string, c *ToDoComment, lines int) error {
+	data, err := ioutil.ReadFile(filepath.Join(root, c.File))
+	if err != nil {
+		// e.g. the comment was scanned in another ref
+		return nil
+	}
+	text := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	start, end := c.Line-lines, c.Line+lines+1
+	if start < 0 {
+		start = 0
+	}
+	if end > len(text) {
+		end = len(text)
+	}
+	width := len(fmt.Sprint(end))
+	for i := start; i < end; i++ {
+		marker := " "
+		if i == c.Line {
+			marker = ">"
+		}
+		if _, err := fmt.Fprintf(w, "%v %*d | %v\n", marker, width, i+1, strings.TrimRight(text[i], "\r")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func printRoulette(w io.Writer, c *ToDoComment, hf *humanFormat, t *theme) error {
+	fmt.Fprintf(w, "%v: %v\n", t.Paint(c.Type, c.Type), c.Title)
+	fmt.Fprintf(w, "%v\n", commentLocation(c))
+	details := make([]string, 0, 3)
+	if age := commentAge(c, time.Now(), hf); len(age) > 0 {
+		details = append(details, "age "+age)
+	}
+	if c.Estimate >= estimateEpsilon {
+		details = append(details, "estimate "+hf.Estimate(c.Estimate))
+	}
+	if len(c.Assignee) > 0 {
+		details = append(details, "assignee "+c.Assignee)
+	}
+	if len(details) > 0 {
+		fmt.Fprintln(w, strings.Join(details, ", "))
+	}
+	if len(c.Body) > 0 {
+		fmt.Fprintf(w, "\n%v\n", c.Body)
+	}
+	if contextFlag <= 0 {
+		return nil
+	}
+	fmt.Fprintln(w)
+	return writeCommentContext(w, srcRootFlag, c, contextFlag)
+}
+
+// runRoulette implements "scorpion roulette [paths]", it picks a random
+// comment matching --filter to pay down, comments of closed tracker
+// issues are skipped
+func runRoulette(args []string) error {
+	weight, ok := rouletteWeights[weightFlag]
+	if !ok {
+		return fmt.Errorf("Unknown weight: %v, use none, age or severity", weightFlag)
+	}
+	comments, report, err := commandComments(args)
+	if err != nil {
+		return err
+	}
+	open := make([]*ToDoComment, 0, len(comments))
+	for _, c := range comments {
+		if !c.Tracker.Closed() {
+			open = append(open, c)
+		}
+	}
+	if len(open) == 0 {
+		return errNoComments
+	}
+	rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return printRoulette(os.Stdout, pickComment(open, weight, rnd), report.display, report.theme)
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	rulesCheckName = "rules"
+	// metadataFieldPrefix selects a key of metadata ("metadata.sla"),
+	// empty if the comment does not have it
+	metadataFieldPrefix = "metadata."
+)
+
+var (
+	errUnknownField = errors.New("Unknown field")
+	// securityRule fails the gate on SECURITY comments unless disabled
+	// with security: false of the gate section
+	securityRule = &RuleConfig{
+		Name:    "security",
+		When:    `type == "SECURITY"`,
+		Message: "security comments must be resolved",
+	}
+)
+
+// ruleField returns value of the comment field for rule expressions,
+// strings, numbers or booleans
+type ruleField func(c *ToDoComment, now time.Time) interface{}
+
+var ruleFields = map[string]ruleField{
+	"type":     func(c *ToDoComment, now time.Time) interface{} { return c.Type },
+	"title":    func(c *ToDoComment, now time.Time) interface{} { return c.Title },
+	"body":     func(c *ToDoComment, now time.Time) interface{} { return c.Body },
+	"file":     func(c *ToDoComment, now time.Time) interface{} { return c.File },
+	"line":     func(c *ToDoComment, now time.Time) interface{} { return float64(c.Line + 1) },
+	"category": func(c *ToDoComment, now time.Time) interface{} { return c.Category },
+	"assignee": func(c *ToDoComment, now time.Time) interface{} { return c.Assignee },
+	"estimate": func(c *ToDoComment, now time.Time) interface{} { return c.Estimate },
+	"language": func(c *ToDoComment, now time.Time) interface{} { return c.Language },
+	"project":  func(c *ToDoComment, now time.Time) interface{} { return c.Project },
+	"issue":    func(c *ToDoComment, now time.Time) interface{} { return float64(c.Issue) },
+	"confidence": func(c *ToDoComment, now time.Time) interface{} {
+		return c.confidence()
+	},
+	"author": func(c *ToDoComment, now time.Time) interface{} {
+		if c.Blame == nil {
+			return ""
+		}
+		if len(c.Blame.CanonicalAuthor) > 0 {
+			return c.Blame.CanonicalAuthor
+		}
+		return c.Blame.Author
+	},
+	// ageDays is 0 without blame
+	"ageDays": func(c *ToDoComment, now time.Time) interface{} {
+		if c.Blame == nil {
+			return float64(0)
+		}
+		return float64(int(now.Sub(c.Blame.Date).Hours() / 24))
+	},
+}
+
+// ruleExpr is a node of the parsed rule expression
+type ruleExpr func(c *ToDoComment, now time.Time) (interface{}, error)
+
+// Rule reports comments matching the compiled expression
+type Rule struct {
+	Name    string
+	Message string
+	expr    ruleExpr
+}
+
+// compileRule parses expression of the rule config
+func compileRule(rc *RuleConfig) (*Rule, error) {
+	p := &ruleParser{}
+	if err := p.tokenize(rc.When); err != nil {
+		return nil, fmt.Errorf("Rule %v: %v", rc.Name, err)
+	}
+	expr, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected %q", p.tokens[p.pos].text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Rule %v: %v", rc.Name, err)
+	}
+	message := rc.Message
+	if len(message) == 0 {
+		message = rc.When
+	}
+	return &Rule{Name: rc.Name, Message: message, expr: expr}, nil
+}
+
+// Match checks if the comment satisfies the rule expression
+func (r *Rule) Match(c *ToDoComment, now time.Time) (bool, error) {
+	v, err := r.expr(c, now)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("Rule %v: expression is not a condition", r.Name)
+	}
+	return b, nil
+}
+
+// rulesCheck reports comments matching any of the configured rules
+func rulesCheck(report *result, config *Config, env *Environment) ([]*Violation, error) {
+	configs := config.Gate.Rules
+	if boolValue(config.Gate.Security, true) {
+		configs = append([]*RuleConfig{securityRule}, configs...)
+	}
+	rules := make([]*Rule, 0, len(configs))
+	for _, rc := range configs {
+		rule, err := compileRule(rc)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	now := time.Now()
+	violations := make([]*Violation, 0)
+	for _, c := range report.Comments {
+		for _, rule := range rules {
+			matched, err := rule.Match(c, now)
+			if err != nil {
+				return nil, err
+			}
+			if matched {
+				violations = append(violations, &Violation{
+					Check:   rulesCheckName + "/" + rule.Name,
+					Message: rule.Message,
+					Comment: c,
+				})
+			}
+		}
+	}
+	return violations, nil
+}
+
+type ruleTokenKind int
+
+const (
+	tokenIdent ruleTokenKind = iota
+	tokenNumber
+	tokenString
+	tokenOperator
+)
+
+type ruleToken struct {
+	kind ruleTokenKind
+	text string
+}
+
+// ruleParser is a recursive descent parser of expressions like
+// `type == "HACK" && ageDays > 90 && estimate == 0`
+type ruleParser struct {
+	tokens []ruleToken
+	pos    int
+}
+
+var ruleOperators = []string{"&&", "||", "==", "!=", "<=", ">=", "=~", "<", ">", "!", "(", ")"}
+
+func (p *ruleParser) tokenize(s string) error {
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			// dots select keys of metadata
+			for j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || runes[j] == '_' || runes[j] == '.') {
+				j++
+			}
+			p.tokens = append(p.tokens, ruleToken{tokenIdent, string(runes[i:j])})
+			i = j
+		case unicode.IsDigit(r):
+			j := i
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.') {
+				j++
+			}
+			p.tokens = append(p.tokens, ruleToken{tokenNumber, string(runes[i:j])})
+			i = j
+		case r == '"' || r == '\'':
+			j := i + 1
+			var sb strings.Builder
+			for ; j < len(runes) && runes[j] != r; j++ {
+				if runes[j] == '\\' && j+1 < len(runes) {
+					j++
+				}
+				sb.WriteRune(runes[j])
+			}
+			if j >= len(runes) {
+				return errors.New("unterminated string")
+			}
+			p.tokens = append(p.tokens, ruleToken{tokenString, sb.String()})
+			i = j + 1
+		default:
+			op := ""
+			for _, o := range ruleOperators {
+				if strings.HasPrefix(string(runes[i:]), o) {
+					op = o
+					break
+				}
+			}
+			if len(op) == 0 {
+				return fmt.Errorf("unexpected %q", r)
+			}
+			p.tokens = append(p.tokens, ruleToken{tokenOperator, op})
+			i += len([]rune(op))
+		}
+	}
+	return nil
+}
+
+func (p *ruleParser) accept(op string) bool {
+	if p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenOperator && p.tokens[p.pos].text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *ruleParser) parseOr() (ruleExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalExpr(left, right, true)
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseAnd() (ruleExpr, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalExpr(left, right, false)
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseNot() (ruleExpr, error) {
+	if !p.accept("!") {
+		return p.parseComparison()
+	}
+	operand, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	return func(c *ToDoComment, now time.Time) (interface{}, error) {
+		v, err := operand(c, now)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := v.(bool)
+		if !ok {
+			return nil, errors.New("operand of ! is not a condition")
+		}
+		return !b, nil
+	}, nil
+}
+
+func (p *ruleParser) parseComparison() (ruleExpr, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "=~", "<", ">"} {
+		if !p.accept(op) {
+			continue
+		}
+		if op == "=~" {
+			if p.pos >= len(p.tokens) || p.tokens[p.pos].kind != tokenString {
+				return nil, errors.New("=~ expects a string pattern")
+			}
+			re, err := regexp.Compile(p.tokens[p.pos].text)
+			if err != nil {
+				return nil, err
+			}
+			p.pos++
+			return matchExpr(left, re), nil
+		}
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return compareExpr(left, right, op), nil
+	}
+	return left, nil
+}
+
+func (p *ruleParser) parseOperand() (ruleExpr, error) {
+	if p.accept("(") {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.accept(")") {
+			return nil, errors.New("missing )")
+		}
+		return expr, nil
+	}
+	if p.pos >= len(p.tokens) {
+		return nil, errors.New("unexpected end of expression")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	switch t.kind {
+	case tokenNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, err
+		}
+		return constExpr(n), nil
+	case tokenString:
+		return constExpr(t.text), nil
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return constExpr(true), nil
+		case "false":
+			return constExpr(false), nil
+		}
+		if strings.HasPrefix(t.text, metadataFieldPrefix) {
+			key := strings.TrimPrefix(t.text, metadataFieldPrefix)
+			return func(c *ToDoComment, now time.Time) (interface{}, error) {
+				return c.Metadata[key], nil
+			}, nil
+		}
+		field, ok := ruleFields[t.text]
+		if !ok {
+			return nil, fmt.Errorf("%v: %v", errUnknownField, t.text)
+		}
+		return func(c *ToDoComment, now time.Time) (interface{}, error) {
+			return field(c, now), nil
+		}, nil
+	}
+	return nil, fmt.Errorf("unexpected %q", t.text)
+}
+
+func constExpr(v interface{}) ruleExpr {
+	return func(c *ToDoComment, now time.Time) (interface{}, error) {
+		return v, nil
+	}
+}
+
+func logicalExpr(left, right ruleExpr, or bool) ruleExpr {
+	return func(c *ToDoComment, now time.Time) (interface{}, error) {
+		for _, operand := range []ruleExpr{left, right} {
+			v, err := operand(c, now)
+			if err != nil {
+				return nil, err
+			}
+			b, ok := v.(bool)
+			if !ok {
+				return nil, errors.New("operand of && or || is not a condition")
+			}
+			// short circuit
+			if b == or {
+				return or, nil
+			}
+		}
+		return !or, nil
+	}
+}
+
+func matchExpr(operand ruleExpr, re *regexp.Regexp) ruleExpr {
+	return func(c *ToDoComment, now time.Time) (interface{}, error) {
+		v, err := operand(c, now)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := v.(string)
+		if !ok {
+			return nil, errors.New("=~ expects a string field")
+		}
+		return re.MatchString(s), nil
+	}
+}
+
+func compareExpr(left, right ruleExpr, op string) ruleExpr {
+	return func(c *ToDoComment, now time.Time) (interface{}, error) {
+		a, err := left(c, now)
+		if err != nil {
+			return nil, err
+		}
+		b, err := right(c, now)
+		if err != nil {
+			return nil, err
+		}
+		switch x := a.(type) {
+		case float64:
+			y, ok := b.(float64)
+			if !ok {
+				return nil, fmt.Errorf("cannot compare number with %v", b)
+			}
+			switch op {
+			case "==":
+				return x == y, nil
+			case "!=":
+				return x != y, nil
+			case "<":
+				return x < y, nil
+			case "<=":
+				return x <= y, nil
+			case ">":
+				return x > y, nil
+			case ">=":
+				return x >= y, nil
+			}
+		case string:
+			y, ok := b.(string)
+			if !ok {
+				return nil, fmt.Errorf("cannot compare string with %v", b)
+			}
+			switch op {
+			case "==":
+				return strings.EqualFold(x, y), nil
+			case "!=":
+				return !strings.EqualFold(x, y), nil
+			case "<":
+				return x < y, nil
+			case "<=":
+				return x <= y, nil
+			case ">":
+				return x > y, nil
+			case ">=":
+				return x >= y, nil
+			}
+		case bool:
+			y, ok := b.(bool)
+			if !ok || (op != "==" && op != "!=") {
+				return nil, fmt.Errorf("cannot compare condition with %v", b)
+			}
+			return (x == y) == (op == "=="), nil
+		}
+		return nil, fmt.Errorf("unsupported comparison %v", op)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+)
+
+const (
+	sarifSinkName = "sarif"
+	sarifSchema   = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifVersion  = "2.1.0"
+	sarifError    = "error"
+	sarifWarning  = "warning"
+	sarifNote     = "note"
+)
+
+// sarifLevels maps comment types to levels of results like
+// lspSeverities, other types are notes
+var sarifLevels = map[string]string{
+	"SECURITY":   sarifError,
+	"URGENT":     sarifError,
+	"BUG":        sarifWarning,
+	"FIXME":      sarifWarning,
+	"DEPRECATED": sarifWarning,
+}
+
+type sarifLog struct {
+	Schema  string      `json:"$schema"`
+	Version string      `json:"version"`
+	Runs    []*sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool      `json:"tool"`
+	Results []*sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name    string       `json:"name"`
+	Version string       `json:"version"`
+	Rules   []*sarifRule `json:"rules"`
+}
+
+// sarifRule is a comment type
+type sarifRule struct {
+	ID               string       `json:"id"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string           `json:"ruleId"`
+	Level     string           `json:"level"`
+	Message   sarifMessage     `json:"message"`
+	Locations []*sarifLocation `json:"locations"`
+	// PartialFingerprints track results across scans by comment ID,
+	// so that code scanning does not reopen moved comments
+	PartialFingerprints map[string]string `json:"partialFingerprints,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation `json:"physicalLocation"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifact `json:"artifactLocation"`
+	Region           sarifRegion   `json:"region"`
+}
+
+type sarifArtifact struct {
+	URI string `json:"uri"`
+}
+
+type sarifRegion struct {
+	StartLine int `json:"startLine"`
+}
+
+// buildSARIF returns the report as a SARIF log, e.g. for GitHub code
+// scanning
+func buildSARIF(report *result) *sarifLog {
+	types := make(map[string]bool)
+	results := make([]*sarifResult, 0, len(report.Comments))
+	for _, c := range report.Comments {
+		types[c.Type] = true
+		level, ok := sarifLevels[c.Type]
+		if !ok {
+			level = sarifNote
+		}
+		r := &sarifResult{
+			RuleID:  c.Type,
+			Level:   level,
+			Message: sarifMessage{Text: c.Title},
+			Locations: []*sarifLocation{{PhysicalLocation: sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifact{URI: c.File},
+				Region:           sarifRegion{StartLine: c.Line + 1},
+			}}},
+		}
+		if len(c.ID) > 0 {
+			r.PartialFingerprints = map[string]string{appName + "/v1": c.ID}
+		}
+		results = append(results, r)
+	}
+	rules := make([]*sarifRule, 0, len(types))
+	for t := range types {
+		rules = append(rules, &sarifRule{ID: t, ShortDescription: sarifMessage{Text: t + " comment"}})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	return &sarifLog{
+		Schema:  sarifSchema,
+		Version: sarifVersion,
+		Runs: []*sarifRun{{
+			Tool:    sarifTool{Driver: sarifDriver{Name: appName, Version: version, Rules: rules}},
+			Results: results,
+		}},
+	}
+}
+
+type sarifSink struct {
+	path string
+}
+
+func newSARIFSink(sc *SinkConfig, env *Environment) (Sink, error) {
+	return &sarifSink{path: sc.Path}, nil
+}
+
+func (s *sarifSink) Emit(report *result, plan *Plan) error {
+	js, err := json.MarshalIndent(buildSARIF(report), "", "  ")
+	if err != nil {
+		return err
+	}
+	if len(s.path) == 0 {
+		plan.Add(&PlanAction{Kind: planStdout, Target: "stdout", Summary: "sarif", Content: string(js) + "\n"})
+		return nil
+	}
+	plan.Add(&PlanAction{Kind: planWriteFile, Target: s.path, Summary: "sarif", Content: string(js)})
+	return nil
+}
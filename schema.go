@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+)
+
+const (
+	// reportSchemaVersion is incremented on incompatible changes
+	// of the json report, e.g. when a field changes its type
+	reportSchemaVersion = 1
+)
+
+// reportSchema is JSON Schema of the report with reportSchemaVersion
+const reportSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "https://github.com/qorpress/scorpion/schema/report/v1.json",
+  "title": "scorpion report",
+  "type": "object",
+  "required": ["schemaVersion", "root", "branch", "author", "project", "comments"],
+  "properties": {
+    "schemaVersion": {"type": "integer", "const": 1},
+    "root": {"type": "string"},
+    "branch": {"type": "string"},
+    "revision": {"type": "string"},
+    "author": {"type": "string"},
+    "project": {"type": "string"},
+    "remotes": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["name", "url"],
+        "properties": {
+          "name": {"type": "string"},
+          "url": {"type": "string"}
+        }
+      }
+    },
+    "comments": {
+      "type": ["array", "null"],
+      "items": {"$ref": "#/definitions/comment"}
+    },
+    "stats": {"$ref": "#/definitions/stats"},
+    "partial": {"type": "boolean", "description": "the scan was interrupted and comments are missing"},
+    "fileErrors": {
+      "type": "array",
+      "description": "files that could not be read, their comments are missing",
+      "items": {
+        "type": "object",
+        "required": ["path", "error"],
+        "properties": {
+          "path": {"type": "string"},
+          "error": {"type": "string"}
+        }
+      }
+    },
+    "cappedFiles": {
+      "type": "array",
+      "description": "files with more comments than the limit, only the first ones are reported",
+      "items": {
+        "type": "object",
+        "required": ["path", "comments", "kept"],
+        "properties": {
+          "path": {"type": "string"},
+          "comments": {"type": "integer"},
+          "kept": {"type": "integer"}
+        }
+      }
+    },
+    "provenance": {
+      "type": "object",
+      "description": "set for signed reports",
+      "required": ["tool", "version", "generatedAt"],
+      "properties": {
+        "tool": {"type": "string"},
+        "version": {"type": "string"},
+        "generatedAt": {"type": "string", "format": "date-time"},
+        "builder": {"type": "string", "description": "url of the CI job or host of the run"},
+        "invocation": {"type": "array", "items": {"type": "string"}},
+        "configDigest": {"type": "string", "description": "sha256 of the effective config"}
+      }
+    }
+  },
+  "definitions": {
+    "comment": {
+      "type": "object",
+      "required": ["type", "title", "body", "file", "line"],
+      "properties": {
+        "id": {"type": "string", "description": "stable across line moves and body edits"},
+        "type": {"type": "string"},
+        "title": {"type": "string"},
+        "body": {"type": "string"},
+        "file": {"type": "string"},
+        "line": {"type": "integer", "minimum": 0, "description": "0-based line number"},
+        "issue": {"type": "integer"},
+        "category": {"type": "string"},
+        "assignee": {"type": "string"},
+        "estimate": {"type": "number", "description": "estimate in hours"},
+        "estimateISO": {"type": "string", "description": "estimate as ISO 8601 duration, e.g. PT4H30M"},
+        "estimateDefault": {"type": "boolean", "description": "estimate is the default of the type"},
+        "metadata": {"type": "object", "additionalProperties": {"type": "string"}, "description": "unknown properties"},
+        "relations": {"type": "array", "items": {"$ref": "#/definitions/relation"}},
+        "duplicateOf": {"type": "string", "description": "ID of the first comment of near-duplicates"},
+        "duplicates": {"type": "array", "items": {"type": "string"}, "description": "IDs of near-duplicates of the comment"},
+        "language": {"type": "string"},
+        "project": {"type": "string", "description": "submodule the comment was found in"},
+        "confidence": {"type": "number", "minimum": 0, "maximum": 1},
+        "titleLanguage": {"type": "string", "description": "ISO 639-1 code"},
+        "originalTitle": {"type": "string", "description": "title before translation"},
+        "rawTitle": {"type": "string", "description": "title as written when normalization changed it"},
+        "blame": {"$ref": "#/definitions/blame"},
+        "tracker": {"$ref": "#/definitions/tracker"}
+      }
+    },
+    "relation": {
+      "type": "object",
+      "required": ["kind", "target"],
+      "properties": {
+        "kind": {"type": "string", "enum": ["issue", "file", "comment"]},
+        "target": {"type": "string", "description": "issue number, path relative to the root or comment ID"}
+      }
+    },
+    "tracker": {
+      "type": "object",
+      "required": ["number", "state"],
+      "properties": {
+        "number": {"type": "integer"},
+        "url": {"type": "string"},
+        "state": {"type": "string", "description": "open or closed"},
+        "assignees": {"type": "array", "items": {"type": "string"}},
+        "labels": {"type": "array", "items": {"type": "string"}}
+      }
+    },
+    "blame": {
+      "type": "object",
+      "required": ["commit", "date"],
+      "properties": {
+        "commit": {"type": "string"},
+        "author": {"type": "string"},
+        "email": {"type": "string"},
+        "date": {"type": "string", "format": "date-time"},
+        "permalink": {"type": "string"},
+        "canonicalAuthor": {"type": "string", "description": "author mapped with .mailmap"},
+        "canonicalEmail": {"type": "string"}
+      }
+    },
+    "counts": {
+      "type": "object",
+      "additionalProperties": {"type": "integer"}
+    },
+    "languageStats": {
+      "type": "object",
+      "required": ["files", "comments", "types"],
+      "properties": {
+        "files": {"type": "integer"},
+        "comments": {"type": "integer"},
+        "estimate": {"type": "number"},
+        "types": {"$ref": "#/definitions/counts"},
+        "cost": {"type": "number"}
+      }
+    },
+    "stats": {
+      "type": "object",
+      "required": ["files", "comments", "types", "languages"],
+      "properties": {
+        "files": {"type": "integer"},
+        "comments": {"type": "integer"},
+        "estimate": {"type": "number"},
+        "types": {"$ref": "#/definitions/counts"},
+        "languages": {
+          "type": "object",
+          "additionalProperties": {"$ref": "#/definitions/languageStats"}
+        },
+        "vendored": {"type": "integer"},
+        "cost": {"type": "number", "description": "estimate in currency of the cost config"},
+        "currency": {"type": "string"}
+      }
+    }
+  }
+}
+`
+
+// checkSchemaVersion fails for reports written by a newer version
+func checkSchemaVersion(report *result) error {
+	if report.SchemaVersion > reportSchemaVersion {
+		return fmt.Errorf("Unsupported report schema version %v (max %v)",
+			report.SchemaVersion, reportSchemaVersion)
+	}
+	return nil
+}
+
+// runSchema implements "scorpion schema"
+func runSchema(args []string) error {
+	_, err := fmt.Print(reportSchema)
+	return err
+}
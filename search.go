@@ -0,0 +1,218 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+const (
+	defaultSearchLimit = 10
+	// bm25K1 and bm25B are the usual term frequency saturation and
+	// length normalization of BM25
+	bm25K1 = 1.2
+	bm25B  = 0.75
+	// titleBoost counts title terms more than body terms
+	titleBoost = 2
+	// fuzzyPenalty scales scores of terms matched with a typo, terms
+	// shorter than fuzzyMinLength have to match exactly
+	fuzzyPenalty   = 0.5
+	fuzzyMinLength = 5
+)
+
+var (
+	errNoQuery = errors.New("Query is empty, use q")
+)
+
+// textTerms returns lowercase words of the text
+func textTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchIndex ranks comments by BM25 of their titles and bodies
+type searchIndex struct {
+	comments []*ToDoComment
+	// postings are term frequencies of the terms by comment index
+	postings map[string]map[int]float64
+	lengths  []float64
+	average  float64
+}
+
+func newSearchIndex(comments []*ToDoComment) *searchIndex {
+	idx := &searchIndex{
+		comments: comments,
+		postings: make(map[string]map[int]float64),
+		lengths:  make([]float64, len(comments)),
+	}
+	total := 0.0
+	for i, c := range comments {
+		add := func(terms []string, weight float64) {
+			for _, t := range terms {
+				if idx.postings[t] == nil {
+					idx.postings[t] = make(map[int]float64)
+				}
+				idx.postings[t][i] += weight
+				idx.lengths[i] += weight
+			}
+		}
+		add(textTerms(c.Title), titleBoost)
+		add(textTerms(c.Body), 1)
+		total += idx.lengths[i]
+	}
+	if len(comments) > 0 {
+		idx.average = total / float64(len(comments))
+	}
+	return idx
+}
+
+// withinOneEdit checks if the words differ by at most one inserted,
+// removed or replaced rune
+func withinOneEdit(a, b string) bool {
+	ra, rb := []rune(a), []rune(b)
+	if len(ra) < len(rb) {
+		ra, rb = rb, ra
+	}
+	if len(ra)-len(rb) > 1 {
+		return false
+	}
+	i := 0
+	for i < len(rb) && ra[i] == rb[i] {
+		i++
+	}
+	if i == len(rb) {
+		return true
+	}
+	if len(ra) == len(rb) {
+		return string(ra[i+1:]) == string(rb[i+1:])
+	}
+	return string(ra[i+1:]) == string(rb[i:])
+}
+
+// matches returns index terms of the query term with their weight,
+// the term itself and terms it extends or has a typo in
+func (idx *searchIndex) matches(term string) map[string]float64 {
+	matched := make(map[string]float64)
+	if _, ok := idx.postings[term]; ok {
+		matched[term] = 1
+	}
+	if len([]rune(term)) < fuzzyMinLength {
+		return matched
+	}
+	for t := range idx.postings {
+		if t != term && (strings.HasPrefix(t, term) || withinOneEdit(t, term)) {
+			matched[t] = fuzzyPenalty
+		}
+	}
+	return matched
+}
+
+// SearchResult is a comment matching the query
+type SearchResult struct {
+	Score   float64      `json:"score"`
+	Comment *ToDoComment `json:"comment"`
+}
+
+// Search returns comments matching any term of the query ordered by
+// score, filtered by the filter if it is not nil
+func (idx *searchIndex) Search(query string, filter *CommentFilter) []*SearchResult {
+	scores := make(map[int]float64)
+	n := float64(len(idx.comments))
+	for _, term := range textTerms(query) {
+		for t, weight := range idx.matches(term) {
+			postings := idx.postings[t]
+			df := float64(len(postings))
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			for i, tf := range postings {
+				norm := tf + bm25K1*(1-bm25B+bm25B*idx.lengths[i]/idx.average)
+				scores[i] += weight * idf * tf * (bm25K1 + 1) / norm
+			}
+		}
+	}
+	now := time.Now()
+	results := make([]*SearchResult, 0, len(scores))
+	for i, score := range scores {
+		c := idx.comments[i]
+		if filter != nil && len(filter.Apply([]*ToDoComment{c}, now)) == 0 {
+			continue
+		}
+		results = append(results, &SearchResult{Score: math.Round(score*1000) / 1000, Comment: c})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		a, b := results[i].Comment, results[j].Comment
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	return results
+}
+
+// searchPage is a response of the search API
+type searchPage struct {
+	Total   int             `json:"total"`
+	Results []*SearchResult `json:"results"`
+}
+
+// searchCache keeps the index of the served report generation
+type searchCache struct {
+	mu         sync.Mutex
+	generation int64
+	index      *searchIndex
+}
+
+func (sc *searchCache) get(report *result, generation int64) *searchIndex {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.index == nil || sc.generation != generation {
+		var comments []*ToDoComment
+		if report != nil {
+			comments = report.Comments
+		}
+		sc.index = newSearchIndex(comments)
+		sc.generation = generation
+	}
+	return sc.index
+}
+
+func (s *Server) handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	query := q.Get("q")
+	if len(textTerms(query)) == 0 {
+		writeError(w, http.StatusBadRequest, errNoQuery)
+		return
+	}
+	filter, err := parseFilterQuery(q)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit := defaultSearchLimit
+	if v := q.Get("limit"); len(v) > 0 {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid limit: %v", v))
+			return
+		}
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+	report, generation := s.snapshot()
+	results := s.search.get(report, generation).Search(query, filter)
+	page := &searchPage{Total: len(results), Results: results}
+	if len(results) > limit {
+		page.Results = results[:limit]
+	}
+	writeJSON(w, http.StatusOK, page)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"unicode"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+const (
+	secretsKeychainService = "scorpion"
+	secretsMasterKeyEnv    = "SCORPION_MASTER_KEY"
+	encryptedSecretPrefix  = "enc:"
+	// secretSaltSize is the size of the random salt stored in front of
+	// the nonce of encrypted secrets
+	secretSaltSize = 16
+	// scrypt parameters recommended for interactive logins
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32
+)
+
+var (
+	errSecretNotFound     = errors.New("Secret not found")
+	errSecretInvalid      = errors.New("Secret contains whitespace or control characters")
+	errNoMasterKey        = errors.New("SCORPION_MASTER_KEY is not set, cannot decrypt config secrets")
+	errCannotDecrypt      = errors.New("Cannot decrypt secret (wrong SCORPION_MASTER_KEY?)")
+	errKeychainNotSupport = errors.New("Keychain is not supported on this platform")
+)
+
+// SecretProvider is a source of integration credentials
+type SecretProvider interface {
+	// Name is used in error messages
+	Name() string
+	// Lookup returns secret value for the key and whether it was found
+	Lookup(key string) (string, bool, error)
+	// Hint describes how to store the secret in this provider
+	Hint(key string) string
+}
+
+// Secrets looks up credentials in a chain of providers
+type Secrets struct {
+	providers []SecretProvider
+}
+
+// NewSecrets creates the default chain: environment, keychain, config
+func NewSecrets(config *Config) *Secrets {
+	return &Secrets{
+		providers: []SecretProvider{
+			&envSecretProvider{},
+			&keychainSecretProvider{},
+			&configSecretProvider{secrets: config.Secrets},
+		},
+	}
+}
+
+// Get returns validated secret for the key (e.g. "github")
+// from the first provider that has it
+func (s *Secrets) Get(key string) (string, error) {
+	for _, p := range s.providers {
+		value, ok, err := p.Lookup(key)
+		if err != nil {
+			return "", fmt.Errorf("%v secret %v: %v", p.Name(), key, err)
+		}
+		if !ok {
+			continue
+		}
+		if err := validateSecret(value); err != nil {
+			return "", fmt.Errorf("%v secret %v: %v", p.Name(), key, err)
+		}
+		return value, nil
+	}
+	hints := make([]string, 0, len(s.providers))
+	for _, p := range s.providers {
+		hints = append(hints, p.Hint(key))
+	}
+	return "", fmt.Errorf("%v: %v. Provide it by one of: %v",
+		errSecretNotFound, key, strings.Join(hints, "; "))
+}
+
+func validateSecret(value string) error {
+	if len(value) == 0 {
+		return errSecretNotFound
+	}
+	for _, r := range value {
+		if unicode.IsSpace(r) || unicode.IsControl(r) {
+			return errSecretInvalid
+		}
+	}
+	return nil
+}
+
+// secretEnvNames returns variables checked for the key,
+// e.g. SCORPION_GITHUB_TOKEN and GITHUB_TOKEN
+func secretEnvNames(key string) []string {
+	name := strings.ToUpper(strings.Replace(key, "-", "_", -1))
+	return []string{"SCORPION_" + name + "_TOKEN", name + "_TOKEN"}
+}
+
+type envSecretProvider struct{}
+
+func (p *envSecretProvider) Name() string { return "environment" }
+
+func (p *envSecretProvider) Lookup(key string) (string, bool, error) {
+	for _, name := range secretEnvNames(key) {
+		if v, ok := os.LookupEnv(name); ok {
+			return strings.TrimSpace(v), true, nil
+		}
+	}
+	return "", false, nil
+}
+
+func (p *envSecretProvider) Hint(key string) string {
+	return "set " + strings.Join(secretEnvNames(key), " or ")
+}
+
+// keychainSecretProvider uses macOS keychain or libsecret
+// via their command line tools
+type keychainSecretProvider struct{}
+
+func (p *keychainSecretProvider) Name() string { return "keychain" }
+
+func (p *keychainSecretProvider) command(key string) (*exec.Cmd, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("security", "find-generic-password",
+			"-s", secretsKeychainService, "-a", key, "-w"), nil
+	case "linux", "freebsd", "openbsd":
+		return exec.Command("secret-tool", "lookup",
+			"service", secretsKeychainService, "account", key), nil
+	}
+	return nil, errKeychainNotSupport
+}
+
+func (p *keychainSecretProvider) Lookup(key string) (string, bool, error) {
+	cmd, err := p.command(key)
+	if err != nil {
+		return "", false, nil
+	}
+	if _, err := exec.LookPath(cmd.Path); err != nil {
+		return "", false, nil
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		// both tools fail with non-zero exit code when item is missing
+		return "", false, nil
+	}
+	value := strings.TrimSpace(string(out))
+	return value, len(value) > 0, nil
+}
+
+func (p *keychainSecretProvider) Hint(key string) string {
+	switch runtime.GOOS {
+	case "darwin":
+		return fmt.Sprintf("run `security add-generic-password -s %v -a %v -w`", secretsKeychainService, key)
+	case "linux", "freebsd", "openbsd":
+		return fmt.Sprintf("run `secret-tool store --label=%v service %v account %v`",
+			secretsKeychainService, secretsKeychainService, key)
+	}
+	return "keychain is not supported on " + runtime.GOOS
+}
+
+// configSecretProvider reads secrets section of the config file,
+// values prefixed with "enc:" are decrypted with SCORPION_MASTER_KEY
+type configSecretProvider struct {
+	secrets map[string]string
+}
+
+func (p *configSecretProvider) Name() string { return "config" }
+
+func (p *configSecretProvider) Lookup(key string) (string, bool, error) {
+	value, ok := p.secrets[key]
+	if !ok {
+		return "", false, nil
+	}
+	if !strings.HasPrefix(value, encryptedSecretPrefix) {
+		return value, true, nil
+	}
+	decrypted, err := decryptSecret(strings.TrimPrefix(value, encryptedSecretPrefix))
+	if err != nil {
+		return "", false, err
+	}
+	return decrypted, true, nil
+}
+
+func (p *configSecretProvider) Hint(key string) string {
+	return fmt.Sprintf("add secrets.%v to %v (encrypt with `scorpion secret encrypt`)", key, defaultConfigName)
+}
+
+// masterKeyCipher derives the key from SCORPION_MASTER_KEY and the salt
+// with scrypt
+func masterKeyCipher(salt []byte) (cipher.AEAD, error) {
+	masterKey := os.Getenv(secretsMasterKeyEnv)
+	if len(masterKey) == 0 {
+		return nil, errNoMasterKey
+	}
+	key, err := scrypt.Key([]byte(masterKey), salt, scryptN, scryptR, scryptP, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptSecret encrypts value with AES-GCM using SCORPION_MASTER_KEY,
+// the output is base64 of salt, nonce and ciphertext
+func encryptSecret(value string) (string, error) {
+	salt := make([]byte, secretSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
+	}
+	gcm, err := masterKeyCipher(salt)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(append(salt, nonce...), nonce, []byte(value), nil)
+	return encryptedSecretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decryptSecret(value string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(data) < secretSaltSize {
+		return "", errCannotDecrypt
+	}
+	salt, data := data[:secretSaltSize], data[secretSaltSize:]
+	gcm, err := masterKeyCipher(salt)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < gcm.NonceSize() {
+		return "", errCannotDecrypt
+	}
+	nonce, sealed := data[:gcm.NonceSize()], data[gcm.NonceSize():]
+	plain, err := gcm.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", errCannotDecrypt
+	}
+	return string(plain), nil
+}
+
+// readSecret prompts for the secret without echo on a terminal and
+// reads it from stdin otherwise, so it does not end up in the shell
+// history or the process list
+func readSecret() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if terminal.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, "Secret: ")
+		value, err := terminal.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		return string(value), nil
+	}
+	value, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(value), "\r\n"), nil
+}
+
+// runSecretCommand implements "scorpion secret encrypt", which reads
+// the value from stdin, and "scorpion secret check <key>"
+func runSecretCommand(args []string) error {
+	usage := errors.New("Usage: scorpion secret encrypt < value | scorpion secret check <key>")
+	if len(args) == 0 {
+		return usage
+	}
+	switch args[0] {
+	case "encrypt":
+		if len(args) != 1 {
+			return usage
+		}
+		value, err := readSecret()
+		if err != nil {
+			return err
+		}
+		if err := validateSecret(value); err != nil {
+			return err
+		}
+		encrypted, err := encryptSecret(value)
+		if err != nil {
+			return err
+		}
+		fmt.Println(encrypted)
+		return nil
+	case "check":
+		if len(args) != 2 {
+			return usage
+		}
+		config, err := loadConfig(configPathFlag, srcRootFlag)
+		if err != nil {
+			return err
+		}
+		if _, err := NewSecrets(config).Get(args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("Secret %v is available\n", args[1])
+		return nil
+	}
+	return fmt.Errorf("Unknown secret command: %v", args[0])
+}
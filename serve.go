@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
+var (
+	errMethodNotAllowed = errors.New("Use POST")
+)
+
+// Server exposes the latest scan result over REST API
+type Server struct {
+	config *Config
+	// auth is nil if authentication is not configured
+	auth    *authenticator
+	watcher *configWatcher
+	// hooks processes webhook deliveries one by one
+	hooks      sync.Mutex
+	mux        sync.RWMutex
+	report     *result
+	generation int64
+	broker     *Broker
+	search     searchCache
+}
+
+// NewServer creates server for the configuration
+func NewServer(config *Config) *Server {
+	return &Server{
+		config: config,
+		broker: NewBroker(),
+	}
+}
+
+// Rescan runs a new scan, replaces served report and notifies
+// subscribers about added and removed comments
+func (s *Server) Rescan() error {
+	s.mux.RLock()
+	config := s.config
+	s.mux.RUnlock()
+	trace := startTrace(appName + " rescan")
+	report, err := scan(config, NewEnvironment(srcRootFlag))
+	trace.finish(err)
+	flushTelemetry()
+	if err != nil {
+		return err
+	}
+	s.update(func(*result) *result { return report })
+	return nil
+}
+
+// RescanPaths scans only the paths relative to the source root,
+// comments in them are replaced and the rest of the report is kept.
+// Comments of paths that no longer exist are removed
+func (s *Server) RescanPaths(paths []string) error {
+	s.mux.RLock()
+	config, current := s.config, s.report
+	s.mux.RUnlock()
+	if len(paths) == 0 || current == nil {
+		return s.Rescan()
+	}
+	env := NewEnvironment(srcRootFlag)
+	// the generator root is absolute, like paths of resolveScanPaths
+	root, err := filepath.Abs(srcRootFlag)
+	if err != nil {
+		return err
+	}
+	var existing []string
+	for _, p := range paths {
+		file := filepath.Join(root, filepath.FromSlash(p))
+		// files of scanned trees are not checked out
+		if _, err := os.Stat(file); err == nil || env.ScansTree() {
+			existing = append(existing, file)
+		}
+	}
+	trace := startTrace(appName + " rescan")
+	trace.set("scorpion.paths", len(paths))
+	scanned := &result{}
+	if len(existing) > 0 {
+		scanned, err = generatePathsReport(config, env, existing)
+	}
+	trace.finish(err)
+	flushTelemetry()
+	if err != nil {
+		return err
+	}
+	s.update(func(previous *result) *result {
+		report := *previous
+		report.Comments = make([]*ToDoComment, 0, len(previous.Comments))
+		for _, c := range previous.Comments {
+			if !inScope(c.File, paths) {
+				report.Comments = append(report.Comments, c)
+			}
+		}
+		report.Comments = append(report.Comments, scanned.Comments...)
+		// files of languages are counted by full scans only
+		report.Stats = refreshStats(&report)
+		report.Partial = previous.Partial || scanned.Partial
+		report.FileErrors = make([]*FileError, 0, len(previous.FileErrors)+len(scanned.FileErrors))
+		for _, fe := range previous.FileErrors {
+			if !inScope(fe.Path, paths) {
+				report.FileErrors = append(report.FileErrors, fe)
+			}
+		}
+		report.FileErrors = append(report.FileErrors, scanned.FileErrors...)
+		report.CappedFiles = make([]*CappedFile, 0, len(previous.CappedFiles)+len(scanned.CappedFiles))
+		for _, cf := range previous.CappedFiles {
+			if !inScope(cf.Path, paths) {
+				report.CappedFiles = append(report.CappedFiles, cf)
+			}
+		}
+		report.CappedFiles = append(report.CappedFiles, scanned.CappedFiles...)
+		return &report
+	})
+	return nil
+}
+
+// update replaces served report with the one merge returns for the
+// current report (nil before the first scan) and notifies subscribers
+func (s *Server) update(merge func(previous *result) *result) {
+	s.mux.Lock()
+	var before []*ToDoComment
+	if s.report != nil {
+		before = s.report.Comments
+	}
+	report := merge(s.report)
+	s.report = report
+	s.generation = time.Now().UnixNano()
+	s.mux.Unlock()
+
+	events := diffComments(before, report.Comments)
+	events = append(events, &ScanEvent{Kind: eventScan, Total: len(report.Comments)})
+	s.broker.Publish(events)
+}
+
+// inScope checks if the file is one of the paths or inside of them
+func inScope(file string, paths []string) bool {
+	for _, p := range paths {
+		if file == p || strings.HasPrefix(file, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Reload rescans with the config, the previous config is restored
+// if the scan fails
+func (s *Server) Reload(config *Config) error {
+	auth, err := newAuthenticator(&config.Serve.Auth, NewSecrets(config))
+	if err != nil {
+		return err
+	}
+	s.mux.Lock()
+	previous, previousAuth := s.config, s.auth
+	s.config, s.auth = config, auth
+	s.mux.Unlock()
+	if err := s.Rescan(); err != nil {
+		s.mux.Lock()
+		s.config, s.auth = previous, previousAuth
+		s.mux.Unlock()
+		return err
+	}
+	return nil
+}
+
+// snapshot returns current report and its generation
+func (s *Server) snapshot() (*result, int64) {
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+	return s.report, s.generation
+}
+
+// Handler returns http handler with all API routes
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/report", s.authorize(roleRead, s.handleReport))
+	mux.HandleFunc("/api/comments", s.authorize(roleRead, s.handleComments))
+	mux.HandleFunc("/api/search", s.authorize(roleRead, s.handleSearch))
+	mux.HandleFunc("/api/events", s.authorize(roleRead, s.broker.ServeHTTP))
+	mux.HandleFunc("/api/rescan", s.authorize(roleAdmin, s.handleRescan))
+	mux.HandleFunc("/api/reload", s.authorize(roleAdmin, s.handleReload))
+	for _, p := range webhookProviders {
+		// deliveries are verified with secrets of the webhooks
+		mux.HandleFunc("/webhooks/"+p.name, s.handleWebhook(p))
+	}
+	return mux
+}
+
+// identify returns the client of the authorization header if it has
+// the role, every client is an admin without configured authentication
+func (s *Server) identify(authorization, role string) (*identity, error) {
+	s.mux.RLock()
+	auth := s.auth
+	s.mux.RUnlock()
+	if auth == nil {
+		return &identity{name: "anonymous", role: roleAdmin}, nil
+	}
+	id, err := auth.authenticate(authorization)
+	if err != nil {
+		if err != errUnauthenticated && err != errInvalidToken {
+			log.Printf("Authentication failed: %v", err)
+		}
+		return nil, errUnauthenticated
+	}
+	if !id.allows(role) {
+		return nil, errForbidden
+	}
+	return id, nil
+}
+
+// authorize calls the handler for clients with the role, admin
+// requests are logged with the name of the client
+func (s *Server) authorize(role string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := s.identify(r.Header.Get("Authorization"), role)
+		if err == errForbidden {
+			writeError(w, http.StatusForbidden, err)
+			return
+		}
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+appName+`"`)
+			writeError(w, http.StatusUnauthorized, err)
+			return
+		}
+		if role == roleAdmin {
+			log.Printf("%v %v by %v", r.Method, r.URL.Path, id.name)
+		}
+		h(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (s *Server) handleReport(w http.ResponseWriter, r *http.Request) {
+	report, _ := s.snapshot()
+	writeJSON(w, http.StatusOK, report)
+}
+
+// commentsPage is a response of the comments API
+type commentsPage struct {
+	Total      int            `json:"total"`
+	Comments   []*ToDoComment `json:"comments"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
+
+// cursor points to the offset in the sorted and filtered comments
+// of the particular report generation
+func encodeCursor(generation int64, offset int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%v:%v", generation, offset)))
+}
+
+func decodeCursor(cursor string) (generation int64, offset int, err error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, 0, err
+	}
+	parts := strings.SplitN(string(data), ":", 2)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Invalid cursor")
+	}
+	if generation, err = strconv.ParseInt(parts[0], 10, 64); err != nil {
+		return 0, 0, err
+	}
+	if offset, err = strconv.Atoi(parts[1]); err != nil {
+		return 0, 0, err
+	}
+	return generation, offset, nil
+}
+
+// listComments returns a page of filtered and sorted comments,
+// status is http status code for errors
+func (s *Server) listComments(filter *CommentFilter, sortFields string, limit int, cursor string) (*commentsPage, int, error) {
+	if limit <= 0 {
+		limit = defaultPageSize
+	}
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	report, generation := s.snapshot()
+	offset := 0
+	if len(cursor) > 0 {
+		g, o, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, http.StatusBadRequest, fmt.Errorf("Invalid cursor: %v", cursor)
+		}
+		if g != generation {
+			return nil, http.StatusGone, fmt.Errorf("Report was rescanned, restart pagination")
+		}
+		offset = o
+	}
+
+	comments := filter.Apply(report.Comments, time.Now())
+	if err := sortComments(comments, sortFields); err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	page := &commentsPage{Total: len(comments)}
+	if offset > len(comments) {
+		offset = len(comments)
+	}
+	end := offset + limit
+	if end < len(comments) {
+		page.NextCursor = encodeCursor(generation, end)
+	} else {
+		end = len(comments)
+	}
+	page.Comments = comments[offset:end]
+	return page, http.StatusOK, nil
+}
+
+func (s *Server) handleComments(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter, err := parseFilterQuery(q)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit := defaultPageSize
+	if v := q.Get("limit"); len(v) > 0 {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid limit: %v", v))
+			return
+		}
+	}
+	page, status, err := s.listComments(filter, q.Get("sort"), limit, q.Get("cursor"))
+	if err != nil {
+		writeError(w, status, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+// rescanRequest limits a rescan to a directory or file and a list
+// of files, e.g. changed by a push. Without them everything is scanned
+type rescanRequest struct {
+	Prefix string   `json:"prefix"`
+	Files  []string `json:"files"`
+}
+
+// paths returns clean slash separated paths relative to the root,
+// none if the whole root has to be scanned
+func (req *rescanRequest) paths() ([]string, error) {
+	var paths []string
+	for _, p := range append([]string{req.Prefix}, req.Files...) {
+		if len(p) == 0 {
+			continue
+		}
+		clean := path.Clean(filepath.ToSlash(p))
+		if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+			return nil, fmt.Errorf("Path %v is outside of the root", p)
+		}
+		if clean == "." {
+			return nil, nil
+		}
+		paths = append(paths, clean)
+	}
+	return paths, nil
+}
+
+func (s *Server) handleRescan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+	req := &rescanRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil && err != io.EOF {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid request: %v", err))
+		return
+	}
+	paths, err := req.paths()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := s.RescanPaths(paths); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	report, _ := s.snapshot()
+	writeJSON(w, http.StatusOK, map[string]int{"total": len(report.Comments)})
+}
+
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+		return
+	}
+	if err := s.watcher.Apply(s.Reload); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+	report, _ := s.snapshot()
+	writeJSON(w, http.StatusOK, map[string]int{"total": len(report.Comments)})
+}
+
+// isLoopbackAddr checks if the listen address is only reachable locally
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// runServe implements "scorpion serve"
+func runServe(args []string) error {
+	config, err := loadConfig(configPathFlag, srcRootFlag)
+	if err != nil {
+		return err
+	}
+	server := NewServer(config)
+	if server.auth, err = newAuthenticator(&config.Serve.Auth, NewSecrets(config)); err != nil {
+		return err
+	}
+	if server.auth == nil && !isLoopbackAddr(serveAddrFlag) {
+		log.Printf("API on %v is not authenticated, configure serve.auth", serveAddrFlag)
+	}
+	if err := server.Rescan(); err != nil {
+		return err
+	}
+	server.watcher = newConfigWatcher(configPathFlag, srcRootFlag)
+	go server.watcher.Run(stopping, watchIntervalFlag, server.Reload)
+	if watchFlag {
+		watcher := NewWatcher(srcRootFlag, watchIntervalFlag, logPathFlag)
+		go watcher.Run(stopping, func() {
+			if err := server.Rescan(); err != nil {
+				log.Printf("Rescan failed: %v", err)
+			}
+		})
+	}
+	if len(grpcAddrFlag) > 0 {
+		go func() {
+			if err := serveGRPC(server, grpcAddrFlag); err != nil {
+				log.Printf("gRPC server failed: %v", err)
+			}
+		}()
+	}
+	httpServer := &http.Server{Addr: serveAddrFlag, Handler: server.Handler()}
+	go func() {
+		<-stopping
+		// event streams are closed after the timeout
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := httpServer.Shutdown(ctx); err != nil {
+			httpServer.Close()
+		}
+	}()
+	log.Printf("Serving API on %v", serveAddrFlag)
+	if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	log.Printf("API server stopped")
+	return nil
+}
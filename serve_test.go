@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRescanPathsRelativeRoot checks that rescanned comments replace
+// the ones of the full scan when the root is relative
+func TestRescanPathsRelativeRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scorpion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "repo")
+	write := func(name, content string) {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	write("a.go", "// TODO: handle errors of the first call here\n")
+	write("b.go", "// TODO: handle errors of the second call here\n")
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	savedRoot, savedNoCache, savedNoGit := srcRootFlag, noCacheFlag, noGitFlag
+	srcRootFlag, noCacheFlag, noGitFlag = "repo", true, true
+	setScanFlags(t, 1, 1)
+	explainSkipsFlag = ""
+	defer func() {
+		srcRootFlag, noCacheFlag, noGitFlag = savedRoot, savedNoCache, savedNoGit
+		os.Chdir(wd)
+	}()
+
+	s := NewServer(NewConfig())
+	if err := s.Rescan(); err != nil {
+		t.Fatal(err)
+	}
+	write("a.go", "// TODO: handle errors of the first call here\n// FIXME: and of the third call of the file\n")
+	if err := s.RescanPaths([]string{"a.go"}); err != nil {
+		t.Fatal(err)
+	}
+	report, _ := s.snapshot()
+	files := make(map[string]int)
+	for _, c := range report.Comments {
+		files[c.File]++
+	}
+	if len(report.Comments) != 3 || files["a.go"] != 2 || files["b.go"] != 1 {
+		t.Fatalf("Rescanned report has comments of %v, expected 2 of a.go and 1 of b.go", files)
+	}
+}
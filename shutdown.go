@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// exitInterrupted is the exit code of interrupted commands, as
+	// of shells for SIGINT
+	exitInterrupted = 130
+	// shutdownTimeout is how long serve mode waits for requests
+	shutdownTimeout = 10 * time.Second
+)
+
+var (
+	errInterrupted = errors.New("Interrupted")
+	stopOnce       sync.Once
+	// stopping is closed on the first SIGINT or SIGTERM
+	stopping = make(chan struct{})
+)
+
+// handleSignals stops scans on the first SIGINT or SIGTERM, they end
+// with comments found so far and the report is marked partial. The
+// second signal exits immediately
+func handleSignals() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("Received %v, stopping (repeat to exit immediately)", sig)
+		stopOnce.Do(func() { close(stopping) })
+		sig = <-signals
+		log.Printf("Received %v again, exiting", sig)
+		os.Exit(exitInterrupted)
+	}()
+}
+
+// stopRequested checks if scans should stop
+func stopRequested() bool {
+	select {
+	case <-stopping:
+		return true
+	default:
+		return false
+	}
+}
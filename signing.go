@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	signingHMAC    = "hmac"
+	signingCosign  = "cosign"
+	signingSecret  = "signing"
+	planSignFile   = "sign-file"
+	defaultCosign  = "cosign"
+	signatureExt   = ".sig"
+	certificateExt = ".pem"
+	// hmacPrefix tells signatures of the methods apart
+	hmacPrefix = "hmac-sha256:"
+)
+
+var (
+	errNoSigning       = errors.New("Signing is not configured, set method of the signing section")
+	errBadSignature    = errors.New("Signature does not match the report")
+	errNoReportArg     = errors.New("Usage: scorpion verify <report> [signature]")
+	errKeylessIdentity = errors.New("Keyless cosign signatures need identity and issuer of the signing section")
+	errNoProvenance    = errors.New("Report has no provenance")
+)
+
+// Provenance records how the report was produced
+type Provenance struct {
+	Tool        string    `json:"tool"`
+	Version     string    `json:"version"`
+	GeneratedAt time.Time `json:"generatedAt"`
+	// Builder is the CI job url or the host of the run
+	Builder string `json:"builder,omitempty"`
+	// Invocation are arguments of the command
+	Invocation []string `json:"invocation,omitempty"`
+	// ConfigDigest is the sha256 of the effective config
+	ConfigDigest string `json:"configDigest,omitempty"`
+}
+
+// provenanceBuilder returns url of the CI job, or the host name
+func provenanceBuilder() string {
+	if os.Getenv("GITHUB_ACTIONS") == "true" {
+		return fmt.Sprintf("%v/%v/actions/runs/%v",
+			os.Getenv("GITHUB_SERVER_URL"), os.Getenv("GITHUB_REPOSITORY"), os.Getenv("GITHUB_RUN_ID"))
+	}
+	if url := os.Getenv("CI_JOB_URL"); len(url) > 0 {
+		return url
+	}
+	host, _ := os.Hostname()
+	return host
+}
+
+func newProvenance(config *Config) *Provenance {
+	p := &Provenance{
+		Tool:        appName,
+		Version:     version,
+		GeneratedAt: time.Now().UTC().Truncate(time.Second),
+		Builder:     provenanceBuilder(),
+		Invocation:  os.Args[1:],
+	}
+	if data, err := json.Marshal(config); err == nil {
+		sum := sha256.Sum256(data)
+		p.ConfigDigest = "sha256:" + hex.EncodeToString(sum[:])
+	}
+	return p
+}
+
+// checkSigning validates the signing config
+func checkSigning(sc *SigningConfig) error {
+	switch sc.Method {
+	case "", signingHMAC:
+	case signingCosign:
+		bin := sc.Binary
+		if len(bin) == 0 {
+			bin = defaultCosign
+		}
+		if _, err := exec.LookPath(bin); err != nil {
+			return fmt.Errorf("Cannot sign reports: %v", err)
+		}
+	default:
+		return fmt.Errorf("Unknown signing method: %v, use hmac or cosign", sc.Method)
+	}
+	return nil
+}
+
+// signAction returns action signing the written report
+func signAction(sc *SigningConfig, path string) *PlanAction {
+	a := &PlanAction{Kind: planSignFile, Target: path, Summary: sc.Method}
+	if sc.Method == signingCosign {
+		a.Fields = map[string][]string{"key": {sc.Key}, "binary": {sc.Binary}}
+	}
+	return a
+}
+
+func hmacSignature(data []byte, key string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write(data)
+	return hmacPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+func cosignBinary(bin string) string {
+	if len(bin) == 0 {
+		return defaultCosign
+	}
+	return bin
+}
+
+// runCosign runs cosign with the arguments
+func runCosign(bin string, args ...string) error {
+	cmd := exec.Command(cosignBinary(bin), args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("cosign %v: %v: %v", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// applySignFile writes the signature of the file next to it, keyless
+// cosign signatures also get the certificate
+func applySignFile(p *Plan, a *PlanAction) error {
+	path := p.resolve(a.Target)
+	switch a.Summary {
+	case signingHMAC:
+		key, err := p.Secrets.Get(signingSecret)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path+signatureExt, []byte(hmacSignature(data, key)+"\n"), 0644)
+	case signingCosign:
+		args := []string{"sign-blob", "--yes", "--output-signature", path + signatureExt}
+		if key := a.Fields["key"]; len(key) > 0 && len(key[0]) > 0 {
+			args = append(args, "--key", key[0])
+		} else {
+			args = append(args, "--output-certificate", path+certificateExt)
+		}
+		var bin string
+		if b := a.Fields["binary"]; len(b) > 0 {
+			bin = b[0]
+		}
+		if err := runCosign(bin, append(args, path)...); err != nil {
+			return err
+		}
+		log.Printf("Signed %v", a.Target)
+		return nil
+	}
+	return fmt.Errorf("Unknown signing method: %v", a.Summary)
+}
+
+// verifyReport checks the signature of the report file, signature is
+// the file next to the report if empty
+func verifyReport(path, signature string, sc *SigningConfig, secrets *Secrets) error {
+	if len(signature) == 0 {
+		signature = path + signatureExt
+	}
+	switch sc.Method {
+	case signingHMAC:
+		key, err := secrets.Get(signingSecret)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		expected, err := ioutil.ReadFile(signature)
+		if err != nil {
+			return err
+		}
+		if !hmac.Equal([]byte(strings.TrimSpace(string(expected))), []byte(hmacSignature(data, key))) {
+			return fmt.Errorf("%v: %v", path, errBadSignature)
+		}
+		return nil
+	case signingCosign:
+		args := []string{"verify-blob", "--signature", signature}
+		if len(sc.Key) > 0 {
+			args = append(args, "--key", sc.Key)
+		} else {
+			if len(sc.Identity) == 0 || len(sc.Issuer) == 0 {
+				return errKeylessIdentity
+			}
+			args = append(args, "--certificate", strings.TrimSuffix(signature, signatureExt)+certificateExt,
+				"--certificate-identity", sc.Identity, "--certificate-oidc-issuer", sc.Issuer)
+		}
+		return runCosign(sc.Binary, append(args, path)...)
+	case "":
+		return errNoSigning
+	}
+	return fmt.Errorf("Unknown signing method: %v, use hmac or cosign", sc.Method)
+}
+
+// runVerify implements "scorpion verify <report> [signature]", it
+// fails unless the report was signed with the configured method and
+// prints its provenance
+func runVerify(args []string) error {
+	if len(args) == 0 || len(args) > 2 {
+		return errNoReportArg
+	}
+	config, err := loadConfig(configPathFlag, srcRootFlag)
+	if err != nil {
+		return err
+	}
+	signature := ""
+	if len(args) == 2 {
+		signature = args[1]
+	}
+	if err := verifyReport(args[0], signature, &config.Signing, NewSecrets(config)); err != nil {
+		return err
+	}
+	report, err := loadReport(args[0])
+	if err != nil {
+		return err
+	}
+	if report.Provenance == nil {
+		return errNoProvenance
+	}
+	p := report.Provenance
+	fmt.Printf("Verified %v: %v %v at %v by %v\n", args[0], p.Tool, p.Version, p.GeneratedAt.Format(time.RFC3339), p.Builder)
+	return nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+)
+
+const (
+	jsonSinkName     = "json"
+	markdownSinkName = "markdown"
+	githubSinkName   = "github"
+	slackSinkName    = "slack"
+	problemsSinkName = "problems"
+)
+
+// SinkConfig routes comments of some types to an output
+type SinkConfig struct {
+	// Sink is the name of the output (json, markdown, problems, github, slack, store, sqlite, kubernetes, upload, csv, bigquery, treemap, sarif)
+	Sink string `yaml:"sink"`
+	// Types of comments sent to the sink, all types if empty
+	Types []string `yaml:"types"`
+	// Path of the file for file outputs, directory for the store,
+	// database for sqlite, template of the object url for upload
+	Path string `yaml:"path"`
+	// Format of the uploaded report: json (default), markdown or html
+	Format string `yaml:"format"`
+	// Repo is "owner/name" for trackers, derived from the remote if empty
+	Repo string `yaml:"repo"`
+	// Labels added to created issues
+	Labels []string `yaml:"labels"`
+	// Fields map comment fields to fields of tracker issues
+	// (labels, assignees), see fieldMapping
+	Fields map[string][]string `yaml:"fields"`
+	// State is the file recording issues created for comments by
+	// trackers, .scorpion/issues.json in the root by default
+	State string `yaml:"state"`
+	// Object is "configmap/<name>" or "deployment/<name>" the
+	// kubernetes sink publishes the summary to
+	Object string `yaml:"object"`
+	// Namespace of the object, the one of the pod by default
+	Namespace string `yaml:"namespace"`
+	// Table is "project.dataset.table" the bigquery sink appends rows to
+	Table string `yaml:"table"`
+}
+
+// Sink is an output of the report, mutating sinks only add
+// actions to the plan so that all of them support --dry-run
+type Sink interface {
+	Emit(report *result, plan *Plan) error
+}
+
+type sinkFactory func(sc *SinkConfig, env *Environment) (Sink, error)
+
+var (
+	sinkFactories = map[string]sinkFactory{
+		jsonSinkName:       newJSONSink,
+		markdownSinkName:   newMarkdownSink,
+		githubSinkName:     newGitHubSink,
+		slackSinkName:      newSlackSink,
+		storeSinkName:      newStoreSink,
+		sqliteSinkName:     newSQLiteSink,
+		kubernetesSinkName: newKubernetesSink,
+		uploadSinkName:     newUploadSink,
+		csvSinkName:        newCSVSink,
+		bigquerySinkName:   newBigQuerySink,
+		problemsSinkName:   newProblemsSink,
+		treemapSinkName:    newTreemapSink,
+		sarifSinkName:      newSARIFSink,
+	}
+	// formatSinks are sinks of --format values used
+	// when the pipeline has no sinks configured
+	formatSinks = map[string]*SinkConfig{
+		jsonSinkName:     &SinkConfig{Sink: jsonSinkName},
+		markdownSinkName: &SinkConfig{Sink: markdownSinkName, Path: todoFilePath},
+		problemsSinkName: &SinkConfig{Sink: problemsSinkName},
+		treemapSinkName:  &SinkConfig{Sink: treemapSinkName},
+		sarifSinkName:    &SinkConfig{Sink: sarifSinkName},
+		csvSinkName:      &SinkConfig{Sink: csvSinkName},
+	}
+)
+
+// defaultSinks returns sinks of formats given with --format,
+// json to stdout and markdown to TODO.md by default. Formats may
+// have a path ("sarif:out.sarif") to write several files in one run
+func defaultSinks() ([]*SinkConfig, error) {
+	formats := formatFlag
+	if stdinFlag && !pflag.CommandLine.Changed("format") {
+		// markdown of a single buffer would overwrite TODO.md
+		formats = []string{jsonSinkName}
+	}
+	sinks := make([]*SinkConfig, 0, len(formats))
+	for _, f := range splitValues(formats) {
+		name, path := f, ""
+		if i := strings.Index(f, ":"); i > 0 {
+			name, path = f[:i], f[i+1:]
+		}
+		sc, ok := formatSinks[name]
+		if !ok {
+			return nil, fmt.Errorf("Unknown format: %v", name)
+		}
+		if len(path) > 0 {
+			copied := *sc
+			copied.Path = path
+			sc = &copied
+		}
+		sinks = append(sinks, sc)
+	}
+	return sinks, nil
+}
+
+// routeReport returns copy of the report with comments of the types only
+func routeReport(report *result, types []string) *result {
+	routed := *report
+	if len(types) == 0 {
+		return &routed
+	}
+	routed.Comments = make([]*ToDoComment, 0, len(report.Comments))
+	for _, c := range report.Comments {
+		if containsFold(types, c.Type) {
+			routed.Comments = append(routed.Comments, c)
+		}
+	}
+	return &routed
+}
+
+// planSinks adds actions of all sinks to the plan
+func planSinks(sinks []*SinkConfig, report *result, env *Environment, plan *Plan) error {
+	if len(sinks) == 0 {
+		var err error
+		if sinks, err = defaultSinks(); err != nil {
+			return err
+		}
+	}
+	for _, sc := range sinks {
+		factory, ok := sinkFactories[sc.Sink]
+		if !ok {
+			return fmt.Errorf("Unknown sink: %v", sc.Sink)
+		}
+		sink, err := factory(sc, env)
+		if err != nil {
+			return fmt.Errorf("Sink %v: %v", sc.Sink, err)
+		}
+		emission := startSpan("sink " + sc.Sink)
+		err = sink.Emit(routeReport(report, sc.Types), plan)
+		emission.finish(err)
+		if err != nil {
+			return fmt.Errorf("Sink %v: %v", sc.Sink, err)
+		}
+	}
+	return nil
+}
+
+type jsonSink struct {
+	path string
+}
+
+func newJSONSink(sc *SinkConfig, env *Environment) (Sink, error) {
+	return &jsonSink{path: sc.Path}, nil
+}
+
+func (s *jsonSink) Emit(report *result, plan *Plan) error {
+	var js []byte
+	var err error
+	// canonical reports are indented to be diffed line by line
+	if verboseFlag || canonicalFlag {
+		js, err = json.MarshalIndent(report, "", "  ")
+	} else {
+		js, err = json.Marshal(report)
+	}
+	if err != nil {
+		return err
+	}
+	if len(s.path) == 0 {
+		plan.Add(&PlanAction{Kind: planStdout, Target: "stdout", Summary: "json report", Content: string(js) + "\n"})
+		return nil
+	}
+	plan.Add(&PlanAction{Kind: planWriteFile, Target: s.path, Summary: "json report", Content: string(js)})
+	if report.signing != nil {
+		plan.Add(signAction(report.signing, s.path))
+	}
+	return nil
+}
+
+// problemsSink prints "file:line:col: TYPE: title" lines
+// understood by problem matchers of editors and CI systems
+type problemsSink struct {
+	path string
+}
+
+func newProblemsSink(sc *SinkConfig, env *Environment) (Sink, error) {
+	return &problemsSink{path: sc.Path}, nil
+}
+
+func (s *problemsSink) Emit(report *result, plan *Plan) error {
+	var sb strings.Builder
+	for _, c := range report.Comments {
+		// columns are not tracked, comments are reported at the line start
+		fmt.Fprintf(&sb, "%v:%v:1: %v: %v\n", c.File, c.Line+1, c.Type, c.Title)
+	}
+	if len(s.path) == 0 {
+		plan.Add(&PlanAction{Kind: planStdout, Target: "stdout", Summary: "problems", Content: sb.String()})
+		return nil
+	}
+	plan.Add(&PlanAction{Kind: planWriteFile, Target: s.path, Summary: "problems", Content: sb.String()})
+	return nil
+}
+
+type markdownSink struct {
+	path string
+}
+
+func newMarkdownSink(sc *SinkConfig, env *Environment) (Sink, error) {
+	path := sc.Path
+	if len(path) == 0 {
+		path = todoFilePath
+	}
+	return &markdownSink{path: path}, nil
+}
+
+func (s *markdownSink) Emit(report *result, plan *Plan) error {
+	content, err := renderTodoFile(*report)
+	if err != nil {
+		return err
+	}
+	plan.Add(&PlanAction{
+		Kind:    planWriteFile,
+		Target:  s.path,
+		Summary: "markdown report",
+		Content: string(content),
+	})
+	return nil
+}
+
+// commentLocation returns permalink of the comment if available
+// or its file and line otherwise
+func commentLocation(c *ToDoComment) string {
+	if c.Blame != nil && len(c.Blame.Permalink) > 0 {
+		return c.Blame.Permalink
+	}
+	return fmt.Sprintf("%v:%v", c.File, c.Line+1)
+}
+
+// issueBody returns description of the tracker issue for the comment
+func issueBody(c *ToDoComment) string {
+	var sb strings.Builder
+	if len(c.Body) > 0 {
+		sb.WriteString(c.Body)
+		sb.WriteString("\n\n")
+	}
+	fmt.Fprintf(&sb, "%v comment in %v", c.Type, commentLocation(c))
+	if len(c.Category) > 0 {
+		fmt.Fprintf(&sb, "\nCategory: %v", c.Category)
+	}
+	if c.Estimate >= estimateEpsilon {
+		fmt.Fprintf(&sb, "\nEstimate: %.1fh", c.Estimate)
+		if c.EstimateDefault {
+			fmt.Fprintf(&sb, " (default for %v)", c.Type)
+		}
+	}
+	keys := make([]string, 0, len(c.Metadata))
+	for k := range c.Metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&sb, "\n%v: %v", k, c.Metadata[k])
+	}
+	return sb.String()
+}
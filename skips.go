@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	skipFilter     = "filter"
+	skipGenerated  = "generated"
+	skipVendor     = "vendor"
+	skipBinary     = "binary"
+	skipUnreadable = "unreadable"
+	skipDepth      = "depth"
+	skipSpecial    = "special"
+	// binaryCheckSize is how many first bytes are checked for NUL
+	// to detect binary files, as git does
+	binaryCheckSize = 8000
+)
+
+// SkippedPath is a file or directory excluded from the scan, vendored
+// files are parsed but their comments are not reported
+type SkippedPath struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+	// Detail is the pattern, attribute or error causing the skip
+	Detail string `json:"detail,omitempty"`
+}
+
+// FileError is a file that could not be read, so that reports tell
+// missing comments apart from files without comments
+type FileError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// fileError records the file that could not be read, the scan goes on
+func (td *ToDoGenerator) fileError(path string, err error) {
+	log.Printf("Cannot read %v: %v", path, err)
+	countMetric("file_errors", "{file}", 1)
+	td.skip(path, skipUnreadable, err.Error())
+	relativePath, rerr := filepath.Rel(td.root, path)
+	if rerr != nil {
+		relativePath = path
+	}
+	td.commentMux.Lock()
+	defer td.commentMux.Unlock()
+	td.fileErrors = append(td.fileErrors, &FileError{Path: filepath.ToSlash(relativePath), Error: err.Error()})
+}
+
+// FileErrors returns files that could not be read sorted by path
+func (td *ToDoGenerator) FileErrors() []*FileError {
+	td.commentMux.Lock()
+	defer td.commentMux.Unlock()
+	errs := append([]*FileError{}, td.fileErrors...)
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Path < errs[j].Path
+	})
+	return errs
+}
+
+// CappedFile is a file with more comments than the limit, only the
+// first ones are reported
+type CappedFile struct {
+	Path     string `json:"path"`
+	Comments int    `json:"comments"`
+	Kept     int    `json:"kept"`
+}
+
+// capComments keeps at most the limit of comments of every file, the
+// comments are ordered by file and line
+func capComments(comments []*ToDoComment, limit int) ([]*ToDoComment, []*CappedFile) {
+	capped := make([]*CappedFile, 0)
+	if limit <= 0 {
+		return comments, capped
+	}
+	kept := make([]*ToDoComment, 0, len(comments))
+	var current *CappedFile
+	count := 0
+	for i, c := range comments {
+		if i == 0 || c.File != comments[i-1].File {
+			count = 0
+			current = nil
+		}
+		count++
+		if count <= limit {
+			kept = append(kept, c)
+			continue
+		}
+		if current == nil {
+			current = &CappedFile{Path: c.File, Kept: limit}
+			capped = append(capped, current)
+		}
+		current.Comments = count
+	}
+	for _, cf := range capped {
+		log.Printf("Keeping %v of %v comments in %v, raise limits.commentsPerFile for more", cf.Kept, cf.Comments, cf.Path)
+	}
+	return kept, capped
+}
+
+// specialFileKind returns kind of files that are not regular, reads of
+// FIFOs block forever and devices may never end, empty for regular files
+func specialFileKind(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeDevice != 0:
+		return "device"
+	case mode&os.ModeIrregular != 0:
+		return "irregular"
+	case mode.IsDir():
+		// symlinks to directories are not followed
+		return "directory"
+	}
+	return ""
+}
+
+// skipSpecialFile checks if the file is special and records the skip,
+// symlinks are checked by their targets
+func (td *ToDoGenerator) skipSpecialFile(path string, mode os.FileMode) bool {
+	if mode&os.ModeSymlink != 0 {
+		fi, err := os.Stat(path)
+		if err != nil {
+			// the read records the error of dangling symlinks
+			return false
+		}
+		mode = fi.Mode()
+	}
+	kind := specialFileKind(mode)
+	if len(kind) == 0 {
+		return false
+	}
+	td.commentMux.Lock()
+	td.specialCount++
+	td.commentMux.Unlock()
+	td.skip(path, skipSpecial, kind)
+	return true
+}
+
+// Special returns number of skipped special files
+func (td *ToDoGenerator) Special() int {
+	td.commentMux.Lock()
+	defer td.commentMux.Unlock()
+	return td.specialCount
+}
+
+// skip records the path excluded from the scan with --explain-skips
+func (td *ToDoGenerator) skip(path, reason, detail string) {
+	countMetric("skipped", "{file}", 1, "reason", reason)
+	if !td.explainSkips {
+		return
+	}
+	relativePath, err := filepath.Rel(td.root, path)
+	if err != nil {
+		relativePath = path
+	}
+	td.commentMux.Lock()
+	defer td.commentMux.Unlock()
+	td.skips = append(td.skips, &SkippedPath{
+		Path:   filepath.ToSlash(relativePath),
+		Reason: reason,
+		Detail: detail,
+	})
+}
+
+// Skips returns paths excluded from the scan sorted by path
+func (td *ToDoGenerator) Skips() []*SkippedPath {
+	td.commentMux.Lock()
+	defer td.commentMux.Unlock()
+	skips := append([]*SkippedPath{}, td.skips...)
+	sort.SliceStable(skips, func(i, j int) bool {
+		return skips[i].Path < skips[j].Path
+	})
+	return skips
+}
+
+// pruneDir checks if the walk should not descend into the directory,
+// which is git metadata, hidden, deeper than --max-depth below the
+// scanned path or a vendor directory not counted
+func (td *ToDoGenerator) pruneDir(start, dir string) bool {
+	name := filepath.Base(dir)
+	if name == ".git" {
+		return true
+	}
+	if dir != start && td.hidden.skips(name) {
+		td.skip(dir, skipHidden, "")
+		return true
+	}
+	if td.maxDepth >= 0 && pathDepth(start, dir) > td.maxDepth {
+		td.skip(dir, skipDepth, "")
+		return true
+	}
+	if td.pruneVendor && td.vendorDirs[name] {
+		td.skip(dir, skipVendor, "")
+		return true
+	}
+	return false
+}
+
+// prunedInTree checks the file of the commit tree against --max-depth
+// and uncounted vendor directories, trees have no directories to prune
+func (td *ToDoGenerator) prunedInTree(path string) bool {
+	dir := filepath.Dir(path)
+	if td.maxDepth >= 0 && pathDepth(td.scanPath(path), dir) > td.maxDepth {
+		td.skip(path, skipDepth, "")
+		return true
+	}
+	if td.pruneVendor {
+		relativeDir, err := filepath.Rel(td.root, dir)
+		if err != nil {
+			return false
+		}
+		for _, part := range strings.Split(filepath.ToSlash(relativeDir), "/") {
+			if td.vendorDirs[part] {
+				td.skip(path, skipVendor, "")
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pathDepth returns number of directories from start down to dir
+func pathDepth(start, dir string) int {
+	relativeDir, err := filepath.Rel(start, dir)
+	if err != nil || relativeDir == "." {
+		return 0
+	}
+	return strings.Count(filepath.ToSlash(relativeDir), "/") + 1
+}
+
+// isBinaryContent checks first bytes of the content for NUL
+func isBinaryContent(content []byte) bool {
+	if len(content) > binaryCheckSize {
+		content = content[:binaryCheckSize]
+	}
+	return bytes.IndexByte(content, 0) >= 0
+}
+
+// planSkips writes the skipped paths as json to the file or stdout
+func planSkips(skips []*SkippedPath, path string, plan *Plan) error {
+	if skips == nil {
+		skips = make([]*SkippedPath, 0)
+	}
+	js, err := json.MarshalIndent(skips, "", "  ")
+	if err != nil {
+		return err
+	}
+	a := &PlanAction{Kind: planWriteFile, Target: path, Summary: "skipped paths", Content: string(js) + "\n"}
+	if path == "-" {
+		a.Kind, a.Target = planStdout, "stdout"
+	}
+	plan.Add(a)
+	return nil
+}
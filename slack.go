@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	slackSecret = "slack-webhook"
+	// slackMaxItems limits the size of a single message
+	slackMaxItems = 20
+)
+
+type slackSink struct{}
+
+func newSlackSink(sc *SinkConfig, env *Environment) (Sink, error) {
+	return &slackSink{}, nil
+}
+
+// slackMessage returns text of the message summarizing comments
+func slackMessage(report *result) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "*%v*: %v comments", report.Project, len(report.Comments))
+	if len(report.Branch) > 0 {
+		fmt.Fprintf(&sb, " on `%v`", report.Branch)
+	}
+	sb.WriteString("\n")
+	for i, c := range report.Comments {
+		if i == slackMaxItems {
+			fmt.Fprintf(&sb, "…and %v more\n", len(report.Comments)-slackMaxItems)
+			break
+		}
+		fmt.Fprintf(&sb, "• %v: %v (%v)\n", c.Type, c.Title, commentLocation(c))
+	}
+	return sb.String()
+}
+
+func (s *slackSink) Emit(report *result, plan *Plan) error {
+	if len(report.Comments) == 0 {
+		return nil
+	}
+	plan.Add(&PlanAction{
+		Kind:    planNotify,
+		Target:  slackSinkName,
+		Summary: fmt.Sprintf("%v comments", len(report.Comments)),
+		Content: slackMessage(report),
+	})
+	return nil
+}
+
+// applySlackMessage posts the message to the incoming webhook
+func applySlackMessage(p *Plan, a *PlanAction) error {
+	webhook, err := p.Secrets.Get(slackSecret)
+	if err != nil {
+		return err
+	}
+	return doJSONRequest("POST", webhook, nil, map[string]string{"text": a.Content}, nil)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	sqliteSinkName = "sqlite"
+	planExecSQL    = "exec-sql"
+	defaultSQLite  = "sqlite3"
+	sqliteBinEnv   = "SCORPION_SQLITE"
+	// sqliteSchema is created in new databases, every scan keeps
+	// its full report besides the comments to be queried
+	sqliteSchema = `CREATE TABLE IF NOT EXISTS scans (
+  id INTEGER PRIMARY KEY,
+  scanned_at TEXT NOT NULL,
+  project TEXT NOT NULL,
+  root TEXT NOT NULL,
+  branch TEXT,
+  revision TEXT,
+  comments INTEGER NOT NULL,
+  estimate REAL NOT NULL,
+  report TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS comments (
+  scan_id INTEGER NOT NULL REFERENCES scans(id),
+  comment_id TEXT,
+  type TEXT NOT NULL,
+  title TEXT NOT NULL,
+  file TEXT NOT NULL,
+  line INTEGER NOT NULL,
+  issue INTEGER,
+  category TEXT,
+  assignee TEXT,
+  estimate REAL
+);
+CREATE INDEX IF NOT EXISTS comments_scan ON comments(scan_id);
+CREATE VIRTUAL TABLE IF NOT EXISTS comments_fts USING fts5(
+  title, body,
+  scan_id UNINDEXED, comment_id UNINDEXED, type UNINDEXED, file UNINDEXED, line UNINDEXED,
+  tokenize = 'porter unicode61'
+);
+INSERT INTO comments_fts
+  SELECT json_extract(c.value, '$.title'), json_extract(c.value, '$.body'), s.id, json_extract(c.value, '$.id'),
+    json_extract(c.value, '$.type'), json_extract(c.value, '$.file'), json_extract(c.value, '$.line') + 1
+  FROM scans s, json_each(s.report, '$.comments') c
+  WHERE NOT EXISTS (SELECT 1 FROM comments_fts);
+`
+)
+
+var (
+	defaultSQLiteDatabase = filepath.Join(".scorpion", "scans.db")
+)
+
+// sqliteSink stores scans in SQLite database with the sqlite3
+// binary, so that no driver has to be linked in
+type sqliteSink struct {
+	database string
+}
+
+func newSQLiteSink(sc *SinkConfig, env *Environment) (Sink, error) {
+	database := sc.Path
+	if len(database) == 0 {
+		database = defaultSQLiteDatabase
+	}
+	return &sqliteSink{database: database}, nil
+}
+
+// sqlQuote returns the string as SQL literal
+func sqlQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+// sqlNullable returns NULL for zero values of optional columns
+func sqlNullable(s string) string {
+	if len(s) == 0 || s == "0" {
+		return "NULL"
+	}
+	return s
+}
+
+// scanSQL returns statements inserting the scan and its comments
+// in a single transaction
+func scanSQL(report *result, now time.Time) (string, error) {
+	js, err := json.Marshal(report)
+	if err != nil {
+		return "", err
+	}
+	estimate := 0.0
+	for _, c := range report.Comments {
+		estimate += c.Estimate
+	}
+	var sb strings.Builder
+	sb.WriteString(sqliteSchema)
+	sb.WriteString("BEGIN;\n")
+	fmt.Fprintf(&sb, "INSERT INTO scans (scanned_at, project, root, branch, revision, comments, estimate, report) VALUES (%v, %v, %v, %v, %v, %v, %v, %v);\n",
+		sqlQuote(now.UTC().Format(time.RFC3339)), sqlQuote(report.Project), sqlQuote(report.Root),
+		sqlQuote(report.Branch), sqlQuote(report.Revision), len(report.Comments),
+		strconv.FormatFloat(estimate, 'f', -1, 64), sqlQuote(string(js)))
+	for _, c := range report.Comments {
+		fmt.Fprintf(&sb, "INSERT INTO comments VALUES ((SELECT max(id) FROM scans), %v, %v, %v, %v, %v, %v, %v, %v, %v);\n",
+			sqlQuote(c.ID), sqlQuote(c.Type), sqlQuote(c.Title), sqlQuote(c.File), c.Line+1,
+			sqlNullable(strconv.Itoa(c.Issue)), sqlQuote(c.Category), sqlQuote(c.Assignee),
+			sqlNullable(strconv.FormatFloat(c.Estimate, 'f', -1, 64)))
+		fmt.Fprintf(&sb, "INSERT INTO comments_fts VALUES (%v, %v, (SELECT max(id) FROM scans), %v, %v, %v, %v);\n",
+			sqlQuote(c.Title), sqlQuote(c.Body), sqlQuote(c.ID), sqlQuote(c.Type), sqlQuote(c.File), c.Line+1)
+	}
+	sb.WriteString("COMMIT;\n")
+	return sb.String(), nil
+}
+
+func (s *sqliteSink) Emit(report *result, plan *Plan) error {
+	script, err := scanSQL(report, time.Now())
+	if err != nil {
+		return err
+	}
+	plan.Add(&PlanAction{
+		Kind:    planExecSQL,
+		Target:  s.database,
+		Summary: fmt.Sprintf("store scan of %v comments", len(report.Comments)),
+		Content: script,
+	})
+	return nil
+}
+
+// sqliteDatabases returns existing databases of sqlite sinks and the
+// daemon, paths are relative to the root
+func sqliteDatabases(config *Config, root string) []string {
+	databases := make([]string, 0)
+	candidates := make([]string, 0)
+	for _, sc := range config.Pipeline.Sinks {
+		if sc.Sink == sqliteSinkName {
+			candidates = append(candidates, sc.Path)
+		}
+	}
+	if len(config.Daemon.Repositories) > 0 {
+		candidates = append(candidates, config.Daemon.Database)
+	}
+	seen := make(map[string]bool)
+	for _, path := range candidates {
+		if len(path) == 0 {
+			path = defaultSQLiteDatabase
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		if _, err := os.Stat(path); err == nil && !seen[path] {
+			seen[path] = true
+			databases = append(databases, path)
+		}
+	}
+	return databases
+}
+
+// applyExecSQL runs the script against the database with sqlite3,
+// SCORPION_SQLITE selects another binary
+func applyExecSQL(p *Plan, a *PlanAction) error {
+	path := p.resolve(a.Target)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	bin := os.Getenv(sqliteBinEnv)
+	if len(bin) == 0 {
+		bin = defaultSQLite
+	}
+	cmd := exec.Command(bin, "-bail", path)
+	cmd.Stdin = strings.NewReader(a.Content)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("sqlite3 %v: %v: %v", a.Target, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
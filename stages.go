@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// reads wait for the disk or network, so more of them run at once
+	defaultIOWorkersPerCPU = 4
+	// mmapMinSize is the size of files mapped with --mmap, smaller
+	// ones are cheaper to read
+	mmapMinSize = 1 << 20
+)
+
+var (
+	errMmapUnsupported = errors.New("File cannot be memory-mapped")
+)
+
+// fileContent is a file read by the I/O stage
+type fileContent struct {
+	path    string
+	content []byte
+	// release unmaps memory-mapped content after parsing
+	release func()
+}
+
+// readFile reads the file or, with --mmap, maps big files into
+// memory to avoid copying them, falling back to reading
+func readFile(path string) (*fileContent, error) {
+	if mmapFlag {
+		if fi, err := os.Stat(path); err == nil && fi.Size() >= mmapMinSize {
+			data, release, err := mmapFile(path)
+			if err == nil {
+				return &fileContent{path: path, content: data, release: release}, nil
+			}
+			log.Printf("Reading %v without mmap: %v", path, err)
+		}
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileContent{path: path, content: content}, nil
+}
+
+// isTransientReadError checks if another read of the file may succeed,
+// missing files and denied permissions do not change between retries
+func isTransientReadError(err error) bool {
+	return !os.IsNotExist(err) && !os.IsPermission(err)
+}
+
+// maxReadRetryDelay caps the doubled delay of retries, longer base
+// delays are not doubled
+const maxReadRetryDelay = time.Minute
+
+// retryDelay returns delay before the retry of the attempt, doubled
+// for every attempt with jitter so that retries of many files do not
+// hit the file server at once
+func retryDelay(base time.Duration, attempt int) time.Duration {
+	limit := maxReadRetryDelay
+	if base > limit {
+		limit = base
+	}
+	delay := base
+	for i := 0; i < attempt && delay > 0 && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	half := int64(delay / 2)
+	if half <= 0 {
+		return delay
+	}
+	return delay - time.Duration(half) + time.Duration(rand.Int63n(half+1))
+}
+
+// readFileRetrying reads the file retrying transient errors up to
+// --read-retries times, e.g. of NFS or SMB mounts
+func readFileRetrying(path string) (*fileContent, error) {
+	fc, err := readFile(path)
+	for attempt := 0; err != nil && attempt < readRetriesFlag && isTransientReadError(err); attempt++ {
+		delay := retryDelay(readRetryDelayFlag, attempt)
+		log.Printf("Retrying read of %v in %v: %v", path, delay, err)
+		countMetric("read_retries", "{retry}", 1)
+		time.Sleep(delay)
+		if stopRequested() {
+			break
+		}
+		fc, err = readFile(path)
+	}
+	return fc, err
+}
+
+// scanStages reads files and parses them in separate stages with
+// independent concurrency limits, since reads are bound by the
+// file system and parsing is bound by CPU
+type scanStages struct {
+	td       *ToDoGenerator
+	paths    chan string
+	contents chan *fileContent
+	limiter  *readLimiter
+	readers  sync.WaitGroup
+	parsers  sync.WaitGroup
+	// queued is the number of files queued by the walker
+	queued int64
+	// busy times of the stages in nanoseconds, reported in their spans
+	readBusy  int64
+	parseBusy int64
+	readSpan  *span
+	parseSpan *span
+}
+
+func workersOrDefault(workers, def int) int {
+	if workers > 0 {
+		return workers
+	}
+	return def
+}
+
+// startStages starts workers of both stages configured with
+// --io-workers and --cpu-workers (a single one each with --nice)
+// and reads limited by --read-limit
+func (td *ToDoGenerator) startStages() *scanStages {
+	cpus := runtime.NumCPU()
+	ioWorkers := workersOrDefault(ioWorkersFlag, concurrency(defaultIOWorkersPerCPU*cpus))
+	cpuWorkers := workersOrDefault(cpuWorkersFlag, concurrency(cpus))
+	log.Printf("Using %v I/O workers and %v CPU workers", ioWorkers, cpuWorkers)
+	s := &scanStages{
+		td:        td,
+		paths:     make(chan string, ioWorkers),
+		contents:  make(chan *fileContent, cpuWorkers),
+		limiter:   newReadLimiter(readLimitFlag),
+		readSpan:  startSpan("read"),
+		parseSpan: startSpan("parse"),
+	}
+	s.readSpan.set("scorpion.workers", ioWorkers)
+	s.parseSpan.set("scorpion.workers", cpuWorkers)
+	for i := 0; i < ioWorkers; i++ {
+		s.readers.Add(1)
+		go s.read()
+	}
+	for i := 0; i < cpuWorkers; i++ {
+		s.parsers.Add(1)
+		go s.parse()
+	}
+	return s
+}
+
+func (s *scanStages) read() {
+	defer s.readers.Done()
+	for path := range s.paths {
+		// queued files are drained without reading them
+		if stopRequested() {
+			continue
+		}
+		start := time.Now()
+		fc, err := readFileRetrying(path)
+		atomic.AddInt64(&s.readBusy, int64(time.Since(start)))
+		if err != nil {
+			s.td.fileError(path, err)
+			continue
+		}
+		s.limiter.wait(len(fc.content))
+		s.contents <- fc
+	}
+}
+
+func (s *scanStages) parse() {
+	defer s.parsers.Done()
+	for fc := range s.contents {
+		if stopRequested() {
+			if fc.release != nil {
+				fc.release()
+			}
+			continue
+		}
+		start := time.Now()
+		// parsed comments copy the text, so content can be released
+		s.td.parseContent(fc.path, fc.content)
+		atomic.AddInt64(&s.parseBusy, int64(time.Since(start)))
+		countMetric("files", "{file}", 1)
+		countMetric("bytes", "By", len(fc.content))
+		if fc.release != nil {
+			fc.release()
+		}
+	}
+}
+
+// Read queues the file for reading and parsing
+func (s *scanStages) Read(path string) {
+	atomic.AddInt64(&s.queued, 1)
+	s.paths <- path
+}
+
+// Queued returns number of files queued for parsing
+func (s *scanStages) Queued() int {
+	return int(atomic.LoadInt64(&s.queued))
+}
+
+// Parse queues content that was already read, e.g. from a git tree
+func (s *scanStages) Parse(path string, content []byte) {
+	atomic.AddInt64(&s.queued, 1)
+	s.limiter.wait(len(content))
+	s.contents <- &fileContent{path: path, content: content}
+}
+
+// Wait stops the stages after all queued files were parsed
+func (s *scanStages) Wait() {
+	close(s.paths)
+	s.readers.Wait()
+	s.readSpan.set("scorpion.busy_ms", atomic.LoadInt64(&s.readBusy)/int64(time.Millisecond))
+	s.readSpan.finish(nil)
+	close(s.contents)
+	s.parsers.Wait()
+	s.parseSpan.set("scorpion.busy_ms", atomic.LoadInt64(&s.parseBusy)/int64(time.Millisecond))
+	s.parseSpan.finish(nil)
+}
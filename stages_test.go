@@ -0,0 +1,23 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestRetryDelay checks that delays stay within the base and the cap
+// for any attempt, zero and negative base delays do not panic
+func TestRetryDelay(t *testing.T) {
+	for _, base := range []time.Duration{-time.Second, 0, 1, 100 * time.Millisecond, 5 * time.Minute} {
+		for _, attempt := range []int{0, 1, 10, 63, 64, 1000} {
+			delay := retryDelay(base, attempt)
+			limit := maxReadRetryDelay
+			if base > limit {
+				limit = base
+			}
+			if base > 0 && (delay < base/2 || delay > limit) {
+				t.Errorf("Delay of attempt %v with base %v is %v", attempt, base, delay)
+			}
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// LanguageStats contains totals for the files of one language
+type LanguageStats struct {
+	Files    int            `json:"files"`
+	Comments int            `json:"comments"`
+	Estimate float64        `json:"estimate,omitempty"`
+	Types    map[string]int `json:"types"`
+	// Cost is the estimate in currency of the cost config
+	Cost float64 `json:"cost,omitempty"`
+}
+
+// Stats contains totals of the report
+type Stats struct {
+	Files     int                       `json:"files"`
+	Comments  int                       `json:"comments"`
+	Estimate  float64                   `json:"estimate,omitempty"`
+	Types     map[string]int            `json:"types"`
+	Languages map[string]*LanguageStats `json:"languages"`
+	// Vendored is number of comments excluded in vendor directories
+	Vendored int `json:"vendored,omitempty"`
+	// Cost and Currency are set if the cost config has rates
+	Cost     float64 `json:"cost,omitempty"`
+	Currency string  `json:"currency,omitempty"`
+}
+
+// computeStats aggregates comments by type and language, files contains
+// number of scanned files per language and vendored is number of
+// comments excluded in vendor directories
+func computeStats(comments []*ToDoComment, files map[string]int, vendored int) *Stats {
+	stats := &Stats{
+		Types:     make(map[string]int),
+		Languages: make(map[string]*LanguageStats),
+		Vendored:  vendored,
+	}
+	language := func(name string) *LanguageStats {
+		ls, ok := stats.Languages[name]
+		if !ok {
+			ls = &LanguageStats{Types: make(map[string]int)}
+			stats.Languages[name] = ls
+		}
+		return ls
+	}
+	for name, count := range files {
+		language(name).Files = count
+		stats.Files += count
+	}
+	for _, c := range comments {
+		stats.Comments++
+		stats.Estimate += c.Estimate
+		stats.Types[c.Type]++
+		ls := language(c.Language)
+		ls.Comments++
+		ls.Estimate += c.Estimate
+		ls.Types[c.Type]++
+	}
+	return stats
+}
+
+// refreshStats recomputes stats after comments of the report changed
+func refreshStats(report *result) *Stats {
+	files := make(map[string]int)
+	vendored := 0
+	if report.Stats != nil {
+		for name, ls := range report.Stats.Languages {
+			files[name] = ls.Files
+		}
+		vendored = report.Stats.Vendored
+	}
+	stats := computeStats(report.Comments, files, vendored)
+	report.costs.apply(stats, report.Comments)
+	return stats
+}
+
+func percent(part, total int) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100.0 * float64(part) / float64(total)
+}
+
+// printStats writes human-readable breakdown of comments per language
+func printStats(w io.Writer, stats *Stats, hf *humanFormat) error {
+	types := make([]string, 0, len(stats.Types))
+	for t := range stats.Types {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	languages := make([]string, 0, len(stats.Languages))
+	for l := range stats.Languages {
+		languages = append(languages, l)
+	}
+	sort.Slice(languages, func(i, j int) bool {
+		a, b := stats.Languages[languages[i]], stats.Languages[languages[j]]
+		if a.Comments != b.Comments {
+			return a.Comments > b.Comments
+		}
+		return languages[i] < languages[j]
+	})
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprint(tw, "LANGUAGE\tFILES\tCOMMENTS\tESTIMATE")
+	if stats.Cost > 0 {
+		fmt.Fprint(tw, "\tCOST")
+	}
+	for _, t := range types {
+		fmt.Fprintf(tw, "\t%v", t)
+	}
+	fmt.Fprintln(tw)
+	for _, l := range languages {
+		ls := stats.Languages[l]
+		fmt.Fprintf(tw, "%v\t%v\t%v (%.0f%%)\t%v", l, ls.Files, ls.Comments,
+			percent(ls.Comments, stats.Comments), hf.Estimate(ls.Estimate))
+		if stats.Cost > 0 {
+			fmt.Fprintf(tw, "\t%v", formatCost(ls.Cost, stats.Currency))
+		}
+		for _, t := range types {
+			fmt.Fprintf(tw, "\t%v (%.0f%%)", ls.Types[t], percent(ls.Types[t], stats.Types[t]))
+		}
+		fmt.Fprintln(tw)
+	}
+	fmt.Fprintf(tw, "Total\t%v\t%v\t%v", stats.Files, stats.Comments, hf.Estimate(stats.Estimate))
+	if stats.Cost > 0 {
+		fmt.Fprintf(tw, "\t%v", formatCost(stats.Cost, stats.Currency))
+	}
+	for _, t := range types {
+		fmt.Fprintf(tw, "\t%v", stats.Types[t])
+	}
+	fmt.Fprintln(tw)
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if stats.Vendored > 0 {
+		fmt.Fprintf(w, "Excluded in vendor directories: %v (use --include-vendor to report them)\n", stats.Vendored)
+	}
+	return nil
+}
+
+// runStats implements "scorpion stats", with --group-by
+// it prints totals per author or other field
+func runStats(args []string) error {
+	if err := resolveScanPaths(args); err != nil {
+		return err
+	}
+	config, err := loadConfig(configPathFlag, srcRootFlag)
+	if err != nil {
+		return err
+	}
+	if groupByFlag == groupByAuthor {
+		blameFlag = true
+	}
+	hf, err := newHumanFormat(&config.Display)
+	if err != nil {
+		return err
+	}
+	costs, err := newCostModel(&config.Cost)
+	if err != nil {
+		return err
+	}
+	result, err := scan(config, NewEnvironment(srcRootFlag))
+	if err != nil {
+		return err
+	}
+	if len(groupByFlag) == 0 {
+		costs.apply(result.Stats, result.Comments)
+		return printStats(os.Stdout, result.Stats, hf)
+	}
+	groups, err := groupComments(result.Comments, groupByFlag, costs)
+	if err != nil {
+		return err
+	}
+	if csvFlag {
+		return writeGroupsCSV(os.Stdout, groupByFlag, groups, costs)
+	}
+	return printGroups(os.Stdout, groupByFlag, groups, hf, costs)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	storeSinkName   = "store"
+	storeTimeFormat = "20060102T150405Z"
+)
+
+var (
+	defaultStoreDir = filepath.Join(".scorpion", "scans")
+	errNoStoredScan = errors.New("No stored scan")
+)
+
+// ScanStore keeps reports of previous scans as json files,
+// file names without extension are IDs of the scans
+type ScanStore struct {
+	dir string
+}
+
+// NewScanStore opens the store in dir, relative to the root
+// if not absolute, .scorpion/scans in the root by default
+func NewScanStore(root, dir string) *ScanStore {
+	if len(dir) == 0 {
+		dir = defaultStoreDir
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+	return &ScanStore{dir: dir}
+}
+
+// newScanID returns ID of the scan taken at the time, IDs
+// of later scans are sorted after the earlier ones
+func newScanID(report *result, now time.Time) string {
+	id := now.UTC().Format(storeTimeFormat)
+	if len(report.Revision) > 0 {
+		id += "-" + report.Revision
+	}
+	return id
+}
+
+func (s *ScanStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+// List returns IDs of stored scans from the oldest to the newest
+func (s *ScanStore) List() ([]string, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(files))
+	for _, f := range files {
+		if !f.IsDir() && filepath.Ext(f.Name()) == ".json" {
+			ids = append(ids, strings.TrimSuffix(f.Name(), ".json"))
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// Latest returns ID of the newest stored scan
+func (s *ScanStore) Latest() (string, error) {
+	ids, err := s.List()
+	if err != nil {
+		return "", err
+	}
+	if len(ids) == 0 {
+		return "", fmt.Errorf("%v in %v", errNoStoredScan, s.dir)
+	}
+	return ids[len(ids)-1], nil
+}
+
+// Load reads the stored scan
+func (s *ScanStore) Load(id string) (*result, error) {
+	return loadReport(s.path(id))
+}
+
+// loadReport reads json report saved by the json or store sink
+func loadReport(path string) (*result, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	report := &result{}
+	if err := json.Unmarshal(data, report); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	if err := checkSchemaVersion(report); err != nil {
+		return nil, err
+	}
+	// reports saved by older versions have no IDs
+	assignIDs(report.Comments)
+	return report, nil
+}
+
+// resolveScan loads the scan by path of a report file or by ID
+// in the store, the latest stored scan if ref is empty
+func resolveScan(store *ScanStore, ref string) (*result, error) {
+	if len(ref) == 0 {
+		id, err := store.Latest()
+		if err != nil {
+			return nil, err
+		}
+		ref = id
+	}
+	if _, err := os.Stat(ref); err == nil {
+		return loadReport(ref)
+	}
+	return store.Load(ref)
+}
+
+type storeSink struct {
+	store *ScanStore
+}
+
+func newStoreSink(sc *SinkConfig, env *Environment) (Sink, error) {
+	return &storeSink{store: NewScanStore(env.root, sc.Path)}, nil
+}
+
+func (s *storeSink) Emit(report *result, plan *Plan) error {
+	js, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	id := newScanID(report, time.Now())
+	plan.Add(&PlanAction{Kind: planWriteFile, Target: s.store.path(id), Summary: "stored scan " + id, Content: string(js)})
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Submodules returns paths of submodules relative to the repository top level
+func (env *Environment) Submodules() []string {
+	top := gitTopLevel(env.root)
+	if len(top) == 0 {
+		return nil
+	}
+	topEnv := &Environment{root: top}
+	out := topEnv.git("config", "--file", ".gitmodules", "--get-regexp", `^submodule\..*\.path$`)
+	paths := make([]string, 0)
+	for _, line := range strings.Split(out, "\n") {
+		parts := strings.Fields(line)
+		if len(parts) == 2 {
+			paths = append(paths, parts[1])
+		}
+	}
+	return paths
+}
+
+// SparseExcluded returns paths (relative to the repository top level)
+// that are not part of sparse checkout, nil if it is not enabled
+func (env *Environment) SparseExcluded() []string {
+	if env.git("config", "--bool", "core.sparseCheckout") != "true" {
+		return nil
+	}
+	top := gitTopLevel(env.root)
+	if len(top) == 0 {
+		return nil
+	}
+	topEnv := &Environment{root: top}
+	out := topEnv.git("ls-files", "-t")
+	paths := make([]string, 0)
+	for _, line := range strings.Split(out, "\n") {
+		// skip-worktree entries are tagged with "S"
+		if strings.HasPrefix(line, "S ") {
+			paths = append(paths, strings.TrimPrefix(line, "S "))
+		}
+	}
+	return paths
+}
+
+// planCheckoutExclusions configures generator to skip submodules (or
+// attribute their comments to the submodule project) and sparse paths
+func planCheckoutExclusions(env *Environment, td *ToDoGenerator, recurseSubmodules bool) {
+	top := gitTopLevel(env.root)
+	if len(top) == 0 {
+		return
+	}
+	for _, p := range env.Submodules() {
+		dir := filepath.Join(top, filepath.FromSlash(p))
+		if recurseSubmodules {
+			td.AddSubproject(dir, filepath.Base(p))
+		} else {
+			td.SkipPath(dir, "submodule")
+		}
+	}
+	for _, p := range env.SparseExcluded() {
+		td.SkipPath(filepath.Join(top, filepath.FromSlash(p)), "sparse-checkout")
+	}
+}
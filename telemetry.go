@@ -0,0 +1,345 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	telemetryScope       = appName
+	defaultServiceName   = appName
+	otlpSpanKindInternal = 1
+	otlpStatusError      = 2
+	// otlpCumulative is aggregation temporality of sums, totals
+	// since the start of the process
+	otlpCumulative = 2
+)
+
+var (
+	// telemetry is nil unless OTLP export is configured
+	telemetry *telemetryExporter
+	// longRunningCommands trace every scan instead of the command
+	longRunningCommands = map[string]bool{"serve": true, "daemon": true, "lsp": true}
+)
+
+// span is a timed operation of the active trace
+type span struct {
+	traceID    string
+	spanID     string
+	parentID   string
+	name       string
+	start      time.Time
+	end        time.Time
+	attributes map[string]interface{}
+	err        error
+}
+
+// metricPoint is a sum or, for durations, a gauge with attributes
+type metricPoint struct {
+	name       string
+	unit       string
+	attributes map[string]string
+	value      float64
+	gauge      bool
+}
+
+// telemetryExporter collects spans and metrics of scans and sends
+// them to an OpenTelemetry collector with OTLP over HTTP (json)
+type telemetryExporter struct {
+	tracesURL  string
+	metricsURL string
+	headers    map[string]string
+	resource   map[string]string
+	started    time.Time
+	mu         sync.Mutex
+	trace      *span
+	spans      []*span
+	metrics    map[string]*metricPoint
+}
+
+// parseKeyValues parses "key=value,key=value" lists of OTEL variables
+func parseKeyValues(s string) map[string]string {
+	values := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || len(strings.TrimSpace(kv[0])) == 0 {
+			continue
+		}
+		v, err := url.QueryUnescape(strings.TrimSpace(kv[1]))
+		if err != nil {
+			v = strings.TrimSpace(kv[1])
+		}
+		values[strings.TrimSpace(kv[0])] = v
+	}
+	return values
+}
+
+// otlpEndpoint returns url of the signal, the specific variable is
+// used as is and the general one gets the path of the signal
+func otlpEndpoint(signal string) string {
+	if os.Getenv("OTEL_"+strings.ToUpper(signal)+"_EXPORTER") == "none" {
+		return ""
+	}
+	if u := os.Getenv("OTEL_EXPORTER_OTLP_" + strings.ToUpper(signal) + "_ENDPOINT"); len(u) > 0 {
+		return u
+	}
+	if u := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); len(u) > 0 {
+		return strings.TrimSuffix(u, "/") + "/v1/" + signal
+	}
+	return ""
+}
+
+// setupTelemetry enables export with the standard OTEL_* variables,
+// it is disabled if no endpoint is set
+func setupTelemetry() {
+	if os.Getenv("OTEL_SDK_DISABLED") == "true" {
+		return
+	}
+	e := &telemetryExporter{
+		tracesURL:  otlpEndpoint("traces"),
+		metricsURL: otlpEndpoint("metrics"),
+		headers:    parseKeyValues(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")),
+		resource:   parseKeyValues(os.Getenv("OTEL_RESOURCE_ATTRIBUTES")),
+		started:    time.Now(),
+		metrics:    make(map[string]*metricPoint),
+	}
+	if len(e.tracesURL) == 0 && len(e.metricsURL) == 0 {
+		return
+	}
+	if protocol := os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"); protocol == "grpc" {
+		log.Printf("Telemetry is disabled: OTLP protocol %v is not supported, use http/json", protocol)
+		return
+	}
+	if name := os.Getenv("OTEL_SERVICE_NAME"); len(name) > 0 {
+		e.resource["service.name"] = name
+	}
+	if len(e.resource["service.name"]) == 0 {
+		e.resource["service.name"] = defaultServiceName
+	}
+	e.resource["service.version"] = version
+	telemetry = e
+}
+
+func randomID(size int) string {
+	b := make([]byte, size)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// startTrace starts the root span, spans started until it ends are
+// its children. TRACEPARENT of the environment (W3C format) makes it
+// a part of the trace of CI jobs
+func startTrace(name string) *span {
+	if telemetry == nil {
+		return nil
+	}
+	s := &span{traceID: randomID(16), spanID: randomID(8), name: name, start: time.Now()}
+	parts := strings.Split(os.Getenv("TRACEPARENT"), "-")
+	if len(parts) == 4 && len(parts[1]) == 32 && len(parts[2]) == 16 {
+		s.traceID, s.parentID = parts[1], parts[2]
+	}
+	telemetry.mu.Lock()
+	telemetry.trace = s
+	telemetry.mu.Unlock()
+	return s
+}
+
+// startSpan starts a child of the active trace, nil without one
+func startSpan(name string) *span {
+	if telemetry == nil {
+		return nil
+	}
+	telemetry.mu.Lock()
+	defer telemetry.mu.Unlock()
+	if telemetry.trace == nil {
+		return nil
+	}
+	return &span{traceID: telemetry.trace.traceID, spanID: randomID(8), parentID: telemetry.trace.spanID, name: name, start: time.Now()}
+}
+
+// set adds the attribute, values are strings, numbers or booleans
+func (s *span) set(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	if s.attributes == nil {
+		s.attributes = make(map[string]interface{})
+	}
+	s.attributes[key] = value
+}
+
+// finish ends the span with the error of the operation
+func (s *span) finish(err error) {
+	if s == nil {
+		return
+	}
+	s.end, s.err = time.Now(), err
+	telemetry.mu.Lock()
+	defer telemetry.mu.Unlock()
+	telemetry.spans = append(telemetry.spans, s)
+	if telemetry.trace == s {
+		telemetry.trace = nil
+		telemetry.record(appName+".duration", "s", s.end.Sub(s.start).Seconds(), true, "operation", s.name)
+	}
+}
+
+// record adds the value to the metric, attributes are key, value pairs
+func (e *telemetryExporter) record(name, unit string, value float64, gauge bool, attributes ...string) {
+	key := name + "\x00" + strings.Join(attributes, "\x00")
+	point, ok := e.metrics[key]
+	if !ok {
+		point = &metricPoint{name: name, unit: unit, gauge: gauge, attributes: make(map[string]string)}
+		for i := 0; i+1 < len(attributes); i += 2 {
+			point.attributes[attributes[i]] = attributes[i+1]
+		}
+		e.metrics[key] = point
+	}
+	if gauge {
+		point.value = value
+	} else {
+		point.value += value
+	}
+}
+
+// countMetric adds n to the counter "scorpion.<name>"
+func countMetric(name, unit string, n int, attributes ...string) {
+	if telemetry == nil {
+		return
+	}
+	telemetry.mu.Lock()
+	telemetry.record(appName+"."+name, unit, float64(n), false, attributes...)
+	telemetry.mu.Unlock()
+}
+
+// gaugeMetric sets the current value of "scorpion.<name>"
+func gaugeMetric(name, unit string, value float64, attributes ...string) {
+	if telemetry == nil {
+		return
+	}
+	telemetry.mu.Lock()
+	telemetry.record(appName+"."+name, unit, value, true, attributes...)
+	telemetry.mu.Unlock()
+}
+
+// otlpValue is AnyValue of OTLP json encoding, 64-bit integers are strings
+func otlpValue(v interface{}) map[string]interface{} {
+	switch x := v.(type) {
+	case bool:
+		return map[string]interface{}{"boolValue": x}
+	case int:
+		return map[string]interface{}{"intValue": strconv.Itoa(x)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(x, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": x}
+	}
+	return map[string]interface{}{"stringValue": fmt.Sprint(v)}
+}
+
+func otlpAttributes(attributes map[string]interface{}) []map[string]interface{} {
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	list := make([]map[string]interface{}, 0, len(keys))
+	for _, k := range keys {
+		list = append(list, map[string]interface{}{"key": k, "value": otlpValue(attributes[k])})
+	}
+	return list
+}
+
+func stringAttributes(attributes map[string]string) []map[string]interface{} {
+	values := make(map[string]interface{}, len(attributes))
+	for k, v := range attributes {
+		values[k] = v
+	}
+	return otlpAttributes(values)
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// flushTelemetry sends the finished spans and current values of the
+// metrics, failures are logged and do not fail scans
+func flushTelemetry() {
+	if telemetry == nil {
+		return
+	}
+	e := telemetry
+	e.mu.Lock()
+	spans := e.spans
+	e.spans = nil
+	now := time.Now()
+	metrics := make([]map[string]interface{}, 0, len(e.metrics))
+	keys := make([]string, 0, len(e.metrics))
+	for k := range e.metrics {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		p := e.metrics[k]
+		point := map[string]interface{}{
+			"attributes":        stringAttributes(p.attributes),
+			"startTimeUnixNano": unixNano(e.started),
+			"timeUnixNano":      unixNano(now),
+			"asDouble":          p.value,
+		}
+		metric := map[string]interface{}{"name": p.name, "unit": p.unit}
+		if p.gauge {
+			metric["gauge"] = map[string]interface{}{"dataPoints": []interface{}{point}}
+		} else {
+			metric["sum"] = map[string]interface{}{"aggregationTemporality": otlpCumulative, "isMonotonic": true, "dataPoints": []interface{}{point}}
+		}
+		metrics = append(metrics, metric)
+	}
+	e.mu.Unlock()
+
+	resource := map[string]interface{}{"attributes": stringAttributes(e.resource)}
+	scope := map[string]interface{}{"name": telemetryScope, "version": version}
+	if len(e.tracesURL) > 0 && len(spans) > 0 {
+		list := make([]map[string]interface{}, 0, len(spans))
+		for _, s := range spans {
+			item := map[string]interface{}{
+				"traceId":           s.traceID,
+				"spanId":            s.spanID,
+				"parentSpanId":      s.parentID,
+				"name":              s.name,
+				"kind":              otlpSpanKindInternal,
+				"startTimeUnixNano": unixNano(s.start),
+				"endTimeUnixNano":   unixNano(s.end),
+				"attributes":        otlpAttributes(s.attributes),
+			}
+			if s.err != nil {
+				item["status"] = map[string]interface{}{"code": otlpStatusError, "message": s.err.Error()}
+			}
+			list = append(list, item)
+		}
+		in := map[string]interface{}{"resourceSpans": []interface{}{map[string]interface{}{
+			"resource":   resource,
+			"scopeSpans": []interface{}{map[string]interface{}{"scope": scope, "spans": list}},
+		}}}
+		if err := doJSONRequest("POST", e.tracesURL, e.headers, in, nil); err != nil {
+			log.Printf("Cannot export %v spans: %v", len(spans), err)
+		}
+	}
+	if len(e.metricsURL) > 0 && len(metrics) > 0 {
+		in := map[string]interface{}{"resourceMetrics": []interface{}{map[string]interface{}{
+			"resource":     resource,
+			"scopeMetrics": []interface{}{map[string]interface{}{"scope": scope, "metrics": metrics}},
+		}}}
+		if err := doJSONRequest("POST", e.metricsURL, e.headers, in, nil); err != nil {
+			log.Printf("Cannot export metrics: %v", err)
+		}
+	}
+}
@@ -1,45 +1,109 @@
 package main
 
 import (
-	"fmt"
-	"os"
+	"bytes"
+	"sort"
+	"strings"
 	"text/template"
 )
 
+const (
+	todoFilePath = "TODO.md"
+)
+
 type todoFileData struct {
 	Root        string         `json:"root"`
 	Branch      string         `json:"branch"`
+	Revision    string         `json:"revision"`
 	Author      string         `json:"author"`
 	Project     string         `json:"project"`
+	Partial     bool           `json:"partial"`
+	FileErrors  int            `json:"fileErrors"`
+	CappedFiles int            `json:"cappedFiles"`
+	HeaderTable string         `json:"-"`
+	Security    []*ToDoComment `json:"security"`
 	Emergencies []*ToDoComment `json:"emergencies"`
 	Todos       []*ToDoComment `json:"todos"`
 	Fixemes     []*ToDoComment `json:"fixmes"`
 	Bugs        []*ToDoComment `json:"bugs"`
 	Hacks       []*ToDoComment `json:"hacks"`
+	Deprecated  []*ToDoComment `json:"deprecated"`
 	Refs        []*ToDoComment `json:"refs"`
+	// Other are sections of custom keywords and classified types
+	Other []*todoSection `json:"other"`
 }
 
-func createTodoFile(result result) error {
-	outputPath := "TODO.md"
-	tTodoFile := template.Must(template.New("todo").Parse(string(templateTasks)))
-	todofile, err := os.Create(outputPath)
-	if err != nil {
-		if verboseFlag {
-			fmt.Println("Error creating the template :", err)
+// todoSection lists comments of a type without a section of its own
+type todoSection struct {
+	Type     string         `json:"type"`
+	Comments []*ToDoComment `json:"comments"`
+}
+
+// oneLine makes a value safe to put into a markdown table cell
+func oneLine(s string) string {
+	s = strings.Replace(s, "|", "\\|", -1)
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func renderTodoFile(result result) ([]byte, error) {
+	tTodoFile := template.Must(template.New("todo").
+		Funcs(template.FuncMap{"oneline": oneLine}).
+		Parse(string(templateTasks)))
+	todoFileData := &todoFileData{
+		Root:        result.Root,
+		Branch:      result.Branch,
+		Revision:    result.Revision,
+		Author:      result.Author,
+		Project:     result.Project,
+		Partial:     result.Partial,
+		FileErrors:  len(result.FileErrors),
+		CappedFiles: len(result.CappedFiles),
+		HeaderTable: headerTable,
+	}
+	other := make(map[string]*todoSection)
+	for _, c := range result.Comments {
+		switch c.Type {
+		case securityKeyword:
+			todoFileData.Security = append(todoFileData.Security, c)
+		case "URGENT":
+			todoFileData.Emergencies = append(todoFileData.Emergencies, c)
+		case "TODO":
+			todoFileData.Todos = append(todoFileData.Todos, c)
+		case "FIXME":
+			todoFileData.Fixemes = append(todoFileData.Fixemes, c)
+		case "BUG":
+			todoFileData.Bugs = append(todoFileData.Bugs, c)
+		case "HACK":
+			todoFileData.Hacks = append(todoFileData.Hacks, c)
+		case deprecatedKeyword:
+			todoFileData.Deprecated = append(todoFileData.Deprecated, c)
+		case "REFS":
+			todoFileData.Refs = append(todoFileData.Refs, c)
+		default:
+			section, ok := other[c.Type]
+			if !ok {
+				section = &todoSection{Type: c.Type}
+				other[c.Type] = section
+				todoFileData.Other = append(todoFileData.Other, section)
+			}
+			section.Comments = append(section.Comments, c)
 		}
-		return err
 	}
-	todoFileData := &todoFileData{
-		Root:    result.Root,
-		Branch:  result.Branch,
-		Author:  result.Author,
-		Project: result.Project,
+	sort.Slice(todoFileData.Other, func(i, j int) bool {
+		return todoFileData.Other[i].Type < todoFileData.Other[j].Type
+	})
+	var buf bytes.Buffer
+	if err := tTodoFile.Execute(&buf, todoFileData); err != nil {
+		return nil, err
 	}
-	return tTodoFile.Execute(todofile, todoFileData)
+	return buf.Bytes(), nil
 }
 
 var (
-	headerTable = `|title|body|file|line|`
+	headerTable = "|title|body|file|line|\n|---|---|---|---|"
+
+	templateRows = `{{ range . }}|{{ if .Tracker.Closed }}**closed #{{ .Tracker.Number }}** {{ end }}{{ oneline .Title }}|{{ oneline .Body }}|{{ .File }}|{{ .Line }}|
+{{ end }}`
 
 	templateTasks = `# Tasks
 
@@ -49,35 +113,50 @@ var (
 * Revision: {{ .Revision }}
 * Author: {{ .Author }}
 * Project: {{ .Project }}
-
-{{if .Urgent}}
+{{- if .Partial }}
+* **Partial**: the scan was interrupted, comments of some files are missing
+{{- end }}
+{{- if .FileErrors }}
+* **File errors**: {{ .FileErrors }} files could not be read, their comments are missing
+{{- end }}
+{{- if .CappedFiles }}
+* **Capped**: {{ .CappedFiles }} files have more comments than the limit, only the first ones are listed
+{{- end }}
+{{ if .Security }}
+### SECURITY
+{{ .HeaderTable }}
+{{ template "rows" .Security }}{{ end }}
+{{- if .Emergencies }}
 ### URGENT
 {{ .HeaderTable }}
-{{ end }}
-
-{{if .Todo}}
+{{ template "rows" .Emergencies }}{{ end }}
+{{- if .Todos }}
 ### TODO
 {{ .HeaderTable }}
-{{ end }}
-
-{{if .Fixme}}
+{{ template "rows" .Todos }}{{ end }}
+{{- if .Fixemes }}
 ### FIXME
 {{ .HeaderTable }}
-{{ end }}
-
-{{if .Bug}}
+{{ template "rows" .Fixemes }}{{ end }}
+{{- if .Bugs }}
 ### BUG
 {{ .HeaderTable }}
-{{ end }}
-
-{{if .Hack}}
+{{ template "rows" .Bugs }}{{ end }}
+{{- if .Hacks }}
 ### HACK
 {{ .HeaderTable }}
-{{ end }}
-
-{{if .Refs}}
+{{ template "rows" .Hacks }}{{ end }}
+{{- if .Deprecated }}
+### DEPRECATED
+{{ .HeaderTable }}
+{{ template "rows" .Deprecated }}{{ end }}
+{{- if .Refs }}
 ### REFS
 {{ .HeaderTable }}
-{{ end }}
-`
+{{ template "rows" .Refs }}{{ end }}
+{{- range .Other }}
+### {{ .Type }}
+{{ $.HeaderTable }}
+{{ template "rows" .Comments }}{{ end }}
+{{- define "rows" }}` + templateRows + `{{ end }}`
 )
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestRenderTodoFileOtherTypes checks that types without a section of
+// their own, of custom keywords and classify rules, are not dropped
+func TestRenderTodoFileOtherTypes(t *testing.T) {
+	report := result{Comments: []*ToDoComment{
+		{Type: "TODO", Title: "handle errors of the call", File: "a.go"},
+		{Type: "XXX", Title: "custom keyword of the config", File: "a.go", Line: 1},
+		{Type: "PERF", Title: "loop is quadratic", File: "b.go"},
+		{Type: "PERF", Title: "allocates on every call", File: "b.go", Line: 4},
+	}}
+	content, err := renderTodoFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md := string(content)
+	for _, title := range []string{"handle errors of the call", "custom keyword of the config", "loop is quadratic", "allocates on every call"} {
+		if !strings.Contains(md, "|"+title+"|") {
+			t.Errorf("TODO.md has no row of %q", title)
+		}
+	}
+	perf, xxx := strings.Index(md, "### PERF\n"), strings.Index(md, "### XXX\n")
+	if perf < 0 || xxx < 0 || perf > xxx || strings.Count(md, "### PERF") != 1 {
+		t.Errorf("TODO.md has no sorted sections of other types:\n%v", md)
+	}
+}
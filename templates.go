@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// templateEngine describes comments of a template language embedded
+// in host files, e.g. Jinja in HTML or YAML
+type templateEngine struct {
+	comments []*commentMarker
+	// regions of code in the embedded language ("<?php ... ?>")
+	regions []*codeRegion
+}
+
+// codeRegion is a part of markup file between start and end
+// using syntax of the embedded language
+type codeRegion struct {
+	start  string
+	end    string
+	syntax *lineSyntax
+}
+
+var (
+	phpSyntax = newLineSyntax(`"'`, "", false, "//", "", "#", "", "/*", "*/")
+
+	templateEngines = map[string]*templateEngine{
+		"php": {regions: []*codeRegion{{start: "<?php", end: "?>", syntax: phpSyntax}}},
+		"erb": {comments: commentMarkers("<%#", "%>")},
+		// Django, Twig and Nunjucks templates are alike
+		"jinja":      {comments: commentMarkers("{#", "#}", "{% comment %}", "{% endcomment %}")},
+		"go":         {comments: commentMarkers("{{/*", "*/", "{{- /*", "*/")},
+		"handlebars": {comments: commentMarkers("{{!--", "--}}", "{{!", "}}")},
+	}
+	// languageEngines are used by files of template languages
+	languageEngines = map[string]string{
+		"PHP":         "php",
+		"ERB":         "erb",
+		"Jinja":       "jinja",
+		"Go Template": "go",
+	}
+)
+
+// templateRule selects files using the template engine
+type templateRule struct {
+	engine   *templateEngine
+	patterns []*regexp.Regexp
+}
+
+// newTemplateEngine returns the named engine with extra comments,
+// error if the engine is unknown and has no comments configured
+func newTemplateEngine(tc *TemplateConfig) (*templateEngine, error) {
+	builtin, ok := templateEngines[strings.ToLower(tc.Engine)]
+	if !ok && len(tc.Comments) == 0 {
+		return nil, fmt.Errorf("Unknown template engine: %v", tc.Engine)
+	}
+	engine := &templateEngine{}
+	if builtin != nil {
+		*engine = *builtin
+	}
+	for _, cc := range tc.Comments {
+		engine.comments = append(engine.comments, commentMarkers(cc.Start, cc.End)...)
+	}
+	return engine, nil
+}
+
+// compileTemplateRules returns rules of the configured engines
+func compileTemplateRules(templates []*TemplateConfig) ([]*templateRule, error) {
+	rules := make([]*templateRule, 0, len(templates))
+	for _, tc := range templates {
+		engine, err := newTemplateEngine(tc)
+		if err != nil {
+			return nil, err
+		}
+		patterns, err := compilePatterns(tc.Patterns)
+		if err != nil {
+			return nil, fmt.Errorf("Templates: %v", err)
+		}
+		rules = append(rules, &templateRule{engine: engine, patterns: patterns})
+	}
+	return rules, nil
+}
+
+// templateEngine returns the engine of the file, configured rules
+// take precedence over the language of the file
+func (td *ToDoGenerator) templateEngine(path, language string) *templateEngine {
+	relativePath, err := filepath.Rel(td.root, path)
+	if err != nil {
+		relativePath = path
+	}
+	for _, rule := range td.templates {
+		for _, p := range rule.patterns {
+			if p.MatchString(relativePath) {
+				return rule.engine
+			}
+		}
+	}
+	if name, ok := languageEngines[language]; ok {
+		return templateEngines[name]
+	}
+	return nil
+}
+
+// hostLanguage returns language of the template file without its
+// template extension ("config.yaml.j2" is YAML)
+func hostLanguage(path, language string) string {
+	if _, ok := languageEngines[language]; !ok {
+		return language
+	}
+	return detectLanguage(strings.TrimSuffix(path, filepath.Ext(path)))
+}
+
+// fileSyntax returns syntax of the file lines, template comments are
+// accepted by markup hosts anywhere and by others after code
+func (td *ToDoGenerator) fileSyntax(path string) *lineSyntax {
+	language := detectLanguage(path)
+	engine := td.templateEngine(path, language)
+	if engine == nil {
+		return lineSyntaxes[language]
+	}
+	host := lineSyntaxes[hostLanguage(path, language)]
+	if host == nil {
+		host = markupSyntax
+	}
+	syntax := *host
+	syntax.markers = append(append([]*commentMarker{}, host.markers...), engine.comments...)
+	if host.markup {
+		syntax.regions = engine.regions
+	}
+	return &syntax
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	colorAuto   = "auto"
+	colorAlways = "always"
+	colorNever  = "never"
+	// ansiReset ends the color of painted text
+	ansiReset = "\x1b[0m"
+)
+
+var (
+	// defaultTypeThemes follow colors of the usual GitHub labels
+	defaultTypeThemes = map[string]*TypeTheme{
+		"URGENT":     {Terminal: "bold red", Badge: "#b60205", LabelColor: "b60205"},
+		"BUG":        {Terminal: "red", Badge: "#d73a4a", LabelColor: "d73a4a"},
+		"FIXME":      {Terminal: "yellow", Badge: "#fbca04", LabelColor: "fbca04"},
+		"HACK":       {Terminal: "magenta", Badge: "#5319e7", LabelColor: "5319e7"},
+		"TODO":       {Terminal: "cyan", Badge: "#0075ca", LabelColor: "0075ca"},
+		"REFS":       {Terminal: "blue", Badge: "#c5def5", LabelColor: "c5def5"},
+		"SECURITY":   {Terminal: "bold bright red", Badge: "#ee0701", LabelColor: "ee0701"},
+		"DEPRECATED": {Terminal: "bright black", Badge: "#cfd3d7", LabelColor: "cfd3d7"},
+	}
+	terminalColors = map[string]int{
+		"black": 30, "red": 31, "green": 32, "yellow": 33,
+		"blue": 34, "magenta": 35, "cyan": 36, "white": 37,
+	}
+	labelColorRegexp = regexp.MustCompile(`^[0-9a-fA-F]{6}$`)
+	badgeRegexp      = regexp.MustCompile(`^(#[0-9a-fA-F]{3,8}|[a-zA-Z]+)$`)
+)
+
+// theme styles comment types, a nil theme paints nothing and uses
+// default labels
+type theme struct {
+	color bool
+	types map[string]*TypeTheme
+	// sequences are ANSI escapes of terminal colors of the types, all
+	// of the same length so that colored columns stay aligned
+	sequences map[string]string
+}
+
+// terminalSequence returns escape of the color like "bold bright red"
+func terminalSequence(color string) (string, error) {
+	bold := 0
+	code := 39
+	for _, word := range strings.Fields(strings.ToLower(color)) {
+		switch word {
+		case "bold":
+			bold = 1
+		case "bright":
+			code += 60
+		default:
+			c, ok := terminalColors[word]
+			if !ok {
+				return "", fmt.Errorf("Unknown terminal color: %v", color)
+			}
+			code = c + code - 39
+		}
+	}
+	if code > 97 {
+		return "", fmt.Errorf("Unknown terminal color: %v", color)
+	}
+	return fmt.Sprintf("\x1b[%v;%vm", bold, code), nil
+}
+
+// isTerminal checks if stdout is a character device and NO_COLOR is
+// not set (https://no-color.org)
+func isTerminal() bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func newTheme(tc *ThemeConfig) (*theme, error) {
+	t := &theme{types: make(map[string]*TypeTheme), sequences: make(map[string]string)}
+	switch strings.ToLower(tc.Color) {
+	case "", colorAuto:
+		t.color = isTerminal()
+	case colorAlways:
+		t.color = true
+	case colorNever:
+	default:
+		return nil, fmt.Errorf("Unknown theme color: %v, use auto, always or never", tc.Color)
+	}
+	for ctype, tt := range defaultTypeThemes {
+		copied := *tt
+		t.types[ctype] = &copied
+	}
+	for ctype, tt := range tc.Types {
+		if tt == nil {
+			continue
+		}
+		merged, ok := t.types[strings.ToUpper(ctype)]
+		if !ok {
+			merged = &TypeTheme{}
+			t.types[strings.ToUpper(ctype)] = merged
+		}
+		if len(tt.Terminal) > 0 {
+			merged.Terminal = tt.Terminal
+		}
+		if len(tt.Badge) > 0 {
+			if !badgeRegexp.MatchString(tt.Badge) {
+				return nil, fmt.Errorf("Invalid badge color of %v: %v", ctype, tt.Badge)
+			}
+			merged.Badge = tt.Badge
+		}
+		if len(tt.Label) > 0 {
+			merged.Label = tt.Label
+		}
+		if len(tt.LabelColor) > 0 {
+			color := strings.TrimPrefix(tt.LabelColor, "#")
+			if !labelColorRegexp.MatchString(color) {
+				return nil, fmt.Errorf("Invalid label color of %v: %v", ctype, tt.LabelColor)
+			}
+			merged.LabelColor = color
+		}
+	}
+	for ctype, tt := range t.types {
+		seq, err := terminalSequence(tt.Terminal)
+		if err != nil {
+			return nil, fmt.Errorf("Theme of %v: %v", ctype, err)
+		}
+		t.sequences[ctype] = seq
+	}
+	return t, nil
+}
+
+func (t *theme) typeTheme(ctype string) *TypeTheme {
+	if t == nil {
+		return nil
+	}
+	return t.types[strings.ToUpper(ctype)]
+}
+
+// Paint colors the text in the color of the type on terminals, types
+// without a color get the default one to keep the same width
+func (t *theme) Paint(ctype, text string) string {
+	if t == nil || !t.color {
+		return text
+	}
+	seq, ok := t.sequences[strings.ToUpper(ctype)]
+	if !ok {
+		seq, _ = terminalSequence("")
+	}
+	return seq + text + ansiReset
+}
+
+// Header paints the header of a painted column to keep it aligned
+func (t *theme) Header(text string) string {
+	if t == nil || !t.color {
+		return text
+	}
+	seq, _ := terminalSequence("bold")
+	return seq + text + ansiReset
+}
+
+// Badge returns the CSS color of the type, gray if it has none
+func (t *theme) Badge(ctype string) string {
+	if tt := t.typeTheme(ctype); tt != nil && len(tt.Badge) > 0 {
+		return tt.Badge
+	}
+	return "#ededed"
+}
+
+// Ink returns black or white, whichever is readable on the badge,
+// white for named colors
+func (t *theme) Ink(ctype string) string {
+	badge := strings.TrimPrefix(t.Badge(ctype), "#")
+	if len(badge) == 3 {
+		badge = string([]byte{badge[0], badge[0], badge[1], badge[1], badge[2], badge[2]})
+	}
+	if len(badge) < 6 {
+		return "#fff"
+	}
+	rgb, err := strconv.ParseUint(badge[:6], 16, 32)
+	if err != nil {
+		return "#fff"
+	}
+	// perceived brightness of ITU-R BT.601
+	if (299*(rgb>>16)+587*(rgb>>8&0xff)+114*(rgb&0xff))/1000 > 150 {
+		return "#000"
+	}
+	return "#fff"
+}
+
+// Label returns the tracker label of the type
+func (t *theme) Label(ctype string) string {
+	if tt := t.typeTheme(ctype); tt != nil && len(tt.Label) > 0 {
+		return tt.Label
+	}
+	return strings.ToLower(ctype)
+}
+
+// LabelColor returns the color labels of the type are created with,
+// empty if the tracker picks it
+func (t *theme) LabelColor(ctype string) string {
+	if tt := t.typeTheme(ctype); tt != nil {
+		return tt.LabelColor
+	}
+	return ""
+}
@@ -2,41 +2,62 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"crypto/md5"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"unicode"
+	"unicode/utf8"
 
 	"github.com/karrick/godirwalk"
-	"github.com/zieckey/goini"
 )
 
 const (
 	estimateEpsilon = 0.01
+	hoursPerDay     = 8
+	daysPerWeek     = 5
+	// minSeparatorLength is minimal number of punctuation
+	// characters in a decorative separator line
+	minSeparatorLength = 5
+	securityKeyword    = "SECURITY"
+	deprecatedKeyword  = "DEPRECATED"
+	// defaultCommentsPerFile stops generated files with thousands of
+	// TODO-looking lines from flooding reports and trackers
+	defaultCommentsPerFile = 1000
 )
 
 var (
-	commentPrefixes        = [...]string{"TODO: ", "FIXME: ", "BUG: ", "HACK: ", "URGENT: ", "REFS: "}
+	commentKeywords        = [...]string{"TODO", "FIXME", "BUG", "HACK", "URGENT", refsKeyword, securityKeyword, deprecatedKeyword}
+	keywordSeparator       = []rune(": ")
+	defaultEstimatePattern = `\[([0-9.]+[mhdw]?)\]\s*$`
 	emptyRunes             = [...]rune{}
+	separatorRunes         = "=-~_+<>"
 	categoryIniKey         = "category"
 	issueIniKey            = "issue"
 	estimateIniKey         = "estimate"
+	assigneeIniKey         = "assignee"
+	refsIniKey             = "refs"
 	errCannotParseIni      = errors.New("Cannot parse ini properties")
 	errCannotParseEstimate = errors.New("Cannot parse time estimate")
+	errInvalidEstimate     = errors.New("Time estimate is not a positive number")
 )
 
 // ToDoComment a task that is parsed from TODO comment
 // estimate is in hours
 type ToDoComment struct {
+	// ID is stable across scans, see assignIDs
+	ID       string  `json:"id,omitempty"`
 	Type     string  `json:"type"`
 	Title    string  `json:"title"`
 	Body     string  `json:"body"`
@@ -44,90 +65,401 @@ type ToDoComment struct {
 	Line     int     `json:"line"`
 	Issue    int     `json:"issue,omitempty"`
 	Category string  `json:"category,omitempty"`
+	Assignee string  `json:"assignee,omitempty"`
 	Estimate float64 `json:"estimate,omitempty"`
+	Language string  `json:"language,omitempty"`
+	Project  string  `json:"project,omitempty"`
+	// EstimateISO is the estimate as ISO 8601 duration, see MarshalJSON
+	EstimateISO string `json:"estimateISO,omitempty"`
+	// EstimateDefault is set when the estimate is the default of the type
+	EstimateDefault bool `json:"estimateDefault,omitempty"`
+	// Metadata are properties other than the known ones
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Relations are issues, files and comments from the title of REFS
+	// comments and refs= of any comment
+	Relations []*Relation `json:"relations,omitempty"`
+	// DuplicateOf is the ID of the first comment of near-duplicates,
+	// which lists IDs of its copies in Duplicates
+	DuplicateOf string   `json:"duplicateOf,omitempty"`
+	Duplicates  []string `json:"duplicates,omitempty"`
+	// Confidence is 1 for strict "KEYWORD:" matches and lower for lenient ones
+	Confidence float64 `json:"confidence,omitempty"`
+	// TitleLanguage is ISO 639-1 code of the natural language of the title
+	TitleLanguage string `json:"titleLanguage,omitempty"`
+	// OriginalTitle is set when the title was translated
+	OriginalTitle string `json:"originalTitle,omitempty"`
+	Blame         *Blame `json:"blame,omitempty"`
+	// RawTitle is the title as written when normalization changed it
+	RawTitle string `json:"rawTitle,omitempty"`
+	// Tracker is the linked issue, see trackerTransformer
+	Tracker *TrackerIssue `json:"tracker,omitempty"`
 }
 
 // ToDoGenerator is responsible for parsing code base to ToDoComments
 type ToDoGenerator struct {
-	root       string
-	filters    []*regexp.Regexp
-	commentsWG sync.WaitGroup
-	comments   []*ToDoComment
+	root             string
+	paths            []string
+	skipped          map[string]string
+	subprojects      map[string]string
+	filters          []*regexp.Regexp
+	keywords         *keywordSet
+	prefilter        *keywordPrefilter
+	estimatePatterns []*regexp.Regexp
+	defaultEstimates map[string]float64
+	generated        []*regexp.Regexp
+	skipGenerated    bool
+	generatedCount   int
+	vendorDirs       map[string]bool
+	pruneVendor      bool
+	hidden           *hiddenPolicy
+	maxDepth         int
+	attributes       *linguistAttributes
+	generatedAttr    bool
+	explainSkips     bool
+	skips            []*SkippedPath
+	fileErrors       []*FileError
+	vendoredAttr     bool
+	vendoredCount    int
+	specialCount     int
+	joinTitles       bool
+	titles           TitlesConfig
+	emoji            *emojiMapper
+	classify         []*classifyRule
+	trailing         bool
+	templates        []*templateRule
+	bodies           BodiesConfig
+	// pending are comments of parsed files in the order parsers found
+	// them, collect orders them before dropping duplicates
+	pending    []*ToDoComment
 	minWords   int
 	minChars   int
-	addedMap   map[string]bool
+	maxPerFile int
+	capped     []*CappedFile
+	files      map[string]int
 	commentMux sync.Mutex
 }
 
 // NewToDoGenerator creates new generator for a source root
-func NewToDoGenerator(root string, filters []string, minWords, minChars int) *ToDoGenerator {
+func NewToDoGenerator(root string, filters []string, minWords, minChars int, config *Config) (*ToDoGenerator, error) {
 	log.Printf("Using %v filters", filters)
-	rfilters := make([]*regexp.Regexp, 0, len(filters))
-	for _, f := range filters {
-		rfilters = append(rfilters, regexp.MustCompile(f))
+	rfilters, err := compilePatterns(filters)
+	if err != nil {
+		return nil, err
+	}
+	patterns := config.Estimates.TitlePatterns
+	if patterns == nil {
+		patterns = []string{defaultEstimatePattern}
+	}
+	estimatePatterns, err := compilePatterns(patterns)
+	if err != nil {
+		return nil, err
+	}
+	generated, err := compileGeneratedPatterns(config.Generated.Patterns)
+	if err != nil {
+		return nil, err
+	}
+	templates, err := compileTemplateRules(config.Templates)
+	if err != nil {
+		return nil, err
+	}
+	defaultEstimates, err := parseDefaultEstimates(config.Estimates.Defaults)
+	if err != nil {
+		return nil, err
 	}
 	absolutePath, err := filepath.Abs(root)
 	if err != nil {
 		log.Printf("Error setting generator root: %v", err)
 		absolutePath = root
 	}
+	vendorDirs := make(map[string]bool)
+	if !config.Vendor.Include && !includeVendorFlag {
+		dirs := config.Vendor.Dirs
+		if len(dirs) == 0 {
+			dirs = defaultVendorDirs
+		}
+		for _, d := range dirs {
+			vendorDirs[d] = true
+		}
+	}
+	keywords := newKeywords(&config.Keywords, lenientFlag)
+	emoji, err := newEmojiMapper(&config.Titles)
+	if err != nil {
+		return nil, err
+	}
+	classifyRules, err := compileClassifyRules(config.Classify)
+	if err != nil {
+		return nil, err
+	}
+	generatedAttr := boolValue(config.Generated.Attributes, true)
+	vendoredAttr := boolValue(config.Vendor.Attributes, true)
+	var attributes *linguistAttributes
+	if generatedAttr || vendoredAttr {
+		attributes = &linguistAttributes{}
+	}
 	td := &ToDoGenerator{
-		root:     absolutePath,
-		filters:  rfilters,
-		minWords: minWords,
-		minChars: minChars,
-		comments: make([]*ToDoComment, 0),
-		addedMap: make(map[string]bool),
+		root:             absolutePath,
+		filters:          rfilters,
+		keywords:         keywords,
+		prefilter:        newKeywordPrefilter(keywords.list),
+		estimatePatterns: estimatePatterns,
+		defaultEstimates: defaultEstimates,
+		generated:        generated,
+		skipGenerated:    !config.Generated.Include && !includeGenFlag,
+		vendorDirs:       vendorDirs,
+		pruneVendor:      !boolValue(config.Vendor.Count, true),
+		hidden:           compileHiddenPolicy(&config.Hidden),
+		maxDepth:         maxDepthFlag,
+		attributes:       attributes,
+		generatedAttr:    generatedAttr,
+		explainSkips:     len(explainSkipsFlag) > 0,
+		vendoredAttr:     vendoredAttr,
+		joinTitles:       config.Titles.JoinWrapped,
+		titles:           config.Titles,
+		emoji:            emoji,
+		classify:         classifyRules,
+		trailing:         boolValue(config.Keywords.Trailing, true),
+		templates:        templates,
+		bodies:           config.Bodies,
+		minWords:         minWords,
+		minChars:         minChars,
+		maxPerFile:       intValue(config.Limits.CommentsPerFile, defaultCommentsPerFile),
+		pending:          make([]*ToDoComment, 0),
+		files:            make(map[string]int),
+		skipped:          make(map[string]string),
+		subprojects:      make(map[string]string),
+	}
+	return td, nil
+}
+
+// compilePatterns compiles configured regular expressions, a bad one
+// fails the scan instead of silently matching nothing
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, p := range patterns {
+		r, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pattern %v: %v", p, err)
+		}
+		compiled = append(compiled, r)
 	}
-	return td
+	return compiled, nil
+}
+
+// SetPaths limits the scan to files and directories inside the root
+func (td *ToDoGenerator) SetPaths(paths []string) {
+	td.paths = paths
+}
+
+// SkipPath excludes absolute path of a file or directory from the scan
+func (td *ToDoGenerator) SkipPath(path, reason string) {
+	td.skipped[path] = reason
+}
+
+// AddSubproject attributes comments in the directory to the project
+func (td *ToDoGenerator) AddSubproject(dir, project string) {
+	td.subprojects[dir] = project
+}
+
+// subproject returns project of the nested directory containing path
+func (td *ToDoGenerator) subproject(path string) string {
+	if len(td.subprojects) == 0 {
+		return ""
+	}
+	path = absolutePath(path)
+	for dir, project := range td.subprojects {
+		if strings.HasPrefix(path, dir+string(os.PathSeparator)) {
+			return project
+		}
+	}
+	return ""
+}
+
+func absolutePath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// generatedPathReason checks path of the file against generated
+// patterns, linguist-generated attribute takes precedence. It returns
+// the attribute or the matching pattern, empty if it is not generated
+func (td *ToDoGenerator) generatedPathReason(path string) string {
+	if generated, ok := td.generatedAttribute(path); ok {
+		if generated {
+			return linguistGenerated
+		}
+		return ""
+	}
+	slashPath := filepath.ToSlash(path)
+	for _, r := range td.generated {
+		if r.MatchString(slashPath) {
+			return r.String()
+		}
+	}
+	return ""
+}
+
+// notGenerated checks if the file is marked as not generated
+// explicitly ("linguist-generated=false")
+func (td *ToDoGenerator) notGenerated(path string) bool {
+	generated, ok := td.generatedAttribute(path)
+	return ok && !generated
+}
+
+// generatedAttribute returns linguist-generated of the file
+// and if it is specified
+func (td *ToDoGenerator) generatedAttribute(path string) (bool, bool) {
+	if !td.generatedAttr {
+		return false, false
+	}
+	return td.linguistAttribute(path, linguistGenerated)
+}
+
+func (td *ToDoGenerator) countGenerated(path, reason string) {
+	td.commentMux.Lock()
+	td.generatedCount++
+	td.commentMux.Unlock()
+	td.skip(path, skipGenerated, reason)
 }
 
 // Generate is an entry point to comment generation
 func (td *ToDoGenerator) Generate() ([]*ToDoComment, error) {
-	matchesCount := 0
+	stages := td.startStages()
 
-	err := godirwalk.Walk(td.root, &godirwalk.Options{
-		Callback: func(osPathname string, de *godirwalk.Dirent) error {
-			if verboseFlag {
-				fmt.Printf("%s %s\n", de.ModeType(), osPathname)
+	// walkRoot is the scanned path, which depth is counted from
+	walkRoot := td.root
+	callback := func(osPathname string, de *godirwalk.Dirent) error {
+		if stopRequested() {
+			return errInterrupted
+		}
+		if verboseFlag {
+			fmt.Printf("%s %s\n", de.ModeType(), osPathname)
+		}
+		if reason, ok := td.skipped[absolutePath(osPathname)]; ok {
+			log.Printf("Skipping %v (%v)", osPathname, reason)
+			td.skip(osPathname, reason, "")
+			if de.IsDir() {
+				return filepath.SkipDir
 			}
-			// skip patterns
+			return nil
+		}
+		if de.IsDir() {
+			if td.pruneDir(walkRoot, osPathname) {
+				return filepath.SkipDir
+			}
+			td.loadAttributes(osPathname)
+			return nil
+		}
+		if td.hidden.skips(de.Name()) {
+			td.skip(osPathname, skipHidden, "")
+			return nil
+		}
+		// skip patterns
 
-			anyMatch := false
-			for _, f := range td.filters {
-				if f.MatchString(osPathname) {
-					anyMatch = true
-					break
-				}
+		anyMatch := false
+		for _, f := range td.filters {
+			if f.MatchString(osPathname) {
+				anyMatch = true
+				break
 			}
-			if !anyMatch && len(td.filters) > 0 {
+		}
+		if !anyMatch && len(td.filters) > 0 {
+			td.skip(osPathname, skipFilter, "")
+			return nil
+		}
+		if td.skipGenerated {
+			if reason := td.generatedPathReason(osPathname); len(reason) > 0 {
+				td.countGenerated(osPathname, reason)
 				return nil
 			}
+		}
+		if td.skipSpecialFile(osPathname, de.ModeType()) {
+			return nil
+		}
 
-			matchesCount++
-			td.commentsWG.Add(1)
-			go td.parseFile(osPathname)
+		stages.Read(osPathname)
 
-			return nil
-		},
-		ErrorCallback: func(osPathname string, err error) godirwalk.ErrorAction {
-			if verboseFlag {
-				fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+		return nil
+	}
+
+	paths := td.paths
+	if len(paths) == 0 {
+		paths = []string{td.root}
+	}
+	walk := startSpan("walk")
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			walk.finish(err)
+			return nil, err
+		}
+		td.loadParentAttributes(path)
+		if !fi.IsDir() {
+			if !td.skipSpecialFile(path, fi.Mode()) {
+				stages.Read(path)
 			}
-			// For the purposes of this example, a simple SkipNode will suffice,
-			// although in reality perhaps additional logic might be called for.
-			return godirwalk.SkipNode
-		},
-		Unsorted: true, // set true for faster yet non-deterministic enumeration (see godoc)
-	})
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s\n", err)
-		os.Exit(1)
+			continue
+		}
+		walkRoot = path
+		err = godirwalk.Walk(path, &godirwalk.Options{
+			Callback: callback,
+			ErrorCallback: func(osPathname string, err error) godirwalk.ErrorAction {
+				if err == errInterrupted {
+					return godirwalk.Halt
+				}
+				if verboseFlag {
+					fmt.Fprintf(os.Stderr, "ERROR: %s\n", err)
+				}
+				td.fileError(osPathname, err)
+				// For the purposes of this example, a simple SkipNode will suffice,
+				// although in reality perhaps additional logic might be called for.
+				return godirwalk.SkipNode
+			},
+			Unsorted: true, // set true for faster yet non-deterministic enumeration (see godoc)
+		})
+		if err == errInterrupted {
+			log.Printf("Walk of %v was interrupted", path)
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			os.Exit(1)
+		}
 	}
+	walk.set("scorpion.files", stages.Queued())
+	walk.finish(nil)
 
-	log.Printf("Matched files: %v", matchesCount)
-	td.commentsWG.Wait()
-	return td.comments, nil
+	log.Printf("Matched files: %v", stages.Queued())
+	stages.Wait()
+	if generated := td.Generated(); generated > 0 {
+		log.Printf("Skipped generated files: %v", generated)
+	}
+	if vendored := td.Vendored(); vendored > 0 {
+		log.Printf("Excluded vendored comments: %v", vendored)
+	}
+	if special := td.Special(); special > 0 {
+		log.Printf("Skipped special files: %v", special)
+	}
+	return td.collect(), nil
+}
+
+// Generated returns number of skipped generated files
+func (td *ToDoGenerator) Generated() int {
+	td.commentMux.Lock()
+	defer td.commentMux.Unlock()
+	return td.generatedCount
+}
+
+// FilesByLanguage returns number of scanned files per language
+func (td *ToDoGenerator) FilesByLanguage() map[string]int {
+	td.commentMux.Lock()
+	defer td.commentMux.Unlock()
+	files := make(map[string]int, len(td.files))
+	for l, count := range td.files {
+		files[l] = count
+	}
+	return files
 }
 
 func countTitleWords(s string) int {
@@ -142,27 +474,54 @@ func countTitleWords(s string) int {
 }
 
 func (td *ToDoGenerator) addComment(c *ToDoComment) {
-	defer td.commentsWG.Done()
-
-	h := md5.New()
-	io.WriteString(h, c.Title)
-	io.WriteString(h, c.Body)
-	s := hex.EncodeToString(h.Sum(nil))
+	if countTitleWords(c.Title) < td.minWords && len(c.Title) < td.minChars {
+		log.Printf("Ignoring comment in %v:%v", c.File, c.Line)
+		return
+	}
+	td.commentMux.Lock()
+	td.pending = append(td.pending, c)
+	td.commentMux.Unlock()
+}
 
+// collect returns comments of the scan ordered by file and line, so
+// that reports do not depend on the order in which files were walked
+// and parsed. A comment found twice at the same location (overlapping
+// scanned paths) is kept once, while copies in other places are kept
+// for duplicate detection to report them
+func (td *ToDoGenerator) collect() []*ToDoComment {
 	td.commentMux.Lock()
 	defer td.commentMux.Unlock()
-
-	if _, ok := td.addedMap[s]; ok {
-		log.Printf("Skipping comment duplicate in %v:%v", c.File, c.Line)
-		return
+	sort.Slice(td.pending, func(i, j int) bool {
+		a, b := td.pending[i], td.pending[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	added := make(map[string]bool, len(td.pending))
+	comments := make([]*ToDoComment, 0, len(td.pending))
+	for _, c := range td.pending {
+		h := md5.New()
+		fmt.Fprintf(h, "%v:%v:", c.File, c.Line)
+		io.WriteString(h, c.Title)
+		io.WriteString(h, c.Body)
+		s := hex.EncodeToString(h.Sum(nil))
+		if added[s] {
+			log.Printf("Skipping comment duplicate in %v:%v", c.File, c.Line)
+			continue
+		}
+		added[s] = true
+		comments = append(comments, c)
 	}
+	comments, td.capped = capComments(comments, td.maxPerFile)
+	return comments
+}
 
-	if countTitleWords(c.Title) >= td.minWords || len(c.Title) >= td.minChars {
-		td.addedMap[s] = true
-		td.comments = append(td.comments, c)
-	} else {
-		log.Printf("Ignoring comment in %v:%v", c.File, c.Line)
-	}
+// CappedFiles returns files with more comments than the limit
+func (td *ToDoGenerator) CappedFiles() []*CappedFile {
+	td.commentMux.Lock()
+	defer td.commentMux.Unlock()
+	return td.capped
 }
 
 func isCommentRune(r rune) bool {
@@ -173,9 +532,52 @@ func isCommentRune(r rune) bool {
 		r == '*'
 }
 
+// isSeparatorLine checks if the commented line is a decorative
+// banner like "//======" or "#-----" made of punctuation only
+func isSeparatorLine(line string) bool {
+	count := 0
+	for _, r := range line {
+		if unicode.IsSpace(r) {
+			continue
+		}
+		if !isCommentRune(r) && !strings.ContainsRune(separatorRunes, r) {
+			return false
+		}
+		count++
+	}
+	return count >= minSeparatorLength
+}
+
 // try to parse comment body from commented line
 func parseComment(line string) []rune {
 	runes := []rune(line)
+	size := len(runes)
+	i := commentStart(runes)
+	if i < 0 {
+		return nil
+	}
+	j := size - 1
+	// skip suffix whitespace
+	for j > i && unicode.IsSpace(runes[j]) {
+		j--
+	}
+	// and the end of block comment ("/* TODO: ... */")
+	if j > i+1 && runes[j] == '/' && runes[j-1] == '*' {
+		j -= 2
+		for j > i && unicode.IsSpace(runes[j]) {
+			j--
+		}
+	}
+	// empty comment
+	if i >= size || j < 0 || i >= j {
+		return emptyRunes[:]
+	}
+	return runes[i : j+1]
+}
+
+// commentStart returns index of the comment text after comment
+// symbols and whitespace, -1 if the line is not a comment
+func commentStart(runes []rune) int {
 	i := 0
 	size := len(runes)
 	// skip prefix whitespace
@@ -189,61 +591,180 @@ func parseComment(line string) []rune {
 		hasComment = true
 	}
 	if !hasComment {
-		return nil
+		return -1
 	}
 	// and skip space again
 	for i < size && unicode.IsSpace(runes[i]) {
 		i++
 	}
-	j := size - 1
-	// skip suffix whitespace
-	for j > i && unicode.IsSpace(runes[j]) {
-		j--
-	}
-	// empty comment
-	if i >= size || j < 0 || i >= j {
-		return emptyRunes[:]
-	}
-	return runes[i : j+1]
+	return i
 }
 
 func startsWith(s, pr []rune) bool {
 	// do not check length (it's checked above)
 	for i, p := range pr {
-		if unicode.ToUpper(s[i]) != p {
+		if !equalFoldRune(s[i], p) {
 			return false
 		}
 	}
 	return true
 }
 
-func parseToDoTitle(line []rune) (ctype, title []rune) {
-	if line == nil || len(line) == 0 {
-		return nil, nil
+func indexRune(s []rune, r rune) int {
+	for i, c := range s {
+		if c == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseToDoTitle splits "TODO(annotation): title" into parts,
+// annotation in parentheses is optional. Confidence is 1 for the
+// strict form and lower for lenient matches
+func parseToDoTitle(keywords *keywordSet, line []rune) (ctype, annotation, title []rune, confidence float64) {
+	m := matchToDo(keywords, line)
+	if m == nil {
+		return nil, nil, nil, 0
+	}
+	return m.kw.ctype, m.annotation, m.title, m.kw.confidence(line, m.strict, m.annotation != nil)
+}
+
+// todoMatch is a keyword matched at the start of the comment text
+type todoMatch struct {
+	kw         *keyword
+	strict     bool
+	annotation []rune
+	// end is index of the text after the keyword and annotation
+	end   int
+	title []rune
+}
+
+// matchToDo finds the keyword of the comment text,
+// strict form with the colon is preferred over lenient one
+func matchToDo(keywords *keywordSet, line []rune) *todoMatch {
+	if len(line) == 0 {
+		return nil
 	}
-	size := len(line)
-	for _, pr := range commentPrefixes {
-		prlen := len(pr)
-		if size > prlen && startsWith(line, []rune(pr)) {
-			// without last ':<space>'
-			ctype = []rune(pr)[:prlen-2]
-			title = line[prlen:]
+	var buf [8]int
+	candidates := keywords.prefixes(line, buf[:0])
+	for _, strict := range [...]bool{true, false} {
+		for _, i := range candidates {
+			kw := keywords.list[i]
+			if (!strict && kw.requireColon) || !kw.match(line) {
+				continue
+			}
+			end := len(kw.runes)
+			rest := line[end:]
+			var a []rune
+			if rest[0] == '(' {
+				if i := indexRune(rest, ')'); i > 0 {
+					a = rest[1:i]
+					rest = rest[i+1:]
+					end += i + 1
+				}
+			}
+			if t := kw.trimTitle(rest, strict); t != nil {
+				return &todoMatch{kw: kw, strict: strict, annotation: a, end: end, title: t}
+			}
+		}
+	}
+	return nil
+}
+
+// parseAnnotation uses title annotation ("TODO(2h): ..." or "TODO(john): ...")
+// as estimate if it can be parsed as such and as assignee otherwise,
+// unless they were already set with properties line
+func parseAnnotation(c *ToDoComment, annotation string) {
+	annotation = strings.TrimSpace(annotation)
+	if len(annotation) == 0 {
+		return
+	}
+	f, err := parseEstimate(annotation)
+	if err == nil {
+		if c.Estimate < estimateEpsilon {
+			c.Estimate = f
+		}
+		return
+	}
+	// "TODO(-3h): ..." is a bad estimate rather than an assignee
+	if err == errInvalidEstimate {
+		return
+	}
+	if len(c.Assignee) == 0 {
+		c.Assignee = strings.TrimPrefix(annotation, "@")
+	}
+}
+
+// parseTitleEstimate sets estimate from a title pattern ("... [3d]"),
+// unless it was already set with properties line or annotation
+func (td *ToDoGenerator) parseTitleEstimate(c *ToDoComment) {
+	if c.Estimate >= estimateEpsilon {
+		return
+	}
+	for _, p := range td.estimatePatterns {
+		m := p.FindStringSubmatchIndex(c.Title)
+		if m == nil || len(m) < 4 || m[2] < 0 {
+			continue
+		}
+		if f, err := parseEstimate(c.Title[m[2]:m[3]]); err == nil {
+			c.Estimate = f
+			c.Title = strings.TrimSpace(c.Title[:m[0]] + c.Title[m[1]:])
 			return
 		}
 	}
+}
 
-	return nil, nil
+// parseDefaultEstimates parses estimates of the types, types are
+// case-insensitive like keywords
+func parseDefaultEstimates(defaults map[string]string) (map[string]float64, error) {
+	estimates := make(map[string]float64, len(defaults))
+	for t, v := range defaults {
+		f, err := parseEstimate(v)
+		if err != nil {
+			return nil, fmt.Errorf("%v of %v: %v", err, t, v)
+		}
+		estimates[strings.ToUpper(t)] = f
+	}
+	return estimates, nil
+}
+
+// setDefaultEstimate sets the default of the type if the comment
+// has no estimate of its own
+func (td *ToDoGenerator) setDefaultEstimate(c *ToDoComment) {
+	if c.Estimate >= estimateEpsilon {
+		return
+	}
+	if f, ok := td.defaultEstimates[strings.ToUpper(c.Type)]; ok && f >= estimateEpsilon {
+		c.Estimate = f
+		c.EstimateDefault = true
+	}
 }
 
-// parseEstimate parses human-readible hours or minutes
-// estimate to float64 in hours
+// parseEstimate parses human-readible minutes, hours, days or weeks
+// estimate or ISO 8601 duration to float64 in hours (day is 8 hours
+// and week is 5 days), estimates must be positive and finite
 func parseEstimate(estimate string) (float64, error) {
+	f, err := parseEstimateHours(estimate)
+	if err != nil {
+		return 0, err
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) || f <= 0 {
+		return 0, errInvalidEstimate
+	}
+	return f, nil
+}
+
+func parseEstimateHours(estimate string) (float64, error) {
 	if len(estimate) == 0 {
 		return 0, errCannotParseEstimate
 	}
+	if estimate[0] == 'P' || estimate[0] == 'p' {
+		return parseISODuration(estimate)
+	}
 	var s string
 	last := rune(estimate[len(estimate)-1])
-	if unicode.IsLetter(last) && last != 'm' && last != 'h' {
+	if unicode.IsLetter(last) && last != 'm' && last != 'h' && last != 'd' && last != 'w' {
 		return 0, errCannotParseEstimate
 	}
 
@@ -254,39 +775,73 @@ func parseEstimate(estimate string) (float64, error) {
 	}
 
 	if f, err := strconv.ParseFloat(s, 64); err == nil {
-		if last == 'm' {
+		switch last {
+		case 'm':
 			return f / 60.0, nil
+		case 'd':
+			return f * hoursPerDay, nil
+		case 'w':
+			return f * hoursPerDay * daysPerWeek, nil
 		}
 		return f, nil
 	}
 	return 0, errCannotParseEstimate
 }
 
+// parseIniProperties sets properties of the line, it fails if the
+// line has none of them and therefore is a part of the body. Unknown
+// keys are kept in metadata, lines with only them must consist of
+// key=value pairs, so that prose with "=" is not mistaken for them
 func (t *ToDoComment) parseIniProperties(line string) error {
 	if !strings.Contains(line, "=") {
 		return errCannotParseIni
 	}
-	ini := goini.New()
-	err := ini.Parse([]byte(line), " ", "=")
+	properties, continued, err := parseProperties(line)
 	if err != nil {
 		return err
 	}
-	if v, ok := ini.Get(categoryIniKey); ok {
+	parsed := false
+	if v, ok := properties[categoryIniKey]; ok && len(v) > 0 {
 		t.Category = v
+		parsed = true
 	}
-	if v, ok := ini.Get(issueIniKey); ok {
-		if i, err := strconv.Atoi(v); err == nil {
+	if v, ok := properties[issueIniKey]; ok {
+		if i, err := strconv.Atoi(v); err == nil && i != 0 {
 			t.Issue = i
+			parsed = true
 		}
 	}
-	if v, ok := ini.Get(estimateIniKey); ok {
-		if f, err := parseEstimate(v); err == nil {
+	if v, ok := properties[assigneeIniKey]; ok && len(v) > 0 {
+		t.Assignee = v
+		parsed = true
+	}
+	if v, ok := properties[estimateIniKey]; ok {
+		if f, err := parseEstimate(v); err == nil && f >= estimateEpsilon {
 			t.Estimate = f
+			parsed = true
+		}
+	}
+	if v, ok := properties[refsIniKey]; ok {
+		for _, ref := range strings.FieldsFunc(v, isRefSeparator) {
+			t.Relations = append(t.Relations, parseRelation(ref))
+			parsed = true
+		}
+	}
+	if !parsed && continued {
+		return errCannotParseIni
+	}
+	for k, v := range properties {
+		switch k {
+		case categoryIniKey, issueIniKey, assigneeIniKey, estimateIniKey, refsIniKey:
+			continue
+		}
+		if t.Metadata == nil {
+			t.Metadata = make(map[string]string)
 		}
+		t.Metadata[k] = v
+		parsed = true
 	}
-	if len(t.Category) == 0 &&
-		t.Issue == 0 &&
-		t.Estimate < estimateEpsilon {
+	if !parsed {
 		return errCannotParseIni
 	}
 	return nil
@@ -304,75 +859,225 @@ func NewComment(path string, lineNumber int, ctype string, body []string) *ToDoC
 		File:  path,
 		Line:  lineNumber,
 	}
+	if strings.EqualFold(t.Type, refsKeyword) {
+		t.Relations = parseTitleRelations(t.Title)
+	}
 
-	if len(body) > 1 {
-		var commentBody string
-		if err := t.parseIniProperties(body[1]); err == nil {
-			commentBody = strings.Join(body[2:], "\n")
-		} else {
-			commentBody = strings.Join(body[1:], "\n")
-		}
-		t.Body = strings.TrimSpace(commentBody)
+	// properties may be split across several lines before the body
+	rest := body[1:]
+	for len(rest) > 0 && t.parseIniProperties(rest[0]) == nil {
+		rest = rest[1:]
 	}
+	t.Body = strings.TrimSpace(strings.Join(rest, "\n"))
 
 	return t
 }
 
-func (td *ToDoGenerator) accountComment(path string, lineNumber int, ctype string, body []string) {
+// normalizeTitle cleans up the title as configured, so that titles
+// differing only in spacing, punctuation or case dedupe and cluster
+func normalizeTitle(tc *TitlesConfig, title string) string {
+	if tc.CollapseWhitespace {
+		title = strings.Join(strings.Fields(title), " ")
+	}
+	if tc.StripPunctuation {
+		title = strings.TrimRight(title, ".,;:!? \t")
+	}
+	if tc.Capitalize {
+		for i, r := range title {
+			if unicode.IsLower(r) {
+				title = title[:i] + string(unicode.ToUpper(r)) + title[i+utf8.RuneLen(r):]
+			}
+			break
+		}
+	}
+	return title
+}
+
+// isTitleContinuation checks if the line looks like a wrapped
+// part of the title: it starts with a lowercase letter, it is not
+// a properties line and the title is not finished with punctuation
+func isTitleContinuation(keywords *keywordSet, title, line string) bool {
+	if len(title) == 0 || len(line) == 0 {
+		return false
+	}
+	if strings.ContainsAny(title[len(title)-1:], ".!?:;") {
+		return false
+	}
+	if strings.Contains(line, "=") {
+		return false
+	}
+	first := []rune(line)[0]
+	if !unicode.IsLower(first) {
+		return false
+	}
+	_, _, t, _ := parseToDoTitle(keywords, []rune(line))
+	return t == nil
+}
+
+// joinWrappedTitle moves continuation lines of the title from body
+func joinWrappedTitle(keywords *keywordSet, body []string) []string {
+	if len(body) < 2 {
+		return body
+	}
+	title := body[0]
+	i := 1
+	for i < len(body) && isTitleContinuation(keywords, title, body[i]) {
+		title = title + " " + body[i]
+		i++
+	}
+	if i == 1 {
+		return body
+	}
+	return append([]string{title}, body[i:]...)
+}
+
+func (td *ToDoGenerator) accountComment(path string, lineNumber int, ctype, annotation string, confidence float64, body []string) {
 
 	relativePath, err := filepath.Rel(td.root, path)
 	if err != nil {
 		relativePath = path
 	}
+	if td.joinTitles {
+		body = joinWrappedTitle(td.keywords, body)
+	}
+	if td.bodies.StopAtBlank {
+		body = cutAtBlankLine(body)
+	}
 	c := NewComment(relativePath, lineNumber, ctype, body)
+	if c != nil && td.isVendored(path) {
+		td.commentMux.Lock()
+		td.vendoredCount++
+		td.commentMux.Unlock()
+		return
+	}
 	if c != nil {
-		td.commentsWG.Add(1)
-		go td.addComment(c)
+		c.Language = detectLanguage(path)
+		c.Project = td.subproject(path)
+		c.Confidence = confidence
+		c.TitleLanguage = detectNaturalLanguage(c.Title)
+		c.Body = truncateBody(c.Body, td.bodies.MaxLines, td.bodies.MaxLength)
+		parseAnnotation(c, annotation)
+		raw := c.Title
+		td.emoji.Apply(c)
+		c.Title = normalizeTitle(&td.titles, c.Title)
+		if c.Title != raw {
+			c.RawTitle = raw
+		}
+		td.parseTitleEstimate(c)
+		classify(td.classify, c)
+		td.setDefaultEstimate(c)
+		td.addComment(c)
 	}
 }
 
-func (td *ToDoGenerator) parseFile(path string) {
-	defer td.commentsWG.Done()
-	f, err := os.Open(path)
-	if err != nil {
-		log.Print(err)
+// parseContent parses comments from the content of the file at path
+func (td *ToDoGenerator) parseContent(path string, content []byte) {
+	if isBinaryContent(content) {
+		td.skip(path, skipBinary, "")
+		return
+	}
+	if td.skipGenerated && hasGeneratedHeader(bytes.NewReader(content)) && !td.notGenerated(path) {
+		td.countGenerated(path, "header")
+		return
+	}
+	if !td.isVendored(path) {
+		td.commentMux.Lock()
+		td.files[detectLanguage(path)]++
+		td.commentMux.Unlock()
+	} else {
+		td.skip(path, skipVendor, "")
+	}
+	// most files have no keywords at all
+	if !td.prefilter.Match(content) {
 		return
 	}
-	defer f.Close()
-	scanner := bufio.NewScanner(f)
+	syntaxes := newSyntaxTracker(td.fileSyntax(path), detectLanguage(path))
+	continued := false
+	scanner := bufio.NewScanner(bytes.NewReader(content))
 	var todo []string
 	var lastType string
+	var lastAnnotation string
+	var lastConfidence float64
 	var lastStart int
 	lineNumber := 0
 	for scanner.Scan() {
 		line := scanner.Text()
 		lineNumber++
-		if c := parseComment(line); c != nil {
+		if lastType != "" && isSeparatorLine(line) {
+			// decorative banner terminates the comment
+			td.accountComment(path, lastStart, lastType, lastAnnotation, lastConfidence, todo)
+			lastType = ""
+			continue
+		}
+		c := parseComment(line)
+		if continued && c == nil {
+			// comment continued after a backslash (make)
+			c = []rune(strings.TrimSpace(line))
+		}
+		syntax := syntaxes.next(line, c != nil)
+		trailing := false
+		if syntax != nil && syntax.markup {
+			c = syntaxes.markupComment(line)
+		} else if c == nil && syntax != nil && td.trailing {
+			// code may be followed by a comment on the same line
+			c = trailingComment(syntax, td.keywords, line)
+			trailing = c != nil
+		}
+		continued = c != nil && syntax != nil && syntax.continuedComments && hasContinuation(string(c))
+		if continued {
+			c = trimContinuation(c)
+		}
+		if c != nil {
 			// current comment is new TODO-like commment
-			if ctype, title := parseToDoTitle(c); title != nil {
+			if ctype, annotation, title, confidence := parseToDoTitle(td.keywords, c); title != nil {
 				// do we need to finalize previous
 				if lastType != "" {
-					td.accountComment(path, lastStart, lastType, todo)
+					td.accountComment(path, lastStart, lastType, lastAnnotation, lastConfidence, todo)
 				}
 				// construct new one
 				lastType = string(ctype)
+				lastAnnotation = string(annotation)
+				lastConfidence = confidence
 				lastStart = lineNumber - 1
 				todo = make([]string, 0)
 				todo = append(todo, string(title))
-			} else if lastType != "" {
+			} else if lastType != "" && !trailing {
 				// continue consecutive comment line
 				todo = append(todo, string(c))
+			} else if lastType != "" {
+				// code with other comment finalizes too
+				td.accountComment(path, lastStart, lastType, lastAnnotation, lastConfidence, todo)
+				lastType = ""
 			}
 		} else {
 			// not a comment anymore: finalize
 			if lastType != "" {
-				td.accountComment(path, lastStart, lastType, todo)
+				td.accountComment(path, lastStart, lastType, lastAnnotation, lastConfidence, todo)
 				lastType = ""
 			}
 		}
 	}
 	// detect todo item at the end of the file
 	if lastType != "" {
-		td.accountComment(path, lastStart, lastType, todo)
+		td.accountComment(path, lastStart, lastType, lastAnnotation, lastConfidence, todo)
+	}
+}
+
+// ParseDocument returns comments of the single file content, e.g. an
+// unsaved editor buffer, it must not be called concurrently
+func (td *ToDoGenerator) ParseDocument(path string, content []byte) []*ToDoComment {
+	td.commentMux.Lock()
+	td.pending = make([]*ToDoComment, 0)
+	td.commentMux.Unlock()
+	td.parseContent(path, content)
+	return td.collect()
+}
+
+// confidence returns confidence of the match, comments from
+// reports without it are strict matches
+func (c *ToDoComment) confidence() float64 {
+	if c.Confidence < estimateEpsilon {
+		return strictConfidence
 	}
+	return c.Confidence
 }
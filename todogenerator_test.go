@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// writeTree creates a synthetic tree with comments in plain files and
+// files skipped as generated, vendored and binary
+func writeTree(t *testing.T, packages, files int) string {
+	root, err := ioutil.TempDir("", "scorpion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	write := func(name, content string) {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for p := 0; p < packages; p++ {
+		for f := 0; f < files; f++ {
+			write(fmt.Sprintf("pkg%v/file%v.go", p, f), fmt.Sprintf(`package pkg%v
+
+// TODO: handle errors of the call number %v in package %v
+func f%v() {}
+
+// FIXME: this loop is quadratic in file %v of package %v [2h]
+// the body of the comment
+func g%v() {}
+
+// TODO: the same copied comment in every single file
+`, p, f, p, f, f, p, f))
+			write(fmt.Sprintf("pkg%v/file%v.pb.go", p, f), "package pkg\n\n// TODO: generated comment that is never reported\n")
+		}
+		write(fmt.Sprintf("pkg%v/header.go", p), "// Code generated by hand. DO NOT EDIT.\n\n// TODO: generated comment that is never reported\n")
+		write(fmt.Sprintf("pkg%v/data.bin", p), "TODO: binary\x00 content of the file")
+		write(fmt.Sprintf("vendor/lib%v/lib.go", p), "package lib\n\n// TODO: vendored comment that is never reported\n")
+	}
+	return root
+}
+
+// scanTree generates comments and skips of the tree like a scan with
+// --explain-skips
+func scanTree(t *testing.T, root string) ([]*ToDoComment, []*SkippedPath) {
+	td, err := NewToDoGenerator(root, nil, 3, 30, NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	comments, err := td.Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return comments, td.Skips()
+}
+
+func setScanFlags(t *testing.T, ioWorkers, cpuWorkers int) {
+	saved := []int{ioWorkersFlag, cpuWorkersFlag, maxDepthFlag}
+	savedSkips := explainSkipsFlag
+	ioWorkersFlag, cpuWorkersFlag, maxDepthFlag = ioWorkers, cpuWorkers, -1
+	explainSkipsFlag = "-"
+	t.Cleanup(func() {
+		ioWorkersFlag, cpuWorkersFlag, maxDepthFlag = saved[0], saved[1], saved[2]
+		explainSkipsFlag = savedSkips
+	})
+}
+
+func mustJSON(t *testing.T, v interface{}) string {
+	js, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(js)
+}
+
+// TestGenerateDeterministic checks that comments and skips do not
+// depend on the number of workers, run it with -race
+func TestGenerateDeterministic(t *testing.T) {
+	root := writeTree(t, 8, 12)
+	defer os.RemoveAll(root)
+	setScanFlags(t, 1, 1)
+	comments, skips := scanTree(t, root)
+	// 2 own comments and a copied one of every file
+	if expected := 8 * 12 * 3; len(comments) != expected {
+		t.Fatalf("Generated %v comments, expected %v", len(comments), expected)
+	}
+	reasons := make(map[string]bool)
+	for _, s := range skips {
+		reasons[s.Reason] = true
+	}
+	for _, reason := range []string{skipGenerated, skipBinary, skipVendor} {
+		if !reasons[reason] {
+			t.Fatalf("No paths skipped as %v", reason)
+		}
+	}
+	expected, expectedSkips := mustJSON(t, comments), mustJSON(t, skips)
+	for _, workers := range []int{2, 8, 32} {
+		setScanFlags(t, workers, workers)
+		comments, skips := scanTree(t, root)
+		if js := mustJSON(t, comments); js != expected {
+			t.Errorf("Comments with %v workers differ from the sequential scan", workers)
+		}
+		if js := mustJSON(t, skips); js != expectedSkips {
+			t.Errorf("Skips with %v workers differ from the sequential scan", workers)
+		}
+	}
+}
+
+// TestGenerateConcurrentScans runs scans of the same tree at the same
+// time, as serve mode and the daemon do
+func TestGenerateConcurrentScans(t *testing.T) {
+	root := writeTree(t, 4, 25)
+	defer os.RemoveAll(root)
+	setScanFlags(t, 8, 4)
+	expected, _ := scanTree(t, root)
+	var wg sync.WaitGroup
+	results := make([][]*ToDoComment, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i], _ = scanTree(t, root)
+		}(i)
+	}
+	wg.Wait()
+	for i, comments := range results {
+		if mustJSON(t, comments) != mustJSON(t, expected) {
+			t.Errorf("Scan %v differs from the first one", i)
+		}
+	}
+}
+
+// TestCollectConcurrentAdds checks that comments added from many
+// goroutines are sorted by location and deduplicated
+func TestCollectConcurrentAdds(t *testing.T) {
+	td, err := NewToDoGenerator(os.TempDir(), nil, 0, 0, NewConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for f := 0; f < 16; f++ {
+		wg.Add(1)
+		go func(f int) {
+			defer wg.Done()
+			for line := 0; line < 50; line++ {
+				c := NewComment(fmt.Sprintf("file%02d.go", f), line, "TODO", []string{fmt.Sprintf("comment %v of file %v", line, f)})
+				td.addComment(c)
+				// overlapping scanned paths find the comment again
+				copied := *c
+				td.addComment(&copied)
+				td.skip(filepath.Join(td.root, c.File), skipFilter, "")
+			}
+		}(f)
+	}
+	wg.Wait()
+	comments := td.collect()
+	if len(comments) != 16*50 {
+		t.Fatalf("Collected %v comments, expected %v", len(comments), 16*50)
+	}
+	for i := 1; i < len(comments); i++ {
+		a, b := comments[i-1], comments[i]
+		if a.File > b.File || (a.File == b.File && a.Line >= b.Line) {
+			t.Fatalf("Comments are not sorted: %v:%v before %v:%v", a.File, a.Line, b.File, b.Line)
+		}
+	}
+}
+
+func TestParseEstimate(t *testing.T) {
+	tests := []struct {
+		estimate string
+		hours    float64
+		err      error
+	}{
+		{"2h", 2, nil},
+		{"2", 2, nil},
+		{"30m", 0.5, nil},
+		{"1.5d", 12, nil},
+		{"1w", 40, nil},
+		{"PT90M", 1.5, nil},
+		{"P1DT2H", 10, nil},
+		{"", 0, errCannotParseEstimate},
+		{"2y", 0, errCannotParseEstimate},
+		{"john", 0, errCannotParseEstimate},
+		{"NaNh", 0, errInvalidEstimate},
+		{"infh", 0, errInvalidEstimate},
+		{"+Infd", 0, errInvalidEstimate},
+		{"-3h", 0, errInvalidEstimate},
+		{"0h", 0, errInvalidEstimate},
+		{"PT0H", 0, errInvalidEstimate},
+	}
+	for _, tt := range tests {
+		hours, err := parseEstimate(tt.estimate)
+		if err != tt.err || hours != tt.hours {
+			t.Errorf("parseEstimate(%q) = %v, %v, expected %v, %v", tt.estimate, hours, err, tt.hours, tt.err)
+		}
+	}
+}
+
+// TestTitleEstimates checks estimates and assignees of the title
+// syntaxes: annotation, title pattern and properties line
+func TestTitleEstimates(t *testing.T) {
+	tests := []struct {
+		comment  string
+		estimate float64
+		assignee string
+		title    string
+	}{
+		{"TODO(2h): handle errors of the call here", 2, "", "handle errors of the call here"},
+		{"TODO(john): handle errors of the call here", 0, "john", "handle errors of the call here"},
+		{"TODO(@john): handle errors of the call here", 0, "john", "handle errors of the call here"},
+		{"TODO(NaNh): handle errors of the call here", 0, "", "handle errors of the call here"},
+		{"TODO(infh): handle errors of the call here", 0, "", "handle errors of the call here"},
+		{"TODO(-3h): handle errors of the call here", 0, "", "handle errors of the call here"},
+		{"TODO: handle errors of the call here [3d]", 24, "", "handle errors of the call here"},
+		{"TODO: handle errors of the call here [0h]", 0, "", "handle errors of the call here [0h]"},
+		{"TODO(1h): handle errors of the call here [3d]", 1, "", "handle errors of the call here [3d]"},
+		{"TODO: handle errors of the call here\n// estimate=30m", 0.5, "", "handle errors of the call here"},
+		{"TODO: handle errors of the call here\n// estimate=-1h", 0, "", "handle errors of the call here"},
+	}
+	root, err := ioutil.TempDir("", "scorpion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	for i, tt := range tests {
+		content := "package a\n\n// " + tt.comment + "\nfunc f() {}\n"
+		if err := ioutil.WriteFile(filepath.Join(root, fmt.Sprintf("file%02d.go", i)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	setScanFlags(t, 1, 1)
+	comments, _ := scanTree(t, root)
+	if len(comments) != len(tests) {
+		t.Fatalf("Generated %v comments, expected %v", len(comments), len(tests))
+	}
+	for i, tt := range tests {
+		c := comments[i]
+		if c.Estimate != tt.estimate || c.Assignee != tt.assignee || c.Title != tt.title {
+			t.Errorf("%q: estimate %v, assignee %q, title %q, expected %v, %q, %q",
+				tt.comment, c.Estimate, c.Assignee, c.Title, tt.estimate, tt.assignee, tt.title)
+		}
+	}
+}
+
+func TestInvalidDefaultEstimates(t *testing.T) {
+	for _, estimate := range []string{"2x", "-1h", "NaNh"} {
+		config := NewConfig()
+		config.Estimates.Defaults = map[string]string{"TODO": estimate}
+		if _, err := NewToDoGenerator(os.TempDir(), nil, 0, 0, config); err == nil {
+			t.Errorf("Default estimate %v is accepted", estimate)
+		}
+		if errs := validateConfig(config); len(errs) == 0 {
+			t.Errorf("Doctor does not report default estimate %v", estimate)
+		}
+	}
+}
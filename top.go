@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const (
+	topByAge      = "age"
+	topByEstimate = "estimate"
+	topBySeverity = "severity"
+)
+
+// topOrders are sort fields of the criteria of "scorpion top", the most
+// critical comments come first
+var topOrders = map[string]string{
+	topByAge:      "-age,-severity",
+	topByEstimate: "-estimate,-severity,-age",
+	topBySeverity: "-severity,-age,-estimate",
+}
+
+// commandFilter returns filter of --filter queries, e.g.
+// "type=BUG,FIXME&minAge=30d", like the comments API of serve mode
+func commandFilter() (*CommentFilter, error) {
+	q, err := url.ParseQuery(strings.Join(filterFlag, "&"))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse filter: %v", err)
+	}
+	return parseFilterQuery(q)
+}
+
+// commandComments scans the paths of the command with blame (for ages
+// and permalinks) and returns comments matching --filter with the
+// report for its display and theme
+func commandComments(args []string) ([]*ToDoComment, *result, error) {
+	if err := resolveScanPaths(args); err != nil {
+		return nil, nil, err
+	}
+	filter, err := commandFilter()
+	if err != nil {
+		return nil, nil, err
+	}
+	config, err := loadConfig(configPathFlag, srcRootFlag)
+	if err != nil {
+		return nil, nil, err
+	}
+	blameFlag = true
+	report, err := buildReport(&config.Pipeline, config, NewEnvironment(srcRootFlag))
+	if err != nil {
+		return nil, nil, err
+	}
+	return filter.Apply(report.Comments, time.Now()), report, nil
+}
+
+// commentAge returns time since the comment was committed, empty
+// without blame
+func commentAge(c *ToDoComment, now time.Time, hf *humanFormat) string {
+	if c.Blame == nil || c.Blame.Date.IsZero() {
+		return ""
+	}
+	return hf.Age(now.Sub(c.Blame.Date))
+}
+
+func printTop(w io.Writer, comments []*ToDoComment, hf *humanFormat, t *theme) error {
+	if verboseFlag {
+		js, err := json.MarshalIndent(comments, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(js))
+		return err
+	}
+	now := time.Now()
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "#\t%v\tAGE\tESTIMATE\tTITLE\tLOCATION\n", t.Header("TYPE"))
+	for i, c := range comments {
+		estimate := ""
+		if c.Estimate >= estimateEpsilon {
+			estimate = hf.Estimate(c.Estimate)
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\n", i+1, t.Paint(c.Type, c.Type), commentAge(c, now, hf), estimate, c.Title, commentLocation(c))
+	}
+	return tw.Flush()
+}
+
+// runTop implements "scorpion top [paths]", it prints the --top most
+// critical comments by --by for grooming sessions
+func runTop(args []string) error {
+	order, ok := topOrders[topByFlag]
+	if !ok {
+		return fmt.Errorf("Unknown top criteria: %v, use age, estimate or severity", topByFlag)
+	}
+	comments, report, err := commandComments(args)
+	if err != nil {
+		return err
+	}
+	if err := sortComments(comments, order); err != nil {
+		return err
+	}
+	if topByFlag == topByAge {
+		// uncommitted comments are the youngest, not the oldest
+		committed := make([]*ToDoComment, 0, len(comments))
+		uncommitted := make([]*ToDoComment, 0)
+		for _, c := range comments {
+			if blameDate(c).IsZero() {
+				uncommitted = append(uncommitted, c)
+			} else {
+				committed = append(committed, c)
+			}
+		}
+		comments = append(committed, uncommitted...)
+	}
+	if topCountFlag >= 0 && len(comments) > topCountFlag {
+		comments = comments[:topCountFlag]
+	}
+	return printTop(os.Stdout, comments, report.display, report.theme)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+const (
+	trackerTransformerName = "tracker"
+	trackerClosed          = "closed"
+)
+
+// TrackerIssue is the state of the tracker issue linked to a comment,
+// imported by the tracker transformer
+type TrackerIssue struct {
+	Number    int      `json:"number"`
+	URL       string   `json:"url,omitempty"`
+	State     string   `json:"state"`
+	Assignees []string `json:"assignees,omitempty"`
+	Labels    []string `json:"labels,omitempty"`
+}
+
+// Closed checks if the issue is done while its comment is still present
+func (t *TrackerIssue) Closed() bool {
+	return t != nil && t.State == trackerClosed
+}
+
+type githubIssueStatus struct {
+	Number    int    `json:"number"`
+	HTMLURL   string `json:"html_url"`
+	State     string `json:"state"`
+	Assignees []struct {
+		Login string `json:"login"`
+	} `json:"assignees"`
+	Labels []struct {
+		Name string `json:"name"`
+	} `json:"labels"`
+}
+
+// fetchGitHubIssue returns state of the issue, public repositories
+// are read without a token
+func fetchGitHubIssue(repo string, number int, token string) (*TrackerIssue, error) {
+	headers := map[string]string{"Accept": "application/vnd.github.v3+json"}
+	if len(token) > 0 {
+		headers = githubHeaders(token)
+	}
+	status := &githubIssueStatus{}
+	endpoint := fmt.Sprintf("%v/repos/%v/issues/%v", githubAPI, repo, number)
+	if err := doJSONRequest("GET", endpoint, headers, nil, status); err != nil {
+		return nil, err
+	}
+	issue := &TrackerIssue{Number: number, URL: status.HTMLURL, State: status.State}
+	for _, a := range status.Assignees {
+		issue.Assignees = append(issue.Assignees, a.Login)
+	}
+	for _, l := range status.Labels {
+		issue.Labels = append(issue.Labels, l.Name)
+	}
+	return issue, nil
+}
+
+// trackerTransformer imports state of issues linked to comments, with
+// issue= or in the state file of the github sink. Comments of closed
+// issues are logged, or removed with --hide-closed
+func trackerTransformer(tc *TransformerConfig, config *Config, env *Environment, report *result) error {
+	repo := tc.Repo
+	if len(repo) == 0 {
+		repo = githubRepo(env)
+	}
+	if len(repo) == 0 {
+		return errNoGitHubRepo
+	}
+	reconciler, err := newIssueReconciler(githubTargetPrefix+repo, tc.State, env.root)
+	if err != nil {
+		return err
+	}
+	token, err := NewSecrets(config).Get(githubSecret)
+	if err != nil {
+		log.Printf("Reading issues of %v without a token: %v", repo, err)
+	}
+	issues := make(map[int]*TrackerIssue)
+	for _, c := range report.Comments {
+		if stopRequested() {
+			break
+		}
+		number := c.Issue
+		if record, ok := reconciler.records[c.ID]; ok && number == 0 {
+			number = record.Number
+		}
+		if number == 0 {
+			continue
+		}
+		issue, ok := issues[number]
+		if !ok {
+			issue, err = fetchGitHubIssue(repo, number, token)
+			if err != nil {
+				return fmt.Errorf("Cannot fetch issue #%v: %v", number, err)
+			}
+			issues[number] = issue
+		}
+		c.Tracker = issue
+		if issue.Closed() && !hideClosedFlag {
+			log.Printf("Issue #%v is closed but its comment is still in %v", number, commentLocation(c))
+		}
+	}
+	if !hideClosedFlag {
+		return nil
+	}
+	open := make([]*ToDoComment, 0, len(report.Comments))
+	for _, c := range report.Comments {
+		if !c.Tracker.Closed() {
+			open = append(open, c)
+		}
+	}
+	if len(open) < len(report.Comments) {
+		log.Printf("Hiding %v comments of closed issues", len(report.Comments)-len(open))
+		report.Comments = open
+		report.Stats = refreshStats(report)
+	}
+	return nil
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// commentMarker starts a comment following code on the same line,
+// end closes block comments ("/* TODO: ... */")
+type commentMarker struct {
+	start []rune
+	end   []rune
+}
+
+// lineSyntax describes comments of a language that can follow code
+type lineSyntax struct {
+	markers []*commentMarker
+	// quotes start and end string literals, backslash escapes
+	// in them unless the quote is raw
+	quotes []rune
+	raw    []rune
+	// spaced markers must follow whitespace ("echo a#b" in shell)
+	spaced bool
+	// escapes are backslashes escaping markers outside of strings too
+	escapes bool
+	// scalarQuotes only start strings at the start of a value, plain
+	// values may contain them ("msg: don't # TODO: ..." in YAML)
+	scalarQuotes bool
+	// continuedComments continue on the next line after a backslash
+	continuedComments bool
+	// markup lines are comments only between the markers, which may
+	// span several lines (HTML)
+	markup bool
+	// regions of markup embed code of other languages
+	regions []*codeRegion
+}
+
+// commentMarkers returns markers of start and end pairs
+func commentMarkers(pairs ...string) []*commentMarker {
+	markers := make([]*commentMarker, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		markers = append(markers, &commentMarker{
+			start: []rune(pairs[i]),
+			end:   []rune(pairs[i+1]),
+		})
+	}
+	return markers
+}
+
+func newLineSyntax(quotes, raw string, spaced bool, markers ...string) *lineSyntax {
+	return &lineSyntax{
+		markers: commentMarkers(markers...),
+		quotes:  []rune(quotes),
+		raw:     []rune(raw),
+		spaced:  spaced,
+	}
+}
+
+var (
+	cSyntax      = newLineSyntax(`"'`, "", false, "//", "", "/*", "*/")
+	hashSyntax   = newLineSyntax(`"'`, "", false, "#", "")
+	dashSyntax   = newLineSyntax(`"'`, "", false, "--", "")
+	lispSyntax   = newLineSyntax(`"`, "", false, ";", "")
+	scriptSyntax = newLineSyntax(`"'`, "`", false, "//", "", "/*", "*/")
+	shellSyntax  = &lineSyntax{
+		markers: commentMarkers("#", ""),
+		quotes:  []rune(`"`),
+		raw:     []rune("'"),
+		spaced:  true,
+		escapes: true,
+	}
+	// quotes are not special for make
+	makeSyntax = &lineSyntax{
+		markers:           commentMarkers("#", ""),
+		spaced:            true,
+		escapes:           true,
+		continuedComments: true,
+	}
+	yamlSyntax = &lineSyntax{
+		markers:      commentMarkers("#", ""),
+		quotes:       []rune(`"'`),
+		spaced:       true,
+		scalarQuotes: true,
+	}
+	cssSyntax = newLineSyntax(`"'`, "", false, "/*", "*/")
+	// apostrophes are common in text
+	markupSyntax = &lineSyntax{
+		markers: commentMarkers("<!--", "-->"),
+		markup:  true,
+	}
+	// dockerfile comments only take whole lines
+	dockerSyntax = newLineSyntax("", "", false)
+
+	// lineSyntaxes are used to find comments after code, files of
+	// other languages only have comments on lines of their own
+	lineSyntaxes = map[string]*lineSyntax{
+		"C": cSyntax, "C#": cSyntax, "C++": cSyntax, "Groovy": cSyntax, "Java": cSyntax,
+		"Kotlin": cSyntax, "Objective-C": cSyntax, "Protocol Buffers": cSyntax,
+		"Scala": cSyntax, "Swift": cSyntax,
+		"Go":         newLineSyntax(`"'`, "`", false, "//", "", "/*", "*/"),
+		"JavaScript": scriptSyntax, "TypeScript": scriptSyntax,
+		// 'a is a lifetime
+		"Rust": newLineSyntax(`"`, "", false, "//", "", "/*", "*/"),
+		"CSS":  cssSyntax,
+		"Less": cSyntax, "SCSS": cSyntax,
+		"CMake": hashSyntax, "Elixir": hashSyntax, "PowerShell": hashSyntax,
+		"Python": hashSyntax, "R": hashSyntax, "Ruby": hashSyntax, "TOML": hashSyntax,
+		"Terraform": newLineSyntax(`"`, "", false, "#", "", "//", "", "/*", "*/"),
+		"Shell":     shellSyntax,
+		// $#array is the last index
+		"Perl": newLineSyntax(`"'`, "", true, "#", ""),
+		"YAML": yamlSyntax, "Makefile": makeSyntax, "Dockerfile": dockerSyntax,
+		"Lua": dashSyntax, "SQL": dashSyntax,
+		// x' is a name
+		"Haskell": newLineSyntax(`"`, "", false, "--", ""),
+		"Erlang":  newLineSyntax(`"'`, "", false, "%", ""),
+		"Clojure": lispSyntax, "Emacs Lisp": lispSyntax, "Lisp": lispSyntax,
+		"HTML": markupSyntax, "Svelte": markupSyntax, "Vue": markupSyntax,
+	}
+	// shellCommands check if the line starts a shell command in files
+	// of the language, commands continue on lines after a backslash
+	shellCommands = map[string]func(line string) bool{
+		"Makefile":   isRecipeLine,
+		"Dockerfile": isRunInstruction,
+	}
+	// regionStartPattern and regionEndPattern find script and style
+	// regions of markup files, which use the syntax of their languages
+	regionStartPattern = regexp.MustCompile(`(?i)<(script|style)\b([^>]*)>`)
+	regionEndPattern   = regexp.MustCompile(`(?i)</(script|style)\s*>`)
+	regionLangPattern  = regexp.MustCompile(`(?i)\blang\s*=\s*["']?([a-z]+)`)
+)
+
+// isRecipeLine checks if the makefile line is a tab-indented recipe
+func isRecipeLine(line string) bool {
+	return strings.HasPrefix(line, "\t")
+}
+
+// isRunInstruction checks if the dockerfile line is a RUN instruction
+func isRunInstruction(line string) bool {
+	fields := strings.Fields(line)
+	return len(fields) > 1 && strings.EqualFold(fields[0], "RUN")
+}
+
+func hasContinuation(line string) bool {
+	return strings.HasSuffix(strings.TrimRightFunc(line, unicode.IsSpace), "\\")
+}
+
+// regionSyntax returns syntax of the script or style region
+// with the attributes of the opening tag
+func regionSyntax(tag, attributes string) *lineSyntax {
+	lang := ""
+	if m := regionLangPattern.FindStringSubmatch(attributes); m != nil {
+		lang = strings.ToLower(m[1])
+	}
+	if strings.EqualFold(tag, "script") {
+		return scriptSyntax
+	}
+	switch lang {
+	case "scss", "sass", "less", "stylus":
+		return cSyntax
+	}
+	return cssSyntax
+}
+
+// syntaxTracker finds syntax of the lines of a file, which changes
+// for shell commands of makefiles and dockerfiles and for script,
+// style and code regions of markup files
+type syntaxTracker struct {
+	syntax  *lineSyntax
+	command func(line string) bool
+	// shell is set while the shell command continues
+	shell     bool
+	continued bool
+	// region is syntax of the current embedded region, regionEnd
+	// closes it unless it is a script or style
+	region    *lineSyntax
+	regionEnd string
+	// open is the marker of the continuing markup comment
+	open *commentMarker
+}
+
+func newSyntaxTracker(syntax *lineSyntax, language string) *syntaxTracker {
+	return &syntaxTracker{
+		syntax:  syntax,
+		command: shellCommands[language],
+	}
+}
+
+// next returns syntax of the following line of the file, nil if it
+// is not known. Commented lines do not end continued commands, as
+// dockerfile comments are removed before the continuation is joined
+func (t *syntaxTracker) next(line string, comment bool) *lineSyntax {
+	if t.syntax != nil && t.syntax.markup {
+		return t.nextRegion(line)
+	}
+	if !comment {
+		if !t.continued && t.command != nil {
+			t.shell = t.command(line)
+		}
+		t.continued = hasContinuation(line)
+	}
+	if t.shell {
+		return shellSyntax
+	}
+	return t.syntax
+}
+
+// nextRegion switches syntax of markup files on lines with tags,
+// which are markup themselves
+func (t *syntaxTracker) nextRegion(line string) *lineSyntax {
+	if t.region != nil {
+		if t.closesRegion(line) {
+			t.region = nil
+			t.regionEnd = ""
+			return t.syntax
+		}
+		return t.region
+	}
+	// all regions start with a tag
+	if t.open != nil || !strings.ContainsRune(line, '<') {
+		return t.syntax
+	}
+	// single-line regions like <script src="..."></script> are skipped
+	for _, r := range t.syntax.regions {
+		if i := strings.Index(line, r.start); i >= 0 && !strings.Contains(line[i+len(r.start):], r.end) {
+			t.region = r.syntax
+			t.regionEnd = r.end
+			return t.syntax
+		}
+	}
+	if m := regionStartPattern.FindStringSubmatchIndex(line); m != nil && !regionEndPattern.MatchString(line[m[1]:]) {
+		t.region = regionSyntax(line[m[2]:m[3]], line[m[4]:m[5]])
+	}
+	return t.syntax
+}
+
+func (t *syntaxTracker) closesRegion(line string) bool {
+	if len(t.regionEnd) > 0 {
+		return strings.Contains(line, t.regionEnd)
+	}
+	return strings.ContainsRune(line, '<') && regionEndPattern.MatchString(line)
+}
+
+// markupComment returns text of the markup comment on the line or
+// continuing from the previous lines, nil if there is none
+func (t *syntaxTracker) markupComment(line string) []rune {
+	text := []rune(line)
+	if t.open == nil {
+		start := -1
+		for _, m := range t.syntax.markers {
+			i := indexRunes(text, m.start)
+			if i >= 0 && (start < 0 || i < start || (i == start && len(m.start) > len(t.open.start))) {
+				start = i
+				t.open = m
+			}
+		}
+		if t.open == nil {
+			return nil
+		}
+		text = text[start+len(t.open.start):]
+	}
+	if j := indexRunes(text, t.open.end); j >= 0 {
+		text = text[:j]
+		t.open = nil
+	}
+	for len(text) > 0 && unicode.IsSpace(text[0]) {
+		text = text[1:]
+	}
+	for len(text) > 0 && unicode.IsSpace(text[len(text)-1]) {
+		text = text[:len(text)-1]
+	}
+	return text
+}
+
+// indexRunes returns index of the first sub in runes, -1 if there is none
+func indexRunes(runes, sub []rune) int {
+	for i := range runes {
+		if hasRunePrefix(runes[i:], sub) {
+			return i
+		}
+	}
+	return -1
+}
+
+func hasRunePrefix(s, prefix []rune) bool {
+	if len(s) < len(prefix) {
+		return false
+	}
+	for i, r := range prefix {
+		if s[i] != r {
+			return false
+		}
+	}
+	return true
+}
+
+func containsRune(runes []rune, r rune) bool {
+	return indexRune(runes, r) >= 0
+}
+
+// markerIndex returns index of the first comment marker outside of
+// string literals before limit and the marker, -1 if there is none
+func (s *lineSyntax) markerIndex(runes []rune, limit int) (int, *commentMarker) {
+	var quote rune
+	for i := 0; i < limit; i++ {
+		r := runes[i]
+		if quote != 0 {
+			if r == '\\' && !containsRune(s.raw, quote) {
+				i++
+			} else if r == quote {
+				quote = 0
+			}
+			continue
+		}
+		if r == '\\' && s.escapes {
+			i++
+			continue
+		}
+		if (containsRune(s.quotes, r) || containsRune(s.raw, r)) && (!s.scalarQuotes || startsValue(runes[:i])) {
+			quote = r
+			continue
+		}
+		if s.spaced && i > 0 && !unicode.IsSpace(runes[i-1]) {
+			continue
+		}
+		for _, m := range s.markers {
+			if hasRunePrefix(runes[i:], m.start) {
+				return i, m
+			}
+		}
+	}
+	return -1, nil
+}
+
+// startsValue checks if a YAML scalar may start after the runes
+func startsValue(runes []rune) bool {
+	for i := len(runes) - 1; i >= 0; i-- {
+		if !unicode.IsSpace(runes[i]) {
+			return strings.ContainsRune(":-[{,?", runes[i])
+		}
+	}
+	return true
+}
+
+// trimContinuation removes the backslash continuing the comment text
+func trimContinuation(text []rune) []rune {
+	text = text[:len(text)-1]
+	for len(text) > 0 && unicode.IsSpace(text[len(text)-1]) {
+		text = text[:len(text)-1]
+	}
+	return text
+}
+
+// trailingComment returns text of the comment following code on the
+// line if it may contain a keyword, nil otherwise
+func trailingComment(syntax *lineSyntax, keywords *keywordSet, line string) []rune {
+	runes := []rune(line)
+	// the keyword starting the comment is the last one at the latest
+	last := -1
+	keywords.find(runes, func(start int, kw *keyword) bool {
+		last = start
+		return true
+	})
+	if last < 0 {
+		return nil
+	}
+	i, m := syntax.markerIndex(runes, last)
+	if i < 0 {
+		return nil
+	}
+	text := runes[i+len(m.start):]
+	if j := indexRunes(text, m.end); len(m.end) > 0 && j >= 0 {
+		text = text[:j]
+	}
+	for len(text) > 0 && (unicode.IsSpace(text[0]) || isCommentRune(text[0])) {
+		text = text[1:]
+	}
+	for len(text) > 0 && unicode.IsSpace(text[len(text)-1]) {
+		text = text[:len(text)-1]
+	}
+	return text
+}
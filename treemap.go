@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	treemapSinkName = "treemap"
+	// hotspotCount is number of directories in hotspots of HTML reports
+	hotspotCount = 10
+)
+
+// TreemapNode is a directory or a file of the treemap with totals of
+// its comments. It has the shape of d3.hierarchy data, leaves have
+// value so that sum() of D3 weights them by comments
+type TreemapNode struct {
+	Name     string         `json:"name"`
+	Path     string         `json:"path"`
+	Comments int            `json:"comments"`
+	Estimate float64        `json:"estimate,omitempty"`
+	Cost     float64        `json:"cost,omitempty"`
+	Types    map[string]int `json:"types"`
+	Value    int            `json:"value,omitempty"`
+	Children []*TreemapNode `json:"children,omitempty"`
+}
+
+// child returns the child of the node named name, created if needed
+func (n *TreemapNode) child(name string) *TreemapNode {
+	for _, c := range n.Children {
+		if c.Name == name {
+			return c
+		}
+	}
+	c := &TreemapNode{Name: name, Path: path.Join(n.Path, name), Types: make(map[string]int)}
+	n.Children = append(n.Children, c)
+	return c
+}
+
+func (n *TreemapNode) add(c *ToDoComment, cost float64) {
+	n.Comments++
+	n.Estimate += c.Estimate
+	n.Cost += cost
+	n.Types[c.Type]++
+}
+
+// sort orders children by number of comments like groups of stats
+func (n *TreemapNode) sort() {
+	sort.Slice(n.Children, func(i, j int) bool {
+		a, b := n.Children[i], n.Children[j]
+		if a.Comments != b.Comments {
+			return a.Comments > b.Comments
+		}
+		return a.Name < b.Name
+	})
+	for _, c := range n.Children {
+		c.sort()
+	}
+}
+
+// buildTreemap returns hierarchy of directories and files of the
+// comments, the root is named by the project
+func buildTreemap(report *result) *TreemapNode {
+	root := &TreemapNode{Name: report.Project, Types: make(map[string]int)}
+	for _, c := range report.Comments {
+		cost := report.costs.Cost(c)
+		node := root
+		node.add(c, cost)
+		for _, name := range strings.Split(path.Clean(c.File), "/") {
+			node = node.child(name)
+			node.add(c, cost)
+		}
+		node.Value = node.Comments
+	}
+	root.sort()
+	return root
+}
+
+// hotspots returns directories of the treemap with most comments
+func hotspots(root *TreemapNode, n int) []*TreemapNode {
+	dirs := make([]*TreemapNode, 0)
+	var walk func(node *TreemapNode)
+	walk = func(node *TreemapNode) {
+		for _, c := range node.Children {
+			if len(c.Children) > 0 {
+				dirs = append(dirs, c)
+				walk(c)
+			}
+		}
+	}
+	walk(root)
+	sort.SliceStable(dirs, func(i, j int) bool { return dirs[i].Comments > dirs[j].Comments })
+	if len(dirs) > n {
+		dirs = dirs[:n]
+	}
+	return dirs
+}
+
+// treemapSink writes the treemap as json to visualize where debt
+// concentrates, e.g. with d3.treemap
+type treemapSink struct {
+	path string
+}
+
+func newTreemapSink(sc *SinkConfig, env *Environment) (Sink, error) {
+	return &treemapSink{path: sc.Path}, nil
+}
+
+func (s *treemapSink) Emit(report *result, plan *Plan) error {
+	var js []byte
+	var err error
+	if verboseFlag {
+		js, err = json.MarshalIndent(buildTreemap(report), "", "  ")
+	} else {
+		js, err = json.Marshal(buildTreemap(report))
+	}
+	if err != nil {
+		return err
+	}
+	if len(s.path) == 0 {
+		plan.Add(&PlanAction{Kind: planStdout, Target: "stdout", Summary: "treemap", Content: string(js) + "\n"})
+		return nil
+	}
+	plan.Add(&PlanAction{Kind: planWriteFile, Target: s.path, Summary: "treemap", Content: string(js)})
+	return nil
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	uploadSinkName = "upload"
+	planUpload     = "upload"
+	gcsSecret      = "gcs"
+	azureSecret    = "azure"
+	awsSecret      = "aws"
+	gcsUploadAPI   = "https://storage.googleapis.com/upload/storage/v1/b"
+	// htmlReportText is a standalone page of the report
+	htmlReportText = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Project}} TODO</title>
+<style>body{font-family:sans-serif}td,th{padding:2px 8px;text-align:left;vertical-align:top}.badge{border-radius:8px;padding:0 6px}</style>
+</head>
+<body>
+<h1>{{.Project}}</h1>
+<p>{{len .Comments}} comments{{if .Branch}} on {{.Branch}}{{end}}{{if .Revision}} at {{.Revision}}{{end}}{{if priced}}, {{total}}{{end}}</p>
+<table>
+<tr><th>Type</th><th>Title</th><th>Location</th><th>Estimate</th>{{if priced}}<th>Cost</th>{{end}}</tr>
+{{range .Comments}}<tr><td><span class="badge" style="background:{{badge .Type}};color:{{ink .Type}}">{{.Type}}</span></td><td>{{.Title}}</td><td>{{.File}}:{{inc .Line}}</td><td>{{if .Estimate}}{{estimate .Estimate}}{{end}}</td>{{if priced}}<td>{{if .Estimate}}{{cost .}}{{end}}</td>{{end}}</tr>
+{{end}}</table>
+{{with hotspots}}<h2>Hotspots</h2>
+<table>
+<tr><th>Directory</th><th>Comments</th><th>Estimate</th></tr>
+{{range .}}<tr><td>{{.Path}}</td><td>{{.Comments}}</td><td>{{if .Estimate}}{{estimate .Estimate}}{{end}}</td></tr>
+{{end}}</table>
+{{end}}<script type="application/json" id="treemap">{{treemap}}</script>
+</body>
+</html>
+`
+)
+
+var (
+	errUnknownStorage = errors.New(`Upload path must start with "s3://", "gs://" or "azblob://"`)
+	errNoAWSKeys      = errors.New("AWS credentials are not set, use AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY or secret aws (\"id:secret\")")
+	errNoAWSRegion    = errors.New("AWS region is not set, use AWS_REGION")
+)
+
+// uploadKeyData is available in templates of object paths
+type uploadKeyData struct {
+	Project  string
+	Branch   string
+	Revision string
+	// Date is "2006-01-02" and Time "20060102T150405Z" in UTC
+	Date string
+	Time string
+}
+
+// uploadSink uploads the report to cloud storage, the path is a
+// template of the object url ("s3://bucket/{{.Project}}/{{.Date}}.json")
+type uploadSink struct {
+	path   *template.Template
+	format string
+}
+
+func newUploadSink(sc *SinkConfig, env *Environment) (Sink, error) {
+	if !strings.HasPrefix(sc.Path, "s3://") && !strings.HasPrefix(sc.Path, "gs://") && !strings.HasPrefix(sc.Path, "azblob://") {
+		return nil, errUnknownStorage
+	}
+	t, err := template.New("path").Parse(sc.Path)
+	if err != nil {
+		return nil, err
+	}
+	format := sc.Format
+	if len(format) == 0 {
+		format = jsonSinkName
+	}
+	if format != jsonSinkName && format != markdownSinkName && format != "html" {
+		return nil, fmt.Errorf("Unknown upload format: %v", format)
+	}
+	return &uploadSink{path: t, format: format}, nil
+}
+
+// renderReport returns the report in the format of the sink
+func (s *uploadSink) renderReport(report *result) ([]byte, error) {
+	switch s.format {
+	case markdownSinkName:
+		return renderTodoFile(*report)
+	case "html":
+		treemap := buildTreemap(report)
+		t := htmltemplate.Must(htmltemplate.New("report").
+			Funcs(htmltemplate.FuncMap{
+				"inc":      func(i int) int { return i + 1 },
+				"badge":    report.theme.Badge,
+				"ink":      report.theme.Ink,
+				"estimate": report.display.Estimate,
+				"priced":   func() bool { return report.costs != nil },
+				"cost":     func(c *ToDoComment) string { return report.costs.Format(report.costs.Cost(c)) },
+				"total":    func() string { return report.costs.Format(report.costs.total(report.Comments)) },
+				"hotspots": func() []*TreemapNode { return hotspots(treemap, hotspotCount) },
+				"treemap":  func() *TreemapNode { return treemap },
+			}).
+			Parse(htmlReportText))
+		var buf bytes.Buffer
+		err := t.Execute(&buf, report)
+		return buf.Bytes(), err
+	}
+	return json.Marshal(report)
+}
+
+func (s *uploadSink) Emit(report *result, plan *Plan) error {
+	now := time.Now().UTC()
+	var target bytes.Buffer
+	err := s.path.Execute(&target, &uploadKeyData{
+		Project:  report.Project,
+		Branch:   report.Branch,
+		Revision: report.Revision,
+		Date:     now.Format("2006-01-02"),
+		Time:     now.Format(storeTimeFormat),
+	})
+	if err != nil {
+		return err
+	}
+	content, err := s.renderReport(report)
+	if err != nil {
+		return err
+	}
+	plan.Add(&PlanAction{
+		Kind:    planUpload,
+		Target:  target.String(),
+		Summary: fmt.Sprintf("%v report of %v comments", s.format, len(report.Comments)),
+		Content: string(content),
+	})
+	return nil
+}
+
+// splitObjectURL returns bucket (account/container for azure)
+// and the object key of the url
+func splitObjectURL(target string) (string, string, string) {
+	scheme := target[:strings.Index(target, "://")]
+	rest := strings.TrimPrefix(target, scheme+"://")
+	parts := strings.SplitN(rest, "/", 2)
+	if scheme == "azblob" {
+		parts = strings.SplitN(rest, "/", 3)
+		if len(parts) < 3 {
+			return scheme, "", ""
+		}
+		return scheme, parts[0] + "/" + parts[1], parts[2]
+	}
+	if len(parts) < 2 {
+		return scheme, "", ""
+	}
+	return scheme, parts[0], parts[1]
+}
+
+// escapeObjectKey escapes segments of the key keeping slashes
+func escapeObjectKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i, s := range segments {
+		segments[i] = strings.Replace(url.PathEscape(s), "+", "%2B", -1)
+	}
+	return strings.Join(segments, "/")
+}
+
+// applyUpload puts the content to the object of the target url
+func applyUpload(p *Plan, a *PlanAction) error {
+	scheme, bucket, key := splitObjectURL(a.Target)
+	if len(bucket) == 0 || len(key) == 0 {
+		return fmt.Errorf("Invalid object url: %v", a.Target)
+	}
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if path.Ext(key) == ".md" {
+		contentType = "text/markdown; charset=utf-8"
+	}
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+	headers := map[string]string{"Content-Type": contentType}
+	body := []byte(a.Content)
+	switch scheme {
+	case "s3":
+		return uploadS3(p, bucket, key, headers, body)
+	case "gs":
+		token, err := p.Secrets.Get(gcsSecret)
+		if err != nil {
+			return err
+		}
+		headers["Authorization"] = "Bearer " + token
+		endpoint := fmt.Sprintf("%v/%v/o?uploadType=media&name=%v", gcsUploadAPI, url.PathEscape(bucket), url.QueryEscape(key))
+		return doRawRequest("POST", endpoint, headers, body)
+	case "azblob":
+		sas, err := p.Secrets.Get(azureSecret)
+		if err != nil {
+			return err
+		}
+		parts := strings.SplitN(bucket, "/", 2)
+		headers["x-ms-blob-type"] = "BlockBlob"
+		endpoint := fmt.Sprintf("https://%v.blob.core.windows.net/%v/%v?%v", parts[0], parts[1], escapeObjectKey(key), strings.TrimPrefix(sas, "?"))
+		return doRawRequest("PUT", endpoint, headers, body)
+	}
+	return errUnknownStorage
+}
+
+// awsCredentials returns keys from the environment or secret aws
+func awsCredentials(p *Plan) (string, string, error) {
+	id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if len(id) > 0 && len(secret) > 0 {
+		return id, secret, nil
+	}
+	value, err := p.Secrets.Get(awsSecret)
+	if err != nil {
+		return "", "", errNoAWSKeys
+	}
+	parts := strings.SplitN(value, ":", 2)
+	if len(parts) != 2 {
+		return "", "", errNoAWSKeys
+	}
+	return parts[0], parts[1], nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// uploadS3 puts the object signed with AWS signature version 4,
+// AWS_ENDPOINT_URL selects S3-compatible storage (path-style)
+func uploadS3(p *Plan, bucket, key string, headers map[string]string, body []byte) error {
+	id, secret, err := awsCredentials(p)
+	if err != nil {
+		return err
+	}
+	region := os.Getenv("AWS_REGION")
+	if len(region) == 0 {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if len(region) == 0 {
+		return errNoAWSRegion
+	}
+	endpoint := fmt.Sprintf("https://%v.s3.%v.amazonaws.com/%v", bucket, region, escapeObjectKey(key))
+	if custom := os.Getenv("AWS_ENDPOINT_URL"); len(custom) > 0 {
+		endpoint = fmt.Sprintf("%v/%v/%v", strings.TrimSuffix(custom, "/"), bucket, escapeObjectKey(key))
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	amzDate := now.Format(storeTimeFormat)
+	date := now.Format("20060102")
+	payloadHash := sha256.Sum256(body)
+	headers["Host"] = u.Host
+	headers["x-amz-date"] = amzDate
+	headers["x-amz-content-sha256"] = hex.EncodeToString(payloadHash[:])
+	if token := os.Getenv("AWS_SESSION_TOKEN"); len(token) > 0 {
+		headers["x-amz-security-token"] = token
+	}
+	names := make([]string, 0, len(headers))
+	canonical := make(map[string]string, len(headers))
+	for k, v := range headers {
+		name := strings.ToLower(k)
+		names = append(names, name)
+		canonical[name] = strings.TrimSpace(v)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%v:%v\n", name, canonical[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{"PUT", u.EscapedPath(), "", canonicalHeaders.String(), signedHeaders, headers["x-amz-content-sha256"]}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	signingKey := hmacSHA256(hmacSHA256(hmacSHA256(hmacSHA256([]byte("AWS4"+secret), date), region), "s3"), "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	headers["Authorization"] = fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v", id, scope, signedHeaders, signature)
+	delete(headers, "Host")
+	return doRawRequest("PUT", endpoint, headers, body)
+}
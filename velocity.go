@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+const (
+	periodWeek  = "week"
+	periodMonth = "month"
+	// velocityTrendPeriods is how many latest periods decide the trend
+	velocityTrendPeriods = 4
+	trendGrowing         = "growing"
+	trendShrinking       = "shrinking"
+	trendFlat            = "flat"
+	velocityChartHeight  = 160
+	velocityBarWidth     = 16
+	// velocityHTMLText is a standalone page with the chart of debt
+	// added above and resolved below the axis
+	velocityHTMLText = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Velocity</title>
+<style>body{font-family:sans-serif}td,th{padding:2px 8px;text-align:right}.added{fill:#d73a49}.removed{fill:#28a745}</style>
+</head>
+<body>
+<h1>Velocity per {{.Period}}</h1>
+<p>Net change: {{printf "%+d" .Net}} comments, {{signedEstimate .NetEstimate}}, trend is {{.Trend}}</p>
+<svg width="{{.Width}}" height="{{.Height}}">
+{{range .Bars}}<rect class="added" x="{{.X}}" y="{{.AddedY}}" width="{{.W}}" height="{{.AddedH}}"><title>{{.Period}}: +{{.Added}}</title></rect>
+<rect class="removed" x="{{.X}}" y="{{.Axis}}" width="{{.W}}" height="{{.RemovedH}}"><title>{{.Period}}: -{{.Removed}}</title></rect>
+{{end}}<line x1="0" y1="{{.Axis}}" x2="{{.Width}}" y2="{{.Axis}}" stroke="#555"/>
+</svg>
+<table>
+<tr><th>Period</th><th>Added</th><th>Resolved</th><th>Net</th><th>Estimate added</th><th>Estimate resolved</th><th>Net estimate</th></tr>
+{{range .Periods}}<tr><td>{{.Period}}</td><td>{{.Added}}</td><td>{{.Removed}}</td><td>{{printf "%+d" .Net}}</td><td>{{estimate .AddedEstimate}}</td><td>{{estimate .RemovedEstimate}}</td><td>{{signedEstimate .NetEstimate}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`
+)
+
+var (
+	errUnknownVelocitySource = errors.New("Velocity source must be store or history")
+)
+
+// VelocityPeriod are comments added and removed in a week or month
+type VelocityPeriod struct {
+	Period          string    `json:"period"`
+	Start           time.Time `json:"start"`
+	Added           int       `json:"added"`
+	Removed         int       `json:"removed"`
+	Net             int       `json:"net"`
+	AddedEstimate   float64   `json:"addedEstimate"`
+	RemovedEstimate float64   `json:"removedEstimate"`
+	NetEstimate     float64   `json:"netEstimate"`
+}
+
+// Velocity is the change of debt over time, the trend is the direction
+// of the net change in the latest periods
+type Velocity struct {
+	Period      string            `json:"period"`
+	Periods     []*VelocityPeriod `json:"periods"`
+	Net         int               `json:"net"`
+	NetEstimate float64           `json:"netEstimate"`
+	Trend       string            `json:"trend"`
+}
+
+// periodStart returns start of the week (Monday) or month of the time
+func periodStart(t time.Time, period string) time.Time {
+	t = t.UTC()
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	if period == periodMonth {
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+}
+
+func periodName(start time.Time, period string) string {
+	if period == periodMonth {
+		return start.Format("2006-01")
+	}
+	year, week := start.ISOWeek()
+	return fmt.Sprintf("%v-W%02d", year, week)
+}
+
+func nextPeriod(start time.Time, period string) time.Time {
+	if period == periodMonth {
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 7)
+}
+
+// computeVelocity aggregates events by period, periods without events
+// between the first and the last one are included
+func computeVelocity(events []*HistoryEvent, period string) (*Velocity, error) {
+	if period != periodWeek && period != periodMonth {
+		return nil, fmt.Errorf("Unknown period: %v, use week or month", period)
+	}
+	v := &Velocity{Period: period, Periods: make([]*VelocityPeriod, 0), Trend: trendFlat}
+	if len(events) == 0 {
+		return v, nil
+	}
+	first, last := events[0].Commit.Date, events[0].Commit.Date
+	for _, ev := range events {
+		if ev.Commit.Date.Before(first) {
+			first = ev.Commit.Date
+		}
+		if ev.Commit.Date.After(last) {
+			last = ev.Commit.Date
+		}
+	}
+	index := make(map[string]*VelocityPeriod)
+	for start := periodStart(first, period); !start.After(last); start = nextPeriod(start, period) {
+		p := &VelocityPeriod{Period: periodName(start, period), Start: start}
+		v.Periods = append(v.Periods, p)
+		index[p.Period] = p
+	}
+	for _, ev := range events {
+		p := index[periodName(periodStart(ev.Commit.Date, period), period)]
+		if ev.Kind == historyRemoved {
+			p.Removed++
+			p.RemovedEstimate += ev.Comment.Estimate
+		} else {
+			p.Added++
+			p.AddedEstimate += ev.Comment.Estimate
+		}
+	}
+	trend := 0
+	for i, p := range v.Periods {
+		p.Net = p.Added - p.Removed
+		p.NetEstimate = p.AddedEstimate - p.RemovedEstimate
+		v.Net += p.Net
+		v.NetEstimate += p.NetEstimate
+		if i >= len(v.Periods)-velocityTrendPeriods {
+			trend += p.Net
+		}
+	}
+	switch {
+	case trend > 0:
+		v.Trend = trendGrowing
+	case trend < 0:
+		v.Trend = trendShrinking
+	}
+	return v, nil
+}
+
+// scanTime returns time of the stored scan from its ID
+func scanTime(id string) (time.Time, error) {
+	if len(id) > len(storeTimeFormat) {
+		id = id[:len(storeTimeFormat)]
+	}
+	return time.Parse(storeTimeFormat, id)
+}
+
+// storeEvents compares consecutive stored scans, comments are added
+// or removed at the time of the later scan
+func storeEvents(store *ScanStore) ([]*HistoryEvent, error) {
+	ids, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	events := make([]*HistoryEvent, 0)
+	var previous *result
+	for _, id := range ids {
+		at, err := scanTime(id)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid stored scan ID %v: %v", id, err)
+		}
+		report, err := store.Load(id)
+		if err != nil {
+			return nil, err
+		}
+		if previous != nil {
+			scan := &Blame{Commit: report.Revision, Date: at}
+			delta := compareScans(previous, report)
+			for _, c := range delta.Added {
+				events = append(events, &HistoryEvent{Kind: historyAdded, Commit: scan, Comment: c})
+			}
+			for _, c := range delta.Removed {
+				events = append(events, &HistoryEvent{Kind: historyRemoved, Commit: scan, Comment: c})
+			}
+		}
+		previous = report
+	}
+	return events, nil
+}
+
+func printVelocity(w io.Writer, v *Velocity, hf *humanFormat) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PERIOD\tADDED\tRESOLVED\tNET\tESTIMATE ADDED\tESTIMATE RESOLVED\tNET ESTIMATE")
+	for _, p := range v.Periods {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%+d\t%v\t%v\t%v\n", p.Period, p.Added, p.Removed, p.Net,
+			hf.Estimate(p.AddedEstimate), hf.Estimate(p.RemovedEstimate), hf.SignedEstimate(p.NetEstimate))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "Net change: %+d comments, %v, trend is %v\n", v.Net, hf.SignedEstimate(v.NetEstimate), v.Trend)
+	return err
+}
+
+func writeVelocityCSV(w io.Writer, v *Velocity) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"period", "start", "added", "removed", "net", "added_estimate", "removed_estimate", "net_estimate"})
+	for _, p := range v.Periods {
+		cw.Write([]string{p.Period, formatDate(p.Start), strconv.Itoa(p.Added), strconv.Itoa(p.Removed), strconv.Itoa(p.Net),
+			strconv.FormatFloat(p.AddedEstimate, 'f', 1, 64), strconv.FormatFloat(p.RemovedEstimate, 'f', 1, 64),
+			strconv.FormatFloat(p.NetEstimate, 'f', 1, 64)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeVelocityMarkdown(w io.Writer, v *Velocity, hf *humanFormat) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Velocity per %v\n\n", v.Period)
+	fmt.Fprintf(&sb, "Net change: %+d comments, %v, trend is %v\n\n", v.Net, hf.SignedEstimate(v.NetEstimate), v.Trend)
+	sb.WriteString("|period|added|resolved|net|estimate added|estimate resolved|net estimate|\n|---|---|---|---|---|---|---|\n")
+	for _, p := range v.Periods {
+		fmt.Fprintf(&sb, "|%v|%v|%v|%+d|%v|%v|%v|\n", p.Period, p.Added, p.Removed, p.Net,
+			hf.Estimate(p.AddedEstimate), hf.Estimate(p.RemovedEstimate), hf.SignedEstimate(p.NetEstimate))
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// velocityBar is geometry of a period in the chart
+type velocityBar struct {
+	*VelocityPeriod
+	X, W, Axis, AddedY, AddedH, RemovedH int
+}
+
+func writeVelocityHTML(w io.Writer, v *Velocity, hf *humanFormat) error {
+	max := 1
+	for _, p := range v.Periods {
+		if p.Added > max {
+			max = p.Added
+		}
+		if p.Removed > max {
+			max = p.Removed
+		}
+	}
+	axis := velocityChartHeight / 2
+	bars := make([]*velocityBar, 0, len(v.Periods))
+	for i, p := range v.Periods {
+		added := p.Added * axis / max
+		bars = append(bars, &velocityBar{
+			VelocityPeriod: p,
+			X:              i * (velocityBarWidth + 4),
+			W:              velocityBarWidth,
+			Axis:           axis,
+			AddedY:         axis - added,
+			AddedH:         added,
+			RemovedH:       p.Removed * axis / max,
+		})
+	}
+	t := htmltemplate.Must(htmltemplate.New("velocity").
+		Funcs(htmltemplate.FuncMap{"estimate": hf.Estimate, "signedEstimate": hf.SignedEstimate}).
+		Parse(velocityHTMLText))
+	var buf bytes.Buffer
+	err := t.Execute(&buf, struct {
+		*Velocity
+		Bars          []*velocityBar
+		Width, Height int
+		Axis          int
+	}{v, bars, len(bars) * (velocityBarWidth + 4), velocityChartHeight, axis})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// runVelocity implements "scorpion velocity [store | history [revision]]",
+// it compares consecutive stored scans (by default) or commits
+func runVelocity(args []string) error {
+	source := "store"
+	if len(args) > 0 {
+		source, args = args[0], args[1:]
+	}
+	config, err := loadConfig(configPathFlag, srcRootFlag)
+	if err != nil {
+		return err
+	}
+	var events []*HistoryEvent
+	switch {
+	case source == "store" && len(args) == 0:
+		env := NewEnvironment(srcRootFlag)
+		events, err = storeEvents(NewScanStore(env.root, config.Gate.Store))
+	case source == "history" && len(args) <= 1:
+		rev := "HEAD"
+		if len(args) == 1 {
+			rev = args[0]
+		}
+		events, err = scanHistory(config, rev)
+	default:
+		return errUnknownVelocitySource
+	}
+	if err != nil {
+		return err
+	}
+	hf, err := newHumanFormat(&config.Display)
+	if err != nil {
+		return err
+	}
+	v, err := computeVelocity(events, periodFlag)
+	if err != nil {
+		return err
+	}
+	if verboseFlag {
+		js, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Println(string(js))
+		return err
+	}
+	if csvFlag {
+		return writeVelocityCSV(os.Stdout, v)
+	}
+	if pflag.CommandLine.Changed("format") {
+		switch f := strings.Join(formatFlag, ","); f {
+		case markdownSinkName:
+			return writeVelocityMarkdown(os.Stdout, v, hf)
+		case "html":
+			return writeVelocityHTML(os.Stdout, v, hf)
+		default:
+			return fmt.Errorf("Unknown velocity format: %v, use markdown or html", f)
+		}
+	}
+	return printVelocity(os.Stdout, v, hf)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// defaultVendorDirs contain third-party code excluded by default
+	defaultVendorDirs = []string{"vendor", "node_modules", "third_party"}
+)
+
+// isVendored checks if any directory in the path relative to the root
+// is a vendor directory, linguist-vendored attribute takes precedence
+func (td *ToDoGenerator) isVendored(path string) bool {
+	if len(td.vendorDirs) == 0 {
+		return false
+	}
+	if td.vendoredAttr {
+		if vendored, ok := td.linguistAttribute(path, linguistVendored); ok {
+			return vendored
+		}
+	}
+	relativePath, err := filepath.Rel(td.root, path)
+	if err != nil {
+		relativePath = path
+	}
+	parts := strings.Split(filepath.ToSlash(filepath.Dir(relativePath)), "/")
+	for _, part := range parts {
+		if td.vendorDirs[part] {
+			return true
+		}
+	}
+	return false
+}
+
+// Vendored returns number of comments excluded in vendor directories
+func (td *ToDoGenerator) Vendored() int {
+	td.commentMux.Lock()
+	defer td.commentMux.Unlock()
+	return td.vendoredCount
+}
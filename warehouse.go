@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	csvSinkName      = "csv"
+	bigquerySinkName = "bigquery"
+	bigquerySecret   = "bigquery"
+	bigqueryPrefix   = "bigquery:"
+	planInsertRows   = "insert-rows"
+	bigqueryAPI      = "https://bigquery.googleapis.com/bigquery/v2"
+	// bigqueryBatchSize is number of rows in a single insert request
+	bigqueryBatchSize = 500
+)
+
+// commentRow is a comment flattened with its scan for warehouses,
+// columns are the same for csv and BigQuery
+type commentRow struct {
+	ScannedAt string  `json:"scanned_at"`
+	Project   string  `json:"project"`
+	Branch    string  `json:"branch"`
+	Revision  string  `json:"revision"`
+	ID        string  `json:"id"`
+	Type      string  `json:"type"`
+	Title     string  `json:"title"`
+	File      string  `json:"file"`
+	Line      int     `json:"line"`
+	Issue     int     `json:"issue,omitempty"`
+	Category  string  `json:"category"`
+	Assignee  string  `json:"assignee"`
+	Estimate  float64 `json:"estimate"`
+	Language  string  `json:"language"`
+	Author    string  `json:"author"`
+	Commit    string  `json:"commit"`
+	// CommittedAt is empty without blame
+	CommittedAt string `json:"committed_at,omitempty"`
+}
+
+var (
+	commentRowColumns = []string{
+		"scanned_at", "project", "branch", "revision", "id", "type", "title", "file", "line",
+		"issue", "category", "assignee", "estimate", "language", "author", "commit", "committed_at",
+	}
+)
+
+// flattenReport returns rows of all comments of the report
+func flattenReport(report *result, now time.Time) []*commentRow {
+	rows := make([]*commentRow, 0, len(report.Comments))
+	scannedAt := now.UTC().Format(time.RFC3339)
+	for _, c := range report.Comments {
+		row := &commentRow{
+			ScannedAt: scannedAt,
+			Project:   report.Project,
+			Branch:    report.Branch,
+			Revision:  report.Revision,
+			ID:        c.ID,
+			Type:      c.Type,
+			Title:     c.Title,
+			File:      c.File,
+			Line:      c.Line + 1,
+			Issue:     c.Issue,
+			Category:  c.Category,
+			Assignee:  c.Assignee,
+			Estimate:  c.Estimate,
+			Language:  c.Language,
+		}
+		if c.Project != "" {
+			row.Project = c.Project
+		}
+		if b := c.Blame; b != nil {
+			row.Author = b.CanonicalAuthor
+			if len(row.Author) == 0 {
+				row.Author = b.Author
+			}
+			row.Commit = b.Commit
+			row.CommittedAt = b.Date.UTC().Format(time.RFC3339)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+func (r *commentRow) values() []string {
+	issue := ""
+	if r.Issue > 0 {
+		issue = strconv.Itoa(r.Issue)
+	}
+	return []string{
+		r.ScannedAt, r.Project, r.Branch, r.Revision, r.ID, r.Type, r.Title, r.File, strconv.Itoa(r.Line),
+		issue, r.Category, r.Assignee, strconv.FormatFloat(r.Estimate, 'f', -1, 64), r.Language,
+		r.Author, r.Commit, r.CommittedAt,
+	}
+}
+
+// csvSink writes rows with a header to stage them for loading into
+// a warehouse ("COPY ... FROM", "bq load")
+type csvSink struct {
+	path string
+}
+
+func newCSVSink(sc *SinkConfig, env *Environment) (Sink, error) {
+	return &csvSink{path: sc.Path}, nil
+}
+
+func (s *csvSink) Emit(report *result, plan *Plan) error {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write(commentRowColumns)
+	for _, row := range flattenReport(report, time.Now()) {
+		w.Write(row.values())
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	if len(s.path) == 0 {
+		plan.Add(&PlanAction{Kind: planStdout, Target: "stdout", Summary: "csv rows", Content: buf.String()})
+		return nil
+	}
+	plan.Add(&PlanAction{Kind: planWriteFile, Target: s.path, Summary: "csv rows", Content: buf.String()})
+	return nil
+}
+
+// bigquerySink appends rows to the table with the streaming API
+type bigquerySink struct {
+	table string
+}
+
+func newBigQuerySink(sc *SinkConfig, env *Environment) (Sink, error) {
+	if len(strings.Split(sc.Table, ".")) != 3 {
+		return nil, fmt.Errorf("BigQuery table must be \"project.dataset.table\": %v", sc.Table)
+	}
+	return &bigquerySink{table: sc.Table}, nil
+}
+
+func (s *bigquerySink) Emit(report *result, plan *Plan) error {
+	rows := flattenReport(report, time.Now())
+	if len(rows) == 0 {
+		return nil
+	}
+	js, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+	plan.Add(&PlanAction{
+		Kind:    planInsertRows,
+		Target:  bigqueryPrefix + s.table,
+		Summary: fmt.Sprintf("%v rows", len(rows)),
+		Content: string(js),
+	})
+	return nil
+}
+
+type bigqueryRow struct {
+	InsertID string      `json:"insertId"`
+	JSON     *commentRow `json:"json"`
+}
+
+type bigqueryInsertResponse struct {
+	InsertErrors []struct {
+		Index  int `json:"index"`
+		Errors []struct {
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	} `json:"insertErrors"`
+}
+
+// applyInsertRows streams the rows to BigQuery in batches, insert IDs
+// deduplicate rows of the same scan when the plan is applied again
+func applyInsertRows(p *Plan, a *PlanAction) error {
+	if !strings.HasPrefix(a.Target, bigqueryPrefix) {
+		return fmt.Errorf("%v: %v %v", errUnknownPlanAction, a.Kind, a.Target)
+	}
+	parts := strings.Split(strings.TrimPrefix(a.Target, bigqueryPrefix), ".")
+	if len(parts) != 3 {
+		return fmt.Errorf("%v: %v %v", errUnknownPlanAction, a.Kind, a.Target)
+	}
+	token, err := p.Secrets.Get(bigquerySecret)
+	if err != nil {
+		return err
+	}
+	var rows []*commentRow
+	if err := json.Unmarshal([]byte(a.Content), &rows); err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%v/projects/%v/datasets/%v/tables/%v/insertAll", bigqueryAPI, parts[0], parts[1], parts[2])
+	headers := map[string]string{"Authorization": "Bearer " + token}
+	for start := 0; start < len(rows); start += bigqueryBatchSize {
+		end := start + bigqueryBatchSize
+		if end > len(rows) {
+			end = len(rows)
+		}
+		batch := make([]*bigqueryRow, 0, end-start)
+		for i, row := range rows[start:end] {
+			batch = append(batch, &bigqueryRow{InsertID: fmt.Sprintf("%v-%v-%v", row.ScannedAt, row.ID, start+i), JSON: row})
+		}
+		response := &bigqueryInsertResponse{}
+		in := map[string]interface{}{"kind": "bigquery#tableDataInsertAllRequest", "rows": batch}
+		if err := doJSONRequest("POST", endpoint, headers, in, response); err != nil {
+			return err
+		}
+		// failed rows are reported with a successful status
+		if len(response.InsertErrors) > 0 {
+			e := response.InsertErrors[0]
+			message := ""
+			if len(e.Errors) > 0 {
+				message = e.Errors[0].Reason + ": " + e.Errors[0].Message
+			}
+			return fmt.Errorf("BigQuery rejected %v rows, row %v: %v", len(response.InsertErrors), start+e.Index, message)
+		}
+	}
+	return nil
+}
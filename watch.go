@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/karrick/godirwalk"
+)
+
+// Watcher polls the source tree and reports when it changes
+type Watcher struct {
+	root     string
+	interval time.Duration
+	ignored  map[string]bool
+}
+
+// NewWatcher creates watcher for the source root, changes of ignored
+// files (e.g. our own log file) do not trigger notifications
+func NewWatcher(root string, interval time.Duration, ignored ...string) *Watcher {
+	w := &Watcher{
+		root:     root,
+		interval: interval,
+		ignored:  make(map[string]bool),
+	}
+	for _, path := range ignored {
+		if abs, err := filepath.Abs(path); err == nil {
+			w.ignored[abs] = true
+		}
+	}
+	return w
+}
+
+// fingerprint returns hash of paths, sizes and modification times
+// of all files in the tree
+func (w *Watcher) fingerprint() (string, error) {
+	h := md5.New()
+	root, err := filepath.Abs(w.root)
+	if err != nil {
+		return "", err
+	}
+	err = godirwalk.Walk(root, &godirwalk.Options{
+		Callback: func(osPathname string, de *godirwalk.Dirent) error {
+			if de.IsDir() && filepath.Base(osPathname) == ".git" {
+				return filepath.SkipDir
+			}
+			if !de.IsRegular() || w.ignored[osPathname] {
+				return nil
+			}
+			fi, err := os.Stat(osPathname)
+			if err != nil {
+				return nil
+			}
+			io.WriteString(h, fmt.Sprintf("%v:%v:%v\n", osPathname, fi.Size(), fi.ModTime().UnixNano()))
+			return nil
+		},
+		ErrorCallback: func(osPathname string, err error) godirwalk.ErrorAction {
+			return godirwalk.SkipNode
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Run calls onChange every time the tree changes until stop is closed
+func (w *Watcher) Run(stop <-chan struct{}, onChange func()) {
+	last, err := w.fingerprint()
+	if err != nil {
+		log.Printf("Watcher error: %v", err)
+	}
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			current, err := w.fingerprint()
+			if err != nil {
+				log.Printf("Watcher error: %v", err)
+				continue
+			}
+			if current != last {
+				last = current
+				log.Printf("Detected changes in %v", w.root)
+				onChange()
+			}
+		}
+	}
+}
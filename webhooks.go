@@ -0,0 +1,497 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	planPostComment = "post-comment"
+	planSetStatus   = "set-status"
+	statusSuccess   = "success"
+	statusFailure   = "failure"
+	// webhookMarker finds the comment of previous deliveries, it is
+	// updated instead of adding one on every push
+	webhookMarker = "<!-- " + appName + " -->"
+	// fetched commits are kept by refs until the next delivery
+	webhookHeadRef = "refs/" + appName + "/head"
+	webhookBaseRef = "refs/" + appName + "/base"
+	zeroCommit     = "0000000000000000000000000000000000000000"
+	// maxWebhookBody is the limit of GitHub deliveries
+	maxWebhookBody = 25 << 20
+	// maxDeltaLines limits comments of large pull requests
+	maxDeltaLines = 50
+)
+
+var (
+	errInvalidSignature = errors.New("Invalid webhook signature")
+	errWebhookDisabled  = errors.New("Webhook is not configured")
+	errWebhookNeedsGit  = errors.New("Webhooks need git to fetch commits")
+)
+
+// webhookEvent is a push or a pull request (merge request on GitLab)
+// of a delivery
+type webhookEvent struct {
+	provider string
+	// target is the repository in targets of plan actions
+	target string
+	// ref is fetched to get the head commit
+	ref string
+	// base is the commit before a push, for pull requests it is
+	// the merge base of the head and the target branch
+	base string
+	head string
+	// branch is the pushed branch or the target of the pull request
+	branch string
+	// number is 0 for pushes
+	number int
+}
+
+func (ev *webhookEvent) String() string {
+	if ev.number > 0 {
+		return fmt.Sprintf("%v#%v", ev.target, ev.number)
+	}
+	return fmt.Sprintf("%v %v", ev.target, ev.ref)
+}
+
+// webhookProvider verifies and parses deliveries of a provider,
+// events that do not change code are parsed as nil
+type webhookProvider struct {
+	name   string
+	title  string
+	config func(wc *WebhooksConfig) *WebhookConfig
+	verify func(r *http.Request, body []byte, secret string) bool
+	parse  func(r *http.Request, body []byte) (*webhookEvent, error)
+}
+
+var webhookProviders = []*webhookProvider{
+	{
+		name:   "github",
+		title:  "GitHub",
+		config: func(wc *WebhooksConfig) *WebhookConfig { return wc.GitHub },
+		verify: verifyGitHubWebhook,
+		parse:  parseGitHubWebhook,
+	},
+	{
+		name:   "gitlab",
+		title:  "GitLab",
+		config: func(wc *WebhooksConfig) *WebhookConfig { return wc.GitLab },
+		verify: verifyGitLabWebhook,
+		parse:  parseGitLabWebhook,
+	},
+}
+
+// validateWebhooks checks that secrets of configured webhooks exist
+func validateWebhooks(wc *WebhooksConfig, secrets *Secrets) []error {
+	errs := make([]error, 0)
+	for _, p := range webhookProviders {
+		hc := p.config(wc)
+		if hc == nil {
+			continue
+		}
+		if len(hc.Secret) == 0 {
+			errs = append(errs, fmt.Errorf("%v webhook: secret is not set", p.title))
+			continue
+		}
+		if _, err := secrets.Get(hc.Secret); err != nil {
+			errs = append(errs, fmt.Errorf("%v webhook: %v", p.title, err))
+		}
+	}
+	return errs
+}
+
+// verifyGitHubWebhook checks the HMAC of the body in X-Hub-Signature-256
+func verifyGitHubWebhook(r *http.Request, body []byte, secret string) bool {
+	signature := r.Header.Get("X-Hub-Signature-256")
+	if !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	expected := hex.EncodeToString(hmacSHA256([]byte(secret), string(body)))
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(signature, "sha256=")), []byte(expected)) == 1
+}
+
+// verifyGitLabWebhook compares X-Gitlab-Token with the secret, GitLab
+// does not sign deliveries
+func verifyGitLabWebhook(r *http.Request, body []byte, secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Gitlab-Token")), []byte(secret)) == 1
+}
+
+type githubPushEvent struct {
+	Ref        string `json:"ref"`
+	Before     string `json:"before"`
+	After      string `json:"after"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+type githubPullRequestEvent struct {
+	Action      string `json:"action"`
+	Number      int    `json:"number"`
+	PullRequest struct {
+		Head struct {
+			SHA string `json:"sha"`
+		} `json:"head"`
+		Base struct {
+			Ref string `json:"ref"`
+		} `json:"base"`
+	} `json:"pull_request"`
+	Repository struct {
+		FullName string `json:"full_name"`
+	} `json:"repository"`
+}
+
+func parseGitHubWebhook(r *http.Request, body []byte) (*webhookEvent, error) {
+	switch r.Header.Get("X-GitHub-Event") {
+	case "push":
+		var push githubPushEvent
+		if err := json.Unmarshal(body, &push); err != nil {
+			return nil, err
+		}
+		return pushEvent("github", githubTargetPrefix+push.Repository.FullName, push.Ref, push.Before, push.After), nil
+	case "pull_request":
+		var pr githubPullRequestEvent
+		if err := json.Unmarshal(body, &pr); err != nil {
+			return nil, err
+		}
+		if pr.Action != "opened" && pr.Action != "synchronize" && pr.Action != "reopened" {
+			return nil, nil
+		}
+		return &webhookEvent{
+			provider: "github",
+			target:   githubTargetPrefix + pr.Repository.FullName,
+			ref:      fmt.Sprintf("refs/pull/%v/head", pr.Number),
+			head:     pr.PullRequest.Head.SHA,
+			branch:   pr.PullRequest.Base.Ref,
+			number:   pr.Number,
+		}, nil
+	}
+	// e.g. ping sent when the webhook is created
+	return nil, nil
+}
+
+type gitlabProject struct {
+	WebURL string `json:"web_url"`
+}
+
+type gitlabEvent struct {
+	ObjectKind       string        `json:"object_kind"`
+	Ref              string        `json:"ref"`
+	Before           string        `json:"before"`
+	After            string        `json:"after"`
+	Project          gitlabProject `json:"project"`
+	ObjectAttributes struct {
+		IID          int    `json:"iid"`
+		Action       string `json:"action"`
+		TargetBranch string `json:"target_branch"`
+		LastCommit   struct {
+			ID string `json:"id"`
+		} `json:"last_commit"`
+	} `json:"object_attributes"`
+}
+
+func parseGitLabWebhook(r *http.Request, body []byte) (*webhookEvent, error) {
+	var ev gitlabEvent
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return nil, err
+	}
+	target := gitlabTargetPrefix + ev.Project.WebURL
+	switch ev.ObjectKind {
+	case "push":
+		return pushEvent("gitlab", target, ev.Ref, ev.Before, ev.After), nil
+	case "merge_request":
+		mr := ev.ObjectAttributes
+		if mr.Action != "open" && mr.Action != "update" && mr.Action != "reopen" {
+			return nil, nil
+		}
+		return &webhookEvent{
+			provider: "gitlab",
+			target:   target,
+			ref:      fmt.Sprintf("refs/merge-requests/%v/head", mr.IID),
+			head:     mr.LastCommit.ID,
+			branch:   mr.TargetBranch,
+			number:   mr.IID,
+		}, nil
+	}
+	return nil, nil
+}
+
+// pushEvent returns event of a branch push, tags and deleted
+// branches are ignored
+func pushEvent(provider, target, ref, before, after string) *webhookEvent {
+	if !strings.HasPrefix(ref, "refs/heads/") || after == zeroCommit {
+		return nil
+	}
+	return &webhookEvent{
+		provider: provider,
+		target:   target,
+		ref:      ref,
+		base:     before,
+		head:     after,
+		branch:   strings.TrimPrefix(ref, "refs/heads/"),
+	}
+}
+
+// handleWebhook accepts verified deliveries of the provider, they are
+// processed in the background since providers time out quickly
+func (s *Server) handleWebhook(p *webhookProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, errMethodNotAllowed)
+			return
+		}
+		s.mux.RLock()
+		config := s.config
+		s.mux.RUnlock()
+		hc := p.config(&config.Serve.Webhooks)
+		if hc == nil {
+			writeError(w, http.StatusNotFound, errWebhookDisabled)
+			return
+		}
+		secret, err := NewSecrets(config).Get(hc.Secret)
+		if err != nil {
+			log.Printf("%v webhook: %v", p.title, err)
+			writeError(w, http.StatusInternalServerError, errWebhookDisabled)
+			return
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxWebhookBody))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		if !p.verify(r, body, secret) {
+			writeError(w, http.StatusUnauthorized, errInvalidSignature)
+			return
+		}
+		ev, err := p.parse(r, body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid delivery: %v", err))
+			return
+		}
+		if ev == nil {
+			writeJSON(w, http.StatusOK, map[string]string{"status": "ignored"})
+			return
+		}
+		log.Printf("Received %v webhook of %v", p.title, ev)
+		go func() {
+			if err := s.processWebhook(ev); err != nil {
+				log.Printf("Webhook of %v failed: %v", ev, err)
+			}
+		}()
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": "accepted"})
+	}
+}
+
+// processWebhook fetches commits of the event, compares scans of the
+// base and the head and reports the delta as commit status and
+// comment of the pull request. Deliveries are processed one by one
+func (s *Server) processWebhook(ev *webhookEvent) error {
+	s.hooks.Lock()
+	defer s.hooks.Unlock()
+	if !hasGitBinary() {
+		return errWebhookNeedsGit
+	}
+	s.mux.RLock()
+	config := s.config
+	s.mux.RUnlock()
+	trace := startTrace(appName + " webhook")
+	trace.set("scorpion.provider", ev.provider)
+	plan, err := planWebhook(config, ev)
+	trace.finish(err)
+	flushTelemetry()
+	if err != nil {
+		return err
+	}
+	if err := executePlan(plan); err != nil {
+		return err
+	}
+	// the checkout is not changed by dry runs either
+	if config.Serve.Webhooks.Pull && ev.number == 0 && !dryRunFlag {
+		return s.pullBranch(ev)
+	}
+	return nil
+}
+
+// fetchWebhook fetches the head of the event and sets the base of
+// pull requests
+func fetchWebhook(env *Environment, ev *webhookEvent) error {
+	args := []string{"fetch", "--no-tags", remoteFlag, "+" + ev.ref + ":" + webhookHeadRef}
+	if ev.number > 0 {
+		args = append(args, "+refs/heads/"+ev.branch+":"+webhookBaseRef)
+	}
+	if _, stderr, err := env.output("git", args...); err != nil {
+		return fmt.Errorf("Cannot fetch %v: %v", ev.ref, strings.TrimSpace(stderr))
+	}
+	if ev.number > 0 {
+		base, stderr, err := env.output("git", "merge-base", webhookBaseRef, ev.head)
+		if err != nil {
+			return fmt.Errorf("Cannot find merge base with %v: %v", ev.branch, strings.TrimSpace(stderr))
+		}
+		ev.base = base
+	}
+	return nil
+}
+
+// scanCommit scans files of the commit without checkout
+func scanCommit(config *Config, hash string) (*result, *Environment, error) {
+	env := NewEnvironment(srcRootFlag)
+	env.ref = hash
+	report, err := generatePathsReport(config, env, nil)
+	return report, env, err
+}
+
+// planWebhook plans the status of the head commit, it fails if gate
+// checks find violations at the head. Pull requests are commented
+// with the delta too
+func planWebhook(config *Config, ev *webhookEvent) (*Plan, error) {
+	if err := fetchWebhook(NewEnvironment(srcRootFlag), ev); err != nil {
+		return nil, err
+	}
+	// first push of a branch has nothing to compare with
+	before := &result{}
+	if ev.base != zeroCommit {
+		report, _, err := scanCommit(config, ev.base)
+		if err != nil {
+			return nil, err
+		}
+		before = report
+	}
+	after, env, err := scanCommit(config, ev.head)
+	if err != nil {
+		return nil, err
+	}
+	delta := compareScans(before, after)
+	violations, err := runGateChecks(after, config, env)
+	if err != nil {
+		return nil, err
+	}
+	state := statusSuccess
+	description := fmt.Sprintf("%v comments (%+d), estimate %.1fh (%+.1fh)",
+		delta.CommentsAfter, delta.CommentsAfter-delta.CommentsBefore, delta.EstimateAfter, delta.EstimateDelta)
+	if len(violations) > 0 {
+		state = statusFailure
+		description = fmt.Sprintf("%v gate violations, %v", len(violations), description)
+	}
+	plan := NewPlan(srcRootFlag)
+	plan.Secrets = NewSecrets(config)
+	plan.Add(&PlanAction{
+		Kind:    planSetStatus,
+		Target:  ev.target + "@" + ev.head,
+		Summary: state,
+		Content: description,
+	})
+	if ev.number > 0 {
+		plan.Add(&PlanAction{
+			Kind:    planPostComment,
+			Target:  fmt.Sprintf("%v#%v", ev.target, ev.number),
+			Content: webhookComment(delta, violations),
+		})
+	}
+	return plan, nil
+}
+
+// webhookComment renders the delta as markdown starting with the marker
+func webhookComment(delta *Delta, violations []*Violation) string {
+	var b strings.Builder
+	b.WriteString(webhookMarker + "\n")
+	fmt.Fprintf(&b, "**%v**: %v added, %v removed, %v changed TODO comments, %v → %v (%+d), estimate %.1fh → %.1fh (%+.1fh)\n",
+		appName, len(delta.Added), len(delta.Removed), len(delta.Changed),
+		delta.CommentsBefore, delta.CommentsAfter, delta.CommentsAfter-delta.CommentsBefore,
+		delta.EstimateBefore, delta.EstimateAfter, delta.EstimateDelta)
+	lines := make([]string, 0)
+	for _, c := range delta.Added {
+		lines = append(lines, fmt.Sprintf("+ %v: %v %v", commentLocation(c), c.Type, c.Title))
+	}
+	for _, c := range delta.Removed {
+		lines = append(lines, fmt.Sprintf("- %v: %v %v", commentLocation(c), c.Type, c.Title))
+	}
+	for _, ch := range delta.Changed {
+		lines = append(lines, fmt.Sprintf("! %v: %v %v (%v)", commentLocation(ch.After), ch.After.Type, ch.After.Title,
+			strings.Join(ch.Fields, ", ")))
+	}
+	if len(lines) > 0 {
+		b.WriteString("\n```diff\n")
+		for i, line := range lines {
+			if i == maxDeltaLines {
+				fmt.Fprintf(&b, "# and %v more\n", len(lines)-i)
+				break
+			}
+			b.WriteString(line + "\n")
+		}
+		b.WriteString("```\n")
+	}
+	if len(violations) > 0 {
+		b.WriteString("\nGate violations:\n\n")
+		for _, v := range violations {
+			b.WriteString("- ")
+			if v.Comment != nil {
+				fmt.Fprintf(&b, "`%v`: ", commentLocation(v.Comment))
+			}
+			fmt.Fprintf(&b, "%v: %v\n", v.Check, v.Message)
+		}
+	}
+	return b.String()
+}
+
+// pullBranch fast-forwards the checked out branch to the pushed
+// commit and rescans the files the push changed
+func (s *Server) pullBranch(ev *webhookEvent) error {
+	env := NewEnvironment(srcRootFlag)
+	if env.ScansTree() || env.Branch() != ev.branch {
+		return nil
+	}
+	if _, stderr, err := env.output("git", "merge", "--ff-only", ev.head); err != nil {
+		return fmt.Errorf("Cannot fast-forward %v: %v", ev.branch, strings.TrimSpace(stderr))
+	}
+	if ev.base == zeroCommit {
+		return s.Rescan()
+	}
+	// paths relative to the root, renamed files are listed twice
+	out, stderr, err := env.output("git", "diff", "--name-only", "--no-renames", "--relative", ev.base, ev.head)
+	if err != nil {
+		return fmt.Errorf("Cannot list changed files: %v", strings.TrimSpace(stderr))
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return s.RescanPaths(strings.Split(out, "\n"))
+}
+
+func applyPostComment(p *Plan, a *PlanAction) error {
+	switch {
+	case strings.HasPrefix(a.Target, githubTargetPrefix):
+		return applyGitHubComment(p, a)
+	case strings.HasPrefix(a.Target, gitlabTargetPrefix):
+		return applyGitLabNote(p, a)
+	}
+	return fmt.Errorf("%v: %v %v", errUnknownPlanAction, a.Kind, a.Target)
+}
+
+func applySetStatus(p *Plan, a *PlanAction) error {
+	switch {
+	case strings.HasPrefix(a.Target, githubTargetPrefix):
+		return applyGitHubStatus(p, a)
+	case strings.HasPrefix(a.Target, gitlabTargetPrefix):
+		return applyGitLabStatus(p, a)
+	}
+	return fmt.Errorf("%v: %v %v", errUnknownPlanAction, a.Kind, a.Target)
+}
+
+// splitTarget splits "<repository><sep><suffix>" targets of comments
+// and statuses after the prefix of the provider
+func splitTarget(target, prefix, sep string) (string, string) {
+	target = strings.TrimPrefix(target, prefix)
+	i := strings.LastIndex(target, sep)
+	if i < 0 {
+		return target, ""
+	}
+	return target[:i], target[i+1:]
+}